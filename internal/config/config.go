@@ -2,12 +2,29 @@ package config
 
 import (
     "fmt"
+    "log"
+    "net"
     "os"
+    "strconv"
+    "strings"
 )
 
 type Config struct {
-    Port           string
-    DatabaseURL    string
+    Port              string
+    DatabaseURL       string
+    ImageVersionsKeep int // Earlier versions kept of each image
+
+    // Authenticating reverse proxies whose user headers are believed when recording who uploaded an image
+    TrustedProxies    []*net.IPNet
+
+    // Where uploaded images are kept: "filesystem" (under ImageStorageRoot) or "s3"
+    ImageStorage      string
+    ImageStorageRoot  string
+    S3Endpoint        string
+    S3Bucket          string
+    S3Region          string
+    S3AccessKeyID     string
+    S3SecretAccessKey string
 }
 
 func Load() *Config {
@@ -22,10 +39,53 @@ func Load() *Config {
     dbURL := getEnv("DATABASE_URL", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", 
         host, port, user, password, dbname, sslmode))
     
+    imageVersionsKeep, err := strconv.Atoi(getEnv("IMAGE_VERSIONS_KEEP", "10"))
+    if err != nil || imageVersionsKeep < 0 {
+        imageVersionsKeep = 10
+    }
+
     return &Config{
-        Port:        getEnv("PORT", "8080"),
-        DatabaseURL: dbURL,
+        Port:              getEnv("PORT", "8080"),
+        DatabaseURL:       dbURL,
+        ImageVersionsKeep: imageVersionsKeep,
+        TrustedProxies:    parseNetworks(getEnv("TRUSTED_PROXIES", "")),
+        ImageStorage:      getEnv("IMAGE_STORAGE", "filesystem"),
+        ImageStorageRoot:  getEnv("IMAGE_STORAGE_ROOT", "./static/images"),
+        S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+        S3Bucket:          getEnv("S3_BUCKET", ""),
+        S3Region:          getEnv("S3_REGION", "us-east-1"),
+        S3AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+        S3SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+    }
+}
+
+// parseNetworks reads a comma-separated list of addresses and CIDR ranges, e.g. "10.0.0.5, 172.16.0.0/12".
+// Entries that can't be read are skipped with a warning, leaving those addresses untrusted.
+func parseNetworks(list string) []*net.IPNet {
+    var networks []*net.IPNet
+    for _, entry := range strings.Split(list, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        if !strings.Contains(entry, "/") {
+            if ip := net.ParseIP(entry); ip != nil {
+                bits := 8 * len(ip.To16())
+                if ip.To4() != nil {
+                    ip, bits = ip.To4(), 32
+                }
+                networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+                continue
+            }
+        }
+        _, network, err := net.ParseCIDR(entry)
+        if err != nil {
+            log.Printf("config: ignoring TRUSTED_PROXIES entry %q: not an address or CIDR range", entry)
+            continue
+        }
+        networks = append(networks, network)
     }
+    return networks
 }
 
 func getEnv(key, defaultValue string) string {
@@ -50,6 +50,262 @@ func Migrate(db *sql.DB) error {
 			FOREIGN KEY (stamp_id) REFERENCES stamps(id) ON DELETE CASCADE,
 			FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
 		)`,
+		`CREATE TABLE IF NOT EXISTS smart_collections (
+			id VARCHAR(36) PRIMARY KEY,
+			name VARCHAR(255) UNIQUE NOT NULL,
+			query TEXT NOT NULL DEFAULT '',
+			view VARCHAR(20) NOT NULL DEFAULT 'gallery',
+			date_created TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS series (
+			id VARCHAR(36) PRIMARY KEY,
+			name VARCHAR(255) UNIQUE NOT NULL,
+			country VARCHAR(255),
+			start_year INTEGER,
+			end_year INTEGER,
+			description TEXT,
+			date_created TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS series_members (
+			id VARCHAR(36) PRIMARY KEY,
+			series_id VARCHAR(36) NOT NULL,
+			scott_number VARCHAR(255),
+			name VARCHAR(255),
+			position INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE CASCADE
+		)`,
+		`ALTER TABLE stamps ADD COLUMN IF NOT EXISTS series_id VARCHAR(36) REFERENCES series(id) ON DELETE SET NULL`,
+		`ALTER TABLE stamps ADD COLUMN IF NOT EXISTS parent_id VARCHAR(36) REFERENCES stamps(id) ON DELETE SET NULL`,
+		`ALTER TABLE stamps ADD COLUMN IF NOT EXISTS variety_type VARCHAR(50)`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS format VARCHAR(50) NOT NULL DEFAULT 'single'`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS plate_number VARCHAR(50)`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS plate_position VARCHAR(50)`,
+		// A single and a souvenir sheet of the same design can share a condition and box
+		`ALTER TABLE stamp_instances DROP CONSTRAINT IF EXISTS stamp_instances_stamp_id_condition_box_id_key`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS gum VARCHAR(10)`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS centering VARCHAR(10)`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS grade INTEGER CHECK (grade BETWEEN 1 AND 100)`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS faults TEXT[]`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS cancel_type VARCHAR(50)`,
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS grade_notes TEXT`,
+		// The catalogue value of one copy as listed, which valuation adjusts for the copy's grading
+		`ALTER TABLE stamp_instances ADD COLUMN IF NOT EXISTS catalogue_value NUMERIC(12, 2) CHECK (catalogue_value >= 0)`,
+		// Copies graded differently are kept as separate groups, by stamp_instances_group_key
+		`DROP INDEX IF EXISTS stamp_instances_stamp_format_key`,
+		// It didn't treat missing grades as equal, so it allowed duplicate groups
+		`DROP INDEX IF EXISTS stamp_instances_grading_key`,
+		`CREATE TABLE IF NOT EXISTS instance_components (
+			instance_id VARCHAR(36),
+			stamp_id VARCHAR(36),
+			quantity INTEGER NOT NULL DEFAULT 1,
+			PRIMARY KEY (instance_id, stamp_id),
+			FOREIGN KEY (instance_id) REFERENCES stamp_instances(id) ON DELETE CASCADE,
+			FOREIGN KEY (stamp_id) REFERENCES stamps(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS covers (
+			id VARCHAR(36) PRIMARY KEY,
+			front_image_url VARCHAR(512),
+			back_image_url VARCHAR(512),
+			sender VARCHAR(255),
+			recipient VARCHAR(255),
+			origin VARCHAR(255),
+			destination VARCHAR(255),
+			postmark_date VARCHAR(255),
+			postmark_town VARCHAR(255),
+			route VARCHAR(255),
+			rate_paid VARCHAR(255),
+			notes TEXT,
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			date_deleted TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS cover_stamps (
+			cover_id VARCHAR(36),
+			stamp_id VARCHAR(36),
+			quantity INTEGER NOT NULL DEFAULT 1,
+			PRIMARY KEY (cover_id, stamp_id),
+			FOREIGN KEY (cover_id) REFERENCES covers(id) ON DELETE CASCADE,
+			FOREIGN KEY (stamp_id) REFERENCES stamps(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS first_day_covers (
+			id VARCHAR(36) PRIMARY KEY,
+			stamp_id VARCHAR(36) NOT NULL,
+			city VARCHAR(255),
+			first_day_date VARCHAR(255),
+			cachet_maker VARCHAR(255),
+			cachet_type VARCHAR(50),
+			image_url VARCHAR(512),
+			notes TEXT,
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			FOREIGN KEY (stamp_id) REFERENCES stamps(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS conditions (
+			id VARCHAR(36) PRIMARY KEY,
+			name VARCHAR(255) UNIQUE NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			is_default BOOLEAN NOT NULL DEFAULT false,
+			date_created TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL
+		)`,
+		// Aliases are stored lower-case and map alternative spellings, e.g. "vf", to a condition
+		`CREATE TABLE IF NOT EXISTS condition_aliases (
+			alias VARCHAR(255) PRIMARY KEY,
+			condition_id VARCHAR(36) NOT NULL,
+			FOREIGN KEY (condition_id) REFERENCES conditions(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS instance_certificates (
+			id VARCHAR(36) PRIMARY KEY,
+			instance_id VARCHAR(36) NOT NULL,
+			issuer VARCHAR(255) NOT NULL,
+			certificate_number VARCHAR(100),
+			issued_date VARCHAR(255),
+			opinion TEXT,
+			file_url VARCHAR(512),
+			notes TEXT,
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			FOREIGN KEY (instance_id) REFERENCES stamp_instances(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_instance_certificates_number ON instance_certificates (LOWER(certificate_number))`,
+		`CREATE TABLE IF NOT EXISTS instance_provenance (
+			id VARCHAR(36) PRIMARY KEY,
+			instance_id VARCHAR(36) NOT NULL,
+			owner VARCHAR(255),
+			auction_house VARCHAR(255),
+			sale_name VARCHAR(255),
+			lot_number VARCHAR(50),
+			acquired_date VARCHAR(255),
+			notes TEXT,
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			FOREIGN KEY (instance_id) REFERENCES stamp_instances(id) ON DELETE CASCADE
+		)`,
+		// An attachment belongs to either a stamp design or a group of copies
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id VARCHAR(36) PRIMARY KEY,
+			stamp_id VARCHAR(36),
+			instance_id VARCHAR(36),
+			attachment_type VARCHAR(50) NOT NULL DEFAULT 'other',
+			caption TEXT,
+			position INTEGER NOT NULL DEFAULT 0,
+			filename VARCHAR(255) NOT NULL,
+			content_type VARCHAR(100) NOT NULL,
+			size_bytes BIGINT NOT NULL,
+			file_url VARCHAR(512) NOT NULL,
+			thumbnail_url VARCHAR(512),
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			CHECK ((stamp_id IS NULL) <> (instance_id IS NULL)),
+			FOREIGN KEY (stamp_id) REFERENCES stamps(id) ON DELETE CASCADE,
+			FOREIGN KEY (instance_id) REFERENCES stamp_instances(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_stamp_id ON attachments (stamp_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_instance_id ON attachments (instance_id)`,
+		// Like attachments, an image belongs to either a stamp design or a group of copies, each of which
+		// has at most one primary image
+		`CREATE TABLE IF NOT EXISTS stamp_images (
+			id VARCHAR(36) PRIMARY KEY,
+			stamp_id VARCHAR(36),
+			instance_id VARCHAR(36),
+			image_type VARCHAR(50) NOT NULL DEFAULT 'front',
+			caption TEXT,
+			position INTEGER NOT NULL DEFAULT 0,
+			is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+			file_url VARCHAR(512) NOT NULL,
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			CHECK ((stamp_id IS NULL) <> (instance_id IS NULL)),
+			FOREIGN KEY (stamp_id) REFERENCES stamps(id) ON DELETE CASCADE,
+			FOREIGN KEY (instance_id) REFERENCES stamp_instances(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stamp_images_stamp_id ON stamp_images (stamp_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_stamp_images_instance_id ON stamp_images (instance_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_stamp_images_primary_stamp ON stamp_images (stamp_id) WHERE is_primary`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_stamp_images_primary_instance ON stamp_images (instance_id) WHERE is_primary`,
+		// Every upload of an image is kept as a version; the image's file_url is the version it shows
+		`CREATE TABLE IF NOT EXISTS image_versions (
+			id VARCHAR(36) PRIMARY KEY,
+			image_id VARCHAR(36) NOT NULL,
+			file_url VARCHAR(512) NOT NULL,
+			uploaded_by VARCHAR(255),
+			date_uploaded TIMESTAMP NOT NULL,
+			FOREIGN KEY (image_id) REFERENCES stamp_images(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_versions_image_id ON image_versions (image_id, date_uploaded)`,
+		// Perceptual hashes of image files, for finding stamps by a photo. A NULL hash marks a file that
+		// couldn't be read, so it isn't tried again.
+		`CREATE TABLE IF NOT EXISTS image_hashes (
+			file_url VARCHAR(512) PRIMARY KEY,
+			phash BIGINT,
+			date_hashed TIMESTAMP NOT NULL
+		)`,
+		// The centering of a group of copies as measured from one of its scans. Margins are fractions of the
+		// stamp's width or height.
+		`CREATE TABLE IF NOT EXISTS instance_measurements (
+			instance_id VARCHAR(36) PRIMARY KEY,
+			image_id VARCHAR(36),
+			margin_left REAL NOT NULL,
+			margin_right REAL NOT NULL,
+			margin_top REAL NOT NULL,
+			margin_bottom REAL NOT NULL,
+			suggested_centering VARCHAR(10),
+			date_measured TIMESTAMP NOT NULL,
+			FOREIGN KEY (instance_id) REFERENCES stamp_instances(id) ON DELETE CASCADE,
+			FOREIGN KEY (image_id) REFERENCES stamp_images(id) ON DELETE SET NULL
+		)`,
+		// The size and perforation gauge are measured too when the scan's resolution is known, so a copy whose
+		// design can't be found has no margins. A gauge of 0 is a straight edge and NULL one that couldn't be read.
+		`ALTER TABLE instance_measurements ALTER COLUMN margin_left DROP NOT NULL`,
+		`ALTER TABLE instance_measurements ALTER COLUMN margin_right DROP NOT NULL`,
+		`ALTER TABLE instance_measurements ALTER COLUMN margin_top DROP NOT NULL`,
+		`ALTER TABLE instance_measurements ALTER COLUMN margin_bottom DROP NOT NULL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS dpi REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS width_mm REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS height_mm REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS gauge_top REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS gauge_bottom REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS gauge_left REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS gauge_right REAL`,
+		`ALTER TABLE instance_measurements ADD COLUMN IF NOT EXISTS perforation VARCHAR(50)`,
+		// Regions marked on one version of an image, such as a plate crack or a thin spot. The rectangle is in
+		// fractions of the image's width and height, from its top left corner.
+		`CREATE TABLE IF NOT EXISTS image_annotations (
+			id VARCHAR(36) PRIMARY KEY,
+			version_id VARCHAR(36) NOT NULL,
+			x REAL NOT NULL,
+			y REAL NOT NULL,
+			width REAL NOT NULL,
+			height REAL NOT NULL,
+			label VARCHAR(100) NOT NULL,
+			note TEXT,
+			date_added TIMESTAMP NOT NULL,
+			date_modified TIMESTAMP NOT NULL,
+			FOREIGN KEY (version_id) REFERENCES image_versions(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_annotations_version_id ON image_annotations (version_id)`,
+		// The main colours of image files, for finding stamps by colour. The hue and lightness are those of the
+		// ink, for sorting by colour, and NULL for a file that couldn't be read or is all paper. Colours are
+		// kept in L*a*b* as well as hex, so their distance to a swatch can be worked out in SQL.
+		`CREATE TABLE IF NOT EXISTS image_palettes (
+			file_url VARCHAR(512) PRIMARY KEY,
+			hue REAL,
+			lightness REAL,
+			date_extracted TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS image_colours (
+			file_url VARCHAR(512) NOT NULL,
+			position INTEGER NOT NULL,
+			colour CHAR(7) NOT NULL,
+			l REAL NOT NULL,
+			a REAL NOT NULL,
+			b REAL NOT NULL,
+			share REAL NOT NULL,
+			PRIMARY KEY (file_url, position),
+			FOREIGN KEY (file_url) REFERENCES image_palettes(file_url) ON DELETE CASCADE
+		)`,
 	}
 
 	for _, query := range queries {
@@ -58,5 +314,140 @@ func Migrate(db *sql.DB) error {
 		}
 	}
 
+	if err := linkStampSeries(db); err != nil {
+		return fmt.Errorf("failed to link stamps to series: %v", err)
+	}
+
+	if err := seedConditions(db); err != nil {
+		return fmt.Errorf("failed to seed conditions: %v", err)
+	}
+
+	if err := importStampImages(db); err != nil {
+		return fmt.Errorf("failed to import stamp images: %v", err)
+	}
+
+	if err := groupStampInstances(db); err != nil {
+		return fmt.Errorf("failed to group stamp copies: %v", err)
+	}
+
+	return nil
+}
+
+// sameGroup matches a copy f with a copy t of the same stamp, condition, box, format and grading, counting
+// missing values as equal
+const sameGroup = `f.stamp_id = t.stamp_id AND f.condition IS NOT DISTINCT FROM t.condition AND f.box_id IS NOT DISTINCT FROM t.box_id
+	AND f.format = t.format AND f.plate_number IS NOT DISTINCT FROM t.plate_number AND f.gum IS NOT DISTINCT FROM t.gum
+	AND f.centering IS NOT DISTINCT FROM t.centering AND f.grade IS NOT DISTINCT FROM t.grade
+	AND f.date_deleted IS NULL AND t.date_deleted IS NULL`
+
+// groupStampInstances creates the unique index that keeps one group of copies per stamp, condition, box,
+// format and grading. Groups that were duplicated before it existed are first folded into the earliest one,
+// adding up their quantities and moving their certificates, provenance, attachments and images; their
+// components and measurements are those of the group kept. NULLS NOT DISTINCT needs PostgreSQL 15 or later.
+func groupStampInstances(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass('stamp_instances_group_key') IS NOT NULL`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// t is the group kept when no earlier copy f matches it, and f a duplicate when an earlier copy t does
+	const duplicate = `EXISTS (SELECT 1 FROM stamp_instances t WHERE ` + sameGroup + ` AND t.id < f.id)`
+	const keptID = `(SELECT MIN(t.id) FROM stamp_instances t WHERE ` + sameGroup + `)`
+	queries := []string{
+		`UPDATE stamp_instances t
+		    SET quantity = t.quantity + (SELECT SUM(f.quantity) FROM stamp_instances f WHERE ` + sameGroup + ` AND f.id > t.id),
+		        date_modified = NOW()
+		  WHERE EXISTS (SELECT 1 FROM stamp_instances f WHERE ` + sameGroup + ` AND f.id > t.id)
+		    AND NOT EXISTS (SELECT 1 FROM stamp_instances f WHERE ` + sameGroup + ` AND f.id < t.id)`,
+	}
+	for _, table := range []string{"instance_certificates", "instance_provenance", "attachments", "stamp_images"} {
+		set := `instance_id = ` + keptID
+		if table == "stamp_images" {
+			// The kept group's primary image stays its primary image
+			set += `, is_primary = FALSE`
+		}
+		queries = append(queries, `UPDATE `+table+` r
+			   SET `+set+`
+			  FROM stamp_instances f
+			 WHERE r.instance_id = f.id AND `+duplicate)
+	}
+	queries = append(queries,
+		`DELETE FROM stamp_instances f WHERE `+duplicate,
+		`CREATE UNIQUE INDEX stamp_instances_group_key
+			ON stamp_instances (stamp_id, condition, box_id, format, plate_number, gum, centering, grade)
+			NULLS NOT DISTINCT WHERE date_deleted IS NULL`,
+	)
+
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// linkStampSeries creates a series for every free-text series name that doesn't have one yet
+// and links the stamps using that name to it
+func linkStampSeries(db *sql.DB) error {
+	queries := []string{
+		`INSERT INTO series (id, name, date_created, date_modified)
+		SELECT gen_random_uuid()::text, names.series, NOW(), NOW()
+		  FROM (SELECT DISTINCT s.series FROM stamps s WHERE s.series IS NOT NULL AND s.series <> '') names
+		ON CONFLICT (name) DO NOTHING`,
+		`UPDATE stamps s SET series_id = se.id
+		  FROM series se
+		 WHERE s.series_id IS NULL AND s.series = se.name`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seedConditions fills an empty condition list with the conditions the copies table used to offer
+func seedConditions(db *sql.DB) error {
+	_, err := db.Exec(`INSERT INTO conditions (id, name, position, date_created, date_modified)
+		SELECT gen_random_uuid()::text, defaults.name, defaults.position, NOW(), NOW()
+		  FROM (VALUES ('Mint', 1), ('Used', 2), ('Damaged', 3), ('Fine', 4), ('Very Fine', 5), ('Excellent', 6))
+		       AS defaults (name, position)
+		 WHERE NOT EXISTS (SELECT 1 FROM conditions)`)
+	return err
+}
+
+// importStampImages starts the gallery of every stamp that has an image but no gallery yet with that image,
+// and the version history of every image that has none with its current file
+func importStampImages(db *sql.DB) error {
+	queries := []string{
+		`INSERT INTO stamp_images (id, stamp_id, image_type, position, is_primary, file_url, date_added, date_modified)
+		SELECT gen_random_uuid()::text, s.id, 'front', 1, TRUE, s.image_url, s.date_modified, s.date_modified
+		  FROM stamps s
+		 WHERE s.image_url IS NOT NULL AND s.image_url <> ''
+		   AND NOT EXISTS (SELECT 1 FROM stamp_images i WHERE i.stamp_id = s.id)`,
+		`INSERT INTO image_versions (id, image_id, file_url, date_uploaded)
+		SELECT gen_random_uuid()::text, i.id, i.file_url, i.date_modified
+		  FROM stamp_images i
+		 WHERE NOT EXISTS (SELECT 1 FROM image_versions v WHERE v.image_id = i.id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -46,14 +46,264 @@ func (qb *QueryBuilder) GetQuery() (string, []interface{}) {
 
 // AddSearchFilter adds search conditions for name, scott_number, and series columns
 func (qb *QueryBuilder) AddSearchFilter(searchTerm string, tableAlias string) {
+	qb.AddTextFilter(searchTerm, tableAlias, false)
+}
+
+// EscapeLike escapes the LIKE wildcards in s so that e.g. "100%" matches literally
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// AddTextFilter matches (or with negate, excludes) a term against the name, scott_number, and series columns
+func (qb *QueryBuilder) AddTextFilter(searchTerm string, tableAlias string, negate bool) {
 	if searchTerm != "" {
-		searchParam := "%" + searchTerm + "%"
-		qb.AddCondition(fmt.Sprintf(` AND (LOWER(%s.name) LIKE LOWER(?) OR LOWER(%s.scott_number) LIKE LOWER(?) OR LOWER(%s.series) LIKE LOWER(?))`,
-			tableAlias, tableAlias, tableAlias),
+		searchParam := "%" + EscapeLike(searchTerm) + "%"
+		qb.addNegatableCondition(fmt.Sprintf(`LOWER(COALESCE(%s.name, '')) LIKE LOWER(?) OR LOWER(COALESCE(%s.scott_number, '')) LIKE LOWER(?) OR LOWER(COALESCE(%s.series, '')) LIKE LOWER(?)`,
+			tableAlias, tableAlias, tableAlias), negate,
 			searchParam, searchParam, searchParam)
 	}
 }
 
+// AddColumnLikeFilter adds a case-insensitive substring match on a single column
+func (qb *QueryBuilder) AddColumnLikeFilter(column string, value string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`LOWER(%s) LIKE LOWER(?)`, column), negate, "%"+EscapeLike(value)+"%")
+}
+
+// AddTagNameFilter adds a condition matching stamps that carry a tag with the given name
+func (qb *QueryBuilder) AddTagNameFilter(tagName string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_tags st JOIN tags t ON t.id = st.tag_id 
+		WHERE st.stamp_id = %s.id AND LOWER(t.name) = LOWER(?))`, tableAlias), negate, tagName)
+}
+
+// AddBoxNameFilter adds a condition matching stamps with a copy stored in the named box
+func (qb *QueryBuilder) AddBoxNameFilter(boxName string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_instances si JOIN storage_boxes sb ON sb.id = si.box_id 
+		WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND LOWER(sb.name) = LOWER(?))`, tableAlias), negate, boxName)
+}
+
+// AddConditionFilter adds a condition matching stamps with a copy in the given condition, given by name or alias
+func (qb *QueryBuilder) AddConditionFilter(condition string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_instances si 
+		WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND (LOWER(si.condition) = LOWER(?)
+		   OR si.condition IN (SELECT c.name FROM condition_aliases ca JOIN conditions c ON c.id = ca.condition_id WHERE ca.alias = LOWER(?))))`, tableAlias),
+		negate, condition, condition)
+}
+
+// AddInstanceColumnFilter adds a condition matching stamps with a copy whose grading column, e.g. gum, has the given value
+func (qb *QueryBuilder) AddInstanceColumnFilter(column, value string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_instances si 
+		WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND LOWER(si.%s) = LOWER(?))`, tableAlias, column), negate, value)
+}
+
+// AddFaultFilter adds a condition matching stamps with a copy that has the given fault
+func (qb *QueryBuilder) AddFaultFilter(fault string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_instances si 
+		WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND LOWER(?) = ANY(si.faults))`, tableAlias), negate, fault)
+}
+
+// AddCertificateFilter adds a condition matching stamps with a copy that has a certificate with the given number
+func (qb *QueryBuilder) AddCertificateFilter(number string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_instances si 
+		JOIN instance_certificates ic ON ic.instance_id = si.id
+		WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND LOWER(ic.certificate_number) = LOWER(?))`, tableAlias), negate, number)
+}
+
+// AddAnnotationFilter adds a condition matching stamps with an image, of the design or of a copy, that has an
+// annotation whose label or note contains the text. Only annotations on the version each image shows count.
+func (qb *QueryBuilder) AddAnnotationFilter(text string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`EXISTS (SELECT 1 FROM image_annotations ia 
+		JOIN image_versions iv ON iv.id = ia.version_id
+		JOIN stamp_images img ON img.id = iv.image_id AND img.file_url = iv.file_url
+		LEFT JOIN stamp_instances si ON si.id = img.instance_id AND si.date_deleted IS NULL
+		WHERE (img.stamp_id = %s.id OR si.stamp_id = %s.id)
+		  AND (LOWER(ia.label) LIKE LOWER(?) OR LOWER(COALESCE(ia.note, '')) LIKE LOWER(?)))`, tableAlias, tableAlias),
+		negate, "%"+EscapeLike(text)+"%", "%"+EscapeLike(text)+"%")
+}
+
+// AddGradeRangeFilter adds a condition matching stamps with a copy graded within the bounds; nil bounds are open-ended
+func (qb *QueryBuilder) AddGradeRangeFilter(min, max *int, tableAlias string, negate bool) {
+	exists := fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_instances si 
+		WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND si.grade`, tableAlias)
+	switch {
+	case min != nil && max != nil:
+		qb.addNegatableCondition(exists+` BETWEEN ? AND ?)`, negate, *min, *max)
+	case min != nil:
+		qb.addNegatableCondition(exists+` >= ?)`, negate, *min)
+	case max != nil:
+		qb.addNegatableCondition(exists+` <= ?)`, negate, *max)
+	}
+}
+
+// AddInstanceExistsFilter adds a condition for stamps that do (owned) or do not have any copies
+func (qb *QueryBuilder) AddInstanceExistsFilter(owned bool, tableAlias string) {
+	existsClause := OwnedExpr(tableAlias + ".id")
+	if owned {
+		qb.AddCondition(` AND ` + existsClause)
+	} else {
+		qb.AddCondition(` AND NOT ` + existsClause)
+	}
+}
+
+// OwnedExpr returns a SQL condition that is true when the stamp with the given ID has copies of its own,
+// is one of the designs in a copy of a multiple such as a souvenir sheet, or is on a cover
+func OwnedExpr(stampIDColumn string) string {
+	return fmt.Sprintf(`(EXISTS (SELECT 1 FROM stamp_instances osi WHERE osi.stamp_id = %[1]s AND osi.date_deleted IS NULL)
+		OR EXISTS (SELECT 1 FROM instance_components oic JOIN stamp_instances osi ON osi.id = oic.instance_id
+		            WHERE oic.stamp_id = %[1]s AND osi.date_deleted IS NULL)
+		OR %[2]s)`, stampIDColumn, OnCoverExpr(stampIDColumn))
+}
+
+// OnCoverExpr returns a SQL condition that is true when the stamp with the given ID is affixed to a cover
+func OnCoverExpr(stampIDColumn string) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM cover_stamps ocs JOIN covers oc ON oc.id = ocs.cover_id
+		            WHERE ocs.stamp_id = %s AND oc.date_deleted IS NULL)`, stampIDColumn)
+}
+
+// AddOnCoverFilter adds a condition for stamps that are (or with onCover false, are not) on any cover
+func (qb *QueryBuilder) AddOnCoverFilter(onCover bool, tableAlias string) {
+	if onCover {
+		qb.AddCondition(` AND ` + OnCoverExpr(tableAlias+".id"))
+	} else {
+		qb.AddCondition(` AND NOT ` + OnCoverExpr(tableAlias+".id"))
+	}
+}
+
+// AddScottNumberFilter adds an exact, case-insensitive match on the Scott number
+func (qb *QueryBuilder) AddScottNumberFilter(scottNumber string, tableAlias string, negate bool) {
+	qb.addNegatableCondition(fmt.Sprintf(`LOWER(%s.scott_number) = LOWER(?)`, tableAlias), negate, scottNumber)
+}
+
+// AddScottRangeFilter adds a condition on the numeric part of the Scott number; nil bounds are open-ended
+func (qb *QueryBuilder) AddScottRangeFilter(min, max *int, tableAlias string, negate bool) {
+	expr := fmt.Sprintf(`CASE WHEN %s.scott_number ~ '^\d+' THEN CAST(SUBSTRING(%s.scott_number FROM '\d+') AS INTEGER) END`,
+		tableAlias, tableAlias)
+	qb.addRangeCondition(expr, min, max, negate)
+}
+
+// AddYearRangeFilter adds a condition on the year of the issue date; nil bounds are open-ended
+func (qb *QueryBuilder) AddYearRangeFilter(min, max *int, tableAlias string, negate bool) {
+	qb.addRangeCondition(IssueYearExpr(tableAlias), min, max, negate)
+}
+
+// NearColourExpr returns a SQL condition that is true when the stamp with the given ID has a front or primary
+// image, of the design or of a copy, one of whose main colours covers at least minShare of it and is within
+// maxDistance of the L*a*b* colour given by the expressions l, a and b
+func NearColourExpr(stampIDColumn, l, a, b string, maxDistance, minShare float64) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM stamp_images nimg
+		JOIN image_colours nic ON nic.file_url = nimg.file_url
+		LEFT JOIN stamp_instances nsi ON nsi.id = nimg.instance_id AND nsi.date_deleted IS NULL
+		WHERE (nimg.stamp_id = %[1]s OR nsi.stamp_id = %[1]s) AND (nimg.image_type = 'front' OR nimg.is_primary)
+		  AND nic.share >= %[5]g
+		  AND power(nic.l - %[2]s, 2) + power(nic.a - %[3]s, 2) + power(nic.b - %[4]s, 2) <= %[6]g)`,
+		stampIDColumn, l, a, b, minShare, maxDistance*maxDistance)
+}
+
+// IssueYearExpr returns a SQL expression for the integer year of a stamp's issue date, or NULL if unknown
+func IssueYearExpr(tableAlias string) string {
+	return YearExpr(tableAlias + ".issue_date")
+}
+
+// YearExpr returns a SQL expression for the integer year of a free-text date column starting "YYYY", or NULL if unknown
+func YearExpr(column string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s ~ '^\d{4}' THEN CAST(SUBSTRING(%[1]s FROM 1 FOR 4) AS INTEGER) END`, column)
+}
+
+// AddYearBetweenFilter adds a condition on the year of a free-text date column; nil bounds are open-ended
+func (qb *QueryBuilder) AddYearBetweenFilter(column string, min, max *int) {
+	qb.addRangeCondition(YearExpr(column), min, max, false)
+}
+
+// AddTagsAnyFilter adds a condition matching stamps that carry any of the named tags
+func (qb *QueryBuilder) AddTagsAnyFilter(tagNames []string, tableAlias string) {
+	if len(tagNames) > 0 {
+		qb.AddCondition(fmt.Sprintf(` AND EXISTS (SELECT 1 FROM stamp_tags st JOIN tags t ON t.id = st.tag_id 
+			WHERE st.stamp_id = %s.id AND t.name IN (%s))`, tableAlias, placeholders(len(tagNames))), toArgs(tagNames)...)
+	}
+}
+
+// AddBoxesAnyFilter adds a condition matching stamps with a copy in any of the given boxes
+func (qb *QueryBuilder) AddBoxesAnyFilter(boxIDs []string, tableAlias string) {
+	if len(boxIDs) > 0 {
+		qb.AddCondition(fmt.Sprintf(` AND EXISTS (SELECT 1 FROM stamp_instances si 
+			WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND si.box_id IN (%s))`, tableAlias, placeholders(len(boxIDs))), toArgs(boxIDs)...)
+	}
+}
+
+// AddConditionsAnyFilter adds a condition matching stamps with a copy in any of the given conditions
+func (qb *QueryBuilder) AddConditionsAnyFilter(conditions []string, tableAlias string) {
+	if len(conditions) > 0 {
+		qb.AddCondition(fmt.Sprintf(` AND EXISTS (SELECT 1 FROM stamp_instances si 
+			WHERE si.stamp_id = %s.id AND si.date_deleted IS NULL AND si.condition IN (%s))`, tableAlias, placeholders(len(conditions))), toArgs(conditions)...)
+	}
+}
+
+// AddColumnInFilter adds a condition matching rows whose column equals any of the values
+func (qb *QueryBuilder) AddColumnInFilter(column string, values []string) {
+	if len(values) > 0 {
+		qb.AddCondition(fmt.Sprintf(` AND %s IN (%s)`, column, placeholders(len(values))), toArgs(values)...)
+	}
+}
+
+// AddDecadesFilter adds a condition matching stamps issued in any of the given decades (e.g. 1890)
+func (qb *QueryBuilder) AddDecadesFilter(decades []int, tableAlias string) {
+	if len(decades) > 0 {
+		args := make([]interface{}, len(decades))
+		for i, d := range decades {
+			args[i] = d
+		}
+		qb.AddCondition(fmt.Sprintf(` AND (%s) / 10 * 10 IN (%s)`, IssueYearExpr(tableAlias), placeholders(len(decades))), args...)
+	}
+}
+
+// AddColoursAnyFilter adds a condition matching stamps with a colour within maxDistance of any of the
+// L*a*b* colours, as NearColourExpr
+func (qb *QueryBuilder) AddColoursAnyFilter(colours [][3]float64, maxDistance, minShare float64, tableAlias string) {
+	if len(colours) > 0 {
+		near := make([]string, len(colours))
+		var args []interface{}
+		for i, c := range colours {
+			near[i] = NearColourExpr(tableAlias+".id", "?", "?", "?", maxDistance, minShare)
+			args = append(args, c[0], c[1], c[2])
+		}
+		qb.AddCondition(` AND (`+strings.Join(near, " OR ")+`)`, args...)
+	}
+}
+
+// placeholders returns n comma-separated ? placeholders for use in an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// addRangeCondition compares an integer SQL expression against optional lower and upper bounds
+func (qb *QueryBuilder) addRangeCondition(expr string, min, max *int, negate bool) {
+	switch {
+	case min != nil && max != nil:
+		qb.addNegatableCondition(fmt.Sprintf(`%s BETWEEN ? AND ?`, expr), negate, *min, *max)
+	case min != nil:
+		qb.addNegatableCondition(fmt.Sprintf(`%s >= ?`, expr), negate, *min)
+	case max != nil:
+		qb.addNegatableCondition(fmt.Sprintf(`%s <= ?`, expr), negate, *max)
+	}
+}
+
+// addNegatableCondition adds a boolean expression, or its negation; negated expressions treat NULL as false
+// so that e.g. "-series:foo" still matches stamps without a series
+func (qb *QueryBuilder) addNegatableCondition(expr string, negate bool, values ...interface{}) {
+	if negate {
+		qb.AddCondition(` AND NOT COALESCE((`+expr+`), false)`, values...)
+	} else {
+		qb.AddCondition(` AND (`+expr+`)`, values...)
+	}
+}
+
 // AddBoxFilter adds a condition to filter by box_id
 func (qb *QueryBuilder) AddBoxFilter(boxID string, instanceAlias string) {
 	if boxID != "" {
@@ -87,6 +337,12 @@ func (qb *QueryBuilder) AddSortAndLimit(sort, order string, limit, offset int, t
 		qb.AddCondition(fmt.Sprintf(` ORDER BY %s.issue_date %s%s`, tableAlias, orderDir, secondarySort))
 	case "date_added":
 		qb.AddCondition(fmt.Sprintf(` ORDER BY %s.date_added %s%s`, tableAlias, orderDir, secondarySort))
+	case "colour":
+		// By the hue of the ink of the stamp's image, round the colour wheel from red, then black and grey inks
+		// from dark to light, then stamps whose colour isn't known
+		qb.AddCondition(fmt.Sprintf(` ORDER BY (SELECT p.hue FROM image_palettes p WHERE p.file_url = %[1]s.image_url) %[2]s NULLS LAST,
+			(SELECT p.lightness FROM image_palettes p WHERE p.file_url = %[1]s.image_url) %[2]s NULLS LAST%[3]s`,
+			tableAlias, orderDir, secondarySort))
 	default:
 		qb.AddCondition(fmt.Sprintf(` 
 			ORDER BY CASE WHEN %s.scott_number ~ '^\d+' THEN 
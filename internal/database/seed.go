@@ -124,5 +124,6 @@ func Seed(db *sql.DB) error {
 		}
 	}
 
-	return nil
+	// Give the sample series names their own series records
+	return linkStampSeries(db)
 }
\ No newline at end of file
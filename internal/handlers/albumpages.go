@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// albumPagesDir is where split album pages wait to be assigned: each page's scan, a preview of it and its
+// crops are kept under <dir>/<page id>/
+const albumPagesDir = "splits"
+
+// maxAlbumPagePixels limits the size of page scans, which are decoded whole: a letter-size page at 600 DPI
+// is about 34 million pixels
+const maxAlbumPagePixels = 150_000_000
+
+// albumPagePreviewSize is the longest side, in pixels, of the preview shown while assigning a page's crops
+const albumPagePreviewSize = 1200
+
+// AlbumPageHandler splits scans of whole album pages into an image of each stamp on them
+type AlbumPageHandler struct {
+	db           *sql.DB
+	templates    *template.Template
+	stampService *services.StampService
+	imageService *services.ImageService
+	index        *imageIndex
+}
+
+func NewAlbumPageHandler(db *sql.DB, templates *template.Template) *AlbumPageHandler {
+	return &AlbumPageHandler{
+		db:           db,
+		templates:    templates,
+		stampService: services.NewStampService(db),
+		imageService: services.NewImageService(db),
+		index:        newImageIndex(db),
+	}
+}
+
+// GetAlbumPagesView renders the page upload form and the pages still waiting for their crops to be assigned
+func (h *AlbumPageHandler) GetAlbumPagesView(w http.ResponseWriter, r *http.Request) {
+	h.renderAlbumPages(w, "", "")
+}
+
+// GetAlbumPage renders the assignment screen of a split page
+func (h *AlbumPageHandler) GetAlbumPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	page, err := loadAlbumPage(vars["id"])
+	if err != nil {
+		writeAlbumPageError(w, err)
+		return
+	}
+	h.renderAlbumPage(w, page, "")
+}
+
+// SplitAlbumPage saves the uploaded "page" scan, crops out each stamp found on it and renders the screen
+// for assigning the crops to stamps
+func (h *AlbumPageHandler) SplitAlbumPage(w http.ResponseWriter, r *http.Request) {
+	pageID := uuid.New().String()
+
+	saved, _, err := saveUploadedFile(r, pageScanUpload, albumPagesDir+"/"+pageID, "page")
+	if err != nil {
+		h.renderAlbumPages(w, err.Error(), "")
+		return
+	}
+
+	log.Printf("handlers.albumpages.SplitAlbumPage: %v: %v (%d bytes)", pageID, saved.Filename, saved.Size)
+
+	page, err := splitAlbumPage(pageID, saved.Key)
+	if err != nil {
+		removeAlbumPage(pageID)
+		h.renderAlbumPages(w, err.Error(), "")
+		return
+	}
+	h.renderAlbumPage(w, page, "")
+}
+
+// AssignAlbumPage saves the crops of a page as images of the stamps chosen for them, creating stamps where
+// asked, and removes the page. Each crop's form fields are suffixed with its number: "action_1" is "skip",
+// "existing" or "new"; "scott_number_1" picks the existing stamp or numbers the new one, "name_1" names a new
+// stamp and "type_1" is the image type.
+func (h *AlbumPageHandler) AssignAlbumPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	page, err := loadAlbumPage(vars["id"])
+	if err != nil {
+		writeAlbumPageError(w, err)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range page.Crops {
+		crop := &page.Crops[i]
+		crop.Action = r.FormValue(fmt.Sprintf("action_%d", crop.Number))
+		crop.ScottNumber = strings.TrimSpace(r.FormValue(fmt.Sprintf("scott_number_%d", crop.Number)))
+		crop.Name = strings.TrimSpace(r.FormValue(fmt.Sprintf("name_%d", crop.Number)))
+		crop.ImageType = r.FormValue(fmt.Sprintf("type_%d", crop.Number))
+	}
+
+	stampIDs, err := h.resolveAlbumPageStamps(page)
+	if err != nil {
+		h.renderAlbumPage(w, page, err.Error())
+		return
+	}
+
+	// Create the new stamps first, so crops given their Scott numbers as existing can be added to them too
+	cropStamps := map[int]string{}
+	for _, crop := range page.Crops {
+		if crop.Action != "new" {
+			continue
+		}
+		if stampID, ok := stampIDs[crop.ScottNumber]; ok && crop.ScottNumber != "" {
+			cropStamps[crop.Number] = stampID
+			continue
+		}
+
+		stampID, err := h.createAlbumPageStamp(crop)
+		if err != nil {
+			log.Printf("handlers.albumpages.AssignAlbumPage: creating stamp for crop %d: %v", crop.Number, err)
+			h.renderRemainingCrops(w, page.ID, fmt.Sprintf("Error creating the stamp for crop %d", crop.Number))
+			return
+		}
+		if crop.ScottNumber != "" {
+			stampIDs[crop.ScottNumber] = stampID
+		}
+		cropStamps[crop.Number] = stampID
+	}
+
+	saved := 0
+	for _, crop := range page.Crops {
+		if crop.Action == "skip" {
+			continue
+		}
+
+		stampID := cropStamps[crop.Number]
+		if stampID == "" {
+			stampID = stampIDs[crop.ScottNumber]
+		}
+		if err := h.saveAlbumPageCrop(r, page.ID, crop, stampID); err != nil {
+			log.Printf("handlers.albumpages.AssignAlbumPage: saving crop %d: %v", crop.Number, err)
+			h.renderRemainingCrops(w, page.ID, fmt.Sprintf("Error saving crop %d", crop.Number))
+			return
+		}
+		saved++
+	}
+
+	log.Printf("handlers.albumpages.AssignAlbumPage: %v: saved %d of %d crops", page.ID, saved, len(page.Crops))
+
+	removeAlbumPage(page.ID)
+	h.renderAlbumPages(w, "", fmt.Sprintf("Saved %d stamp images from the page.", saved))
+}
+
+// DeleteAlbumPage discards a split page and its crops without saving any of them
+func (h *AlbumPageHandler) DeleteAlbumPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pageID := vars["id"]
+
+	if _, err := uuid.Parse(pageID); err != nil {
+		http.Error(w, "Album page not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("handlers.albumpages.DeleteAlbumPage: %v", pageID)
+
+	removeAlbumPage(pageID)
+	h.renderAlbumPages(w, "", "")
+}
+
+// resolveAlbumPageStamps checks what was chosen for each crop of a page and looks up the existing stamps
+// they go to, by Scott number. Crops of a new stamp may share its Scott number, or other crops may name it
+// as existing, to add several images to the stamp being created.
+func (h *AlbumPageHandler) resolveAlbumPageStamps(page *models.AlbumPage) (map[string]string, error) {
+	stampIDs := map[string]string{}
+	created := map[string]bool{}
+
+	for i := range page.Crops {
+		crop := &page.Crops[i]
+
+		image := models.StampImage{Type: crop.ImageType}
+		if err := services.ValidateImage(&image); err != nil {
+			return nil, fmt.Errorf("Crop %d: %v", crop.Number, err)
+		}
+		crop.ImageType = image.Type
+
+		switch crop.Action {
+		case "skip":
+		case "new":
+			if crop.Name == "" {
+				return nil, fmt.Errorf("Crop %d: enter a name for the new stamp", crop.Number)
+			}
+			if crop.ScottNumber == "" {
+				continue
+			}
+			if _, err := h.stampService.GetStampIDByScottNumber(crop.ScottNumber); err == nil || errors.Is(err, services.ErrAmbiguousScottNumber) {
+				return nil, fmt.Errorf("Crop %d: a stamp with Scott number %s already exists; add the crop to it instead", crop.Number, crop.ScottNumber)
+			} else if err != sql.ErrNoRows {
+				return nil, err
+			}
+			created[crop.ScottNumber] = true
+		case "existing":
+			if crop.ScottNumber == "" {
+				return nil, fmt.Errorf("Crop %d: enter the Scott number of its stamp, or skip it", crop.Number)
+			}
+		default:
+			return nil, fmt.Errorf("Crop %d: choose what to do with it", crop.Number)
+		}
+	}
+
+	for _, crop := range page.Crops {
+		if crop.Action != "existing" || created[crop.ScottNumber] {
+			continue
+		}
+		if _, ok := stampIDs[crop.ScottNumber]; ok {
+			continue
+		}
+		stampID, err := h.stampService.GetStampIDByScottNumber(crop.ScottNumber)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("Crop %d: no stamp has Scott number %s", crop.Number, crop.ScottNumber)
+		}
+		if errors.Is(err, services.ErrAmbiguousScottNumber) {
+			return nil, fmt.Errorf("Crop %d: several stamps have Scott number %s", crop.Number, crop.ScottNumber)
+		}
+		if err != nil {
+			return nil, err
+		}
+		stampIDs[crop.ScottNumber] = stampID
+	}
+	return stampIDs, nil
+}
+
+// createAlbumPageStamp creates the stamp design a crop was assigned to
+func (h *AlbumPageHandler) createAlbumPageStamp(crop models.AlbumPageCrop) (string, error) {
+	stamp := models.Stamp{
+		ID:           uuid.New().String(),
+		Name:         crop.Name,
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+	if crop.ScottNumber != "" {
+		stamp.ScottNumber = &crop.ScottNumber
+	}
+
+	if _, err := h.stampService.CreateStamp(&stamp); err != nil {
+		return "", err
+	}
+	return stamp.ID, nil
+}
+
+// saveAlbumPageCrop copies a crop into the image storage and adds it to the gallery of a stamp, then deletes
+// it from the page so it isn't saved twice if a later crop fails
+func (h *AlbumPageHandler) saveAlbumPageCrop(r *http.Request, pageID string, crop models.AlbumPageCrop, stampID string) error {
+	image := models.StampImage{
+		ID:           uuid.New().String(),
+		StampID:      &stampID,
+		Type:         crop.ImageType,
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+
+	key := "stamps/" + image.ID + ".jpg"
+	if err := copyStoredFile(albumCropKey(pageID, crop.Number), key); err != nil {
+		return err
+	}
+	makeImageVariants(key, h.index)
+
+	image.FileURL = storage.URL(key)
+	if _, err := h.imageService.CreateImage(&image, uploaderName(r)); err != nil {
+		removeImageFiles([]string{image.FileURL})
+		return err
+	}
+
+	if err := storage.Files.Delete(albumCropKey(pageID, crop.Number)); err != nil {
+		log.Printf("handlers.albumpages.saveAlbumPageCrop: %v", err)
+	}
+	return nil
+}
+
+// renderRemainingCrops shows the crops of a page not yet saved after a failed assignment
+func (h *AlbumPageHandler) renderRemainingCrops(w http.ResponseWriter, pageID, message string) {
+	page, err := loadAlbumPage(pageID)
+	if err != nil {
+		writeAlbumPageError(w, err)
+		return
+	}
+	h.renderAlbumPage(w, page, message)
+}
+
+func (h *AlbumPageHandler) renderAlbumPage(w http.ResponseWriter, page *models.AlbumPage, message string) {
+	data := models.AlbumPageView{Page: *page, Error: message}
+	if err := h.templates.ExecuteTemplate(w, "album-page.html", data); err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+func (h *AlbumPageHandler) renderAlbumPages(w http.ResponseWriter, message, notice string) {
+	pages, err := listAlbumPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := models.AlbumPagesView{Pages: pages, Error: message, Notice: notice}
+	if err := h.templates.ExecuteTemplate(w, "album-pages-view.html", data); err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// splitAlbumPage finds the stamps on the page scan stored under key and saves a preview of the page and a
+// JPEG crop of each stamp alongside it
+func splitAlbumPage(pageID, key string) (*models.AlbumPage, error) {
+	body, _, err := storage.Files.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("The page scan could not be read")
+	}
+	if config.Width*config.Height > maxAlbumPagePixels {
+		return nil, fmt.Errorf("The page scan is too large (%d x %d pixels); scan at 600 DPI or less", config.Width, config.Height)
+	}
+
+	page, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("The page scan could not be read")
+	}
+
+	boxes := imaging.DetectStamps(page)
+	if len(boxes) == 0 {
+		return nil, errors.New("No stamps were found on the page. Scan it against a plain background that contrasts with the stamps.")
+	}
+
+	dir := albumPagesDir + "/" + pageID
+	if err := imaging.SaveJPEG(storage.Files, dir+"/preview.jpg", imaging.Fit(page, albumPagePreviewSize)); err != nil {
+		log.Printf("handlers.albumpages.splitAlbumPage: %v: %v", pageID, err)
+		return nil, errors.New("Error saving the page")
+	}
+	for i, box := range boxes {
+		if err := imaging.SaveJPEG(storage.Files, albumCropKey(pageID, i+1), imaging.Crop(page, box)); err != nil {
+			log.Printf("handlers.albumpages.splitAlbumPage: %v: %v", pageID, err)
+			return nil, errors.New("Error saving the page")
+		}
+	}
+
+	log.Printf("handlers.albumpages.splitAlbumPage: %v: found %d stamps", pageID, len(boxes))
+	return loadAlbumPage(pageID)
+}
+
+// loadAlbumPage reads a split page back from storage. Pages that don't exist, or have no crops left, are
+// reported as storage.ErrNotFound.
+func loadAlbumPage(pageID string) (*models.AlbumPage, error) {
+	if _, err := uuid.Parse(pageID); err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	dir := albumPagesDir + "/" + pageID
+	keys, err := storage.Files.List(dir + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.AlbumPage{ID: pageID}
+	for _, key := range keys {
+		name := path.Base(key)
+		if name == "preview.jpg" {
+			info, err := storage.Files.Stat(key)
+			if err != nil {
+				return nil, err
+			}
+			page.PreviewURL = storage.URL(key)
+			page.DateUploaded = info.ModTime
+			continue
+		}
+		if strings.HasPrefix(name, "page.") {
+			page.ScanURL = storage.URL(key)
+			continue
+		}
+
+		var number int
+		if _, err := fmt.Sscanf(name, "crop-%d.jpg", &number); err == nil {
+			page.Crops = append(page.Crops, models.AlbumPageCrop{
+				Number:    number,
+				URL:       storage.URL(key),
+				Action:    "existing",
+				ImageType: "front",
+			})
+		}
+	}
+	if page.PreviewURL == "" || len(page.Crops) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	sort.Slice(page.Crops, func(i, j int) bool { return page.Crops[i].Number < page.Crops[j].Number })
+	return page, nil
+}
+
+// listAlbumPages returns the split pages waiting to be assigned, most recently uploaded first
+func listAlbumPages() ([]models.AlbumPage, error) {
+	keys, err := storage.Files.List(albumPagesDir + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []models.AlbumPage
+	for _, key := range keys {
+		if path.Base(key) != "preview.jpg" {
+			continue
+		}
+		page, err := loadAlbumPage(path.Base(path.Dir(key)))
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, *page)
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].DateUploaded.After(pages[j].DateUploaded) })
+	return pages, nil
+}
+
+// removeAlbumPage deletes a split page: its scan, preview and any crops left
+func removeAlbumPage(pageID string) {
+	keys, err := storage.Files.List(albumPagesDir + "/" + pageID + "/")
+	if err != nil {
+		log.Printf("handlers.albumpages.removeAlbumPage: %v: %v", pageID, err)
+		return
+	}
+	for _, key := range keys {
+		if err := storage.Files.Delete(key); err != nil {
+			log.Printf("handlers.albumpages.removeAlbumPage: %v", err)
+		}
+	}
+}
+
+// albumCropKey is where crop number n of a page is stored
+func albumCropKey(pageID string, n int) string {
+	return fmt.Sprintf("%s/%s/crop-%d.jpg", albumPagesDir, pageID, n)
+}
+
+// copyStoredFile copies the stored file under one key to another
+func copyStoredFile(from, to string) error {
+	body, info, err := storage.Files.Get(from)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return storage.Files.Put(to, body, info.ContentType)
+}
+
+// writeAlbumPageError reports a missing page as not found and anything else as a server error
+func writeAlbumPageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Album page not found", http.StatusNotFound)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
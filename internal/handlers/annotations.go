@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+// annotationRectFields are the fields of an annotation's rectangle that can be set through the API
+var annotationRectFields = map[string]func(a *models.ImageAnnotation) *float64{
+	"x":      func(a *models.ImageAnnotation) *float64 { return &a.X },
+	"y":      func(a *models.ImageAnnotation) *float64 { return &a.Y },
+	"width":  func(a *models.ImageAnnotation) *float64 { return &a.Width },
+	"height": func(a *models.ImageAnnotation) *float64 { return &a.Height },
+}
+
+type AnnotationHandler struct {
+	db           *sql.DB
+	templates    *template.Template
+	service      *services.AnnotationService
+	imageService *services.ImageService
+}
+
+func NewAnnotationHandler(db *sql.DB, templates *template.Template) *AnnotationHandler {
+	return &AnnotationHandler{
+		db:           db,
+		templates:    templates,
+		service:      services.NewAnnotationService(db),
+		imageService: services.NewImageService(db),
+	}
+}
+
+// GetImageAnnotations lists the annotations on the version of an image it shows
+func (h *AnnotationHandler) GetImageAnnotations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	if !h.imageExists(w, imageID) {
+		return
+	}
+
+	annotations, err := h.service.GetImageAnnotations(imageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if annotations == nil {
+		annotations = []models.ImageAnnotation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(annotations)
+}
+
+// CreateAnnotation marks a region of the version of an image it shows
+func (h *AnnotationHandler) CreateAnnotation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	if !h.imageExists(w, imageID) {
+		return
+	}
+
+	var annotation models.ImageAnnotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateAnnotation(&annotation); err != nil {
+		writeAnnotationError(w, err)
+		return
+	}
+
+	annotation.ID = uuid.New().String()
+	annotation.ImageID = imageID
+	if annotation.Note != nil {
+		annotation.Note = optionalString(*annotation.Note)
+	}
+	annotation.DateAdded = time.Now()
+	annotation.DateModified = time.Now()
+
+	log.Printf("handlers.annotations.CreateAnnotation: %+v", annotation)
+
+	createdAnnotation, err := h.service.CreateAnnotation(&annotation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdAnnotation)
+}
+
+func (h *AnnotationHandler) UpdateAnnotation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingAnnotation, err := h.service.GetAnnotation(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Annotation not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for field, target := range annotationRectFields {
+		if value, ok := updates[field].(float64); ok {
+			*target(existingAnnotation) = value
+		}
+	}
+	if label, ok := updates["label"].(string); ok {
+		existingAnnotation.Label = label
+	}
+	if value, ok := updates["note"]; ok {
+		existingAnnotation.Note = optionalString(value)
+	}
+
+	if err := services.ValidateAnnotation(existingAnnotation); err != nil {
+		writeAnnotationError(w, err)
+		return
+	}
+
+	log.Printf("handlers.annotations.UpdateAnnotation: %+v", existingAnnotation)
+
+	updatedAnnotation, err := h.service.UpdateAnnotation(existingAnnotation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedAnnotation)
+}
+
+func (h *AnnotationHandler) DeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.annotations.DeleteAnnotation: %v", id)
+
+	if err := h.service.DeleteAnnotation(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Annotation not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// imageExists reports whether the image exists, writing an error response if it doesn't
+func (h *AnnotationHandler) imageExists(w http.ResponseWriter, imageID string) bool {
+	if _, err := h.imageService.GetImage(imageID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// writeAnnotationError reports an annotation without a label or off the image as a bad request and anything
+// else as a server error
+func writeAnnotationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidAnnotation) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+type AttachmentHandler struct {
+	db              *sql.DB
+	templates       *template.Template
+	service         *services.AttachmentService
+	stampService    *services.StampService
+	instanceService *services.InstanceService
+}
+
+func NewAttachmentHandler(db *sql.DB, templates *template.Template) *AttachmentHandler {
+	return &AttachmentHandler{
+		db:              db,
+		templates:       templates,
+		service:         services.NewAttachmentService(db),
+		stampService:    services.NewStampService(db),
+		instanceService: services.NewInstanceService(db),
+	}
+}
+
+// GetStampAttachments lists the files attached to a stamp design
+func (h *AttachmentHandler) GetStampAttachments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	if _, err := h.stampService.GetStampByID(stampID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Stamp not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	attachments, err := h.service.GetStampAttachments(stampID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if attachments == nil {
+		attachments = []models.Attachment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// GetInstanceAttachments lists the files attached to a group of copies
+func (h *AttachmentHandler) GetInstanceAttachments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	if !h.instanceExists(w, instanceID) {
+		return
+	}
+
+	attachments, err := h.service.GetInstanceAttachments(instanceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if attachments == nil {
+		attachments = []models.Attachment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// UploadStampAttachment attaches the uploaded "file" form file to a stamp design, with the optional
+// "type" and "caption" form fields
+func (h *AttachmentHandler) UploadStampAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	if _, err := h.stampService.GetStampByID(stampID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Stamp not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	attachment, status, err := saveAttachment(r, h.service, &stampID, nil)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// UploadInstanceAttachment attaches the uploaded "file" form file to a group of copies, like
+// UploadStampAttachment
+func (h *AttachmentHandler) UploadInstanceAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	if !h.instanceExists(w, instanceID) {
+		return
+	}
+
+	attachment, status, err := saveAttachment(r, h.service, nil, &instanceID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+func (h *AttachmentHandler) UpdateAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingAttachment, err := h.service.GetAttachment(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if attachmentType, ok := updates["type"].(string); ok {
+		existingAttachment.Type = attachmentType
+	}
+	if _, ok := updates["caption"]; ok {
+		existingAttachment.Caption = optionalString(updates["caption"])
+	}
+
+	if err := services.ValidateAttachment(existingAttachment); err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	log.Printf("handlers.attachments.UpdateAttachment: %+v", existingAttachment)
+
+	updatedAttachment, err := h.service.UpdateAttachment(existingAttachment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedAttachment)
+}
+
+// ReorderAttachments sets the display order of a stamp's or group's attachments from a JSON list of IDs
+func (h *AttachmentHandler) ReorderAttachments(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ReorderAttachments(ids); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteAttachment removes an attachment along with its file and thumbnail
+func (h *AttachmentHandler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	attachment, err := h.service.GetAttachment(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.attachments.DeleteAttachment: %v", id)
+
+	if err := h.service.DeleteAttachment(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	removeAttachmentFiles(attachment)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// instanceExists reports whether the group of copies exists, writing an error response if it doesn't
+func (h *AttachmentHandler) instanceExists(w http.ResponseWriter, instanceID string) bool {
+	if _, err := h.instanceService.GetStampInstance(instanceID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// saveAttachment stores the uploaded "file" form file under attachments in the image storage, makes a thumbnail
+// of it where possible and records it against the stamp or group of copies. On failure it returns the
+// HTTP status to report along with a message for the user.
+func saveAttachment(r *http.Request, service *services.AttachmentService, stampID, instanceID *string) (*models.Attachment, int, error) {
+	attachment := models.Attachment{
+		ID:           uuid.New().String(),
+		StampID:      stampID,
+		InstanceID:   instanceID,
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+
+	saved, status, err := saveUploadedFile(r, attachmentUpload, "attachments", attachment.ID)
+	if err != nil {
+		return nil, status, err
+	}
+
+	attachment.Type = r.FormValue("type")
+	attachment.Caption = formString(r, "caption")
+	if err := services.ValidateAttachment(&attachment); err != nil {
+		if err := storage.Files.Delete(saved.Key); err != nil {
+			log.Printf("handlers.attachments.saveAttachment: %v", err)
+		}
+		return nil, http.StatusBadRequest, err
+	}
+
+	attachment.Filename = filepath.Base(saved.Filename)
+	attachment.ContentType = saved.ContentType
+	attachment.Size = saved.Size
+	attachment.FileURL = saved.URL
+
+	// Thumbnails are a nicety: a file that can't be previewed is still attached
+	thumbKey := "attachments/thumbs/" + attachment.ID + ".jpg"
+	if err := imaging.MakeThumbnail(storage.Files, saved.Key, saved.ContentType, thumbKey); err == nil {
+		thumbnailURL := storage.URL(thumbKey)
+		attachment.ThumbnailURL = &thumbnailURL
+	} else if !errors.Is(err, imaging.ErrUnsupported) {
+		log.Printf("handlers.attachments.saveAttachment: no thumbnail for %v: %v", attachment.ID, err)
+	}
+
+	if _, err := service.CreateAttachment(&attachment); err != nil {
+		removeAttachmentFiles(&attachment)
+		return nil, http.StatusInternalServerError, errors.New("Error saving attachment")
+	}
+	return &attachment, http.StatusCreated, nil
+}
+
+// removeAttachmentFiles deletes the file and thumbnail of an attachment from disk
+func removeAttachmentFiles(attachment *models.Attachment) {
+	urls := []string{attachment.FileURL}
+	if attachment.ThumbnailURL != nil {
+		urls = append(urls, *attachment.ThumbnailURL)
+	}
+
+	for _, url := range urls {
+		key, ok := storage.Key(url)
+		if !ok || !strings.HasPrefix(key, "attachments/") {
+			continue
+		}
+		if err := storage.Files.Delete(key); err != nil {
+			log.Printf("handlers.attachments.removeAttachmentFiles: %v", err)
+		}
+	}
+}
+
+// writeAttachmentError reports an unknown attachment type as a bad request and anything else as a
+// server error
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidAttachment) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+type AutocompleteHandler struct {
+	db        *sql.DB
+	templates *template.Template
+	service   *services.AutocompleteService
+}
+
+func NewAutocompleteHandler(db *sql.DB, templates *template.Template) *AutocompleteHandler {
+	return &AutocompleteHandler{
+		db:        db,
+		templates: templates,
+		service:   services.NewAutocompleteService(db),
+	}
+}
+
+// GetSuggestions returns ranked autocomplete matches as JSON
+func (h *AutocompleteHandler) GetSuggestions(w http.ResponseWriter, r *http.Request) {
+	kind, term, limit := parseAutocompleteRequest(r)
+
+	suggestions, err := h.service.GetSuggestions(kind, term, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// GetSuggestionOptions returns ranked autocomplete matches as <option> elements for a <datalist>
+func (h *AutocompleteHandler) GetSuggestionOptions(w http.ResponseWriter, r *http.Request) {
+	kind, term, limit := parseAutocompleteRequest(r)
+
+	suggestions, err := h.service.GetSuggestions(kind, term, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "autocomplete-options.html", suggestions)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseAutocompleteRequest reads the kind from the path and the search term and limit from the query string
+func parseAutocompleteRequest(r *http.Request) (kind, term string, limit int) {
+	kind = mux.Vars(r)["kind"]
+	term = r.FormValue("q")
+
+	limit, _ = strconv.Atoi(r.FormValue("limit"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+	return kind, term, limit
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+type CollectionHandler struct {
+	db        *sql.DB
+	templates *template.Template
+	service   *services.CollectionService
+}
+
+func NewCollectionHandler(db *sql.DB, templates *template.Template) *CollectionHandler {
+	return &CollectionHandler{
+		db:        db,
+		templates: templates,
+		service:   services.NewCollectionService(db),
+	}
+}
+
+func (h *CollectionHandler) GetCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := h.service.GetCollections()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collections)
+}
+
+func (h *CollectionHandler) GetCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	collection, err := h.service.GetCollectionByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Smart collection not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collection)
+}
+
+func (h *CollectionHandler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	var collection models.SmartCollection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if collection.Name == "" {
+		http.Error(w, "Collection name is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.ValidateCollectionQuery("", collection.Query); err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+	if collection.View != "list" {
+		collection.View = "gallery"
+	}
+
+	collection.ID = uuid.New().String()
+	collection.DateCreated = time.Now()
+	collection.DateModified = time.Now()
+
+	log.Printf("handlers.collections.CreateCollection: %+v", collection)
+
+	createdCollection, err := h.service.CreateCollection(&collection)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdCollection)
+}
+
+func (h *CollectionHandler) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingCollection, err := h.service.GetCollectionByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Smart collection not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if name, ok := updates["name"].(string); ok && name != "" {
+		existingCollection.Name = name
+	}
+	if query, ok := updates["query"].(string); ok {
+		if err := h.service.ValidateCollectionQuery(id, query); err != nil {
+			writeCollectionError(w, err)
+			return
+		}
+		existingCollection.Query = query
+	}
+	if view, ok := updates["view"].(string); ok && (view == "gallery" || view == "list") {
+		existingCollection.View = view
+	}
+
+	log.Printf("handlers.collections.UpdateCollection: %+v", existingCollection)
+
+	updatedCollection, err := h.service.UpdateCollection(existingCollection)
+	if err != nil {
+		writeCollectionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedCollection)
+}
+
+func (h *CollectionHandler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.collections.DeleteCollection: %v", id)
+
+	if err := h.service.DeleteCollection(id); err != nil {
+		if errors.Is(err, services.ErrCollectionInUse) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeCollectionError reports a failure to save a smart collection: 400 for a query that can't be saved, 409
+// for a name already taken and 500 otherwise
+func writeCollectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidCollection):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case strings.Contains(err.Error(), "duplicate key value violates unique constraint"):
+		http.Error(w, "A smart collection with this name already exists", http.StatusConflict)
+	default:
+		log.Printf("handlers.collections.writeCollectionError: %v", err)
+		http.Error(w, "Failed to save smart collection", http.StatusInternalServerError)
+	}
+}
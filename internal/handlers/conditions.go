@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+type ConditionHandler struct {
+	db        *sql.DB
+	templates *template.Template
+	service   *services.ConditionService
+}
+
+func NewConditionHandler(db *sql.DB, templates *template.Template) *ConditionHandler {
+	return &ConditionHandler{
+		db:        db,
+		templates: templates,
+		service:   services.NewConditionService(db),
+	}
+}
+
+func (h *ConditionHandler) GetConditions(w http.ResponseWriter, r *http.Request) {
+	conditions, err := h.service.GetConditions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conditions)
+}
+
+// CreateCondition adds a condition to the end of the list, optionally with aliases and as the default
+func (h *ConditionHandler) CreateCondition(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name      string   `json:"name"`
+		Aliases   []string `json:"aliases"`
+		IsDefault bool     `json:"is_default"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	condition, err := h.service.CreateCondition(request.Name)
+	if err != nil {
+		writeConditionError(w, err)
+		return
+	}
+
+	if len(request.Aliases) > 0 {
+		if err := h.service.SetAliases(condition.ID, request.Aliases); err != nil {
+			writeConditionError(w, err)
+			return
+		}
+	}
+	if request.IsDefault {
+		if err := h.service.SetDefault(condition.ID); err != nil {
+			writeConditionError(w, err)
+			return
+		}
+	}
+
+	createdCondition, err := h.service.GetCondition(condition.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdCondition)
+}
+
+// UpdateCondition renames a condition, replaces its aliases or sets it as the default
+func (h *ConditionHandler) UpdateCondition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	condition, err := h.service.GetCondition(id)
+	if err != nil {
+		writeConditionError(w, err)
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("handlers.conditions.UpdateCondition: %v %+v", id, updates)
+
+	if name, ok := updates["name"].(string); ok {
+		if err := h.service.RenameCondition(id, name); err != nil {
+			writeConditionError(w, err)
+			return
+		}
+	}
+
+	if aliases, ok := updates["aliases"]; ok {
+		if err := h.service.SetAliases(id, listValue(aliases)); err != nil {
+			writeConditionError(w, err)
+			return
+		}
+	}
+
+	if isDefault, ok := updates["is_default"].(bool); ok && isDefault != condition.IsDefault {
+		defaultID := ""
+		if isDefault {
+			defaultID = id
+		}
+		if err := h.service.SetDefault(defaultID); err != nil {
+			writeConditionError(w, err)
+			return
+		}
+	}
+
+	updatedCondition, err := h.service.GetCondition(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedCondition)
+}
+
+// ReorderConditions sets the display order from a list of condition IDs
+func (h *ConditionHandler) ReorderConditions(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ReorderConditions(ids); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.GetConditions(w, r)
+}
+
+// MergeCondition folds a condition into the one given as "into"
+func (h *ConditionHandler) MergeCondition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var request struct {
+		Into string `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.MergeCondition(id, request.Into); err != nil {
+		writeConditionError(w, err)
+		return
+	}
+
+	mergedCondition, err := h.service.GetCondition(request.Into)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mergedCondition)
+}
+
+func (h *ConditionHandler) DeleteCondition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.conditions.DeleteCondition: %v", id)
+
+	if err := h.service.DeleteCondition(id); err != nil {
+		writeConditionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNormalization previews normalizing the stored condition values: each value that isn't a listed
+// name, with the condition it would become
+func (h *ConditionHandler) GetNormalization(w http.ResponseWriter, r *http.Request) {
+	values, err := h.service.GetConditionValues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(values)
+}
+
+// NormalizeConditions rewrites the stored condition values to their listed names
+func (h *ConditionHandler) NormalizeConditions(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.NormalizeConditions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeConditionError reports a missing condition as not found, an invalid change to the list as a
+// bad request and anything else as a server error
+func writeConditionError(w http.ResponseWriter, err error) {
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Condition not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidCondition):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
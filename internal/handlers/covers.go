@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+// coverTextFields are the free-text cover fields that can be set through the API and the edit form
+var coverTextFields = map[string]func(c *models.Cover) **string{
+	"sender":        func(c *models.Cover) **string { return &c.Sender },
+	"recipient":     func(c *models.Cover) **string { return &c.Recipient },
+	"origin":        func(c *models.Cover) **string { return &c.Origin },
+	"destination":   func(c *models.Cover) **string { return &c.Destination },
+	"postmark_date": func(c *models.Cover) **string { return &c.PostmarkDate },
+	"postmark_town": func(c *models.Cover) **string { return &c.PostmarkTown },
+	"route":         func(c *models.Cover) **string { return &c.Route },
+	"rate_paid":     func(c *models.Cover) **string { return &c.RatePaid },
+	"notes":         func(c *models.Cover) **string { return &c.Notes },
+}
+
+type CoverHandler struct {
+	db        *sql.DB
+	templates *template.Template
+	service   *services.CoverService
+}
+
+func NewCoverHandler(db *sql.DB, templates *template.Template) *CoverHandler {
+	return &CoverHandler{
+		db:        db,
+		templates: templates,
+		service:   services.NewCoverService(db),
+	}
+}
+
+// GetCovers returns the covers matching the search, origin, destination, year_from, year_to and scott parameters
+func (h *CoverHandler) GetCovers(w http.ResponseWriter, r *http.Request) {
+	covers, err := h.service.GetCovers(services.NewCoverFiltersFromValues(r.URL.Query()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(covers)
+}
+
+func (h *CoverHandler) GetCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	cover, err := h.service.GetCoverByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cover)
+}
+
+func (h *CoverHandler) CreateCover(w http.ResponseWriter, r *http.Request) {
+	var cover models.Cover
+	if err := json.NewDecoder(r.Body).Decode(&cover); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stamps, err := h.service.ResolveStamps(cover.Stamps)
+	if err != nil {
+		writeCoverError(w, err)
+		return
+	}
+
+	cover.ID = uuid.New().String()
+	cover.DateAdded = time.Now()
+	cover.DateModified = time.Now()
+
+	log.Printf("handlers.covers.CreateCover: %+v", cover)
+
+	if _, err := h.service.CreateCover(&cover); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(stamps) > 0 {
+		if err := h.service.SetCoverStamps(cover.ID, stamps); err != nil {
+			writeCoverError(w, err)
+			return
+		}
+	}
+
+	createdCover, err := h.service.GetCoverByID(cover.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdCover)
+}
+
+func (h *CoverHandler) UpdateCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingCover, err := h.service.GetCoverByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for field, value := range updates {
+		if target, ok := coverTextFields[field]; ok {
+			*target(existingCover) = optionalString(value)
+		}
+	}
+	if _, ok := updates["front_image_url"]; ok {
+		existingCover.FrontImageURL = optionalString(updates["front_image_url"])
+	}
+	if _, ok := updates["back_image_url"]; ok {
+		existingCover.BackImageURL = optionalString(updates["back_image_url"])
+	}
+
+	// A stamps list, if given, replaces the stamps on the cover
+	var stamps []models.CoverStamp
+	_, stampsGiven := updates["stamps"]
+	if stampsGiven {
+		raw, _ := json.Marshal(updates["stamps"])
+		if err := json.Unmarshal(raw, &stamps); err != nil {
+			http.Error(w, "Invalid stamps: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if stamps, err = h.service.ResolveStamps(stamps); err != nil {
+			writeCoverError(w, err)
+			return
+		}
+	}
+
+	log.Printf("handlers.covers.UpdateCover: %+v", existingCover)
+
+	if _, err := h.service.UpdateCover(existingCover); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if stampsGiven {
+		if err := h.service.SetCoverStamps(id, stamps); err != nil {
+			writeCoverError(w, err)
+			return
+		}
+	}
+
+	updatedCover, err := h.service.GetCoverByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedCover)
+}
+
+// SetCoverStamps replaces the stamps affixed to a cover
+func (h *CoverHandler) SetCoverStamps(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var stamps []models.CoverStamp
+	if err := json.NewDecoder(r.Body).Decode(&stamps); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.service.GetCoverByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	stamps, err := h.service.ResolveStamps(stamps)
+	if err != nil {
+		writeCoverError(w, err)
+		return
+	}
+
+	log.Printf("handlers.covers.SetCoverStamps: %v (%d stamps)", id, len(stamps))
+
+	if err := h.service.SetCoverStamps(id, stamps); err != nil {
+		writeCoverError(w, err)
+		return
+	}
+
+	updatedCover, err := h.service.GetCoverByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedCover)
+}
+
+func (h *CoverHandler) DeleteCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.covers.DeleteCover: %v", id)
+
+	if err := h.service.DeleteCover(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadCoverImage saves the front or back image of a cover from the "image" form file
+func (h *CoverHandler) UploadCoverImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	side := vars["side"]
+
+	cover, err := h.service.GetCoverByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	imageURL, status, err := saveCoverImage(r, cover, side)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if _, err := h.service.UpdateCover(cover); err != nil {
+		http.Error(w, "Error updating cover", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("handlers.covers.UploadCoverImage: %s image of cover %v is now %v", side, id, imageURL)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"image_url": imageURL})
+}
+
+// saveCoverImage stores the uploaded "image" form file as the given side ("front" or "back") of the cover
+// and sets the matching image URL on it. On failure it returns the HTTP status to report.
+func saveCoverImage(r *http.Request, cover *models.Cover, side string) (string, int, error) {
+	imageURL, status, err := saveUploadedImage(r, nil, "covers", cover.ID+"-"+side)
+	if err != nil {
+		return "", status, err
+	}
+
+	if side == "back" {
+		cover.BackImageURL = &imageURL
+	} else {
+		cover.FrontImageURL = &imageURL
+	}
+	return imageURL, status, nil
+}
+
+// writeCoverError reports an unknown stamp on a cover as a bad request and anything else as a server error
+func writeCoverError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidCover) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+type FirstDayCoverHandler struct {
+	db           *sql.DB
+	templates    *template.Template
+	service      *services.FirstDayCoverService
+	stampService *services.StampService
+}
+
+func NewFirstDayCoverHandler(db *sql.DB, templates *template.Template) *FirstDayCoverHandler {
+	return &FirstDayCoverHandler{
+		db:           db,
+		templates:    templates,
+		service:      services.NewFirstDayCoverService(db),
+		stampService: services.NewStampService(db),
+	}
+}
+
+// GetFirstDayCovers returns all first-day covers, or those of one design if stamp_id is given
+func (h *FirstDayCoverHandler) GetFirstDayCovers(w http.ResponseWriter, r *http.Request) {
+	fdcs, err := h.service.GetFirstDayCovers(r.URL.Query().Get("stamp_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fdcs)
+}
+
+func (h *FirstDayCoverHandler) GetFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	fdc, err := h.service.GetFirstDayCover(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fdc)
+}
+
+// CreateFirstDayCover adds an FDC for the design given by stamp_id, or by scott_number
+func (h *FirstDayCoverHandler) CreateFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	var fdc models.FirstDayCover
+	if err := json.NewDecoder(r.Body).Decode(&fdc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if fdc.StampID == "" && fdc.ScottNumber != nil {
+		stampID, err := h.stampService.GetStampIDByScottNumber(*fdc.ScottNumber)
+		if err == sql.ErrNoRows {
+			http.Error(w, "No stamp with Scott number "+*fdc.ScottNumber, http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrAmbiguousScottNumber) {
+			http.Error(w, "Several stamps have Scott number "+*fdc.ScottNumber+"; give its stamp_id instead", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fdc.StampID = stampID
+	}
+	if fdc.StampID == "" {
+		http.Error(w, "stamp_id or scott_number is required", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateFirstDayCover(&fdc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fdc.ID = uuid.New().String()
+	fdc.DateAdded = time.Now()
+	fdc.DateModified = time.Now()
+
+	log.Printf("handlers.fdcs.CreateFirstDayCover: %+v", fdc)
+
+	if _, err := h.service.CreateFirstDayCover(&fdc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	createdFDC, err := h.service.GetFirstDayCover(fdc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdFDC)
+}
+
+func (h *FirstDayCoverHandler) UpdateFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingFDC, err := h.service.GetFirstDayCover(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for field, target := range fdcTextFields {
+		if value, ok := updates[field]; ok {
+			*target(existingFDC) = optionalString(value)
+		}
+	}
+	if _, ok := updates["image_url"]; ok {
+		existingFDC.ImageURL = optionalString(updates["image_url"])
+	}
+
+	if err := services.ValidateFirstDayCover(existingFDC); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("handlers.fdcs.UpdateFirstDayCover: %+v", existingFDC)
+
+	updatedFDC, err := h.service.UpdateFirstDayCover(existingFDC)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedFDC)
+}
+
+func (h *FirstDayCoverHandler) DeleteFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.fdcs.DeleteFirstDayCover: %v", id)
+
+	if err := h.service.DeleteFirstDayCover(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadFirstDayCoverImage saves the image of a first-day cover from the "image" form file
+func (h *FirstDayCoverHandler) UploadFirstDayCoverImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	fdc, err := h.service.GetFirstDayCover(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	imageURL, status, err := saveUploadedImage(r, nil, "fdcs", fdc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	fdc.ImageURL = &imageURL
+	if _, err := h.service.UpdateFirstDayCover(fdc); err != nil {
+		http.Error(w, "Error updating first day cover", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"image_url": imageURL})
+}
+
+// GetReport lists the designs we have FDCs for versus just the stamp; status narrows it to
+// "both", "fdc_only" or "stamp_only"
+func (h *FirstDayCoverHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.service.GetReport(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// fdcTextFields are the first-day cover fields that can be set through the API and the stamp page
+var fdcTextFields = map[string]func(f *models.FirstDayCover) **string{
+	"city":         func(f *models.FirstDayCover) **string { return &f.City },
+	"date":         func(f *models.FirstDayCover) **string { return &f.Date },
+	"cachet_maker": func(f *models.FirstDayCover) **string { return &f.CachetMaker },
+	"cachet_type":  func(f *models.FirstDayCover) **string { return &f.CachetType },
+	"notes":        func(f *models.FirstDayCover) **string { return &f.Notes },
+}
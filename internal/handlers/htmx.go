@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,20 +18,38 @@ import (
 
 // HTMXHandler handles HTMX-specific endpoints that return HTML fragments
 type HTMXHandler struct {
-	db           *sql.DB
-	templates    *template.Template
-	stampService *services.StampService
-	tagService   *services.TagService
-	boxService   *services.BoxService
+	db                *sql.DB
+	templates         *template.Template
+	stampService      *services.StampService
+	tagService        *services.TagService
+	boxService        *services.BoxService
+	collectionService *services.CollectionService
+	seriesService     *services.SeriesService
+	coverService      *services.CoverService
+	fdcService        *services.FirstDayCoverService
+	conditionService  *services.ConditionService
+	provenanceService *services.ProvenanceService
+	attachmentService *services.AttachmentService
+	imageService      *services.ImageService
+	index             *imageIndex
 }
 
 func NewHTMXHandler(db *sql.DB, templates *template.Template) *HTMXHandler {
 	return &HTMXHandler{
-		db:           db,
-		templates:    templates,
-		stampService: services.NewStampService(db),
-		tagService:   services.NewTagService(db),
-		boxService:   services.NewBoxService(db),
+		db:                db,
+		templates:         templates,
+		stampService:      services.NewStampService(db),
+		tagService:        services.NewTagService(db),
+		boxService:        services.NewBoxService(db),
+		collectionService: services.NewCollectionService(db),
+		seriesService:     services.NewSeriesService(db),
+		coverService:      services.NewCoverService(db),
+		fdcService:        services.NewFirstDayCoverService(db),
+		conditionService:  services.NewConditionService(db),
+		provenanceService: services.NewProvenanceService(db),
+		attachmentService: services.NewAttachmentService(db),
+		imageService:      services.NewImageService(db),
+		index:             newImageIndex(db),
 	}
 }
 
@@ -49,7 +69,7 @@ func (h *HTMXHandler) UpdateStampField(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	switch field {
-	case "name", "scott_number", "series", "notes":
+	case "name", "scott_number", "series", "notes", "parent_scott_number", "variety_type":
 		value = strings.TrimSpace(r.FormValue("value"))
 		if value == "" {
 			value = nil
@@ -107,6 +127,41 @@ func (h *HTMXHandler) UpdateStampField(w http.ResponseWriter, r *http.Request) {
 		} else {
 			stamp.Notes = nil
 		}
+	case "parent_scott_number":
+		// The parent is entered by Scott number, e.g. "219" for a "219a" shade
+		if value != nil {
+			parentID, err := h.stampService.GetStampIDByScottNumber(value.(string))
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "No stamp with that Scott number", http.StatusBadRequest)
+				return
+			} else if errors.Is(err, services.ErrAmbiguousScottNumber) {
+				http.Error(w, "Several stamps have that Scott number", http.StatusBadRequest)
+				return
+			} else if err != nil {
+				log.Printf("handlers.htmx.UpdateStampField: %v", err)
+				http.Error(w, "Failed to update stamp", http.StatusInternalServerError)
+				return
+			}
+			stamp.ParentID = &parentID
+		} else {
+			stamp.ParentID = nil
+		}
+	case "variety_type":
+		if value != nil {
+			valueStr := value.(string)
+			stamp.VarietyType = &valueStr
+		} else {
+			stamp.VarietyType = nil
+		}
+	}
+
+	if err := h.stampService.ValidateVariety(stamp); err != nil {
+		if errors.Is(err, services.ErrInvalidVariety) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to update stamp", http.StatusInternalServerError)
+		}
+		return
 	}
 
 	// Update timestamp
@@ -114,6 +169,10 @@ func (h *HTMXHandler) UpdateStampField(w http.ResponseWriter, r *http.Request) {
 
 	// Save the updated stamp
 	_, err = h.stampService.UpdateStamp(stamp)
+	if errors.Is(err, services.ErrInvalidSeries) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update stamp", http.StatusInternalServerError)
 		return
@@ -357,4 +416,1241 @@ func (h *HTMXHandler) DeleteBox(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
 	}
-}
\ No newline at end of file
+}
+
+// CreateCollection saves the current filters as a smart collection and returns the updated sidebar list
+func (h *HTMXHandler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The name comes from hx-prompt, or a plain form field when posted without one
+	name := strings.TrimSpace(r.Header.Get("HX-Prompt"))
+	if name == "" {
+		name = strings.TrimSpace(r.FormValue("name"))
+	}
+	if name == "" {
+		http.Error(w, "Collection name is required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.FormValue("query")
+	if err := h.collectionService.ValidateCollectionQuery("", query); err != nil {
+		if errors.Is(err, services.ErrInvalidCollection) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to create smart collection", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	view := r.FormValue("view")
+	if view != "list" {
+		view = "gallery"
+	}
+
+	collection := &models.SmartCollection{
+		ID:           uuid.New().String(),
+		Name:         name,
+		Query:        query,
+		View:         view,
+		DateCreated:  time.Now(),
+		DateModified: time.Now(),
+	}
+
+	log.Printf("handlers.htmx.CreateCollection: %+v", collection)
+
+	_, err := h.collectionService.CreateCollection(collection)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			http.Error(w, "A smart collection with this name already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create smart collection", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.renderCollectionList(w, collection.ID)
+}
+
+// DeleteCollection deletes a smart collection and returns the updated sidebar list
+func (h *HTMXHandler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	collectionID := vars["id"]
+
+	log.Printf("handlers.htmx.DeleteCollection: %v", collectionID)
+
+	if err := h.collectionService.DeleteCollection(collectionID); err != nil {
+		if errors.Is(err, services.ErrCollectionInUse) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("handlers.htmx.DeleteCollection: %v", err)
+		http.Error(w, "Failed to delete smart collection", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderCollectionList(w, "")
+}
+
+func (h *HTMXHandler) renderCollectionList(w http.ResponseWriter, activeCollectionID string) {
+	collections, err := h.collectionService.GetCollections()
+	if err != nil {
+		http.Error(w, "Failed to fetch smart collections", http.StatusInternalServerError)
+		return
+	}
+
+	data := models.CollectionListView{
+		Collections:        collections,
+		ActiveCollectionID: activeCollectionID,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "collection-list.html", data)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateSeries adds a series named from the sidebar and opens its page, so stamps can be linked to it
+func (h *HTMXHandler) CreateSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The name comes from hx-prompt, or a plain form field when posted without one
+	name := strings.TrimSpace(r.Header.Get("HX-Prompt"))
+	if name == "" {
+		name = strings.TrimSpace(r.FormValue("name"))
+	}
+	if name == "" {
+		http.Error(w, "Series name is required", http.StatusBadRequest)
+		return
+	}
+
+	series := &models.Series{
+		ID:           uuid.New().String(),
+		Name:         name,
+		DateCreated:  time.Now(),
+		DateModified: time.Now(),
+	}
+
+	log.Printf("handlers.htmx.CreateSeries: %+v", series)
+
+	if _, err := h.seriesService.CreateSeries(series); err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			http.Error(w, "A series with this name already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	series, err := h.seriesService.GetSeriesByID(series.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch series", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("HX-Trigger", "seriesUpdated")
+	err = h.templates.ExecuteTemplate(w, "series-detail.html", series)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateSeries saves a series' details and expected member list from the series page and re-renders it
+func (h *HTMXHandler) UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	seriesID := vars["id"]
+
+	series, err := h.seriesService.GetSeriesByID(seriesID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Series not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch series", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		series.Name = name
+	}
+	series.Country = formString(r, "country")
+	series.Description = formString(r, "description")
+	series.StartYear = formInt(r, "start_year")
+	series.EndYear = formInt(r, "end_year")
+
+	log.Printf("handlers.htmx.UpdateSeries: %+v", series)
+
+	if _, err := h.seriesService.UpdateSeries(series); err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			http.Error(w, "A series with this name already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to update series", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := h.seriesService.SetMembers(seriesID, services.ParseSeriesMembers(r.FormValue("members"))); err != nil {
+		http.Error(w, "Failed to update series members", http.StatusInternalServerError)
+		return
+	}
+
+	series, err = h.seriesService.GetSeriesByID(seriesID)
+	if err != nil {
+		http.Error(w, "Failed to fetch series", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("HX-Trigger", "seriesUpdated")
+	err = h.templates.ExecuteTemplate(w, "series-detail.html", series)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateCover adds a blank cover and opens it for editing
+func (h *HTMXHandler) CreateCover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cover := models.Cover{
+		ID:           uuid.New().String(),
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+
+	if _, err := h.coverService.CreateCover(&cover); err != nil {
+		http.Error(w, "Failed to create cover", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderCoverDetail(w, cover.ID, "")
+}
+
+// UpdateCover saves a cover's details and the stamps affixed to it from the cover page and re-renders it
+func (h *HTMXHandler) UpdateCover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	coverID := vars["id"]
+
+	cover, err := h.coverService.GetCoverByID(coverID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch cover", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	for field, target := range coverTextFields {
+		*target(cover) = formString(r, field)
+	}
+
+	log.Printf("handlers.htmx.UpdateCover: %+v", cover)
+
+	if _, err := h.coverService.UpdateCover(cover); err != nil {
+		http.Error(w, "Failed to update cover", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the rest of the edit if the stamps list names an unknown stamp, and say which
+	stamps, err := h.coverService.ResolveStamps(services.ParseCoverStamps(r.FormValue("stamps")))
+	if err == nil {
+		err = h.coverService.SetCoverStamps(coverID, stamps)
+	}
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidCover) {
+			http.Error(w, "Failed to update cover stamps", http.StatusInternalServerError)
+			return
+		}
+		h.renderCoverDetail(w, coverID, err.Error())
+		return
+	}
+
+	h.renderCoverDetail(w, coverID, "")
+}
+
+// UploadCoverImage saves the front or back image of a cover and re-renders the cover page
+func (h *HTMXHandler) UploadCoverImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	coverID := vars["id"]
+
+	cover, err := h.coverService.GetCoverByID(coverID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch cover", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if _, _, err := saveCoverImage(r, cover, vars["side"]); err != nil {
+		h.renderCoverDetail(w, coverID, err.Error())
+		return
+	}
+
+	if _, err := h.coverService.UpdateCover(cover); err != nil {
+		http.Error(w, "Failed to update cover", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderCoverDetail(w, coverID, "")
+}
+
+// DeleteCover removes a cover and returns to the covers gallery
+func (h *HTMXHandler) DeleteCover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	coverID := vars["id"]
+
+	log.Printf("handlers.htmx.DeleteCover: %v", coverID)
+
+	if err := h.coverService.DeleteCover(coverID); err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Failed to delete cover", http.StatusInternalServerError)
+		return
+	}
+
+	covers, err := h.coverService.GetCovers(services.CoverFilters{})
+	if err != nil {
+		http.Error(w, "Failed to fetch covers", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "covers-view.html", models.CoverGalleryView{Covers: covers})
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *HTMXHandler) renderCoverDetail(w http.ResponseWriter, coverID, problem string) {
+	cover, err := h.coverService.GetCoverByID(coverID)
+	if err != nil {
+		http.Error(w, "Failed to fetch cover", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "cover-detail.html", models.CoverDetailView{Cover: *cover, Error: problem})
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateFirstDayCover adds a first-day cover to a stamp from its detail page
+func (h *HTMXHandler) CreateFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	fdc := models.FirstDayCover{
+		ID:           uuid.New().String(),
+		StampID:      stampID,
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+	for field, target := range fdcTextFields {
+		*target(&fdc) = formString(r, field)
+	}
+
+	if err := services.ValidateFirstDayCover(&fdc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.fdcService.CreateFirstDayCover(&fdc); err != nil {
+		http.Error(w, "Failed to add first day cover", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderFDCSection(w, stampID)
+}
+
+// UpdateFirstDayCover saves the fields of a first-day cover edited on the stamp page
+func (h *HTMXHandler) UpdateFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fdcID := vars["id"]
+
+	fdc, err := h.fdcService.GetFirstDayCover(fdcID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch first day cover", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	for field, target := range fdcTextFields {
+		*target(fdc) = formString(r, field)
+	}
+
+	if err := services.ValidateFirstDayCover(fdc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.fdcService.UpdateFirstDayCover(fdc); err != nil {
+		http.Error(w, "Failed to update first day cover", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderFDCSection(w, fdc.StampID)
+}
+
+// UploadFirstDayCoverImage saves the image of a first-day cover and re-renders the stamp's FDC section
+func (h *HTMXHandler) UploadFirstDayCoverImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fdcID := vars["id"]
+
+	fdc, err := h.fdcService.GetFirstDayCover(fdcID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch first day cover", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	imageURL, status, err := saveUploadedImage(r, nil, "fdcs", fdc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	fdc.ImageURL = &imageURL
+	if _, err := h.fdcService.UpdateFirstDayCover(fdc); err != nil {
+		http.Error(w, "Failed to update first day cover", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderFDCSection(w, fdc.StampID)
+}
+
+// DeleteFirstDayCover removes a first-day cover from the stamp page
+func (h *HTMXHandler) DeleteFirstDayCover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	fdcID := vars["id"]
+
+	fdc, err := h.fdcService.GetFirstDayCover(fdcID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "First day cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch first day cover", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.htmx.DeleteFirstDayCover: %v", fdcID)
+
+	if err := h.fdcService.DeleteFirstDayCover(fdcID); err != nil {
+		http.Error(w, "Failed to delete first day cover", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderFDCSection(w, fdc.StampID)
+}
+
+func (h *HTMXHandler) renderFDCSection(w http.ResponseWriter, stampID string) {
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		return
+	}
+
+	data := models.StampDetailView{
+		Stamp:       *stamp,
+		CachetTypes: services.CachetTypes,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "stamp-fdc-section", data)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateCertificate adds an expert certificate to a group of copies from the stamp page
+func (h *HTMXHandler) CreateCertificate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	certificate := models.Certificate{
+		ID:           uuid.New().String(),
+		InstanceID:   vars["id"],
+		Issuer:       r.FormValue("issuer"),
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+	for field, target := range certificateTextFields {
+		*target(&certificate) = formString(r, field)
+	}
+
+	if err := services.ValidateCertificate(&certificate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.provenanceService.CreateCertificate(&certificate); err != nil {
+		http.Error(w, "Failed to add certificate", http.StatusInternalServerError)
+		return
+	}
+
+	createdCertificate, err := h.provenanceService.GetCertificate(certificate.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch certificate", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, createdCertificate.StampID)
+}
+
+// UpdateCertificate saves the fields of a certificate edited on the stamp page
+func (h *HTMXHandler) UpdateCertificate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	certificateID := vars["id"]
+
+	certificate, err := h.provenanceService.GetCertificate(certificateID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch certificate", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	certificate.Issuer = r.FormValue("issuer")
+	for field, target := range certificateTextFields {
+		*target(certificate) = formString(r, field)
+	}
+
+	if err := services.ValidateCertificate(certificate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.provenanceService.UpdateCertificate(certificate); err != nil {
+		http.Error(w, "Failed to update certificate", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, certificate.StampID)
+}
+
+// UploadCertificateFile saves a scan or PDF of a certificate and re-renders the stamp's provenance section
+func (h *HTMXHandler) UploadCertificateFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	certificateID := vars["id"]
+
+	certificate, err := h.provenanceService.GetCertificate(certificateID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch certificate", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	fileURL, status, err := saveUploadedScan(r, "certificates", certificate.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	certificate.FileURL = &fileURL
+	if _, err := h.provenanceService.UpdateCertificate(certificate); err != nil {
+		http.Error(w, "Failed to update certificate", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, certificate.StampID)
+}
+
+// DeleteCertificate removes a certificate from the stamp page
+func (h *HTMXHandler) DeleteCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	certificateID := vars["id"]
+
+	certificate, err := h.provenanceService.GetCertificate(certificateID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch certificate", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.htmx.DeleteCertificate: %v", certificateID)
+
+	if err := h.provenanceService.DeleteCertificate(certificateID); err != nil {
+		http.Error(w, "Failed to delete certificate", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, certificate.StampID)
+}
+
+// CreateProvenanceEntry adds a previous owner or auction appearance to a group of copies from the stamp page
+func (h *HTMXHandler) CreateProvenanceEntry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	entry := models.ProvenanceEntry{
+		ID:           uuid.New().String(),
+		InstanceID:   vars["id"],
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+	for field, target := range provenanceTextFields {
+		*target(&entry) = formString(r, field)
+	}
+
+	if err := services.ValidateProvenanceEntry(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.provenanceService.CreateProvenanceEntry(&entry); err != nil {
+		http.Error(w, "Failed to add provenance entry", http.StatusInternalServerError)
+		return
+	}
+
+	createdEntry, err := h.provenanceService.GetProvenanceEntry(entry.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch provenance entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, createdEntry.StampID)
+}
+
+// UpdateProvenanceEntry saves the fields of a provenance entry edited on the stamp page
+func (h *HTMXHandler) UpdateProvenanceEntry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	entryID := vars["id"]
+
+	entry, err := h.provenanceService.GetProvenanceEntry(entryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Provenance entry not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch provenance entry", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	for field, target := range provenanceTextFields {
+		*target(entry) = formString(r, field)
+	}
+
+	if err := services.ValidateProvenanceEntry(entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.provenanceService.UpdateProvenanceEntry(entry); err != nil {
+		http.Error(w, "Failed to update provenance entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, entry.StampID)
+}
+
+// DeleteProvenanceEntry removes a provenance entry from the stamp page
+func (h *HTMXHandler) DeleteProvenanceEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	entryID := vars["id"]
+
+	entry, err := h.provenanceService.GetProvenanceEntry(entryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Provenance entry not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch provenance entry", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.htmx.DeleteProvenanceEntry: %v", entryID)
+
+	if err := h.provenanceService.DeleteProvenanceEntry(entryID); err != nil {
+		http.Error(w, "Failed to delete provenance entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderProvenanceSection(w, entry.StampID)
+}
+
+func (h *HTMXHandler) renderProvenanceSection(w http.ResponseWriter, stampID string) {
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "stamp-provenance-section", models.StampDetailView{Stamp: *stamp})
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UploadAttachment attaches the uploaded file to a stamp, or to one of its groups of copies when "instance_id"
+// is given, and re-renders the stamp's attachments section
+func (h *HTMXHandler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Stamp not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Files go on the design itself unless one of its groups of copies is picked
+	stampOwner, instanceOwner := &stampID, formString(r, "instance_id")
+	if instanceOwner != nil {
+		found := false
+		for _, instance := range stamp.Instances {
+			found = found || instance.ID == *instanceOwner
+		}
+		if !found {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+			return
+		}
+		stampOwner = nil
+	}
+
+	if _, status, err := saveAttachment(r, h.attachmentService, stampOwner, instanceOwner); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	h.renderAttachmentsSection(w, stampID)
+}
+
+// UpdateAttachment saves the type and caption of an attachment edited on the stamp page
+func (h *HTMXHandler) UpdateAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	attachmentID := vars["id"]
+
+	attachment, stampID, ok := h.getAttachment(w, attachmentID)
+	if !ok {
+		return
+	}
+
+	attachment.Type = r.FormValue("type")
+	attachment.Caption = formString(r, "caption")
+	if err := services.ValidateAttachment(attachment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.attachmentService.UpdateAttachment(attachment); err != nil {
+		http.Error(w, "Failed to update attachment", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderAttachmentsSection(w, stampID)
+}
+
+// MoveAttachment moves an attachment one place up or down among those of its stamp or group of copies
+func (h *HTMXHandler) MoveAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	attachmentID := vars["id"]
+
+	_, stampID, ok := h.getAttachment(w, attachmentID)
+	if !ok {
+		return
+	}
+
+	delta := 1
+	if vars["direction"] == "up" {
+		delta = -1
+	}
+	if err := h.attachmentService.MoveAttachment(attachmentID, delta); err != nil {
+		http.Error(w, "Failed to move attachment", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderAttachmentsSection(w, stampID)
+}
+
+// DeleteAttachment removes an attachment and its files from the stamp page
+func (h *HTMXHandler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	attachmentID := vars["id"]
+
+	attachment, stampID, ok := h.getAttachment(w, attachmentID)
+	if !ok {
+		return
+	}
+
+	log.Printf("handlers.htmx.DeleteAttachment: %v", attachmentID)
+
+	if err := h.attachmentService.DeleteAttachment(attachmentID); err != nil {
+		http.Error(w, "Failed to delete attachment", http.StatusInternalServerError)
+		return
+	}
+	removeAttachmentFiles(attachment)
+
+	h.renderAttachmentsSection(w, stampID)
+}
+
+// getAttachment fetches an attachment and the stamp it belongs to, writing an error response if it can't
+func (h *HTMXHandler) getAttachment(w http.ResponseWriter, attachmentID string) (*models.Attachment, string, bool) {
+	attachment, err := h.attachmentService.GetAttachment(attachmentID)
+	if err == nil {
+		var stampID string
+		stampID, err = h.attachmentService.GetAttachmentStampID(attachment)
+		if err == nil {
+			return attachment, stampID, true
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+	} else {
+		http.Error(w, "Failed to fetch attachment", http.StatusInternalServerError)
+	}
+	return nil, "", false
+}
+
+func (h *HTMXHandler) renderAttachmentsSection(w http.ResponseWriter, stampID string) {
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		return
+	}
+
+	data := models.StampDetailView{Stamp: *stamp}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "stamp-attachments-section", data)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetImageSection renders the image gallery of the stamp page
+func (h *HTMXHandler) GetImageSection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h.renderImageSection(w, vars["id"])
+}
+
+// UploadImage adds the uploaded image to the gallery of a stamp, or of one of its groups of copies when
+// "instance_id" is given, and re-renders the stamp's image gallery
+func (h *HTMXHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Stamp not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Images go in the design's gallery unless one of its groups of copies is picked
+	stampOwner, instanceOwner := &stampID, formString(r, "instance_id")
+	if instanceOwner != nil {
+		found := false
+		for _, instance := range stamp.Instances {
+			found = found || instance.ID == *instanceOwner
+		}
+		if !found {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+			return
+		}
+		stampOwner = nil
+	}
+
+	if _, status, err := saveGalleryImage(r, h.imageService, h.index, stampOwner, instanceOwner); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	h.renderImageSection(w, stampID)
+}
+
+// UpdateImage saves the type and caption of an image edited on the stamp page
+func (h *HTMXHandler) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	image, stampID, ok := h.getImage(w, imageID)
+	if !ok {
+		return
+	}
+
+	image.Type = r.FormValue("type")
+	image.Caption = formString(r, "caption")
+	if err := services.ValidateImage(image); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.imageService.UpdateImage(image); err != nil {
+		http.Error(w, "Failed to update image", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderImageSection(w, stampID)
+}
+
+// SetPrimaryImage makes an image the one shown for its stamp or group of copies
+func (h *HTMXHandler) SetPrimaryImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	_, stampID, ok := h.getImage(w, imageID)
+	if !ok {
+		return
+	}
+
+	if err := h.imageService.SetPrimaryImage(imageID); err != nil {
+		http.Error(w, "Failed to update image", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderImageSection(w, stampID)
+}
+
+// MoveImage moves an image one place up or down in its gallery
+func (h *HTMXHandler) MoveImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	_, stampID, ok := h.getImage(w, imageID)
+	if !ok {
+		return
+	}
+
+	delta := 1
+	if vars["direction"] == "up" {
+		delta = -1
+	}
+	if err := h.imageService.MoveImage(imageID, delta); err != nil {
+		http.Error(w, "Failed to move image", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderImageSection(w, stampID)
+}
+
+// DeleteImage removes an image and its file from the stamp page
+func (h *HTMXHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	_, stampID, ok := h.getImage(w, imageID)
+	if !ok {
+		return
+	}
+
+	log.Printf("handlers.htmx.DeleteImage: %v", imageID)
+
+	unused, err := h.imageService.DeleteImage(imageID)
+	if err != nil {
+		http.Error(w, "Failed to delete image", http.StatusInternalServerError)
+		return
+	}
+	removeImageFiles(unused)
+
+	h.renderImageSection(w, stampID)
+}
+
+// UploadImageVersion replaces the file of an image with a new upload from the stamp page, keeping the old
+// file in the image's history
+func (h *HTMXHandler) UploadImageVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+
+	image, stampID, ok := h.getImage(w, imageID)
+	if !ok {
+		return
+	}
+
+	if status, err := saveImageVersion(r, h.imageService, h.index, image); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	h.renderImageSection(w, stampID)
+}
+
+// RestoreImageVersion makes an image show one of the earlier versions in its history again
+func (h *HTMXHandler) RestoreImageVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+	versionID := vars["version_id"]
+
+	_, stampID, ok := h.getImage(w, imageID)
+	if !ok {
+		return
+	}
+
+	version, err := h.imageService.GetImageVersion(versionID)
+	if err != nil || version.ImageID != imageID {
+		if err == nil || err == sql.ErrNoRows {
+			http.Error(w, "Image version not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch image version", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.htmx.RestoreImageVersion: %v to %v", imageID, versionID)
+
+	if err := h.imageService.RestoreImageVersion(versionID); err != nil {
+		http.Error(w, "Failed to restore image", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderImageSection(w, stampID)
+}
+
+// getImage fetches an image and the stamp it belongs to, writing an error response if it can't
+func (h *HTMXHandler) getImage(w http.ResponseWriter, imageID string) (*models.StampImage, string, bool) {
+	image, err := h.imageService.GetImage(imageID)
+	if err == nil {
+		var stampID string
+		stampID, err = h.imageService.GetImageStampID(image)
+		if err == nil {
+			return image, stampID, true
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Image not found", http.StatusNotFound)
+	} else {
+		http.Error(w, "Failed to fetch image", http.StatusInternalServerError)
+	}
+	return nil, "", false
+}
+
+func (h *HTMXHandler) renderImageSection(w http.ResponseWriter, stampID string) {
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Stamp not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = h.templates.ExecuteTemplate(w, "stamp-image-section", models.StampDetailView{Stamp: *stamp})
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateCondition adds a condition to the end of the condition list from the settings page
+func (h *HTMXHandler) CreateCondition(w http.ResponseWriter, r *http.Request) {
+	condition, err := h.conditionService.CreateCondition(r.FormValue("name"))
+	if err == nil && strings.TrimSpace(r.FormValue("aliases")) != "" {
+		err = h.conditionService.SetAliases(condition.ID, strings.Split(r.FormValue("aliases"), ","))
+	}
+	h.renderConditionsTable(w, err, "")
+}
+
+// UpdateCondition renames a condition, replaces its aliases or makes it the default, depending on which
+// of the name, aliases and is_default form fields are sent
+func (h *HTMXHandler) UpdateCondition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	conditionID := vars["id"]
+
+	r.ParseForm()
+	log.Printf("handlers.htmx.UpdateCondition: %v %v", conditionID, r.PostForm)
+
+	var err error
+	if _, ok := r.PostForm["name"]; ok {
+		err = h.conditionService.RenameCondition(conditionID, r.PostFormValue("name"))
+	}
+	if _, ok := r.PostForm["aliases"]; ok && err == nil {
+		err = h.conditionService.SetAliases(conditionID, strings.Split(r.PostFormValue("aliases"), ","))
+	}
+	if _, ok := r.PostForm["is_default"]; ok && err == nil {
+		if r.PostFormValue("is_default") == "true" {
+			err = h.conditionService.SetDefault(conditionID)
+		} else {
+			err = h.conditionService.SetDefault("")
+		}
+	}
+	h.renderConditionsTable(w, err, "")
+}
+
+// MoveCondition moves a condition one place up or down the list
+func (h *HTMXHandler) MoveCondition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	delta := 1
+	if vars["direction"] == "up" {
+		delta = -1
+	}
+	h.renderConditionsTable(w, h.conditionService.MoveCondition(vars["id"], delta), "")
+}
+
+// MergeCondition folds a condition, its aliases and its copies into the condition given as "into"
+func (h *HTMXHandler) MergeCondition(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	conditionID := vars["id"]
+
+	log.Printf("handlers.htmx.MergeCondition: %v into %v", conditionID, r.FormValue("into"))
+
+	h.renderConditionsTable(w, h.conditionService.MergeCondition(conditionID, r.FormValue("into")), "")
+}
+
+// DeleteCondition removes an unused condition from the list
+func (h *HTMXHandler) DeleteCondition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	conditionID := vars["id"]
+
+	log.Printf("handlers.htmx.DeleteCondition: %v", conditionID)
+
+	h.renderConditionsTable(w, h.conditionService.DeleteCondition(conditionID), "")
+}
+
+// NormalizeConditions rewrites the stored condition values to their listed names and reports how many
+// copies changed
+func (h *HTMXHandler) NormalizeConditions(w http.ResponseWriter, r *http.Request) {
+	result, err := h.conditionService.NormalizeConditions()
+	if err != nil {
+		http.Error(w, "Failed to normalize conditions", http.StatusInternalServerError)
+		return
+	}
+
+	notice := "Updated " + strconv.Itoa(result.Updated) + " copies"
+	if len(result.Values) > 0 {
+		notice += "; " + strconv.Itoa(len(result.Values)) + " values match no condition"
+	}
+	h.renderConditionsTable(w, nil, notice)
+}
+
+// renderConditionsTable returns the condition list after a change. An invalid change is shown above
+// the list rather than failing the request.
+func (h *HTMXHandler) renderConditionsTable(w http.ResponseWriter, err error, notice string) {
+	data := models.SettingsView{ConditionNotice: notice}
+	if err != nil {
+		if err != sql.ErrNoRows && !errors.Is(err, services.ErrInvalidCondition) {
+			http.Error(w, "Failed to update conditions", http.StatusInternalServerError)
+			return
+		}
+		data.ConditionError = err.Error()
+		if err == sql.ErrNoRows {
+			data.ConditionError = "Condition not found"
+		}
+	}
+
+	var fetchErr error
+	if data.Conditions, fetchErr = h.conditionService.GetConditions(); fetchErr == nil {
+		data.ConditionValues, fetchErr = h.conditionService.GetConditionValues()
+	}
+	if fetchErr != nil {
+		http.Error(w, "Failed to fetch conditions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := h.templates.ExecuteTemplate(w, "conditions-table", data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// formString returns a trimmed form value, or nil if it is empty
+func formString(r *http.Request, key string) *string {
+	value := strings.TrimSpace(r.FormValue(key))
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// formInt returns a form value as an int, or nil if it is empty or not a number
+func formInt(r *http.Request, key string) *int {
+	value, err := strconv.Atoi(strings.TrimSpace(r.FormValue(key)))
+	if err != nil {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// imageIndex records what the images in stamp galleries are searched by: their perceptual hashes and main
+// colours. Images are indexed as they are saved, after their variants are made, and searches and listings only
+// read the index.
+type imageIndex struct {
+	search   *services.ImageSearchService
+	palettes *services.PaletteService
+}
+
+func newImageIndex(db *sql.DB) *imageIndex {
+	return &imageIndex{
+		search:   services.NewImageSearchService(db),
+		palettes: services.NewPaletteService(db),
+	}
+}
+
+// add indexes the image file at fileURL. Files that can't be read are recorded as such, so they aren't tried
+// again; any other error, such as unreachable storage, is returned and the file is tried again by IndexImages.
+func (x *imageIndex) add(fileURL string) error {
+	img, err := loadImageForHash(fileURL)
+	if err != nil && !errors.Is(err, imaging.ErrUnsupported) && !errors.Is(err, imaging.ErrTooLarge) &&
+		!errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	var hash *uint64
+	var palette []imaging.Swatch
+	if err == nil {
+		value := imaging.PerceptualHash(img)
+		hash = &value
+		// A scan on a mat is narrowed to the stamp, so the mat isn't taken for the paper or the ink
+		if boxes := imaging.DetectStamps(img); len(boxes) == 1 {
+			img = imaging.Crop(img, boxes[0])
+		}
+		palette = imaging.Palette(img)
+	}
+	if err := x.search.SaveImageHash(fileURL, hash); err != nil {
+		return err
+	}
+	return x.palettes.SavePalette(fileURL, palette)
+}
+
+// IndexImages indexes the images in stamp galleries that haven't been, such as those saved before the index
+// was kept, and returns how many it indexed. It is run by the backfill-images command.
+func IndexImages(db *sql.DB) (int, error) {
+	index := newImageIndex(db)
+	unhashed, err := index.search.GetUnhashedImageURLs()
+	if err != nil {
+		return 0, err
+	}
+	unextracted, err := index.palettes.GetUnextractedImageURLs()
+	if err != nil {
+		return 0, err
+	}
+
+	// An image indexed before colours were kept has a hash but no palette
+	fileURLs := unhashed
+	seen := make(map[string]bool, len(unhashed))
+	for _, fileURL := range unhashed {
+		seen[fileURL] = true
+	}
+	for _, fileURL := range unextracted {
+		if !seen[fileURL] {
+			fileURLs = append(fileURLs, fileURL)
+		}
+	}
+
+	indexed := 0
+	for _, fileURL := range fileURLs {
+		if err := index.add(fileURL); err != nil {
+			log.Printf("handlers.imageindex.IndexImages: %v: %v", fileURL, err)
+			continue
+		}
+		indexed++
+	}
+	return indexed, nil
+}
@@ -0,0 +1,762 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// mimeExtensions maps the file types uploads are accepted in to the extension they are saved with. Files are
+// named after their type as detected from their content, so each is served as what it is.
+var mimeExtensions = map[string]string{
+	"image/jpeg":                ".jpg",
+	"image/png":                 ".png",
+	"image/gif":                 ".gif",
+	"image/webp":                ".webp",
+	"image/bmp":                 ".bmp",
+	"image/tiff":                ".tif",
+	"application/pdf":           ".pdf",
+	"text/plain; charset=utf-8": ".txt",
+}
+
+// detectContentType is http.DetectContentType, which doesn't know TIFF, the format many scanners save in
+func detectContentType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(data)
+}
+
+// uploadKind describes a kind of upload: the form field it arrives in, the file types accepted and how large
+// the file may be
+type uploadKind struct {
+	field   string
+	types   []string // Accepted content type prefixes
+	message string   // Shown when the file is of another type
+	maxSize int64
+}
+
+var (
+	// High-resolution scans of single stamps, which are shown in a deep-zoom viewer, can be large too
+	imageUpload      = uploadKind{"image", []string{"image/"}, "File must be an image", 60 << 20}
+	scanUpload       = uploadKind{"file", []string{"image/", "application/pdf"}, "File must be an image or a PDF", 5 << 20}
+	attachmentUpload = uploadKind{"file", []string{"image/", "application/pdf", "text/plain"}, "File must be an image, a PDF or a text file", 5 << 20}
+	// Whole album pages scanned at 600 DPI run to tens of megabytes
+	pageScanUpload = uploadKind{"page", []string{"image/jpeg", "image/png"}, "The page scan must be a JPEG or PNG image", 60 << 20}
+)
+
+// uploadedFile is a file saved by saveUploadedFile
+type uploadedFile struct {
+	URL         string
+	Key         string // Location in storage
+	Filename    string // Name of the file as uploaded
+	ContentType string
+	Size        int64
+}
+
+// saveUploadedImage stores the uploaded "image" form file as <dir>/<name><ext> in the image storage and
+// returns its URL. Images for stamp galleries are added to the index, which is nil for others such as covers.
+// On failure it returns the HTTP status to report along with a message for the user.
+func saveUploadedImage(r *http.Request, index *imageIndex, dir, name string) (string, int, error) {
+	saved, status, err := saveUploadedFile(r, imageUpload, dir, name)
+	if err != nil {
+		return "", status, err
+	}
+	makeImageVariants(saved.Key, index)
+	return saved.URL, status, nil
+}
+
+// makeImageVariants makes the smaller copies of a newly saved image that pages show in its place and, if it
+// is a large scan, its deep-zoom tiles, then adds it to the index unless that is nil. This is done in the
+// background, as decoding a large image takes a while; until the variants are ready pages show the image whole,
+// as they do images that can't be decoded, such as WebP uploads.
+func makeImageVariants(key string, index *imageIndex) {
+	go processImage(key, index)
+}
+
+// processing is held while the variants and tiles of an image are made or removed. Decoding a large scan takes
+// a lot of memory, so images are processed one at a time.
+var processing sync.Mutex
+
+func processImage(key string, index *imageIndex) {
+	processing.Lock()
+	defer processing.Unlock()
+
+	// The image may have been deleted again before its turn came, e.g. when saving its record failed, and
+	// then there is nothing to index either
+	err := imaging.MakeVariants(storage.Files, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return
+	}
+	if err != nil && !errors.Is(err, imaging.ErrUnsupported) {
+		log.Printf("handlers.images.processImage: %v: %v", key, err)
+		return
+	}
+	if index != nil {
+		if err := index.add(storage.URL(key)); err != nil {
+			log.Printf("handlers.images.processImage: %v: %v", key, err)
+		}
+	}
+	if _, err := imaging.MakeTiles(storage.Files, key); err != nil &&
+		!errors.Is(err, imaging.ErrUnsupported) && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("handlers.images.processImage: %v: %v", key, err)
+	}
+}
+
+// saveUploadedScan stores the uploaded "file" form file, an image or a PDF, like saveUploadedImage
+func saveUploadedScan(r *http.Request, dir, name string) (string, int, error) {
+	saved, status, err := saveUploadedFile(r, scanUpload, dir, name)
+	if err != nil {
+		return "", status, err
+	}
+	return saved.URL, status, nil
+}
+
+// saveUploadedFile stores an uploaded form file of the given kind as <dir>/<name><ext> in the image storage.
+// Files are limited in size by their kind and their type is checked from their content, not their name.
+func saveUploadedFile(r *http.Request, kind uploadKind, dir, name string) (*uploadedFile, int, error) {
+	tooLarge := fmt.Errorf("File too large. Maximum size is %dMB.", kind.maxSize>>20)
+	if err := r.ParseMultipartForm(5 << 20); err != nil {
+		return nil, http.StatusBadRequest, tooLarge
+	}
+
+	file, header, err := r.FormFile(kind.field)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("No file uploaded")
+	}
+	defer file.Close()
+
+	if header.Size > kind.maxSize {
+		return nil, http.StatusBadRequest, tooLarge
+	}
+
+	// Validate file type by reading the first 512 bytes
+	buffer := make([]byte, 512)
+	n, err := file.Read(buffer)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error reading file")
+	}
+	file.Seek(0, 0)
+
+	// Types without an extension, such as icons or UTF-16 text, are turned away too
+	contentType := detectContentType(buffer[:n])
+	ext := mimeExtensions[contentType]
+	accepted := false
+	for _, prefix := range kind.types {
+		if strings.HasPrefix(contentType, prefix) {
+			accepted = true
+		}
+	}
+	if !accepted || ext == "" {
+		return nil, http.StatusBadRequest, errors.New(kind.message)
+	}
+
+	// An image is decoded whole to make its variants, so one claiming too many pixels is turned away before then
+	if strings.HasPrefix(contentType, "image/") {
+		if err := imaging.CheckSize(file); errors.Is(err, imaging.ErrTooLarge) {
+			return nil, http.StatusBadRequest, fmt.Errorf("Image too large. Maximum size is %d megapixels.",
+				imaging.MaxPixels/1_000_000)
+		}
+		file.Seek(0, 0)
+	}
+
+	key := dir + "/" + name + ext
+	contentType = strings.TrimSuffix(contentType, "; charset=utf-8")
+	if err := storage.Files.Put(key, file, contentType); err != nil {
+		log.Printf("handlers.images.saveUploadedFile: %v: %v", key, err)
+		return nil, http.StatusInternalServerError, errors.New("Error saving file")
+	}
+
+	return &uploadedFile{
+		URL:         storage.URL(key),
+		Key:         key,
+		Filename:    header.Filename,
+		ContentType: contentType,
+		Size:        header.Size,
+	}, http.StatusOK, nil
+}
+
+type ImageHandler struct {
+	db              *sql.DB
+	templates       *template.Template
+	service         *services.ImageService
+	stampService    *services.StampService
+	instanceService *services.InstanceService
+	index           *imageIndex
+}
+
+func NewImageHandler(db *sql.DB, templates *template.Template) *ImageHandler {
+	return &ImageHandler{
+		db:              db,
+		templates:       templates,
+		service:         services.NewImageService(db),
+		stampService:    services.NewStampService(db),
+		instanceService: services.NewInstanceService(db),
+		index:           newImageIndex(db),
+	}
+}
+
+// GetStampImages lists the gallery of a stamp design
+func (h *ImageHandler) GetStampImages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	if !h.stampExists(w, stampID) {
+		return
+	}
+
+	images, err := h.service.GetStampImages(stampID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if images == nil {
+		images = []models.StampImage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(images)
+}
+
+// GetInstanceImages lists the gallery of a group of copies
+func (h *ImageHandler) GetInstanceImages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	if !h.instanceExists(w, instanceID) {
+		return
+	}
+
+	images, err := h.service.GetInstanceImages(instanceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if images == nil {
+		images = []models.StampImage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(images)
+}
+
+// AddStampImage adds the uploaded "image" form file to the gallery of a stamp design, with the
+// optional "type", "caption" and "primary" form fields
+func (h *ImageHandler) AddStampImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stampID := vars["id"]
+
+	if !h.stampExists(w, stampID) {
+		return
+	}
+
+	image, status, err := saveGalleryImage(r, h.service, h.index, &stampID, nil)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(image)
+}
+
+// AddInstanceImage adds the uploaded "image" form file to the gallery of a group of copies, like
+// AddStampImage
+func (h *ImageHandler) AddInstanceImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	if !h.instanceExists(w, instanceID) {
+		return
+	}
+
+	image, status, err := saveGalleryImage(r, h.service, h.index, nil, &instanceID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(image)
+}
+
+// UpdateImage changes the type or caption of an image, or makes it the primary image with "is_primary": true
+func (h *ImageHandler) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingImage, err := h.service.GetImage(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if imageType, ok := updates["type"].(string); ok {
+		existingImage.Type = imageType
+	}
+	if _, ok := updates["caption"]; ok {
+		existingImage.Caption = optionalString(updates["caption"])
+	}
+
+	if err := services.ValidateImage(existingImage); err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	log.Printf("handlers.images.UpdateImage: %+v", existingImage)
+
+	if _, err := h.service.UpdateImage(existingImage); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isPrimary, ok := updates["is_primary"].(bool); ok && isPrimary && !existingImage.IsPrimary {
+		if err := h.service.SetPrimaryImage(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	updatedImage, err := h.service.GetImage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedImage)
+}
+
+// ReorderImages sets the display order of a gallery from a JSON list of image IDs
+func (h *ImageHandler) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ReorderImages(ids); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteImage removes an image from its gallery along with the files of all its versions
+func (h *ImageHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if _, err := h.service.GetImage(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.images.DeleteImage: %v", id)
+
+	unused, err := h.service.DeleteImage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	removeImageFiles(unused)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetImageVersions lists the kept versions of an image, newest first
+func (h *ImageHandler) GetImageVersions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if _, err := h.service.GetImage(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	versions, err := h.service.GetImageVersions(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []models.ImageVersion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetImageTiles serves the Deep Zoom descriptor of a large image, which lists its tiles
+func (h *ImageHandler) GetImageTiles(w http.ResponseWriter, r *http.Request) {
+	h.serveTiles(w, r, imaging.TilesPath)
+}
+
+// GetImageTile serves one deep-zoom tile of a large image, by its level, column and row
+func (h *ImageHandler) GetImageTile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	level, err1 := strconv.Atoi(vars["level"])
+	column, err2 := strconv.Atoi(vars["column"])
+	row, err3 := strconv.Atoi(vars["row"])
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.serveTiles(w, r, func(original string) string {
+		return imaging.TilePath(original, level, column, row)
+	})
+}
+
+// serveTiles serves the file of an image's tiles that tilePath finds from the image's own file
+func (h *ImageHandler) serveTiles(w http.ResponseWriter, r *http.Request, tilePath func(original string) string) {
+	image, err := h.service.GetImage(mux.Vars(r)["id"])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	key, ok := storage.Key(image.FileURL)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	storage.Serve(storage.Files, w, r, tilePath(key))
+}
+
+// UploadImageVersion replaces the file of an image with the uploaded "image" form file, keeping the
+// previous file as an earlier version
+func (h *ImageHandler) UploadImageVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	image, err := h.service.GetImage(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if status, err := saveImageVersion(r, h.service, h.index, image); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	updatedImage, err := h.service.GetImage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(updatedImage)
+}
+
+// EditImage crops, rotates or flips an image from a JSON imaging.Edit, saving the result as a new version
+func (h *ImageHandler) EditImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	image, err := h.service.GetImage(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Image not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var edit imaging.Edit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("handlers.images.EditImage: %v: %+v", id, edit)
+
+	if status, err := saveEditedImage(r, h.service, h.index, image, edit); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	editedImage, err := h.service.GetImage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(editedImage)
+}
+
+// RestoreImageVersion makes an image show one of its earlier versions again
+func (h *ImageHandler) RestoreImageVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	versionID := vars["version_id"]
+
+	version, err := h.service.GetImageVersion(versionID)
+	if err != nil || version.ImageID != id {
+		if err == nil || err == sql.ErrNoRows {
+			http.Error(w, "Image version not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.images.RestoreImageVersion: %v to %v", id, versionID)
+
+	if err := h.service.RestoreImageVersion(versionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	restoredImage, err := h.service.GetImage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restoredImage)
+}
+
+// stampExists reports whether the stamp exists, writing an error response if it doesn't
+func (h *ImageHandler) stampExists(w http.ResponseWriter, stampID string) bool {
+	if _, err := h.stampService.GetStampByID(stampID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Stamp not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// instanceExists reports whether the group of copies exists, writing an error response if it doesn't
+func (h *ImageHandler) instanceExists(w http.ResponseWriter, instanceID string) bool {
+	if _, err := h.instanceService.GetStampInstance(instanceID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// saveGalleryImage stores the uploaded "image" form file as stamps/<image id><ext> in the image storage and adds
+// it to the gallery of the stamp or group of copies. On failure it returns the HTTP status to report along
+// with a message for the user.
+func saveGalleryImage(r *http.Request, service *services.ImageService, index *imageIndex, stampID, instanceID *string) (*models.StampImage, int, error) {
+	image := models.StampImage{
+		ID:           uuid.New().String(),
+		StampID:      stampID,
+		InstanceID:   instanceID,
+		DateAdded:    time.Now(),
+		DateModified: time.Now(),
+	}
+
+	saved, status, err := saveUploadedFile(r, imageUpload, "stamps", image.ID)
+	if err != nil {
+		return nil, status, err
+	}
+
+	image.Type = r.FormValue("type")
+	image.Caption = formString(r, "caption")
+	image.IsPrimary = r.FormValue("primary") == "true"
+	image.FileURL = saved.URL
+	if err := services.ValidateImage(&image); err != nil {
+		removeImageFiles([]string{saved.URL})
+		return nil, http.StatusBadRequest, err
+	}
+
+	makeImageVariants(saved.Key, index)
+	if _, err := service.CreateImage(&image, uploaderName(r)); err != nil {
+		removeImageFiles([]string{saved.URL})
+		return nil, http.StatusInternalServerError, errors.New("Error saving image")
+	}
+	return &image, http.StatusCreated, nil
+}
+
+// saveImageVersion stores the uploaded "image" form file under a name of its own and makes it the new
+// version of the image, deleting the files of versions beyond the kept number. On failure it returns the
+// HTTP status to report along with a message for the user.
+func saveImageVersion(r *http.Request, service *services.ImageService, index *imageIndex, image *models.StampImage) (int, error) {
+	imageURL, status, err := saveUploadedImage(r, index, "stamps", uuid.New().String())
+	if err != nil {
+		return status, err
+	}
+
+	pruned, err := service.AddImageVersion(image.ID, imageURL, uploaderName(r))
+	if err != nil {
+		removeImageFiles([]string{imageURL})
+		return http.StatusInternalServerError, errors.New("Error saving image")
+	}
+	removeImageFiles(pruned)
+	return http.StatusCreated, nil
+}
+
+// saveEditedImage makes an edit to the file an image shows and saves the result as a new version of the
+// image, so the unedited file can be restored. PNGs stay PNGs to keep them lossless; other images become
+// JPEGs. On failure it returns the HTTP status to report along with a message for the user.
+func saveEditedImage(r *http.Request, service *services.ImageService, index *imageIndex, image *models.StampImage, edit imaging.Edit) (int, error) {
+	if err := edit.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
+	key, ok := storage.Key(image.FileURL)
+	if !ok {
+		return http.StatusBadRequest, errors.New("Only uploaded images can be edited")
+	}
+
+	original, err := imaging.Load(storage.Files, key)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUnsupported) {
+			return http.StatusBadRequest, errors.New("Only JPEG, PNG and GIF images can be edited")
+		}
+		if errors.Is(err, imaging.ErrTooLarge) {
+			return http.StatusBadRequest, errors.New("The image is too large to edit")
+		}
+		log.Printf("handlers.images.saveEditedImage: %v: %v", key, err)
+		return http.StatusInternalServerError, errors.New("Error reading image")
+	}
+
+	edited, err := edit.Apply(original)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	// Lossless originals, such as TIFF scans, stay lossless
+	ext, contentType, encode := ".jpg", "image/jpeg", imaging.EncodeJPEG
+	if path.Ext(key) == ".png" || path.Ext(key) == ".tif" {
+		ext, contentType, encode = ".png", "image/png", imaging.EncodePNG
+	}
+	data, err := encode(edited)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("Error saving image")
+	}
+
+	editedKey := "stamps/" + uuid.New().String() + ext
+	if err := storage.Files.Put(editedKey, bytes.NewReader(data), contentType); err != nil {
+		log.Printf("handlers.images.saveEditedImage: %v: %v", editedKey, err)
+		return http.StatusInternalServerError, errors.New("Error saving image")
+	}
+	makeImageVariants(editedKey, index)
+
+	pruned, err := service.AddImageVersion(image.ID, storage.URL(editedKey), uploaderName(r))
+	if err != nil {
+		removeImageFiles([]string{storage.URL(editedKey)})
+		return http.StatusInternalServerError, errors.New("Error saving image")
+	}
+	removeImageFiles(pruned)
+	return http.StatusCreated, nil
+}
+
+// TrustedProxies are the authenticating reverse proxies whose user headers are believed. It is set from
+// TRUSTED_PROXIES at startup; any client could send the headers, so with none set they are ignored.
+var TrustedProxies []*net.IPNet
+
+// uploaderName is who an upload is recorded against. StampKeeper has no accounts of its own, so this is the
+// user passed along by one of TrustedProxies, or nil if the upload didn't come through one.
+func uploaderName(r *http.Request) *string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	for _, proxy := range TrustedProxies {
+		if !proxy.Contains(ip) {
+			continue
+		}
+		for _, header := range []string{"X-Forwarded-User", "X-Remote-User", "Remote-User"} {
+			if user := strings.TrimSpace(r.Header.Get(header)); user != "" {
+				return &user
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// removeImageFiles deletes image files that are no longer used, along with their variants. Only uploaded
+// files are deleted; images linked from elsewhere are left alone.
+func removeImageFiles(fileURLs []string) {
+	for _, fileURL := range fileURLs {
+		key, ok := storage.Key(fileURL)
+		if !ok || !strings.HasPrefix(key, "stamps/") {
+			continue
+		}
+		processing.Lock()
+		if err := storage.Files.Delete(key); err != nil {
+			log.Printf("handlers.images.removeImageFiles: %v", err)
+		}
+		imaging.RemoveVariants(storage.Files, key)
+		imaging.RemoveTiles(storage.Files, key)
+		processing.Unlock()
+	}
+}
+
+// writeImageError reports an unknown image type as a bad request and anything else as a server error
+func writeImageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidImage) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"log"
+	"net/http"
+
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// imageSearchLimit is how many stamps a search by image returns at most
+const imageSearchLimit = 12
+
+// ImageSearchHandler identifies stamps from a photo by comparing perceptual hashes of their images
+type ImageSearchHandler struct {
+	db        *sql.DB
+	templates *template.Template
+	service   *services.ImageSearchService
+}
+
+func NewImageSearchHandler(db *sql.DB, templates *template.Template) *ImageSearchHandler {
+	return &ImageSearchHandler{
+		db:        db,
+		templates: templates,
+		service:   services.NewImageSearchService(db),
+	}
+}
+
+// FindStampsByImage lists the stamps that look like the uploaded "image" form file, closest first, with the
+// similarity of each
+func (h *ImageSearchHandler) FindStampsByImage(w http.ResponseWriter, r *http.Request) {
+	matches, status, err := h.findStamps(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// GetDuplicateStamps lists pairs of stamp records whose images match, likely the same design entered twice
+func (h *ImageSearchHandler) GetDuplicateStamps(w http.ResponseWriter, r *http.Request) {
+	pairs, err := h.findDuplicates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pairs)
+}
+
+// GetImageSearchView renders the page for finding stamps by a photo
+func (h *ImageSearchHandler) GetImageSearchView(w http.ResponseWriter, r *http.Request) {
+	h.render(w, "image-search.html", models.ImageSearchView{})
+}
+
+// GetDuplicatesView renders the possible duplicate stamps, which the search page loads after it is shown
+func (h *ImageSearchHandler) GetDuplicatesView(w http.ResponseWriter, r *http.Request) {
+	pairs, err := h.findDuplicates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, "image-search-duplicates", models.ImageSearchView{Duplicates: pairs})
+}
+
+// SearchByImage renders the stamps that look like the uploaded "image" form file
+func (h *ImageSearchHandler) SearchByImage(w http.ResponseWriter, r *http.Request) {
+	matches, _, err := h.findStamps(r)
+	data := models.ImageSearchView{Matches: matches, Searched: err == nil}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	h.render(w, "image-search-results", data)
+}
+
+func (h *ImageSearchHandler) render(w http.ResponseWriter, name string, data models.ImageSearchView) {
+	if err := h.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// findStamps compares the uploaded "image" form file with the front images of every stamp. A photo of one
+// stamp lying on a table is narrowed to the stamp first, and it is compared at each quarter turn. On failure
+// it returns the HTTP status to report along with a message for the user.
+func (h *ImageSearchHandler) findStamps(r *http.Request) ([]models.StampMatch, int, error) {
+	img, status, err := readUploadedImage(r, imageUpload)
+	if err != nil {
+		return nil, status, err
+	}
+	if boxes := imaging.DetectStamps(img); len(boxes) == 1 {
+		img = imaging.Crop(img, boxes[0])
+	}
+
+	hashes := imaging.PerceptualHashes(img)
+	matches, err := h.service.FindStamps(hashes[:], imageSearchLimit)
+	if err != nil {
+		log.Printf("handlers.imagesearch.findStamps: %v", err)
+		return nil, http.StatusInternalServerError, errors.New("Error searching the collection")
+	}
+	return matches, http.StatusOK, nil
+}
+
+func (h *ImageSearchHandler) findDuplicates() ([]models.DuplicateStamps, error) {
+	return h.service.GetDuplicateStamps()
+}
+
+// loadImageForHash decodes an uploaded image, from its thumbnail where there is one as that is much quicker
+// to read. Images linked from elsewhere are reported as storage.ErrNotFound.
+func loadImageForHash(fileURL string) (image.Image, error) {
+	key, ok := storage.Key(fileURL)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	if thumbKey, ok := storage.Key(imaging.VariantURL(storage.Files, fileURL, "thumb", ".jpg")); ok {
+		key = thumbKey
+	}
+	return imaging.Load(storage.Files, key)
+}
+
+// readUploadedImage decodes an uploaded form file of the given kind without saving it. On failure it returns
+// the HTTP status to report along with a message for the user.
+func readUploadedImage(r *http.Request, kind uploadKind) (image.Image, int, error) {
+	tooLarge := fmt.Errorf("File too large. Maximum size is %dMB.", kind.maxSize>>20)
+	if err := r.ParseMultipartForm(5 << 20); err != nil {
+		return nil, http.StatusBadRequest, tooLarge
+	}
+
+	file, header, err := r.FormFile(kind.field)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("No file uploaded")
+	}
+	defer file.Close()
+
+	if header.Size > kind.maxSize {
+		return nil, http.StatusBadRequest, tooLarge
+	}
+
+	img, err := imaging.Decode(file)
+	if errors.Is(err, imaging.ErrTooLarge) {
+		return nil, http.StatusBadRequest, fmt.Errorf("Image too large. Maximum size is %d megapixels.", imaging.MaxPixels/1_000_000)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("The image must be a JPEG, PNG or GIF")
+	}
+	return img, http.StatusOK, nil
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"net/http"
 	"strings"
@@ -16,16 +17,18 @@ import (
 )
 
 type InstanceHandler struct {
-	db        *sql.DB
-	templates *template.Template
-	service   *services.InstanceService
+	db               *sql.DB
+	templates        *template.Template
+	service          *services.InstanceService
+	conditionService *services.ConditionService
 }
 
 func NewInstanceHandler(db *sql.DB, templates *template.Template) *InstanceHandler {
 	return &InstanceHandler{
-		db:        db,
-		templates: templates,
-		service:   services.NewInstanceService(db),
+		db:               db,
+		templates:        templates,
+		service:          services.NewInstanceService(db),
+		conditionService: services.NewConditionService(db),
 	}
 }
 
@@ -51,9 +54,25 @@ func (h *InstanceHandler) CreateStampInstance(w http.ResponseWriter, r *http.Req
 		instance.Quantity = 1
 	}
 
+	// Copies added without a condition are recorded in the default condition, if one is set
+	if instance.Condition == nil || strings.TrimSpace(*instance.Condition) == "" {
+		defaultCondition, err := h.conditionService.GetDefaultCondition()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		instance.Condition = defaultCondition
+	}
+
+	components, err := h.validateInstance(&instance, instance.Components)
+	if err != nil {
+		writeInstanceError(w, err)
+		return
+	}
+
 	log.Printf("%s Creating Stamp Instance: %+v", logPrefix, instance)
 
-	_, err := h.service.CreateStampInstance(&instance)
+	_, err = h.service.CreateStampInstance(&instance)
 	if err != nil {
 		log.Printf("%s Error creating stamp instance: %v", logPrefix, err)
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
@@ -64,6 +83,14 @@ func (h *InstanceHandler) CreateStampInstance(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if len(components) > 0 {
+		if err := h.service.SetInstanceComponents(instance.ID, components); err != nil {
+			log.Printf("%s Error saving components: %v", logPrefix, err)
+			writeInstanceError(w, err)
+			return
+		}
+	}
+
 	// After creating, fetch the full instance data to get BoxName etc.
 	fullInstance, err := h.service.GetStampInstance(instance.ID)
 	if err != nil {
@@ -128,6 +155,65 @@ func (h *InstanceHandler) UpdateStampInstance(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	if format, ok := updates["format"].(string); ok {
+		existingInstance.Format = format
+	}
+
+	if plateNumber, ok := updates["plate_number"]; ok {
+		if plateNumber == nil || plateNumber == "" {
+			existingInstance.PlateNumber = nil
+		} else if plateNumberStr, ok := plateNumber.(string); ok {
+			existingInstance.PlateNumber = &plateNumberStr
+		}
+	}
+
+	if platePosition, ok := updates["plate_position"]; ok {
+		if platePosition == nil || platePosition == "" {
+			existingInstance.PlatePosition = nil
+		} else if platePositionStr, ok := platePosition.(string); ok {
+			existingInstance.PlatePosition = &platePositionStr
+		}
+	}
+
+	// Grading
+	for field, target := range instanceGradingFields {
+		if value, ok := updates[field]; ok {
+			*target(existingInstance) = optionalString(value)
+		}
+	}
+
+	if grade, ok := updates["grade"]; ok {
+		existingInstance.Grade = optionalInt(grade)
+		if existingInstance.Grade != nil && *existingInstance.Grade == 0 {
+			existingInstance.Grade = nil
+		}
+	}
+
+	if faults, ok := updates["faults"]; ok {
+		existingInstance.Faults = listValue(faults)
+	}
+
+	if catalogueValue, ok := updates["catalogue_value"]; ok {
+		existingInstance.CatalogueValue = optionalFloat(catalogueValue)
+	}
+
+	// Components are replaced as a whole when given
+	var components []models.InstanceComponent
+	_, componentsGiven := updates["components"]
+	if componentsGiven {
+		raw, _ := json.Marshal(updates["components"])
+		if err := json.Unmarshal(raw, &components); err != nil {
+			http.Error(w, "Invalid components: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	components, err = h.validateInstance(existingInstance, components)
+	if err != nil {
+		writeInstanceError(w, err)
+		return
+	}
+
 	existingInstance.DateModified = time.Now()
 
 	// If quantity is 0, delete the instance
@@ -146,6 +232,20 @@ func (h *InstanceHandler) UpdateStampInstance(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if componentsGiven {
+		if err := h.service.SetInstanceComponents(instanceID, components); err != nil {
+			writeInstanceError(w, err)
+			return
+		}
+		// Reload so the response carries the components' Scott numbers and names
+		updatedInstance, err = h.service.GetStampInstance(instanceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	updatedInstance.EstimatedValue = services.EstimateValue(updatedInstance)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedInstance)
 }
@@ -178,4 +278,60 @@ func (h *InstanceHandler) GetStampInstance(w http.ResponseWriter, r *http.Reques
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(instance)
+}
+
+// validateInstance checks the instance's condition against the condition list, storing its listed name,
+// checks its format and grading and resolves the stamp IDs of its components
+func (h *InstanceHandler) validateInstance(instance *models.StampInstance, components []models.InstanceComponent) ([]models.InstanceComponent, error) {
+	if instance.Condition != nil {
+		condition, err := h.conditionService.ResolveCondition(*instance.Condition)
+		if err != nil {
+			return nil, err
+		}
+		instance.Condition = condition
+	}
+	if err := services.ValidateFormat(instance); err != nil {
+		return nil, err
+	}
+	if err := services.ValidateGrading(instance); err != nil {
+		return nil, err
+	}
+	return h.service.ResolveComponents(instance.StampID, components)
+}
+
+// writeInstanceError reports an invalid format or component as a bad request and anything else as a server error
+func writeInstanceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidInstance) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// instanceGradingFields are the free-text grading fields of a copy that can be set through the API
+var instanceGradingFields = map[string]func(i *models.StampInstance) **string{
+	"gum":         func(i *models.StampInstance) **string { return &i.Gum },
+	"centering":   func(i *models.StampInstance) **string { return &i.Centering },
+	"cancel_type": func(i *models.StampInstance) **string { return &i.CancelType },
+	"grade_notes": func(i *models.StampInstance) **string { return &i.GradeNotes },
+}
+
+// listValue converts a JSON array of strings, or a comma-separated string such as "thin, crease", to a list
+func listValue(v interface{}) []string {
+	var values []string
+	switch list := v.(type) {
+	case string:
+		for _, part := range strings.Split(list, ",") {
+			if value := strings.TrimSpace(part); value != "" {
+				values = append(values, value)
+			}
+		}
+	case []interface{}:
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
 }
\ No newline at end of file
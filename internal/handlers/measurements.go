@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// Resolutions outside this range, in dots per inch, are taken for typing mistakes
+const (
+	minDPI = 100
+	maxDPI = 12000
+)
+
+// MeasurementHandler measures the centering, size and perforations of groups of copies from their scans
+type MeasurementHandler struct {
+	db              *sql.DB
+	templates       *template.Template
+	service         *services.MeasurementService
+	instanceService *services.InstanceService
+	stampService    *services.StampService
+	imageService    *services.ImageService
+}
+
+func NewMeasurementHandler(db *sql.DB, templates *template.Template) *MeasurementHandler {
+	return &MeasurementHandler{
+		db:              db,
+		templates:       templates,
+		service:         services.NewMeasurementService(db),
+		instanceService: services.NewInstanceService(db),
+		stampService:    services.NewStampService(db),
+		imageService:    services.NewImageService(db),
+	}
+}
+
+// MeasureInstance measures a group of copies from the scan named by an optional JSON "image_id", or from its
+// primary image, and returns the saved measurement with a suggested centering grade. Size and perforations
+// are measured too when the scan records its resolution or a JSON "dpi" is given.
+func (h *MeasurementHandler) MeasureInstance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ImageID string  `json:"image_id"`
+		DPI     float64 `json:"dpi"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	measurement, _, status, err := h.measureInstance(mux.Vars(r)["instance_id"], body.ImageID, body.DPI)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(measurement)
+}
+
+// MeasureInstanceFromPage measures a group of copies from the scan, and at the resolution, chosen on the stamp
+// page and re-renders the stamp's measurements section, with the problem if the scan couldn't be measured
+func (h *MeasurementHandler) MeasureInstanceFromPage(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instance_id"]
+
+	var dpi float64
+	if text := strings.TrimSpace(r.FormValue("dpi")); text != "" {
+		var err error
+		if dpi, err = strconv.ParseFloat(text, 64); err != nil {
+			dpi = -1 // Reported as out of range
+		}
+	}
+
+	_, stampID, status, problem := h.measureInstance(instanceID, r.FormValue("image_id"), dpi)
+	if problem != nil && status != http.StatusBadRequest {
+		http.Error(w, problem.Error(), status)
+		return
+	}
+
+	stamp, err := h.stampService.GetStampByID(stampID)
+	if err != nil {
+		http.Error(w, "Failed to fetch stamp", http.StatusInternalServerError)
+		return
+	}
+
+	data := models.StampDetailView{Stamp: *stamp, Grading: services.GetGradingOptions()}
+	if problem != nil {
+		data.MeasurementErrors = map[string]string{instanceID: problem.Error()}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := h.templates.ExecuteTemplate(w, "stamp-measurements-section", data); err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// measureInstance measures and saves the centering of a group of copies from one of its scans, or from one
+// of its stamp's, along with its size and perforations when the resolution is known. A dpi of 0 uses the
+// resolution recorded in the scan. It returns the measurement and the stamp. On failure it returns the HTTP
+// status to report along with a message for the user; the stamp is known for every failure but a missing
+// group of copies.
+func (h *MeasurementHandler) measureInstance(instanceID, imageID string, dpi float64) (*models.InstanceMeasurement, string, int, error) {
+	instance, err := h.instanceService.GetStampInstance(instanceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", http.StatusNotFound, errors.New("Instance not found")
+		}
+		log.Printf("handlers.measurements.measureInstance: %v", err)
+		return nil, "", http.StatusInternalServerError, errors.New("Error fetching instance")
+	}
+
+	if dpi != 0 && (dpi < minDPI || dpi > maxDPI) {
+		return nil, instance.StampID, http.StatusBadRequest, fmt.Errorf("The DPI must be between %d and %d", minDPI, maxDPI)
+	}
+
+	image, err := h.chooseScan(instance, imageID)
+	if err != nil {
+		return nil, instance.StampID, http.StatusBadRequest, err
+	}
+
+	key, ok := storage.Key(image.FileURL)
+	if !ok {
+		return nil, instance.StampID, http.StatusBadRequest, errors.New("Only uploaded images can be measured")
+	}
+	img, recordedDPI, err := imaging.LoadScan(storage.Files, key)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUnsupported) {
+			return nil, instance.StampID, http.StatusBadRequest, errors.New("Only JPEG, PNG, GIF and TIFF images can be measured")
+		}
+		if errors.Is(err, imaging.ErrTooLarge) {
+			return nil, instance.StampID, http.StatusBadRequest, errors.New("The scan is too large to measure")
+		}
+		log.Printf("handlers.measurements.measureInstance: %v: %v", key, err)
+		return nil, instance.StampID, http.StatusInternalServerError, errors.New("Error reading image")
+	}
+	if dpi == 0 {
+		dpi = recordedDPI
+	}
+
+	measurement := models.InstanceMeasurement{
+		InstanceID:   instance.ID,
+		ImageID:      &image.ID,
+		DateMeasured: time.Now(),
+	}
+
+	centering, centeringErr := imaging.MeasureCentering(img)
+	if centeringErr == nil {
+		horizontal, vertical := centering.Balance()
+		grade := services.SuggestCenteringGrade(horizontal, vertical)
+		measurement.MarginLeft, measurement.MarginRight = &centering.Left, &centering.Right
+		measurement.MarginTop, measurement.MarginBottom = &centering.Top, &centering.Bottom
+		measurement.SuggestedCentering = &grade
+	}
+
+	// Without a resolution only the centering can be measured, so it must have worked
+	if dpi == 0 && centeringErr != nil {
+		return nil, instance.StampID, http.StatusBadRequest, centeringErr
+	}
+	if dpi > 0 {
+		perforation, err := imaging.MeasurePerforations(img, dpi)
+		if err != nil && centeringErr != nil {
+			return nil, instance.StampID, http.StatusBadRequest, centeringErr
+		}
+		measurement.DPI = &dpi
+		if err == nil {
+			measurement.WidthMM, measurement.HeightMM = &perforation.WidthMM, &perforation.HeightMM
+			measurement.GaugeTop = readableGauge(perforation.Top)
+			measurement.GaugeBottom = readableGauge(perforation.Bottom)
+			measurement.GaugeLeft = readableGauge(perforation.Left)
+			measurement.GaugeRight = readableGauge(perforation.Right)
+			if gauge := perforation.Gauge(); gauge != "" {
+				measurement.Perforation = &gauge
+			}
+		}
+	}
+
+	log.Printf("handlers.measurements.measureInstance: %s from %s at %.0f DPI", instance.ID, image.ID, dpi)
+
+	saved, err := h.service.SaveMeasurement(&measurement)
+	if err != nil {
+		log.Printf("handlers.measurements.measureInstance: %v", err)
+		return nil, instance.StampID, http.StatusInternalServerError, errors.New("Error saving measurement")
+	}
+	return saved, instance.StampID, http.StatusOK, nil
+}
+
+// readableGauge returns the gauge of an edge to store, or nil for one that couldn't be read
+func readableGauge(gauge float64) *float64 {
+	if gauge == imaging.UnreadableEdge {
+		return nil
+	}
+	return &gauge
+}
+
+// chooseScan returns the image to measure a group of copies from: the one asked for, which must belong to
+// the copies or their stamp, or else the copies' primary image
+func (h *MeasurementHandler) chooseScan(instance *models.StampInstance, imageID string) (*models.StampImage, error) {
+	if imageID == "" {
+		for i, image := range instance.Images {
+			if image.IsPrimary {
+				return &instance.Images[i], nil
+			}
+		}
+		return nil, errors.New("Add a scan of the copy first, or choose one of the stamp's images")
+	}
+
+	image, err := h.imageService.GetImage(imageID)
+	if err != nil {
+		return nil, errors.New("Image not found")
+	}
+	if (image.InstanceID == nil || *image.InstanceID != instance.ID) &&
+		(image.StampID == nil || *image.StampID != instance.StampID) {
+		return nil, errors.New("The image belongs to another stamp")
+	}
+	return image, nil
+}
@@ -3,11 +3,13 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 
 	"github.com/jeepinbird/stampkeeper/internal/middleware"
+	"github.com/jeepinbird/stampkeeper/internal/models"
 	"github.com/jeepinbird/stampkeeper/internal/services"
 )
 
@@ -86,7 +88,12 @@ func (h *PreferencesHandler) GetDefaultView(w http.ResponseWriter, r *http.Reque
 	// Get total items and stamps for the current page using enhanced request with user preferences
 	totalItems, stamps, err := h.stampService.GetStampsWithCount(newReq, page, limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		var parseErr *services.SearchParseError
+		if errors.As(err, &parseErr) {
+			http.Error(w, parseErr.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 	
@@ -97,15 +104,7 @@ func (h *PreferencesHandler) GetDefaultView(w http.ResponseWriter, r *http.Reque
 	}
 	
 	// Create pagination struct
-	pagination := struct {
-		CurrentPage int
-		TotalPages  int
-		TotalItems  int64
-		HasNext     bool
-		HasPrev     bool
-		NextPage    int
-		PrevPage    int
-	}{
+	pagination := models.Pagination{
 		CurrentPage: page,
 		TotalPages:  totalPages,
 		TotalItems:  totalItems,
@@ -120,17 +119,22 @@ func (h *PreferencesHandler) GetDefaultView(w http.ResponseWriter, r *http.Reque
 	scrollQuery.Del("page")
 	baseURLWithParams := "/views/stamps/" + prefs.DefaultView + "/scroll?" + scrollQuery.Encode()
 	
+	// Facet counts for the sidebar, rendered out-of-band alongside the view
+	facets, err := h.stampService.GetFacets(newReq)
+	if err != nil {
+		log.Printf("Warning: could not compute facets: %v", err)
+	}
+
 	// Prepare the data for the template
-	data := struct {
-		Stamps      interface{}
-		Pagination  interface{}
-		BaseURL     string
-		CurrentView string
-	}{
-		Stamps:      stamps,
-		Pagination:  pagination,
-		BaseURL:     baseURLWithParams,
-		CurrentView: prefs.DefaultView,
+	data := models.PaginatedStampsView{
+		Stamps:            stamps,
+		Pagination:        pagination,
+		BaseURL:           baseURLWithParams,
+		CurrentView:       prefs.DefaultView,
+		Facets:            facets,
+		Query:             services.CollectionQueryFromValues(newReq.URL.Query()),
+		CollapseVarieties: newReq.URL.Query().Get("collapse_varieties") == "true",
+		SortByColour:      newReq.URL.Query().Get("sort") == "colour",
 	}
 	
 	// Return the appropriate view template
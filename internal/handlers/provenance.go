@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+// certificateTextFields are the certificate fields that can be set through the API and the stamp page
+var certificateTextFields = map[string]func(c *models.Certificate) **string{
+	"certificate_number": func(c *models.Certificate) **string { return &c.CertificateNumber },
+	"issued_date":        func(c *models.Certificate) **string { return &c.IssuedDate },
+	"opinion":            func(c *models.Certificate) **string { return &c.Opinion },
+	"notes":              func(c *models.Certificate) **string { return &c.Notes },
+}
+
+// provenanceTextFields are the provenance entry fields that can be set through the API and the stamp page
+var provenanceTextFields = map[string]func(p *models.ProvenanceEntry) **string{
+	"owner":         func(p *models.ProvenanceEntry) **string { return &p.Owner },
+	"auction_house": func(p *models.ProvenanceEntry) **string { return &p.AuctionHouse },
+	"sale_name":     func(p *models.ProvenanceEntry) **string { return &p.SaleName },
+	"lot_number":    func(p *models.ProvenanceEntry) **string { return &p.LotNumber },
+	"acquired_date": func(p *models.ProvenanceEntry) **string { return &p.AcquiredDate },
+	"notes":         func(p *models.ProvenanceEntry) **string { return &p.Notes },
+}
+
+type ProvenanceHandler struct {
+	db              *sql.DB
+	templates       *template.Template
+	service         *services.ProvenanceService
+	instanceService *services.InstanceService
+}
+
+func NewProvenanceHandler(db *sql.DB, templates *template.Template) *ProvenanceHandler {
+	return &ProvenanceHandler{
+		db:              db,
+		templates:       templates,
+		service:         services.NewProvenanceService(db),
+		instanceService: services.NewInstanceService(db),
+	}
+}
+
+// CreateCertificate adds an expert certificate to a group of copies
+func (h *ProvenanceHandler) CreateCertificate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	if !h.instanceExists(w, instanceID) {
+		return
+	}
+
+	var certificate models.Certificate
+	if err := json.NewDecoder(r.Body).Decode(&certificate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateCertificate(&certificate); err != nil {
+		writeProvenanceError(w, err)
+		return
+	}
+
+	certificate.ID = uuid.New().String()
+	certificate.InstanceID = instanceID
+	certificate.DateAdded = time.Now()
+	certificate.DateModified = time.Now()
+
+	log.Printf("handlers.provenance.CreateCertificate: %+v", certificate)
+
+	if _, err := h.service.CreateCertificate(&certificate); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	createdCertificate, err := h.service.GetCertificate(certificate.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdCertificate)
+}
+
+func (h *ProvenanceHandler) UpdateCertificate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingCertificate, err := h.service.GetCertificate(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if issuer, ok := updates["issuer"].(string); ok {
+		existingCertificate.Issuer = issuer
+	}
+	for field, target := range certificateTextFields {
+		if value, ok := updates[field]; ok {
+			*target(existingCertificate) = optionalString(value)
+		}
+	}
+	if _, ok := updates["file_url"]; ok {
+		existingCertificate.FileURL = optionalString(updates["file_url"])
+	}
+
+	if err := services.ValidateCertificate(existingCertificate); err != nil {
+		writeProvenanceError(w, err)
+		return
+	}
+
+	log.Printf("handlers.provenance.UpdateCertificate: %+v", existingCertificate)
+
+	updatedCertificate, err := h.service.UpdateCertificate(existingCertificate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedCertificate)
+}
+
+func (h *ProvenanceHandler) DeleteCertificate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.provenance.DeleteCertificate: %v", id)
+
+	if err := h.service.DeleteCertificate(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadCertificateFile saves a scan or PDF of a certificate from the "file" form file
+func (h *ProvenanceHandler) UploadCertificateFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	certificate, err := h.service.GetCertificate(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Certificate not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	fileURL, status, err := saveUploadedScan(r, "certificates", certificate.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	certificate.FileURL = &fileURL
+	if _, err := h.service.UpdateCertificate(certificate); err != nil {
+		http.Error(w, "Error updating certificate", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("handlers.provenance.UploadCertificateFile: file of certificate %v is now %v", id, fileURL)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"file_url": fileURL})
+}
+
+// CreateProvenanceEntry adds a previous owner or auction appearance to a group of copies
+func (h *ProvenanceHandler) CreateProvenanceEntry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	if !h.instanceExists(w, instanceID) {
+		return
+	}
+
+	var entry models.ProvenanceEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateProvenanceEntry(&entry); err != nil {
+		writeProvenanceError(w, err)
+		return
+	}
+
+	entry.ID = uuid.New().String()
+	entry.InstanceID = instanceID
+	entry.DateAdded = time.Now()
+	entry.DateModified = time.Now()
+
+	log.Printf("handlers.provenance.CreateProvenanceEntry: %+v", entry)
+
+	if _, err := h.service.CreateProvenanceEntry(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	createdEntry, err := h.service.GetProvenanceEntry(entry.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdEntry)
+}
+
+func (h *ProvenanceHandler) UpdateProvenanceEntry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingEntry, err := h.service.GetProvenanceEntry(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Provenance entry not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for field, target := range provenanceTextFields {
+		if value, ok := updates[field]; ok {
+			*target(existingEntry) = optionalString(value)
+		}
+	}
+
+	if err := services.ValidateProvenanceEntry(existingEntry); err != nil {
+		writeProvenanceError(w, err)
+		return
+	}
+
+	log.Printf("handlers.provenance.UpdateProvenanceEntry: %+v", existingEntry)
+
+	updatedEntry, err := h.service.UpdateProvenanceEntry(existingEntry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedEntry)
+}
+
+func (h *ProvenanceHandler) DeleteProvenanceEntry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.provenance.DeleteProvenanceEntry: %v", id)
+
+	if err := h.service.DeleteProvenanceEntry(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Provenance entry not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// instanceExists reports whether the group of copies exists, writing an error response if it doesn't
+func (h *ProvenanceHandler) instanceExists(w http.ResponseWriter, instanceID string) bool {
+	if _, err := h.instanceService.GetStampInstance(instanceID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Instance not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// writeProvenanceError reports an incomplete certificate or provenance entry as a bad request and
+// anything else as a server error
+func writeProvenanceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidProvenance) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+)
+
+type SeriesHandler struct {
+	db        *sql.DB
+	templates *template.Template
+	service   *services.SeriesService
+}
+
+func NewSeriesHandler(db *sql.DB, templates *template.Template) *SeriesHandler {
+	return &SeriesHandler{
+		db:        db,
+		templates: templates,
+		service:   services.NewSeriesService(db),
+	}
+}
+
+func (h *SeriesHandler) GetSeries(w http.ResponseWriter, r *http.Request) {
+	series, err := h.service.GetSeries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+func (h *SeriesHandler) GetSeriesByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	series, err := h.service.GetSeriesByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Series not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+func (h *SeriesHandler) CreateSeries(w http.ResponseWriter, r *http.Request) {
+	var series models.Series
+	if err := json.NewDecoder(r.Body).Decode(&series); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if series.Name == "" {
+		http.Error(w, "Series name is required", http.StatusBadRequest)
+		return
+	}
+
+	series.ID = uuid.New().String()
+	series.DateCreated = time.Now()
+	series.DateModified = time.Now()
+
+	log.Printf("handlers.series.CreateSeries: %+v", series)
+
+	createdSeries, err := h.service.CreateSeries(&series)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			http.Error(w, "A series with this name already exists", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Save the expected member list if one was given
+	if len(series.Members) > 0 {
+		if err := h.service.SetMembers(createdSeries.ID, series.Members); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	createdSeries, err = h.service.GetSeriesByID(createdSeries.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdSeries)
+}
+
+func (h *SeriesHandler) UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existingSeries, err := h.service.GetSeriesByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Series not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Parse the incoming JSON into a map to handle partial updates
+	var updates map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if name, ok := updates["name"].(string); ok && name != "" {
+		existingSeries.Name = name
+	}
+	if _, ok := updates["country"]; ok {
+		existingSeries.Country = optionalString(updates["country"])
+	}
+	if _, ok := updates["description"]; ok {
+		existingSeries.Description = optionalString(updates["description"])
+	}
+	if _, ok := updates["start_year"]; ok {
+		existingSeries.StartYear = optionalInt(updates["start_year"])
+	}
+	if _, ok := updates["end_year"]; ok {
+		existingSeries.EndYear = optionalInt(updates["end_year"])
+	}
+
+	log.Printf("handlers.series.UpdateSeries: %+v", existingSeries)
+
+	if _, err := h.service.UpdateSeries(existingSeries); err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			http.Error(w, "A series with this name already exists", http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	updatedSeries, err := h.service.GetSeriesByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedSeries)
+}
+
+// SetSeriesMembers replaces the expected member list of a series
+func (h *SeriesHandler) SetSeriesMembers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var members []models.SeriesMember
+	if err := json.NewDecoder(r.Body).Decode(&members); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.service.GetSeriesByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Series not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("handlers.series.SetSeriesMembers: %v (%d members)", id, len(members))
+
+	if err := h.service.SetMembers(id, members); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updatedSeries, err := h.service.GetSeriesByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedSeries)
+}
+
+func (h *SeriesHandler) DeleteSeries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	log.Printf("handlers.series.DeleteSeries: %v", id)
+
+	if err := h.service.DeleteSeries(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// optionalString converts a JSON value to a string pointer, treating null and "" as unset
+func optionalString(v interface{}) *string {
+	if s, ok := v.(string); ok && s != "" {
+		return &s
+	}
+	return nil
+}
+
+// optionalInt converts a JSON number to an int pointer, treating null as unset
+func optionalInt(v interface{}) *int {
+	if f, ok := v.(float64); ok {
+		i := int(f)
+		return &i
+	}
+	return nil
+}
+
+// optionalFloat converts a JSON number to a float pointer, treating null and 0 as unset
+func optionalFloat(v interface{}) *float64 {
+	if f, ok := v.(float64); ok && f != 0 {
+		return &f
+	}
+	return nil
+}
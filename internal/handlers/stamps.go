@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"net/http"
 	"strconv"
@@ -10,9 +11,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -21,16 +19,20 @@ import (
 )
 
 type StampHandler struct {
-	db         *sql.DB
-	templates  *template.Template
-	service    *services.StampService
+	db           *sql.DB
+	templates    *template.Template
+	service      *services.StampService
+	imageService *services.ImageService
+	index        *imageIndex
 }
 
 func NewStampHandler(db *sql.DB, templates *template.Template) *StampHandler {
 	return &StampHandler{
-		db:         db,
-		templates:  templates,
-		service:    services.NewStampService(db),
+		db:           db,
+		templates:    templates,
+		service:      services.NewStampService(db),
+		imageService: services.NewImageService(db),
+		index:        newImageIndex(db),
 	}
 }
 
@@ -48,7 +50,14 @@ func (h *StampHandler) GetStamps(w http.ResponseWriter, r *http.Request) {
 	// Call the service with the new arguments
 	stamps, err := h.service.GetStamps(r, page, limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		var parseErr *services.SearchParseError
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Smart collection not found", http.StatusNotFound)
+		} else if errors.As(err, &parseErr) {
+			http.Error(w, parseErr.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -56,6 +65,23 @@ func (h *StampHandler) GetStamps(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stamps)
 }
 
+// GetFacets returns facet values and counts for the stamps matching the request's filters
+func (h *StampHandler) GetFacets(w http.ResponseWriter, r *http.Request) {
+	facets, err := h.service.GetFacets(r)
+	if err != nil {
+		var parseErr *services.SearchParseError
+		if errors.As(err, &parseErr) {
+			http.Error(w, parseErr.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facets)
+}
+
 func (h *StampHandler) GetStamp(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -87,12 +113,33 @@ func (h *StampHandler) CreateStamp(w http.ResponseWriter, r *http.Request) {
 	stamp.DateModified = time.Now()
 	stamp.IsOwned = false // Will be calculated based on instances
 
+	if err := h.service.ValidateVariety(&stamp); err != nil {
+		if errors.Is(err, services.ErrInvalidVariety) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	createdStamp, err := h.service.CreateStamp(&stamp)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, services.ErrInvalidSeries) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
+	// Start the stamp's gallery with the image it was created with
+	if createdStamp.ImageURL != nil {
+		if _, err := h.imageService.SetStampImageURL(createdStamp.ID, createdStamp.ImageURL, uploaderName(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(createdStamp)
@@ -183,6 +230,24 @@ func (h *StampHandler) UpdateStamp(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if parentID, ok := updates["parent_id"]; ok {
+		if parentID == nil || parentID == "" {
+			existingStamp.ParentID = nil
+		} else if parentIDStr, ok := parentID.(string); ok {
+			existingStamp.ParentID = &parentIDStr
+			log.Printf("Updated parent_id to: %s", parentIDStr)
+		}
+	}
+
+	if varietyType, ok := updates["variety_type"]; ok {
+		if varietyType == nil || varietyType == "" {
+			existingStamp.VarietyType = nil
+		} else if varietyTypeStr, ok := varietyType.(string); ok {
+			existingStamp.VarietyType = &varietyTypeStr
+			log.Printf("Updated variety_type to: %s", varietyTypeStr)
+		}
+	}
+
 	// Handle tags array
 	if tagsInterface, ok := updates["tags"]; ok {
 		log.Printf("Processing tags update: %+v", tagsInterface)
@@ -198,17 +263,40 @@ func (h *StampHandler) UpdateStamp(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := h.service.ValidateVariety(existingStamp); err != nil {
+		if errors.Is(err, services.ErrInvalidVariety) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// Update the modified timestamp
 	existingStamp.DateModified = time.Now()
 
 	// Save the updated stamp
 	updatedStamp, err := h.service.UpdateStamp(existingStamp)
+	if errors.Is(err, services.ErrInvalidSeries) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating stamp in service: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to update stamp: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// The image URL is the primary image of the stamp's gallery, so keep the gallery in step
+	if _, ok := updates["image_url"]; ok {
+		pruned, err := h.imageService.SetStampImageURL(existingStamp.ID, existingStamp.ImageURL, uploaderName(r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to update stamp images: %v", err), http.StatusInternalServerError)
+			return
+		}
+		removeImageFiles(pruned)
+	}
+
 	log.Print("Stamp updated successfully")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedStamp)
@@ -229,133 +317,39 @@ func (h *StampHandler) DeleteStamp(w http.ResponseWriter, r *http.Request) {
 func (h *StampHandler) UploadStampImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	stampID := vars["id"]
-	
-	// Parse multipart form with 5MB limit
-	err := r.ParseMultipartForm(5 << 20) // 5MB
-	if err != nil {
-		http.Error(w, "File too large. Maximum size is 5MB.", http.StatusBadRequest)
-		return
-	}
-	
-	file, handler, err := r.FormFile("image")
-	if err != nil {
-		http.Error(w, "No file uploaded", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-	
-	// Validate file size
-	if handler.Size > 5<<20 {
-		http.Error(w, "File too large. Maximum size is 5MB.", http.StatusBadRequest)
-		return
-	}
-	
-	// Validate file type by reading the first 512 bytes
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
-		http.Error(w, "Error reading file", http.StatusInternalServerError)
-		return
-	}
-	
-	// Reset file pointer
-	file.Seek(0, 0)
-	
-	// Check if it's an image
-	contentType := http.DetectContentType(buffer)
-	if !strings.HasPrefix(contentType, "image/") {
-		http.Error(w, "File must be an image", http.StatusBadRequest)
-		return
-	}
-	
-	// Create stamps directory if it doesn't exist
-	imagesDir := "./static/images/stamps"
-	err = os.MkdirAll(imagesDir, 0755)
-	if err != nil {
-		http.Error(w, "Error creating directory", http.StatusInternalServerError)
-		return
-	}
 
-	// Get existing stamp to check for current image
 	existingStamp, err := h.service.GetStampByID(stampID)
 	if err != nil {
 		http.Error(w, "Stamp not found", http.StatusNotFound)
 		return
 	}
 
-	// Backup existing image if it exists
-	if existingStamp.ImageURL != nil && *existingStamp.ImageURL != "" {
-		// Extract filename from the current image URL
-		currentImageURL := *existingStamp.ImageURL
-		if strings.HasPrefix(currentImageURL, "/static/images/stamps/") {
-			currentFilename := strings.TrimPrefix(currentImageURL, "/static/images/stamps/")
-			currentFilepath := filepath.Join(imagesDir, currentFilename)
-			
-			// Check if the current image file exists
-			if _, err := os.Stat(currentFilepath); err == nil {
-				// Create backup by renaming with .bak extension
-				backupFilepath := currentFilepath + ".bak"
-				err = os.Rename(currentFilepath, backupFilepath)
-				if err != nil {
-					log.Printf("Warning: Could not backup existing image: %v", err)
-					// Continue anyway - don't fail the upload for backup issues
-				} else {
-					log.Printf("Backed up existing image to: %s", backupFilepath)
-				}
-			}
-		}
-	}
-	
-	// Generate unique filename
-	ext := filepath.Ext(handler.Filename)
-	if ext == "" {
-		// Determine extension from content type
-		switch contentType {
-		case "image/jpeg":
-			ext = ".jpg"
-		case "image/png":
-			ext = ".png"
-		case "image/gif":
-			ext = ".gif"
-		case "image/webp":
-			ext = ".webp"
-		default:
-			ext = ".jpg"
-		}
-	}
-	
-	filename := fmt.Sprintf("%s%s", stampID, ext)
-	filepath := filepath.Join(imagesDir, filename)
-	
-	// Create the destination file
-	log.Printf("Uploading file to: %v", filepath)
-	dst, err := os.Create(filepath)
+	// Every upload gets a file of its own, so earlier versions stay in the image's history
+	imageURL, status, err := saveUploadedImage(r, h.index, "stamps", uuid.New().String())
 	if err != nil {
-		http.Error(w, "Error creating file", http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
-	defer dst.Close()
-	
-	// Copy the uploaded file to destination
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
-		return
-	}
-	log.Print("File uploaded successfully")
-	
+	log.Printf("Uploaded image for stamp_id %v to %v", stampID, imageURL)
+
 	// Update the stamp record with the new image URL
-	imageURL := fmt.Sprintf("/static/images/stamps/%s", filename)
 	existingStamp.ImageURL = &imageURL
 	existingStamp.DateModified = time.Now()
-	
+
 	_, err = h.service.UpdateStamp(existingStamp)
 	if err != nil {
 		http.Error(w, "Error updating stamp", http.StatusInternalServerError)
 		return
 	}
 	log.Printf("ImageURL for stamp_id %v updated to point to the new file", stampID)
-	
+
+	pruned, err := h.imageService.SetStampImageURL(stampID, &imageURL, uploaderName(r))
+	if err != nil {
+		http.Error(w, "Error updating stamp images", http.StatusInternalServerError)
+		return
+	}
+	removeImageFiles(pruned)
+
 	// Return the new image URL as JSON
 	response := map[string]string{"image_url": imageURL}
 	w.Header().Set("Content-Type", "application/json")
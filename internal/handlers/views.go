@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"html/template"
 	"math"
 	"net/http"
@@ -20,6 +21,11 @@ type ViewHandler struct {
 	templates         *template.Template
 	stampService      *services.StampService
 	boxService        *services.BoxService
+	collectionService *services.CollectionService
+	seriesService     *services.SeriesService
+	coverService      *services.CoverService
+	fdcService        *services.FirstDayCoverService
+	conditionService  *services.ConditionService
 	sessionMiddleware *middleware.SessionMiddleware
 }
 
@@ -29,6 +35,11 @@ func NewViewHandler(db *sql.DB, templates *template.Template, sessionMiddleware
 		templates:         templates,
 		stampService:      services.NewStampService(db),
 		boxService:        services.NewBoxService(db),
+		collectionService: services.NewCollectionService(db),
+		seriesService:     services.NewSeriesService(db),
+		coverService:      services.NewCoverService(db),
+		fdcService:        services.NewFirstDayCoverService(db),
+		conditionService:  services.NewConditionService(db),
 		sessionMiddleware: sessionMiddleware,
 	}
 }
@@ -50,10 +61,19 @@ func (h *ViewHandler) GetStampsView(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get total items and stamps for the current page
+	var searchError string
 	totalItems, stamps, err := h.stampService.GetStampsWithCount(r, page, limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		// An invalid search query is shown in place of the results rather than failing the swap
+		var parseErr *services.SearchParseError
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Smart collection not found", http.StatusNotFound)
+			return
+		} else if !errors.As(err, &parseErr) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		searchError = parseErr.Error()
 	}
 
 	// Calculate pagination data
@@ -74,6 +94,15 @@ func (h *ViewHandler) GetStampsView(w http.ResponseWriter, r *http.Request) {
 	// The BaseURL must point to the /scroll endpoint
 	baseURLWithParams := fmt.Sprintf("/views/stamps/%s/scroll?%s", view, query.Encode())
 
+	// Facet counts for the sidebar, which is refreshed out-of-band with every result set
+	var facets []models.Facet
+	if searchError == "" {
+		facets, err = h.stampService.GetFacets(r)
+		if err != nil {
+			log.Printf("Warning: could not compute facets: %v", err)
+		}
+	}
+
 	// Get box details if filtering by box
 	var filteredBox *models.StorageBox
 	boxID := r.URL.Query().Get("box_id")
@@ -84,13 +113,28 @@ func (h *ViewHandler) GetStampsView(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Get the smart collection the results are scoped to, if any
+	var collection *models.SmartCollection
+	if collectionID := r.URL.Query().Get("collection"); collectionID != "" {
+		collection, err = h.collectionService.GetCollectionByID(collectionID)
+		if err != nil {
+			log.Printf("Warning: could not fetch smart collection %s: %v", collectionID, err)
+		}
+	}
+
 	// Prepare the full data payload for the template
 	data := models.PaginatedStampsView{
-		Stamps:      stamps,
-		Pagination:  pagination,
-		BaseURL:     baseURLWithParams, // e.g., /views/stamps/gallery
-		CurrentView: view,
-		FilteredBox: filteredBox,
+		Stamps:            stamps,
+		Pagination:        pagination,
+		BaseURL:           baseURLWithParams, // e.g., /views/stamps/gallery
+		CurrentView:       view,
+		FilteredBox:       filteredBox,
+		SearchError:       searchError,
+		Facets:            facets,
+		Query:             services.CollectionQueryFromValues(r.URL.Query()),
+		CollapseVarieties: r.URL.Query().Get("collapse_varieties") == "true",
+		SortByColour:      r.URL.Query().Get("sort") == "colour",
+		Collection:        collection,
 	}
 
 	templateName := view + "-view.html"
@@ -183,10 +227,21 @@ func (h *ViewHandler) GetStampDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	conditions, err := h.conditionService.GetConditions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Create the view data
 	data := models.StampDetailView{
-		Stamp:    *stamp,
-		AllBoxes: allBoxes,
+		Stamp:           *stamp,
+		Conditions:      conditions,
+		AllBoxes:        allBoxes,
+		VarietyTypes:    services.VarietyTypes,
+		InstanceFormats: services.InstanceFormats,
+		CachetTypes:     services.CachetTypes,
+		Grading:         services.GetGradingOptions(),
 	}
 
 	err = h.templates.ExecuteTemplate(w, "stamp-detail.html", data)
@@ -227,6 +282,130 @@ func (h *ViewHandler) GetBoxesView(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetCollectionsView returns the smart collections list for the sidebar
+func (h *ViewHandler) GetCollectionsView(w http.ResponseWriter, r *http.Request) {
+	collections, err := h.collectionService.GetCollections()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := models.CollectionListView{
+		Collections:        collections,
+		ActiveCollectionID: r.URL.Query().Get("collection"),
+	}
+
+	err = h.templates.ExecuteTemplate(w, "collection-list.html", data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *ViewHandler) GetSeriesListView(w http.ResponseWriter, r *http.Request) {
+	series, err := h.seriesService.GetSeries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.templates.ExecuteTemplate(w, "series-list.html", series)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetSeriesDetail renders a series page with each member marked as owned or needed
+func (h *ViewHandler) GetSeriesDetail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	series, err := h.seriesService.GetSeriesByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Series not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = h.templates.ExecuteTemplate(w, "series-detail.html", series)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// GetCoversView renders the covers gallery along with its search filters
+func (h *ViewHandler) GetCoversView(w http.ResponseWriter, r *http.Request) {
+	h.renderCovers(w, r, "covers-view.html")
+}
+
+// GetCoverResults renders just the covers matching the search filters, for updating the gallery as they change
+func (h *ViewHandler) GetCoverResults(w http.ResponseWriter, r *http.Request) {
+	h.renderCovers(w, r, "_cover-gallery.html")
+}
+
+func (h *ViewHandler) renderCovers(w http.ResponseWriter, r *http.Request, templateName string) {
+	query := r.URL.Query()
+	covers, err := h.coverService.GetCovers(services.NewCoverFiltersFromValues(query))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := models.CoverGalleryView{
+		Covers:      covers,
+		Search:      query.Get("search"),
+		Origin:      query.Get("origin"),
+		Destination: query.Get("destination"),
+		YearFrom:    query.Get("year_from"),
+		YearTo:      query.Get("year_to"),
+		ScottNumber: query.Get("scott"),
+	}
+
+	err = h.templates.ExecuteTemplate(w, templateName, data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// GetCoverDetail renders a cover with its images, postal details and the stamps affixed to it
+func (h *ViewHandler) GetCoverDetail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	cover, err := h.coverService.GetCoverByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cover not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = h.templates.ExecuteTemplate(w, "cover-detail.html", models.CoverDetailView{Cover: *cover})
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// GetFDCReport renders the designs we have first-day covers for versus just the stamp
+func (h *ViewHandler) GetFDCReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.fdcService.GetReport(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.templates.ExecuteTemplate(w, "fdc-report.html", report)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
 func (h *ViewHandler) GetNewInstanceRow(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	stampID := vars["id"]
@@ -238,9 +417,18 @@ func (h *ViewHandler) GetNewInstanceRow(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	conditions, err := h.conditionService.GetConditions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	data := models.StampDetailView{
-		Stamp:    models.Stamp{ID: stampID},
-		AllBoxes: allBoxes,
+		Stamp:           models.Stamp{ID: stampID},
+		AllBoxes:        allBoxes,
+		Conditions:      conditions,
+		InstanceFormats: services.InstanceFormats,
+		Grading:         services.GetGradingOptions(),
 	}
 
 	err = h.templates.ExecuteTemplate(w, "new-instance-row.html", data)
@@ -283,6 +471,18 @@ func (h *ViewHandler) GetSettingsView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Get the condition list and the stored values that need normalizing
+	conditions, err := h.conditionService.GetConditions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conditionValues, err := h.conditionService.GetConditionValues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Get fresh user preferences directly from cookie to ensure we have the latest values
 	prefs := h.sessionMiddleware.GetPreferences(r)
 	
@@ -291,7 +491,9 @@ func (h *ViewHandler) GetSettingsView(w http.ResponseWriter, r *http.Request) {
 
 	// Create the view data
 	data := models.SettingsView{
-		AllBoxes: allBoxes,
+		AllBoxes:        allBoxes,
+		Conditions:      conditions,
+		ConditionValues: conditionValues,
 		Preferences: models.UserPreferences{
 			DefaultView:   prefs.DefaultView,
 			DefaultSort:   prefs.DefaultSort,
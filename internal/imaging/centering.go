@@ -0,0 +1,275 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// ErrNotMeasurable is wrapped by the errors returned for scans a stamp can't be measured from
+var ErrNotMeasurable = errors.New("the stamp could not be measured")
+
+// measureSize is the longest side, in pixels, scans are scaled down to before they are measured
+const measureSize = 1200
+
+// Centering is where a stamp's design sits between its perforations, measured from a scan
+type Centering struct {
+	Stamp  image.Rectangle // The stamp's edges, through the middle of the perforations
+	Design image.Rectangle // The edges of the printed design or its frame line
+	// Margins between the design and the perforations, as fractions of the stamp's width (left and
+	// right) or height (top and bottom)
+	Left, Right, Top, Bottom float64
+}
+
+// Balance returns how evenly the design sits across and down the stamp: the narrower margin over the
+// wider one, from 1 for a perfectly centred design to 0 for one touching or cut by the perforations
+func (c Centering) Balance() (horizontal, vertical float64) {
+	return marginBalance(c.Left, c.Right), marginBalance(c.Top, c.Bottom)
+}
+
+// MeasureCentering finds the edges of the single stamp on a scan and of the design printed on it, and
+// measures the margins between them. The stamp must lie on a background that contrasts with its paper, with
+// some background showing all round. Each edge is taken over the middle of the side, so perforation teeth
+// and holes average out and a cancel crossing a margin here and there doesn't move it.
+func MeasureCentering(img image.Image) (*Centering, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() < 2 || bounds.Dy() < 2 {
+		return nil, fmt.Errorf("%w: the image is empty", ErrNotMeasurable)
+	}
+
+	small, mask, box, err := findStamp(img, measureSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	w := small.Bounds().Dx()
+	scale := float64(bounds.Dx()) / float64(w)
+
+	// The paper's edge on each side, scanning in from the side of the bounding box
+	stamp, ok := paperEdges(mask, w, box)
+	if !ok {
+		return nil, fmt.Errorf("%w: the stamp's edges could not be found", ErrNotMeasurable)
+	}
+
+	design, ok := designEdges(small, stamp)
+	if !ok {
+		return nil, fmt.Errorf("%w: the design could not be told apart from the margins", ErrNotMeasurable)
+	}
+
+	width, height := float64(stamp.Dx()), float64(stamp.Dy())
+	c := &Centering{
+		Stamp:  scaleRect(stamp, scale).Add(bounds.Min),
+		Design: scaleRect(design, scale).Add(bounds.Min),
+		Left:   float64(design.Min.X-stamp.Min.X) / width,
+		Right:  float64(stamp.Max.X-design.Max.X) / width,
+		Top:    float64(design.Min.Y-stamp.Min.Y) / height,
+		Bottom: float64(stamp.Max.Y-design.Max.Y) / height,
+	}
+	return c, nil
+}
+
+// findStamp scales img down to size pixels on its longest side and finds the single stamp on it, returning
+// the scaled image, the mask of pixels that differ from the background and the stamp's bounding box. Specks
+// smaller than speck pixels across are ignored; 0 picks a size suited to finding the stamp as a whole.
+func findStamp(img image.Image, size, speck int) (*image.RGBA, []bool, image.Rectangle, error) {
+	small := toRGBA(Fit(img, size))
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+	if speck == 0 {
+		speck = max(1, max(w, h)/400)
+	}
+
+	mask := open(foregroundMask(small, borderColour(small)), w, h, speck)
+	box, ok := largestComponent(mask, w, h)
+	if !ok || box.Dx() < 20 || box.Dy() < 20 {
+		return nil, nil, image.Rectangle{}, fmt.Errorf("%w: no stamp stands out from the background", ErrNotMeasurable)
+	}
+	if box.Dx() > w*97/100 && box.Dy() > h*97/100 {
+		return nil, nil, image.Rectangle{}, fmt.Errorf("%w: the scan must show some background around the stamp", ErrNotMeasurable)
+	}
+	return small, mask, box, nil
+}
+
+// largestComponent returns the bounding box of the largest connected group of marked pixels in a w x h mask
+func largestComponent(mask []bool, w, h int) (image.Rectangle, bool) {
+	var largest image.Rectangle
+	found := false
+	for _, box := range components(mask, w, h) {
+		if !found || box.Dx()*box.Dy() > largest.Dx()*largest.Dy() {
+			largest, found = box, true
+		}
+	}
+	return largest, found
+}
+
+// paperEdges finds the four edges of the paper in box, each the mean of where the paper starts along the
+// middle of that side, which falls midway between the tips of the perforation teeth and the holes
+func paperEdges(mask []bool, w int, box image.Rectangle) (image.Rectangle, bool) {
+	var left, right, top, bottom []int
+	for y := middleStart(box.Min.Y, box.Dy()); y < middleEnd(box.Min.Y, box.Dy()); y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			if mask[y*w+x] {
+				left = append(left, x)
+				break
+			}
+		}
+		for x := box.Max.X - 1; x >= box.Min.X; x-- {
+			if mask[y*w+x] {
+				right = append(right, x+1)
+				break
+			}
+		}
+	}
+	for x := middleStart(box.Min.X, box.Dx()); x < middleEnd(box.Min.X, box.Dx()); x++ {
+		for y := box.Min.Y; y < box.Max.Y; y++ {
+			if mask[y*w+x] {
+				top = append(top, y)
+				break
+			}
+		}
+		for y := box.Max.Y - 1; y >= box.Min.Y; y-- {
+			if mask[y*w+x] {
+				bottom = append(bottom, y+1)
+				break
+			}
+		}
+	}
+	if len(left) == 0 || len(top) == 0 {
+		return image.Rectangle{}, false
+	}
+
+	edges := image.Rect(mean(left), mean(top), mean(right), mean(bottom))
+	return edges, edges.Dx() > 0 && edges.Dy() > 0
+}
+
+// designEdges finds where the design starts inside the stamp's edges. Walking in from each side, past the
+// perforation holes to the plain paper of the margin, the design starts at the first few pixels in a row
+// that differ clearly from the paper.
+func designEdges(img *image.RGBA, stamp image.Rectangle) (image.Rectangle, bool) {
+	paper := marginColour(img, stamp)
+
+	var left, right, top, bottom []int
+	walk := func(from, to, step int, at func(int) [3]uint8) (int, bool) {
+		const paperRun, designRun = 2, 3
+		seenPaper, run := 0, 0
+		for i := from; i != to; i += step {
+			isPaper := colourDistance(at(i), paper) <= 40
+			if seenPaper < paperRun {
+				if isPaper {
+					seenPaper++
+				} else {
+					seenPaper = 0
+				}
+				continue
+			}
+			if isPaper {
+				run = 0
+				continue
+			}
+			if run++; run == designRun {
+				return i - step*(designRun-1), true
+			}
+		}
+		return 0, false
+	}
+
+	for y := middleStart(stamp.Min.Y, stamp.Dy()); y < middleEnd(stamp.Min.Y, stamp.Dy()); y++ {
+		row := func(x int) [3]uint8 { return pixelColour(img, x, y) }
+		if x, ok := walk(stamp.Min.X, stamp.Min.X+stamp.Dx()/2, 1, row); ok {
+			left = append(left, x)
+		}
+		if x, ok := walk(stamp.Max.X-1, stamp.Max.X-1-stamp.Dx()/2, -1, row); ok {
+			right = append(right, x+1)
+		}
+	}
+	for x := middleStart(stamp.Min.X, stamp.Dx()); x < middleEnd(stamp.Min.X, stamp.Dx()); x++ {
+		column := func(y int) [3]uint8 { return pixelColour(img, x, y) }
+		if y, ok := walk(stamp.Min.Y, stamp.Min.Y+stamp.Dy()/2, 1, column); ok {
+			top = append(top, y)
+		}
+		if y, ok := walk(stamp.Max.Y-1, stamp.Max.Y-1-stamp.Dy()/2, -1, column); ok {
+			bottom = append(bottom, y+1)
+		}
+	}
+
+	// Most rows and columns must reach the design, or it isn't distinct from the margins
+	across, down := middleEnd(0, stamp.Dy())-middleStart(0, stamp.Dy()), middleEnd(0, stamp.Dx())-middleStart(0, stamp.Dx())
+	if len(left) < across/2 || len(right) < across/2 || len(top) < down/2 || len(bottom) < down/2 {
+		return image.Rectangle{}, false
+	}
+
+	design := image.Rect(median(left), median(top), median(right), median(bottom))
+	return design, design.Dx() > 0 && design.Dy() > 0 && design.In(stamp)
+}
+
+// marginColour is the median colour of the paper a little in from the stamp's edges, past the perforations
+func marginColour(img *image.RGBA, stamp image.Rectangle) [3]uint8 {
+	inset := max(2, min(stamp.Dx(), stamp.Dy())/25)
+	var channels [3][]int
+	add := func(x, y int) {
+		c := pixelColour(img, x, y)
+		for i := range channels {
+			channels[i] = append(channels[i], int(c[i]))
+		}
+	}
+	for y := middleStart(stamp.Min.Y, stamp.Dy()); y < middleEnd(stamp.Min.Y, stamp.Dy()); y++ {
+		add(stamp.Min.X+inset, y)
+		add(stamp.Max.X-1-inset, y)
+	}
+	for x := middleStart(stamp.Min.X, stamp.Dx()); x < middleEnd(stamp.Min.X, stamp.Dx()); x++ {
+		add(x, stamp.Min.Y+inset)
+		add(x, stamp.Max.Y-1-inset)
+	}
+
+	var colour [3]uint8
+	for i, values := range channels {
+		colour[i] = uint8(median(values))
+	}
+	return colour
+}
+
+// middleStart and middleEnd bound the middle 60% of a side starting at start and length long, away from
+// the corners where the perforations of two sides meet
+func middleStart(start, length int) int { return start + length/5 }
+func middleEnd(start, length int) int   { return start + length*4/5 }
+
+func pixelColour(img *image.RGBA, x, y int) [3]uint8 {
+	i := img.PixOffset(x, y)
+	return [3]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2]}
+}
+
+func colourDistance(a, b [3]uint8) float64 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+func median(values []int) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func mean(values []int) int {
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	return int(math.Round(float64(sum) / float64(len(values))))
+}
+
+func marginBalance(a, b float64) float64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	return math.Min(a, b) / math.Max(a, b)
+}
+
+// scaleRect scales a rectangle found on a scaled-down image back to the original
+func scaleRect(r image.Rectangle, scale float64) image.Rectangle {
+	return image.Rect(int(math.Round(float64(r.Min.X)*scale)), int(math.Round(float64(r.Min.Y)*scale)),
+		int(math.Round(float64(r.Max.X)*scale)), int(math.Round(float64(r.Max.Y)*scale)))
+}
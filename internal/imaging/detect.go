@@ -0,0 +1,256 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// detectSize is the longest side, in pixels, pages are scaled down to before looking for stamps. At 600 DPI
+// that leaves several pixels per millimetre, plenty to find the edges of a stamp.
+const detectSize = 1200
+
+// DetectStamps finds the stamps on a scanned album or stock page and returns their bounding boxes in the
+// page's coordinates, in reading order: top to bottom, then left to right within a row. The background
+// colour is taken from the edges of the scan, so it works on white or black pages, and anything that
+// differs from it enough to stand out is taken to be a stamp. Each box is padded slightly so the crop keeps
+// the perforations.
+func DetectStamps(img image.Image) []image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Dx() < 2 || bounds.Dy() < 2 {
+		return nil
+	}
+
+	small := toRGBA(Fit(img, detectSize))
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+	scale := float64(bounds.Dx()) / float64(w)
+	longest := max(w, h)
+
+	mask := foregroundMask(small, borderColour(small))
+
+	// Remove what is too thin to be a stamp, like specks of dust and the edges of stock page strips, which
+	// would otherwise join the stamps sitting on them into one
+	mask = open(mask, w, h, max(1, longest/400))
+
+	// Widen the foreground so the parts of a stamp with little contrast against the page, like a pale
+	// margin between the design and the perforations, don't split it into pieces
+	gap := max(1, longest/200)
+	boxes := components(dilate(mask, w, h, gap), w, h)
+
+	minSide := max(4, longest/50)
+	var stamps []image.Rectangle
+	for _, box := range boxes {
+		box = box.Inset(gap).Intersect(image.Rect(0, 0, w, h))
+		if box.Dx() < minSide || box.Dy() < minSide {
+			continue // Dust, specks and page marks
+		}
+		if box.Dx() > w*9/10 && box.Dy() > h*9/10 {
+			continue // The page itself, when the scan caught its edge
+		}
+		if ratio := float64(max(box.Dx(), box.Dy())) / float64(min(box.Dx(), box.Dy())); ratio > 8 {
+			continue // The strips and rules of a stock page
+		}
+		stamps = append(stamps, box)
+	}
+
+	pad := max(1, longest/150)
+	for i := range stamps {
+		stamps[i] = stamps[i].Inset(-pad).Intersect(image.Rect(0, 0, w, h))
+	}
+	stamps = mergeOverlapping(stamps)
+
+	for i, box := range stamps {
+		stamps[i] = image.Rect(
+			int(math.Floor(float64(box.Min.X)*scale)), int(math.Floor(float64(box.Min.Y)*scale)),
+			int(math.Ceil(float64(box.Max.X)*scale)), int(math.Ceil(float64(box.Max.Y)*scale)),
+		).Add(bounds.Min).Intersect(bounds)
+	}
+	sortReadingOrder(stamps)
+	return stamps
+}
+
+// Crop returns the part of img inside rect, sharing its pixels where the image type allows
+func Crop(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	return toRGBA(img).SubImage(rect.Sub(img.Bounds().Min))
+}
+
+// borderColour is the median colour of the outermost pixels of img, which on a page scan is the page
+func borderColour(img *image.RGBA) [3]uint8 {
+	b := img.Bounds()
+	depth := max(1, min(b.Dx(), b.Dy())/50)
+
+	var channels [3][]uint8
+	add := func(x, y int) {
+		i := img.PixOffset(x, y)
+		for c := range channels {
+			channels[c] = append(channels[c], img.Pix[i+c])
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if x-b.Min.X < depth || b.Max.X-x <= depth || y-b.Min.Y < depth || b.Max.Y-y <= depth {
+				add(x, y)
+			}
+		}
+	}
+
+	var colour [3]uint8
+	for c, values := range channels {
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		colour[c] = values[len(values)/2]
+	}
+	return colour
+}
+
+// foregroundMask marks the pixels of img that differ clearly from the background colour
+func foregroundMask(img *image.RGBA, background [3]uint8) []bool {
+	const threshold = 48 // Distance in RGB space; scanner noise and paper texture stay well below it
+
+	b := img.Bounds()
+	mask := make([]bool, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			i := img.PixOffset(b.Min.X+x, b.Min.Y+y)
+			var distance float64
+			for c := 0; c < 3; c++ {
+				d := float64(img.Pix[i+c]) - float64(background[c])
+				distance += d * d
+			}
+			mask[y*b.Dx()+x] = distance > threshold*threshold
+		}
+	}
+	return mask
+}
+
+// dilate grows the marked pixels of a w x h mask by radius in every direction
+func dilate(mask []bool, w, h, radius int) []bool {
+	// A square is grown in two passes, across and then down, each counting marked pixels within reach
+	across := make([]bool, len(mask))
+	for y := 0; y < h; y++ {
+		row := mask[y*w : (y+1)*w]
+		count := 0
+		for x := 0; x < min(radius, w); x++ {
+			if row[x] {
+				count++
+			}
+		}
+		for x := 0; x < w; x++ {
+			if x+radius < w && row[x+radius] {
+				count++
+			}
+			if x-radius-1 >= 0 && row[x-radius-1] {
+				count--
+			}
+			across[y*w+x] = count > 0
+		}
+	}
+
+	out := make([]bool, len(mask))
+	for x := 0; x < w; x++ {
+		count := 0
+		for y := 0; y < min(radius, h); y++ {
+			if across[y*w+x] {
+				count++
+			}
+		}
+		for y := 0; y < h; y++ {
+			if y+radius < h && across[(y+radius)*w+x] {
+				count++
+			}
+			if y-radius-1 >= 0 && across[(y-radius-1)*w+x] {
+				count--
+			}
+			out[y*w+x] = count > 0
+		}
+	}
+	return out
+}
+
+// open removes the marked parts of a w x h mask narrower than a square of the given radius, leaving the
+// rest as it was
+func open(mask []bool, w, h, radius int) []bool {
+	inverted := make([]bool, len(mask))
+	for i, marked := range mask {
+		inverted[i] = !marked
+	}
+	eroded := dilate(inverted, w, h, radius)
+	for i, unmarked := range eroded {
+		eroded[i] = !unmarked
+	}
+	return dilate(eroded, w, h, radius)
+}
+
+// components returns the bounding box of each connected group of marked pixels in a w x h mask
+func components(mask []bool, w, h int) []image.Rectangle {
+	seen := make([]bool, len(mask))
+	var boxes []image.Rectangle
+	var stack []int
+
+	for start := range mask {
+		if !mask[start] || seen[start] {
+			continue
+		}
+		seen[start] = true
+		stack = append(stack[:0], start)
+		box := image.Rect(start%w, start/w, start%w+1, start/w+1)
+
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := p%w, p/w
+			box = box.Union(image.Rect(x, y, x+1, y+1))
+
+			for _, n := range [4]int{p - 1, p + 1, p - w, p + w} {
+				if n < 0 || n >= len(mask) || (n == p-1 && x == 0) || (n == p+1 && x == w-1) {
+					continue
+				}
+				if mask[n] && !seen[n] {
+					seen[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes
+}
+
+// mergeOverlapping joins boxes that overlap until none do, so a stamp found in pieces is cropped whole
+func mergeOverlapping(boxes []image.Rectangle) []image.Rectangle {
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(boxes) && !merged; i++ {
+			for j := i + 1; j < len(boxes); j++ {
+				if boxes[i].Overlaps(boxes[j]) {
+					boxes[i] = boxes[i].Union(boxes[j])
+					boxes = append(boxes[:j], boxes[j+1:]...)
+					merged = true
+					break
+				}
+			}
+		}
+	}
+	return boxes
+}
+
+// sortReadingOrder sorts boxes into rows, top to bottom, and each row left to right. A box starts a new row
+// when its top is below the middle of the first box of the row.
+func sortReadingOrder(boxes []image.Rectangle) {
+	sort.Slice(boxes, func(i, j int) bool { return boxes[i].Min.Y < boxes[j].Min.Y })
+
+	for start := 0; start < len(boxes); {
+		end := start + 1
+		middle := (boxes[start].Min.Y + boxes[start].Max.Y) / 2
+		for end < len(boxes) && boxes[end].Min.Y < middle {
+			end++
+		}
+		row := boxes[start:end]
+		sort.Slice(row, func(i, j int) bool { return row[i].Min.X < row[j].Min.X })
+		start = end
+	}
+}
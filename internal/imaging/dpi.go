@@ -0,0 +1,152 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// minScanDPI is the least resolution taken as a real one. Lower values, usually 72 or 96, are placeholders
+// written by cameras and editors rather than the resolution of a scan.
+const minScanDPI = 100
+
+// ReadDPI returns the resolution, in dots per inch, recorded in the metadata of a PNG, JPEG or TIFF file, or
+// 0 if none is. PNGs record it in their pHYs chunk, JPEGs in their EXIF or JFIF header, and TIFFs among their
+// tags. Only the horizontal resolution is read; scanners don't record different ones.
+func ReadDPI(data []byte) float64 {
+	var dpi float64
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		dpi = pngDPI(data)
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		dpi = jpegDPI(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		dpi = tiffDPI(data)
+	}
+	if dpi < minScanDPI {
+		return 0
+	}
+	return dpi
+}
+
+// LoadScan decodes the JPEG, PNG, GIF or TIFF image stored under key along with the resolution recorded in its
+// metadata, which is 0 if there is none
+func LoadScan(store storage.Storage, key string) (image.Image, float64, error) {
+	body, _, err := store.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	return img, ReadDPI(data), nil
+}
+
+// pngDPI reads the pHYs chunk, which gives pixels per metre when its unit is 1
+func pngDPI(data []byte) float64 {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunk := string(data[i+4 : i+8])
+		body := data[i+8:]
+		if length < 0 || length > len(body) || chunk == "IDAT" {
+			return 0
+		}
+		if chunk == "pHYs" && length >= 9 && body[8] == 1 {
+			return float64(binary.BigEndian.Uint32(body)) * 0.0254
+		}
+		i += 12 + length
+	}
+	return 0
+}
+
+// jpegDPI reads the resolution from the EXIF header, which is written by more scanners, or else from the
+// JFIF header
+func jpegDPI(data []byte) float64 {
+	var jfif float64
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // The image data starts
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			if dpi := tiffDPI(segment[6:]); dpi > 0 {
+				return dpi
+			}
+		case marker == 0xE0 && bytes.HasPrefix(segment, []byte("JFIF\x00")) && len(segment) >= 12:
+			density := float64(binary.BigEndian.Uint16(segment[8:]))
+			switch segment[7] {
+			case 1: // Dots per inch
+				jfif = density
+			case 2: // Dots per centimetre
+				jfif = density * 2.54
+			}
+		}
+		i += 2 + length
+	}
+	return jfif
+}
+
+// tiffDPI reads the XResolution and ResolutionUnit tags from the first directory of a TIFF file, or of the
+// TIFF structure inside a JPEG's EXIF header
+func tiffDPI(data []byte) float64 {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	dir := int(order.Uint32(data[4:]))
+	if dir < 0 || dir+2 > len(data) {
+		return 0
+	}
+	count := int(order.Uint16(data[dir:]))
+
+	var resolution float64
+	unit := uint16(2) // Inches unless the file says otherwise
+	for n := 0; n < count; n++ {
+		entry := dir + 2 + n*12
+		if entry+12 > len(data) {
+			return 0
+		}
+		switch order.Uint16(data[entry:]) {
+		case 0x011A: // XResolution, a fraction stored elsewhere in the file
+			offset := int(order.Uint32(data[entry+8:]))
+			if offset < 0 || offset+8 > len(data) {
+				return 0
+			}
+			numerator, denominator := order.Uint32(data[offset:]), order.Uint32(data[offset+4:])
+			if denominator != 0 {
+				resolution = float64(numerator) / float64(denominator)
+			}
+		case 0x0128: // ResolutionUnit: 1 for none, 2 for inches and 3 for centimetres
+			unit = order.Uint16(data[entry+8:])
+		}
+	}
+
+	switch unit {
+	case 2:
+		return resolution
+	case 3:
+		return resolution * 2.54
+	}
+	return 0
+}
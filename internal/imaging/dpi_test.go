@@ -0,0 +1,74 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// setTIFFResolution rewrites the XResolution and ResolutionUnit tags of a little-endian TIFF file
+func setTIFFResolution(t *testing.T, data []byte, numerator, denominator uint32, unit uint16) {
+	t.Helper()
+	order := binary.LittleEndian
+	dir := int(order.Uint32(data[4:]))
+	found := 0
+	for n := 0; n < int(order.Uint16(data[dir:])); n++ {
+		entry := dir + 2 + n*12
+		switch order.Uint16(data[entry:]) {
+		case 0x011A:
+			offset := order.Uint32(data[entry+8:])
+			order.PutUint32(data[offset:], numerator)
+			order.PutUint32(data[offset+4:], denominator)
+			found++
+		case 0x0128:
+			order.PutUint16(data[entry+8:], unit)
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatal("resolution tags not found")
+	}
+}
+
+func TestTIFFScans(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("Decode gave a %v image, want %v", decoded.Bounds(), img.Bounds())
+	}
+
+	// The encoder records 72 DPI, a placeholder rather than a scan's resolution
+	if dpi := ReadDPI(data); dpi != 0 {
+		t.Errorf("ReadDPI of a 72 DPI file = %v, want 0", dpi)
+	}
+
+	tests := []struct {
+		numerator, denominator uint32
+		unit                   uint16
+		want                   float64
+	}{
+		{600, 1, 2, 600},
+		{1200, 1, 2, 1200},
+		{23622, 100, 3, 600}, // Per centimetre
+		{600, 1, 1, 0},       // No unit
+	}
+	for _, tt := range tests {
+		setTIFFResolution(t, data, tt.numerator, tt.denominator, tt.unit)
+		if dpi := ReadDPI(data); math.Abs(dpi-tt.want) > 0.1 {
+			t.Errorf("ReadDPI of %d/%d with unit %d = %v, want %v", tt.numerator, tt.denominator, tt.unit, dpi, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// ErrInvalidEdit is wrapped by the errors Edit.Validate returns
+var ErrInvalidEdit = errors.New("invalid image edit")
+
+// Edit describes changes to an image. They are applied in order: rotation, then flips, then the crop, whose
+// rectangle is given in fractions of the rotated and flipped image so it doesn't depend on its resolution.
+type Edit struct {
+	Rotate         float64    `json:"rotate"` // Degrees clockwise; any angle, to straighten crooked scans
+	FlipHorizontal bool       `json:"flip_horizontal"`
+	FlipVertical   bool       `json:"flip_vertical"`
+	Crop           *CropFrame `json:"crop,omitempty"`
+}
+
+// CropFrame is a rectangle in fractions (0 to 1) of an image's width and height
+type CropFrame struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// IsZero reports whether the edit changes nothing
+func (e Edit) IsZero() bool {
+	return math.Mod(e.Rotate, 360) == 0 && !e.FlipHorizontal && !e.FlipVertical && e.Crop == nil
+}
+
+// Validate rejects edits that change nothing and crops that don't lie within the image
+func (e Edit) Validate() error {
+	if math.IsNaN(e.Rotate) || math.IsInf(e.Rotate, 0) {
+		return fmt.Errorf("%w: rotation must be a number of degrees", ErrInvalidEdit)
+	}
+	if e.IsZero() {
+		return fmt.Errorf("%w: nothing to change", ErrInvalidEdit)
+	}
+	if c := e.Crop; c != nil {
+		if c.X < 0 || c.Y < 0 || c.Width <= 0 || c.Height <= 0 || c.X+c.Width > 1.0001 || c.Y+c.Height > 1.0001 {
+			return fmt.Errorf("%w: the crop must lie within the image", ErrInvalidEdit)
+		}
+	}
+	return nil
+}
+
+// Apply makes the edit to img. Corners uncovered by rotating are filled with white, like scanner background.
+func (e Edit) Apply(img image.Image) (image.Image, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	out := Rotate(toRGBA(img), e.Rotate, color.White)
+	if e.FlipHorizontal {
+		out = FlipHorizontal(out)
+	}
+	if e.FlipVertical {
+		out = FlipVertical(out)
+	}
+
+	if c := e.Crop; c != nil {
+		w, h := float64(out.Bounds().Dx()), float64(out.Bounds().Dy())
+		rect := image.Rect(int(math.Round(c.X*w)), int(math.Round(c.Y*h)),
+			int(math.Round((c.X+c.Width)*w)), int(math.Round((c.Y+c.Height)*h))).Add(out.Bounds().Min)
+		rect = rect.Intersect(out.Bounds())
+		if rect.Empty() {
+			return nil, fmt.Errorf("%w: the crop is smaller than a pixel", ErrInvalidEdit)
+		}
+		out = out.SubImage(rect).(*image.RGBA)
+	}
+	return out, nil
+}
+
+// Rotate turns img clockwise by degrees, enlarging the canvas to fit the turned image and filling the
+// uncovered corners with background. Quarter turns are exact; other angles are interpolated.
+func Rotate(img *image.RGBA, degrees float64, background color.Color) *image.RGBA {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	if degrees == 0 {
+		return img
+	}
+	if math.Mod(degrees, 90) == 0 {
+		return rotateQuarters(img, int(degrees/90))
+	}
+
+	b := img.Bounds()
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	w, h := float64(b.Dx()), float64(b.Dy())
+	nw := int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin) - 1e-9))
+	nh := int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos) - 1e-9))
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+
+	br, bg, bb, ba := background.RGBA()
+	bgPixel := [4]float64{float64(br >> 8), float64(bg >> 8), float64(bb >> 8), float64(ba >> 8)}
+
+	// Each destination pixel takes the source colour found by turning it back about the centre
+	cx, cy := w/2, h/2
+	ncx, ncy := float64(nw)/2, float64(nh)/2
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			dx, dy := float64(x)+0.5-ncx, float64(y)+0.5-ncy
+			sx := dx*cos + dy*sin + cx - 0.5
+			sy := -dx*sin + dy*cos + cy - 0.5
+
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			fx, fy := sx-float64(x0), sy-float64(y0)
+			var pixel [4]float64
+			for _, corner := range [4]struct {
+				x, y   int
+				weight float64
+			}{
+				{x0, y0, (1 - fx) * (1 - fy)},
+				{x0 + 1, y0, fx * (1 - fy)},
+				{x0, y0 + 1, (1 - fx) * fy},
+				{x0 + 1, y0 + 1, fx * fy},
+			} {
+				source := bgPixel
+				if corner.x >= 0 && corner.y >= 0 && corner.x < b.Dx() && corner.y < b.Dy() {
+					i := img.PixOffset(b.Min.X+corner.x, b.Min.Y+corner.y)
+					source = [4]float64{float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2]), float64(img.Pix[i+3])}
+				}
+				for c := range pixel {
+					pixel[c] += source[c] * corner.weight
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			for c := range pixel {
+				dst.Pix[i+c] = uint8(math.Round(pixel[c]))
+			}
+		}
+	}
+	return dst
+}
+
+// rotateQuarters turns img clockwise by a number of quarter turns
+func rotateQuarters(img *image.RGBA, turns int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	size := image.Rect(0, 0, h, w)
+	if turns == 2 {
+		size = image.Rect(0, 0, w, h)
+	}
+	dst := image.NewRGBA(size)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch turns {
+			case 1:
+				nx, ny = h-1-y, x
+			case 2:
+				nx, ny = w-1-x, h-1-y
+			default:
+				nx, ny = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(nx, ny):dst.PixOffset(nx, ny)+4], img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y):])
+		}
+	}
+	return dst
+}
+
+// FlipHorizontal mirrors img left to right
+func FlipHorizontal(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			i := img.PixOffset(b.Max.X-1-x, b.Min.Y+y)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], img.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// FlipVertical mirrors img top to bottom
+func FlipVertical(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		i := img.PixOffset(b.Min.X, b.Max.Y-1-y)
+		copy(dst.Pix[dst.PixOffset(0, y):dst.PixOffset(0, y)+4*b.Dx()], img.Pix[i:i+4*b.Dx()])
+	}
+	return dst
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed, so its pixels can be read directly
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,220 @@
+// Package imaging holds the image processing used for uploaded scans: decoding, resizing and thumbnails.
+// It depends on the standard library and golang.org/x/image for TIFF, the format many scanners save in; PDF pages
+// are rendered with poppler's pdftoppm when it is installed.
+// Images are read from and written to a storage backend by key.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+	_ "golang.org/x/image/tiff" // Register the TIFF decoder
+)
+
+// ThumbnailSize is the longest side, in pixels, of the thumbnails made for uploads
+const ThumbnailSize = 300
+
+// MaxPixels is the most pixels an image may have to be decoded, as it is held whole in memory while it is: a
+// scan of a full album page at over 1200 DPI. A small file can claim far more, so the size is checked first.
+const MaxPixels = 100_000_000
+
+// ErrUnsupported is returned for files that can't be decoded, e.g. WebP images, or PDFs when pdftoppm
+// isn't installed
+var ErrUnsupported = errors.New("imaging: unsupported file type")
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels
+var ErrTooLarge = errors.New("imaging: image too large")
+
+// Decode decodes a JPEG, PNG, GIF or TIFF image, once CheckSize has found it small enough
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupported
+	}
+	return img, err
+}
+
+// CheckSize reads the size of a JPEG, PNG, GIF or TIFF image from its header, without decoding it, and returns
+// ErrTooLarge if it has more than MaxPixels pixels
+func CheckSize(r io.Reader) error {
+	config, _, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		return ErrUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, config.Width, config.Height)
+	}
+	return nil
+}
+
+// Load decodes the JPEG, PNG, GIF or TIFF image stored under key
+func Load(store storage.Storage, key string) (image.Image, error) {
+	body, _, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return Decode(body)
+}
+
+// EncodeJPEG encodes img as a JPEG
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodePNG encodes img as a PNG, for edits of images that should stay lossless
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveJPEG stores img as a JPEG under key
+func SaveJPEG(store storage.Storage, key string, img image.Image) error {
+	data, err := EncodeJPEG(img)
+	if err != nil {
+		return err
+	}
+	return store.Put(key, bytes.NewReader(data), "image/jpeg")
+}
+
+// Fit scales img down so neither side is longer than maxSize, keeping its aspect ratio. Smaller images
+// are returned unchanged.
+func Fit(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSize && h <= maxSize {
+		return img
+	}
+	if w >= h {
+		return Resize(img, maxSize, max(1, h*maxSize/w))
+	}
+	return Resize(img, max(1, w*maxSize/h), maxSize)
+}
+
+// Resize scales img to width x height, averaging the source pixels that fall in each destination pixel
+func Resize(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// MakeThumbnail stores a JPEG thumbnail of the image or PDF under src as dst. The first page of a PDF is
+// used; ErrUnsupported is returned if it can't be rendered.
+func MakeThumbnail(store storage.Storage, src, contentType, dst string) error {
+	var img image.Image
+	var err error
+	switch {
+	case contentType == "application/pdf":
+		img, err = renderPDFPage(store, src)
+	case strings.HasPrefix(contentType, "image/"):
+		img, err = Load(store, src)
+	default:
+		return ErrUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	return SaveJPEG(store, dst, Fit(img, ThumbnailSize))
+}
+
+// renderPDFPage renders the first page of the PDF stored under key. pdftoppm works on files, so the PDF
+// and the page are kept in a temporary directory meanwhile.
+func renderPDFPage(store storage.Storage, key string) (image.Image, error) {
+	pdftoppm, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	out, err := os.MkdirTemp("", "stampkeeper-pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(out)
+
+	src := filepath.Join(out, "source.pdf")
+	if err := copyToFile(store, key, src); err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Join(out, "page")
+	cmd := exec.Command(pdftoppm, "-png", "-singlefile", "-f", "1", "-l", "1",
+		"-scale-to", fmt.Sprint(ThumbnailSize*2), src, prefix)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("imaging: pdftoppm failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	f, err := os.Open(prefix + ".png")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// copyToFile writes the file stored under key to path, for tools that only work on files
+func copyToFile(store storage.Storage, key, path string) error {
+	body, _, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
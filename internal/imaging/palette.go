@@ -0,0 +1,235 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Palettes are worked out on the image shrunk to paletteSampleSize on its longest side, with up to
+// paletteColours colours. Smaller reductions blend the fine lines of engraved stamps into the paper.
+const (
+	paletteSampleSize = 160
+	paletteColours    = 5
+	paletteIterations = 10
+	minPaletteShare   = 0.03 // Colours covering less of the image are left out
+	paletteSeedSpread = 12   // Least distance between the colours the clustering starts from
+)
+
+// Colours of the paper rather than the ink: light, and white or nearly so
+const (
+	paperLightness = 78
+	paperChroma    = 16
+	// Below this chroma an ink has no hue to speak of: black, grey or a very dull brown
+	achromaticChroma = 8
+)
+
+// Swatch is one colour of an image's palette and the share of the image it covers
+type Swatch struct {
+	Colour color.RGBA
+	Share  float64
+}
+
+// Lab is a colour in the CIE L*a*b* space, where the distance between two colours follows how different
+// they look: about 2 can just be told apart side by side, and over 20 they would be called different colours
+type Lab struct {
+	L, A, B float64
+}
+
+// Hex writes the swatch's colour the way HTML does, e.g. "#b22234"
+func (s Swatch) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", s.Colour.R, s.Colour.G, s.Colour.B)
+}
+
+// ParseHex reads a colour written the way HTML does, e.g. "#b22234"
+func ParseHex(hex string) (color.RGBA, error) {
+	var c color.RGBA
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) != 6 {
+		return c, fmt.Errorf("invalid colour %q", hex)
+	}
+	if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid colour %q", hex)
+	}
+	c.A = 255
+	return c, nil
+}
+
+// ToLab converts an sRGB colour to CIE L*a*b* under daylight (D65)
+func ToLab(c color.RGBA) Lab {
+	linear := func(v uint8) float64 {
+		f := float64(v) / 255
+		if f <= 0.04045 {
+			return f / 12.92
+		}
+		return math.Pow((f+0.055)/1.055, 2.4)
+	}
+	r, g, b := linear(c.R), linear(c.G), linear(c.B)
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return Lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// Distance is how different two colours look, the CIE 1976 colour difference
+func (c Lab) Distance(other Lab) float64 {
+	return math.Sqrt((c.L-other.L)*(c.L-other.L) + (c.A-other.A)*(c.A-other.A) + (c.B-other.B)*(c.B-other.B))
+}
+
+// Chroma is how far a colour is from grey
+func (c Lab) Chroma() float64 {
+	return math.Hypot(c.A, c.B)
+}
+
+// Hue is the angle of a colour around the colour wheel, in degrees: about 40 for red, 90 for yellow, 160
+// for green, 250 for blue and 330 for purple
+func (c Lab) Hue() float64 {
+	hue := math.Atan2(c.B, c.A) * 180 / math.Pi
+	if hue < 0 {
+		hue += 360
+	}
+	return hue
+}
+
+// IsPaper reports whether a colour looks like the paper a stamp is printed on rather than its ink
+func (c Lab) IsPaper() bool {
+	return c.L >= paperLightness && c.Chroma() < paperChroma
+}
+
+// IsAchromatic reports whether a colour is too near grey to have a hue
+func (c Lab) IsAchromatic() bool {
+	return c.Chroma() < achromaticChroma
+}
+
+// Palette returns the main colours of an image, most of the image first. Pixels are grouped into up to
+// paletteColours clusters of like colours by k-means in L*a*b*, started from the most common colours, so the
+// same image always gives the same palette.
+func Palette(img image.Image) []Swatch {
+	small := toRGBA(Fit(img, paletteSampleSize))
+	bounds := small.Bounds()
+
+	var pixels []Lab
+	var colours []color.RGBA
+	counts := map[[3]uint8]int{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := small.PixOffset(x, y)
+			c := color.RGBA{small.Pix[i], small.Pix[i+1], small.Pix[i+2], 255}
+			pixels = append(pixels, ToLab(c))
+			colours = append(colours, c)
+			counts[[3]uint8{c.R >> 4, c.G >> 4, c.B >> 4}]++
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	centres := paletteSeeds(counts)
+	assigned := make([]int, len(pixels))
+	for iteration := 0; iteration < paletteIterations; iteration++ {
+		for i, pixel := range pixels {
+			assigned[i] = nearestCentre(pixel, centres)
+		}
+		sums := make([]Lab, len(centres))
+		sizes := make([]int, len(centres))
+		for i, pixel := range pixels {
+			k := assigned[i]
+			sums[k].L, sums[k].A, sums[k].B = sums[k].L+pixel.L, sums[k].A+pixel.A, sums[k].B+pixel.B
+			sizes[k]++
+		}
+		for k := range centres {
+			if sizes[k] > 0 {
+				n := float64(sizes[k])
+				centres[k] = Lab{sums[k].L / n, sums[k].A / n, sums[k].B / n}
+			}
+		}
+	}
+
+	// Each swatch is shown in the average sRGB colour of its pixels, which is what they look like on screen
+	type cluster struct{ r, g, b, n int }
+	clusters := make([]cluster, len(centres))
+	for i, c := range colours {
+		k := &clusters[assigned[i]]
+		k.r, k.g, k.b, k.n = k.r+int(c.R), k.g+int(c.G), k.b+int(c.B), k.n+1
+	}
+
+	var palette []Swatch
+	for _, k := range clusters {
+		share := float64(k.n) / float64(len(pixels))
+		if k.n == 0 || share < minPaletteShare {
+			continue
+		}
+		palette = append(palette, Swatch{
+			Colour: color.RGBA{uint8(k.r / k.n), uint8(k.g / k.n), uint8(k.b / k.n), 255},
+			Share:  share,
+		})
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Share > palette[j].Share })
+	return palette
+}
+
+// InkColour returns the colour a stamp is printed in: the commonest colour of its palette that isn't the
+// paper. It reports false if the palette is all paper.
+func InkColour(palette []Swatch) (Swatch, bool) {
+	for _, swatch := range palette {
+		if !ToLab(swatch.Colour).IsPaper() {
+			return swatch, true
+		}
+	}
+	return Swatch{}, false
+}
+
+// paletteSeeds picks the colours the clustering starts from: the commonest colours of the image, coarsely
+// counted, that are at least paletteSeedSpread apart
+func paletteSeeds(counts map[[3]uint8]int) []Lab {
+	type bucket struct {
+		colour [3]uint8
+		count  int
+	}
+	buckets := make([]bucket, 0, len(counts))
+	for colour, count := range counts {
+		buckets = append(buckets, bucket{colour, count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].count != buckets[j].count {
+			return buckets[i].count > buckets[j].count
+		}
+		a, b := buckets[i].colour, buckets[j].colour
+		return a[0] < b[0] || a[0] == b[0] && (a[1] < b[1] || a[1] == b[1] && a[2] < b[2])
+	})
+
+	var seeds []Lab
+	for _, b := range buckets {
+		// The middle of the bucket
+		lab := ToLab(color.RGBA{b.colour[0]<<4 | 8, b.colour[1]<<4 | 8, b.colour[2]<<4 | 8, 255})
+		if len(seeds) > 0 && lab.Distance(seeds[nearestCentre(lab, seeds)]) < paletteSeedSpread {
+			continue
+		}
+		seeds = append(seeds, lab)
+		if len(seeds) == paletteColours {
+			break
+		}
+	}
+	return seeds
+}
+
+func nearestCentre(pixel Lab, centres []Lab) int {
+	nearest, best := 0, math.Inf(1)
+	for k, centre := range centres {
+		if d := pixel.Distance(centre); d < best {
+			nearest, best = k, d
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestToLab(t *testing.T) {
+	// Reference values for sRGB under D65
+	tests := []struct {
+		hex  string
+		want Lab
+	}{
+		{"#ffffff", Lab{100, 0, 0}},
+		{"#000000", Lab{0, 0, 0}},
+		{"#808080", Lab{53.59, 0, 0}},
+		{"#ff0000", Lab{53.24, 80.09, 67.20}},
+		{"#00ff00", Lab{87.73, -86.18, 83.18}},
+		{"#0000ff", Lab{32.30, 79.19, -107.86}},
+	}
+	for _, tt := range tests {
+		c, err := ParseHex(tt.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ToLab(c); got.Distance(tt.want) > 0.2 {
+			t.Errorf("ToLab(%s) = %.2f, want %.2f", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+		ok   bool
+	}{
+		{"#b22234", color.RGBA{0xb2, 0x22, 0x34, 255}, true},
+		{"B22234", color.RGBA{0xb2, 0x22, 0x34, 255}, true},
+		{" #c41e3a ", color.RGBA{0xc4, 0x1e, 0x3a, 255}, true},
+		{"#fff", color.RGBA{}, false},
+		{"#gggggg", color.RGBA{}, false},
+		{"", color.RGBA{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseHex(tt.in)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Errorf("ParseHex(%q) = %v, %v", tt.in, got, err)
+		}
+		if hex := (Swatch{Colour: got}).Hex(); tt.ok && hex != (Swatch{Colour: tt.want}).Hex() {
+			t.Errorf("Hex of %q = %s", tt.in, hex)
+		}
+	}
+}
+
+func TestLabHueAndKind(t *testing.T) {
+	tests := []struct {
+		hex          string
+		hue          float64 // Ignored for achromatic colours
+		paper        bool
+		achromatic   bool
+		hueTolerance float64
+	}{
+		{"#c41e3a", 25, false, false, 15},  // Carmine
+		{"#e8c53a", 85, false, false, 15},  // Yellow
+		{"#2e8540", 145, false, false, 15}, // Green
+		{"#2a5aa8", 280, false, false, 15}, // Blue
+		{"#f0e4d4", 0, true, false, 0},     // Cream paper
+		{"#ffffff", 0, true, true, 0},
+		{"#737373", 0, false, true, 0},
+		{"#1e1e1e", 0, false, true, 0},
+	}
+	for _, tt := range tests {
+		c, _ := ParseHex(tt.hex)
+		lab := ToLab(c)
+		if lab.IsPaper() != tt.paper {
+			t.Errorf("%s IsPaper = %v, want %v", tt.hex, lab.IsPaper(), tt.paper)
+		}
+		if lab.IsAchromatic() != tt.achromatic {
+			t.Errorf("%s IsAchromatic = %v, want %v", tt.hex, lab.IsAchromatic(), tt.achromatic)
+		}
+		if tt.hueTolerance > 0 && math.Abs(lab.Hue()-tt.hue) > tt.hueTolerance {
+			t.Errorf("%s Hue = %.1f, want about %.0f", tt.hex, lab.Hue(), tt.hue)
+		}
+	}
+}
+
+func TestPalette(t *testing.T) {
+	paper := color.RGBA{0xf0, 0xe4, 0xd4, 255}
+	ink := color.RGBA{0xc4, 0x1e, 0x3a, 255}
+
+	// A cream stamp with carmine covering a quarter of it, small enough to be read as it is
+	img := image.NewRGBA(image.Rect(0, 0, 120, 160))
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 120; x++ {
+			c := paper
+			if x < 30 {
+				c = ink
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	palette := Palette(img)
+	if len(palette) != 2 {
+		t.Fatalf("Palette = %v, want paper and ink", palette)
+	}
+	if d := ToLab(palette[0].Colour).Distance(ToLab(paper)); d > 5 || math.Abs(palette[0].Share-0.75) > 0.05 {
+		t.Errorf("first colour = %s covering %.2f, want %s covering 0.75", palette[0].Hex(), palette[0].Share, Swatch{Colour: paper}.Hex())
+	}
+
+	got, ok := InkColour(palette)
+	if !ok {
+		t.Fatalf("InkColour(%v) found no ink", palette)
+	}
+	if d := ToLab(got.Colour).Distance(ToLab(ink)); d > 10 {
+		t.Errorf("InkColour = %s, want about %s", got.Hex(), Swatch{Colour: ink}.Hex())
+	}
+
+	if _, ok := InkColour([]Swatch{{Colour: paper, Share: 1}}); ok {
+		t.Error("InkColour of a blank stamp found ink")
+	}
+}
+
+func TestPaletteIsStable(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 90, 90))
+	colours := []color.RGBA{{0x2a, 0x5a, 0xa8, 255}, {0xe8, 0x84, 0x2a, 255}, {0xf5, 0xf5, 0xf0, 255}}
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 90; x++ {
+			img.Set(x, y, colours[(x/30+y/30)%3])
+		}
+	}
+
+	first := Palette(img)
+	for i := 0; i < 5; i++ {
+		again := Palette(img)
+		if len(again) != len(first) {
+			t.Fatalf("Palette gave %v, then %v", first, again)
+		}
+		for k := range first {
+			if again[k] != first[k] {
+				t.Fatalf("Palette gave %v, then %v", first, again)
+			}
+		}
+	}
+}
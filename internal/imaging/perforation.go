@@ -0,0 +1,189 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+// perforationSize is the longest side, in pixels, the stamp is scaled down to before its perforations are
+// read. It is larger than measureSize, as the teeth are small.
+const perforationSize = 2400
+
+// Edge gauges that aren't a count of teeth
+const (
+	StraightEdge   = 0  // A straight-cut or imperforate edge
+	UnreadableEdge = -1 // Perforated, perhaps, but the teeth couldn't be counted
+)
+
+// Perforation is the size of a stamp and the gauge of its perforations, measured from a scan of known
+// resolution
+type Perforation struct {
+	WidthMM, HeightMM float64 // Through the middle of the perforations
+	// Teeth per 2 cm along each edge, or StraightEdge or UnreadableEdge
+	Top, Bottom, Left, Right float64
+}
+
+// Gauge describes the perforations the usual way, the top and bottom edges first and the sides second, to the
+// nearest quarter, e.g. "11 x 10½". It is a single number when they are the same and "imperf" for straight
+// edges, and empty when no edge could be read.
+func (p Perforation) Gauge() string {
+	horizontal, vertical := edgePairGauge(p.Top, p.Bottom), edgePairGauge(p.Left, p.Right)
+	switch {
+	case horizontal == "" && vertical == "":
+		return ""
+	case horizontal == "":
+		return "? x " + vertical
+	case vertical == "":
+		return horizontal + " x ?"
+	case horizontal == vertical:
+		return horizontal
+	}
+	return horizontal + " x " + vertical
+}
+
+// FormatGauge writes a gauge to the nearest quarter, e.g. "10½"
+func FormatGauge(gauge float64) string {
+	quarters := int(math.Round(gauge * 4))
+	whole, fraction := quarters/4, []string{"", "¼", "½", "¾"}[quarters%4]
+	return fmt.Sprintf("%d%s", whole, fraction)
+}
+
+// MeasurePerforations measures the single stamp on a scan of the given resolution, in dots per inch, and
+// counts the perforation teeth along each of its edges. As with MeasureCentering, the stamp must lie on a
+// contrasting background with some showing all round. Only the middle of each edge is read, away from the
+// corners, and the gauge is worked out from the spacing of the holes, so a short or pulled perf or two
+// changes it little.
+func MeasurePerforations(img image.Image, dpi float64) (*Perforation, error) {
+	if dpi <= 0 {
+		return nil, fmt.Errorf("%w: the scan's resolution is needed", ErrNotMeasurable)
+	}
+
+	// The stamp is found on a small copy of the scan, then read from the scan cut down around it, as large as
+	// it can be
+	small, _, box, err := findStamp(img, measureSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	scale := float64(bounds.Dx()) / float64(small.Bounds().Dx())
+	pad := max(box.Dx(), box.Dy()) / 10
+	around := scaleRect(box.Inset(-pad), scale).Add(bounds.Min).Intersect(bounds)
+
+	stamp, mask, box, err := findStamp(Crop(img, around), perforationSize, 1)
+	if err != nil {
+		return nil, err
+	}
+	w := stamp.Bounds().Dx()
+	pixelsPerMM := dpi / 25.4 * float64(w) / float64(around.Dx())
+
+	edges, ok := paperEdges(mask, w, box)
+	if !ok {
+		return nil, fmt.Errorf("%w: the stamp's edges could not be found", ErrNotMeasurable)
+	}
+
+	profiles := perforationProfiles(mask, w, box)
+	p := &Perforation{
+		WidthMM:  float64(edges.Dx()) / pixelsPerMM,
+		HeightMM: float64(edges.Dy()) / pixelsPerMM,
+		Top:      edgeGauge(profiles[0], 20*pixelsPerMM),
+		Bottom:   edgeGauge(profiles[1], 20*pixelsPerMM),
+		Left:     edgeGauge(profiles[2], 20*pixelsPerMM),
+		Right:    edgeGauge(profiles[3], 20*pixelsPerMM),
+	}
+	return p, nil
+}
+
+// perforationProfiles returns how far in from the top, bottom, left and right of box the paper starts, at
+// each point along the middle 80% of that side. Along a perforated edge it rises and falls with the holes.
+func perforationProfiles(mask []bool, w int, box image.Rectangle) [4][]int {
+	var profiles [4][]int
+	reach := min(box.Dx(), box.Dy()) / 8
+
+	depth := func(x, y, dx, dy int) int {
+		for d := 0; d < reach; d++ {
+			if mask[(y+d*dy)*w+x+d*dx] {
+				return d
+			}
+		}
+		return reach
+	}
+	for x := box.Min.X + box.Dx()/10; x < box.Max.X-box.Dx()/10; x++ {
+		profiles[0] = append(profiles[0], depth(x, box.Min.Y, 0, 1))
+		profiles[1] = append(profiles[1], depth(x, box.Max.Y-1, 0, -1))
+	}
+	for y := box.Min.Y + box.Dy()/10; y < box.Max.Y-box.Dy()/10; y++ {
+		profiles[2] = append(profiles[2], depth(box.Min.X, y, 1, 0))
+		profiles[3] = append(profiles[3], depth(box.Max.X-1, y, -1, 0))
+	}
+	return profiles
+}
+
+// edgeGauge counts the holes along an edge's profile and returns how many teeth there are per 2 cm, given
+// how many pixels 2 cm is. The gauge comes from the average spacing of the holes, from the first to the last.
+func edgeGauge(profile []int, pixelsPer2CM float64) float64 {
+	if len(profile) == 0 {
+		return UnreadableEdge
+	}
+	sorted := append([]int(nil), profile...)
+	sort.Ints(sorted)
+	low, high := float64(sorted[len(sorted)/10]), float64(sorted[len(sorted)*9/10])
+	if high-low < math.Max(2, pixelsPer2CM/200) {
+		return StraightEdge
+	}
+
+	// A hole starts where the edge falls well below the middle of its range, and ends where it rises
+	// well above it, so small wobbles in the outline aren't taken for holes
+	middle, band := (low+high)/2, (high-low)/4
+	var starts []int
+	inHole := float64(profile[0]) > middle
+	for i, d := range profile {
+		switch depth := float64(d); {
+		case !inHole && depth > middle+band:
+			inHole = true
+			starts = append(starts, i)
+		case inHole && depth < middle-band:
+			inHole = false
+		}
+	}
+	if len(starts) < 3 {
+		return UnreadableEdge
+	}
+
+	spacing := float64(starts[len(starts)-1]-starts[0]) / float64(len(starts)-1)
+	return pixelsPer2CM / spacing
+}
+
+// edgePairGauge describes the gauge of two opposite edges, which are the same on all but a few stamps
+func edgePairGauge(a, b float64) string {
+	var readable []float64
+	for _, gauge := range []float64{a, b} {
+		if gauge != UnreadableEdge {
+			readable = append(readable, gauge)
+		}
+	}
+
+	switch {
+	case len(readable) == 0:
+		return ""
+	case len(readable) == 2 && (readable[0] == StraightEdge) != (readable[1] == StraightEdge):
+		// Straight on one side only, as on a coil stamp or one from the edge of a booklet pane
+		parts := []string{"imperf", "imperf"}
+		for i, gauge := range readable {
+			if gauge != StraightEdge {
+				parts[i] = FormatGauge(gauge)
+			}
+		}
+		return strings.Join(parts, "/")
+	case readable[0] == StraightEdge:
+		return "imperf"
+	}
+
+	sum := 0.0
+	for _, gauge := range readable {
+		sum += gauge
+	}
+	return FormatGauge(sum / float64(len(readable)))
+}
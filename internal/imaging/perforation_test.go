@@ -0,0 +1,159 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestFormatGauge(t *testing.T) {
+	tests := []struct {
+		gauge float64
+		want  string
+	}{
+		{11, "11"},
+		{10.5, "10½"},
+		{12.74, "12¾"},
+		{10.12, "10"},
+		{10.13, "10¼"},
+		{13.9, "14"},
+	}
+	for _, tt := range tests {
+		if got := FormatGauge(tt.gauge); got != tt.want {
+			t.Errorf("FormatGauge(%v) = %q, want %q", tt.gauge, got, tt.want)
+		}
+	}
+}
+
+func TestPerforationGauge(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Perforation
+		want string
+	}{
+		{"all the same", Perforation{Top: 11, Bottom: 11.05, Left: 10.95, Right: 11}, "11"},
+		{"compound", Perforation{Top: 11, Bottom: 11, Left: 10.5, Right: 10.5}, "11 x 10½"},
+		{"imperforate", Perforation{}, "imperf"},
+		{"coil", Perforation{Top: StraightEdge, Bottom: StraightEdge, Left: 10, Right: 10}, "imperf x 10"},
+		{"booklet pane edge", Perforation{Top: StraightEdge, Bottom: 11, Left: 10.5, Right: 10.5}, "imperf/11 x 10½"},
+		{"one edge unreadable", Perforation{Top: UnreadableEdge, Bottom: 12, Left: 12, Right: 12}, "12"},
+		{"sides unreadable", Perforation{Top: 12, Bottom: 12, Left: UnreadableEdge, Right: UnreadableEdge}, "12 x ?"},
+		{"top and bottom unreadable", Perforation{Top: UnreadableEdge, Bottom: UnreadableEdge, Left: 12, Right: 12}, "? x 12"},
+		{"nothing readable", Perforation{Top: UnreadableEdge, Bottom: UnreadableEdge, Left: UnreadableEdge, Right: UnreadableEdge}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Gauge(); got != tt.want {
+			t.Errorf("%s: Gauge() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEdgeGauge(t *testing.T) {
+	// A perforated edge's profile: teeth 8 pixels deep and holes 30 deep, repeating every period pixels
+	teeth := func(period, length int) []int {
+		profile := make([]int, length)
+		for i := range profile {
+			profile[i] = 8
+			if i%period < period/2 {
+				profile[i] = 30
+			}
+		}
+		return profile
+	}
+	flat := make([]int, 400)
+	for i := range flat {
+		flat[i] = 5 + i%2
+	}
+
+	tests := []struct {
+		name         string
+		profile      []int
+		pixelsPer2CM float64
+		want         float64
+	}{
+		{"perf 11", teeth(20, 400), 220, 11},
+		{"perf 12½", teeth(16, 400), 200, 12.5},
+		{"straight edge", flat, 220, StraightEdge},
+		{"too few holes", teeth(200, 400), 220, UnreadableEdge},
+		{"empty", nil, 220, UnreadableEdge},
+	}
+	for _, tt := range tests {
+		if got := edgeGauge(tt.profile, tt.pixelsPer2CM); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%s: edgeGauge = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// perforatedScan draws a white stamp of the given size in millimetres on a dark background at dpi, with holes
+// of the given gauges along its top and bottom and its sides
+func perforatedScan(widthMM, heightMM, horizontal, vertical, dpi float64) image.Image {
+	px := func(mm float64) float64 { return mm / 25.4 * dpi }
+	w, h := px(widthMM), px(heightMM)
+	margin := px(8)
+	img := image.NewRGBA(image.Rect(0, 0, int(w+2*margin), int(h+2*margin)))
+
+	radius := px(0.5)
+	inHole := func(x, y float64) bool {
+		// Holes are centred on the edges, along the middle of the perforation line
+		for _, edge := range []struct {
+			along, across, length, spacing float64
+		}{
+			{x, y, w, px(20 / horizontal)},
+			{x, y - h, w, px(20 / horizontal)},
+			{y, x, h, px(20 / vertical)},
+			{y, x - w, h, px(20 / vertical)},
+		} {
+			nearest := math.Round(edge.along/edge.spacing) * edge.spacing
+			if math.Hypot(edge.along-nearest, edge.across) < radius {
+				return true
+			}
+		}
+		return false
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sx, sy := float64(x)-margin, float64(y)-margin
+			c := color.RGBA{0x20, 0x20, 0x28, 255}
+			if sx >= 0 && sy >= 0 && sx < w && sy < h && !inHole(sx, sy) {
+				c = color.RGBA{0xf4, 0xf0, 0xe6, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestMeasurePerforations(t *testing.T) {
+	const dpi = 600
+	p, err := MeasurePerforations(perforatedScan(25, 30, 12, 11, dpi), dpi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, edge := range []struct {
+		name        string
+		gauge, want float64
+	}{
+		{"top", p.Top, 12}, {"bottom", p.Bottom, 12}, {"left", p.Left, 11}, {"right", p.Right, 11},
+	} {
+		if math.Abs(edge.gauge-edge.want) > 0.25 {
+			t.Errorf("%s edge gauge = %.2f, want %v", edge.name, edge.gauge, edge.want)
+		}
+	}
+	if got := p.Gauge(); got != "12 x 11" {
+		t.Errorf("Gauge() = %q, want \"12 x 11\"", got)
+	}
+	if math.Abs(p.WidthMM-25) > 1 || math.Abs(p.HeightMM-30) > 1 {
+		t.Errorf("size = %.1f x %.1f mm, want 25 x 30", p.WidthMM, p.HeightMM)
+	}
+}
+
+func TestMeasurePerforationsNeedsDPI(t *testing.T) {
+	if _, err := MeasurePerforations(perforatedScan(25, 30, 12, 12, 300), 0); !errors.Is(err, ErrNotMeasurable) {
+		t.Errorf("MeasurePerforations without a resolution = %v, want ErrNotMeasurable", err)
+	}
+}
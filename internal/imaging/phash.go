@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// hashSampleSize is the side, in pixels, images are shrunk to before their perceptual hash is taken
+const hashSampleSize = 32
+
+// PerceptualHash returns a 64-bit fingerprint of what img looks like, which stays nearly the same when the
+// image is resized, recompressed, or lit and coloured a little differently, as two scans or photos of the
+// same stamp design are. It is the sign of the lowest frequencies of the image's discrete cosine transform
+// against their median: the broad shapes of the design rather than its detail.
+func PerceptualHash(img image.Image) uint64 {
+	small := Resize(img, hashSampleSize, hashSampleSize)
+
+	var luma [hashSampleSize][hashSampleSize]float64
+	for y := 0; y < hashSampleSize; y++ {
+		for x := 0; x < hashSampleSize; x++ {
+			i := small.PixOffset(x, y)
+			luma[y][x] = 0.299*float64(small.Pix[i]) + 0.587*float64(small.Pix[i+1]) + 0.114*float64(small.Pix[i+2])
+		}
+	}
+
+	// Only the 8 x 8 lowest frequencies are needed, so the transform is done directly, rows then columns
+	var cosines [8][hashSampleSize]float64
+	for u := range cosines {
+		for x := range cosines[u] {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * hashSampleSize))
+		}
+	}
+	var rows [hashSampleSize][8]float64
+	for y := 0; y < hashSampleSize; y++ {
+		for u := 0; u < 8; u++ {
+			for x := 0; x < hashSampleSize; x++ {
+				rows[y][u] += luma[y][x] * cosines[u][x]
+			}
+		}
+	}
+	var coefficients [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			for y := 0; y < hashSampleSize; y++ {
+				coefficients[v*8+u] += rows[y][u] * cosines[v][y]
+			}
+		}
+	}
+
+	// The first coefficient is the average brightness, which says nothing about the design
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i := 1; i < len(coefficients); i++ {
+		if coefficients[i] > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// PerceptualHashes returns the perceptual hash of img turned by each quarter turn, for matching photos
+// that weren't taken upright
+func PerceptualHashes(img image.Image) [4]uint64 {
+	small := toRGBA(Fit(img, 8*hashSampleSize))
+
+	var hashes [4]uint64
+	for turns := range hashes {
+		turned := small
+		if turns > 0 {
+			turned = rotateQuarters(small, turns)
+		}
+		hashes[turns] = PerceptualHash(turned)
+	}
+	return hashes
+}
+
+// HashDistance is the number of bits in which two perceptual hashes differ: 0 for images that look the same
+// and around 32 for unrelated ones
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// HashSimilarity converts a hash distance into a similarity from 0 to 1
+func HashSimilarity(distance int) float64 {
+	return 1 - float64(distance)/64
+}
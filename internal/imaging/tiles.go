@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// Deep-zoom tiles are made of images larger than TiledMinSize on their longest side, such as high-resolution
+// scans, so they can be panned and zoomed in the browser without loading them whole. Smaller images are shown
+// whole.
+const (
+	TiledMinSize = 2000
+	TileSize     = 256
+	tileOverlap  = 1 // Pixels each tile shares with its neighbours, so no seams show between them
+)
+
+// TilesPath returns where the Deep Zoom descriptor of an image is kept, next to its variants: the tiles of
+// stamps/abc.png are listed in stamps/derived/abc_tiles.dzi and kept under stamps/derived/abc_tiles_files/.
+func TilesPath(original string) string {
+	return VariantPath(original, "tiles", ".dzi")
+}
+
+// TilePath returns where one tile of an image is kept, by its level in the pyramid and its column and row.
+// As in Deep Zoom, level 0 is a single pixel and each level doubles the size of the one before, up to the
+// image at full size.
+func TilePath(original string, level, column, row int) string {
+	return fmt.Sprintf("%s_files/%d/%d_%d.jpg", strings.TrimSuffix(TilesPath(original), ".dzi"), level, column, row)
+}
+
+// TilesVersion returns the modification time of the tiles of the image served at imageURL, for use as a
+// version in their URL, or "" if the image has no tiles
+func TilesVersion(store storage.Storage, imageURL string) string {
+	key, ok := storage.Key(imageURL)
+	if !ok {
+		return ""
+	}
+	url := storedURL(store, TilesPath(key))
+	if i := strings.Index(url, "?v="); i >= 0 {
+		return url[i+3:]
+	}
+	return ""
+}
+
+// MakeTiles stores the Deep Zoom pyramid of the image under key, if it is large enough to need one: JPEG
+// tiles of every level and the descriptor listing them, which is written last so the tiles are only used
+// once they are all there. It reports whether tiles were made.
+func MakeTiles(store storage.Storage, key string) (bool, error) {
+	config, err := decodeConfig(store, key)
+	if err != nil {
+		return false, err
+	}
+	if max(config.Width, config.Height) <= TiledMinSize {
+		return false, nil
+	}
+	if config.Width*config.Height > MaxPixels {
+		return false, fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, config.Width, config.Height)
+	}
+
+	img, err := Load(store, key)
+	if err != nil {
+		return false, err
+	}
+	bounds := img.Bounds()
+	if max(bounds.Dx(), bounds.Dy()) <= TiledMinSize {
+		return false, nil
+	}
+
+	// Each level is the one above it halved, from the full-size image down
+	levels := int(math.Ceil(math.Log2(float64(max(bounds.Dx(), bounds.Dy()))))) + 1
+	level := toRGBA(img)
+	for number := levels - 1; number >= 0; number-- {
+		if err := putLevelTiles(store, key, number, level); err != nil {
+			return false, err
+		}
+		if number > 0 {
+			level = halve(level)
+		}
+	}
+
+	descriptor := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="jpg" Overlap="%d" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, tileOverlap, TileSize, bounds.Dx(), bounds.Dy())
+	dziKey := TilesPath(key)
+	if err := store.Put(dziKey, strings.NewReader(descriptor), "application/xml"); err != nil {
+		return false, err
+	}
+	forgetVariantURL(dziKey)
+	return true, nil
+}
+
+// RemoveTiles deletes the Deep Zoom pyramid of the image under key, if it has one
+func RemoveTiles(store storage.Storage, key string) {
+	dziKey := TilesPath(key)
+	if err := store.Delete(dziKey); err != nil {
+		log.Printf("imaging.RemoveTiles: %v", err)
+	}
+	forgetVariantURL(dziKey)
+
+	tiles, err := store.List(strings.TrimSuffix(dziKey, ".dzi") + "_files/")
+	if err != nil {
+		log.Printf("imaging.RemoveTiles: %v", err)
+		return
+	}
+	for _, tile := range tiles {
+		if err := store.Delete(tile); err != nil {
+			log.Printf("imaging.RemoveTiles: %v", err)
+		}
+	}
+}
+
+// putLevelTiles cuts one level of the pyramid into tiles and stores them
+func putLevelTiles(store storage.Storage, key string, number int, level *image.RGBA) error {
+	w, h := level.Bounds().Dx(), level.Bounds().Dy()
+	for row := 0; row*TileSize < h; row++ {
+		for column := 0; column*TileSize < w; column++ {
+			tile := image.Rect(column*TileSize-tileOverlap, row*TileSize-tileOverlap,
+				(column+1)*TileSize+tileOverlap, (row+1)*TileSize+tileOverlap).Intersect(level.Bounds())
+			data, err := EncodeJPEG(level.SubImage(tile))
+			if err != nil {
+				return err
+			}
+			if err := store.Put(TilePath(key, number, column, row), bytes.NewReader(data), "image/jpeg"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// halve shrinks an image to half its size, rounding up, averaging each two by two block of pixels
+func halve(src *image.RGBA) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, (w+1)/2, (h+1)/2))
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		for x := 0; x < dst.Bounds().Dx(); x++ {
+			var sum [4]int
+			count := 0
+			for dy := 0; dy < 2 && 2*y+dy < h; dy++ {
+				for dx := 0; dx < 2 && 2*x+dx < w; dx++ {
+					i := src.PixOffset(src.Bounds().Min.X+2*x+dx, src.Bounds().Min.Y+2*y+dy)
+					for c := range sum {
+						sum[c] += int(src.Pix[i+c])
+					}
+					count++
+				}
+			}
+			j := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[j+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// needsTiles reports whether the image under key is large enough for tiles but has none at least as new
+// as the image
+func needsTiles(store storage.Storage, key string) bool {
+	info, err := store.Stat(key)
+	if err != nil {
+		return false
+	}
+	if tilesInfo, err := store.Stat(TilesPath(key)); err == nil && !tilesInfo.ModTime.Before(info.ModTime) {
+		return false
+	}
+
+	config, err := decodeConfig(store, key)
+	return err == nil && max(config.Width, config.Height) > TiledMinSize
+}
+
+// decodeConfig reads the size of the image under key from its header, without decoding the whole image
+func decodeConfig(store storage.Storage, key string) (image.Config, error) {
+	body, _, err := store.Get(key)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer body.Close()
+	config, _, err := image.DecodeConfig(body)
+	if errors.Is(err, image.ErrFormat) {
+		return image.Config{}, ErrUnsupported
+	}
+	return config, err
+}
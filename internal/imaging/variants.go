@@ -0,0 +1,207 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/storage"
+)
+
+// Variant is a smaller copy of an uploaded image made for display, e.g. on gallery cards
+type Variant struct {
+	Name    string
+	MaxSize int // Longest side in pixels
+}
+
+// Variants are the derivatives made of every uploaded image, smallest first
+var Variants = []Variant{
+	{Name: "thumb", MaxSize: ThumbnailSize},
+	{Name: "medium", MaxSize: 1000},
+}
+
+// VariantPath returns where the named variant of an image is kept: next to the original, in a "derived"
+// directory. It works on keys, paths and URLs alike, so /static/images/stamps/abc.png has its thumbnail at
+// /static/images/stamps/derived/abc_thumb.jpg. ext is ".jpg" or ".webp".
+func VariantPath(original, name, ext string) string {
+	original = filepath.ToSlash(original)
+	base := strings.TrimSuffix(path.Base(original), path.Ext(original))
+	return path.Join(path.Dir(original), "derived", base+"_"+name+ext)
+}
+
+// variantURLTTL is how long VariantURL remembers a lookup. Pages show dozens of thumbnails, and asking
+// remote storage about each of them on every render would be slow. Uploads get new keys, so a variant
+// rarely changes once made.
+const variantURLTTL = time.Minute
+
+type cachedVariantURL struct {
+	url     string
+	expires time.Time
+}
+
+var variantURLs sync.Map // Derived file key -> cachedVariantURL
+
+// VariantURL returns the URL of a variant of the image served at imageURL, with the variant's modification
+// time as a version so it can be cached for good. It returns "" if the variant hasn't been made.
+func VariantURL(store storage.Storage, imageURL, name, ext string) string {
+	key, ok := storage.Key(imageURL)
+	if !ok {
+		return ""
+	}
+
+	return storedURL(store, VariantPath(key, name, ext))
+}
+
+// storedURL returns the URL of a derived file with its modification time as a version, or "" if it doesn't
+// exist, remembering the answer for variantURLTTL
+func storedURL(store storage.Storage, key string) string {
+	if cached, ok := variantURLs.Load(key); ok && time.Now().Before(cached.(cachedVariantURL).expires) {
+		return cached.(cachedVariantURL).url
+	}
+
+	url := ""
+	if info, err := store.Stat(key); err == nil {
+		url = fmt.Sprintf("%s?v=%d", storage.URL(key), info.ModTime.Unix())
+	}
+	variantURLs.Store(key, cachedVariantURL{url: url, expires: time.Now().Add(variantURLTTL)})
+	return url
+}
+
+// MakeVariants stores every variant of the image under key as a JPEG and, when cwebp is installed, a WebP.
+// Images smaller than a variant are copied at their own size, so every variant always exists.
+func MakeVariants(store storage.Storage, key string) error {
+	img, err := Load(store, key)
+	if err != nil {
+		return err
+	}
+
+	cwebp, _ := exec.LookPath("cwebp")
+	for _, variant := range Variants {
+		data, err := EncodeJPEG(Fit(img, variant.MaxSize))
+		if err != nil {
+			return err
+		}
+		jpegKey := VariantPath(key, variant.Name, ".jpg")
+		if err := store.Put(jpegKey, bytes.NewReader(data), "image/jpeg"); err != nil {
+			return err
+		}
+		forgetVariantURL(jpegKey)
+
+		if cwebp == "" {
+			continue
+		}
+		webp, err := encodeWebP(cwebp, data)
+		if err != nil {
+			return err
+		}
+		webpKey := VariantPath(key, variant.Name, ".webp")
+		if err := store.Put(webpKey, bytes.NewReader(webp), "image/webp"); err != nil {
+			return err
+		}
+		forgetVariantURL(webpKey)
+	}
+	return nil
+}
+
+// RemoveVariants deletes every variant of the image under key
+func RemoveVariants(store storage.Storage, key string) {
+	for _, variant := range Variants {
+		for _, ext := range []string{".jpg", ".webp"} {
+			variantKey := VariantPath(key, variant.Name, ext)
+			if err := store.Delete(variantKey); err != nil {
+				log.Printf("imaging.RemoveVariants: %v", err)
+			}
+			forgetVariantURL(variantKey)
+		}
+	}
+}
+
+// BackfillVariants makes the missing variants and deep-zoom tiles of every image in a directory of the
+// storage, such as "stamps", skipping backups and the derived directory itself. It returns how many images
+// it made variants or tiles for.
+func BackfillVariants(store storage.Storage, dir string) (int, error) {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+	keys, err := store.List(dir + "/")
+	if err != nil {
+		return 0, err
+	}
+
+	made := 0
+	for _, key := range keys {
+		if path.Dir(key) != dir || strings.HasSuffix(key, ".bak") {
+			continue
+		}
+
+		variants, tiles := !hasVariants(store, key), needsTiles(store, key)
+		if variants {
+			if err := MakeVariants(store, key); err != nil {
+				if errors.Is(err, ErrUnsupported) {
+					log.Printf("imaging.BackfillVariants: skipping %v: not a JPEG, PNG or GIF", key)
+					continue
+				}
+				if errors.Is(err, ErrTooLarge) {
+					log.Printf("imaging.BackfillVariants: skipping %v: %v", key, err)
+					continue
+				}
+				return made, fmt.Errorf("%v: %w", key, err)
+			}
+		}
+		if tiles {
+			if _, err := MakeTiles(store, key); err != nil {
+				if !errors.Is(err, ErrTooLarge) {
+					return made, fmt.Errorf("%v: %w", key, err)
+				}
+				log.Printf("imaging.BackfillVariants: not tiling %v: %v", key, err)
+			}
+		}
+		if variants || tiles {
+			made++
+		}
+	}
+	return made, nil
+}
+
+// hasVariants reports whether every JPEG variant of the image under key is at least as new as the image
+func hasVariants(store storage.Storage, key string) bool {
+	info, err := store.Stat(key)
+	if err != nil {
+		return false
+	}
+	for _, variant := range Variants {
+		variantInfo, err := store.Stat(VariantPath(key, variant.Name, ".jpg"))
+		if err != nil || variantInfo.ModTime.Before(info.ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeWebP converts a JPEG to WebP with cwebp, which only works on files
+func encodeWebP(cwebp string, jpegData []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "stampkeeper-webp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "variant.jpg"), filepath.Join(dir, "variant.webp")
+	if err := os.WriteFile(src, jpegData, 0644); err != nil {
+		return nil, err
+	}
+	if output, err := exec.Command(cwebp, "-quiet", "-q", "80", src, "-o", dst).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("imaging: cwebp failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(dst)
+}
+
+func forgetVariantURL(key string) {
+	variantURLs.Delete(key)
+}
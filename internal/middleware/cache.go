@@ -0,0 +1,23 @@
+package middleware
+
+import "net/http"
+
+// CacheVersioned marks static files requested with a version, e.g. /static/images/stamps/derived/abc_thumb.jpg?v=1700000000,
+// as cacheable for a year. The version changes whenever the file does, so browsers never see a stale copy.
+func CacheVersioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("v") != "" {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Download has files saved downloaded rather than opened in the browser, so an uploaded attachment can't run
+// as a page of the site
+func Download(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", "attachment")
+		next.ServeHTTP(w, r)
+	})
+}
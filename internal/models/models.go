@@ -4,35 +4,351 @@ import "time"
 
 // StampInstance represents a group of physical copies with the same condition in the same box.
 // For example: "3 Used copies in Box 1" would be one instance with Quantity=3.
+// Each copy may be a multiple such as a block of four or a souvenir sheet, described by Format.
 type StampInstance struct {
-	ID           string     `json:"id"`
-	StampID      string     `json:"stamp_id"`
-	Condition    *string    `json:"condition,omitempty"`
-	BoxID        *string    `json:"box_id,omitempty"`
-	BoxName      *string    `json:"box_name,omitempty"` // For joined queries
-	Quantity     int        `json:"quantity"`
-	DateAdded    time.Time  `json:"date_added"`
-	DateModified time.Time  `json:"date_modified"`
-	DateDeleted  *time.Time `json:"date_deleted,omitempty"` // For soft deletes
+	ID             string               `json:"id"`
+	StampID        string               `json:"stamp_id"`
+	Condition      *string              `json:"condition,omitempty"`
+	BoxID          *string              `json:"box_id,omitempty"`
+	BoxName        *string              `json:"box_name,omitempty"` // For joined queries
+	Quantity       int                  `json:"quantity"`
+	Format         string               `json:"format"` // "single", "block", "sheet", etc.
+	PlateNumber    *string              `json:"plate_number,omitempty"`
+	PlatePosition  *string              `json:"plate_position,omitempty"` // e.g. "UL" for an upper-left plate block
+	Gum            *string              `json:"gum,omitempty"`            // e.g. "MNH"
+	Centering      *string              `json:"centering,omitempty"`      // e.g. "VF"
+	Grade          *int                 `json:"grade,omitempty"`          // Numeric grade from 1 to 100
+	Faults         []string             `json:"faults,omitempty"`         // e.g. "thin", "crease"
+	CancelType     *string              `json:"cancel_type,omitempty"`
+	GradeNotes     *string              `json:"grade_notes,omitempty"`
+	CatalogueValue *float64             `json:"catalogue_value,omitempty"` // Value of one copy as listed, e.g. in Scott
+	EstimatedValue *float64             `json:"estimated_value,omitempty"` // Calculated from the catalogue value, grading and quantity
+	StampName      *string              `json:"stamp_name,omitempty"`      // For joined queries
+	Components     []InstanceComponent  `json:"components,omitempty"`      // Other designs contained in a multiple
+	Certificates   []Certificate        `json:"certificates,omitempty"`
+	Provenance     []ProvenanceEntry    `json:"provenance,omitempty"` // Previous owners, oldest first
+	Attachments    []Attachment         `json:"attachments,omitempty"`
+	Images         []StampImage         `json:"images,omitempty"`      // Photos of these particular copies
+	Measurement    *InstanceMeasurement `json:"measurement,omitempty"` // Centering measured from a scan
+	DateAdded      time.Time            `json:"date_added"`
+	DateModified   time.Time            `json:"date_modified"`
+	DateDeleted    *time.Time           `json:"date_deleted,omitempty"` // For soft deletes
+}
+
+// InstanceComponent is another stamp design contained in a multiple, e.g. one stamp of a souvenir sheet.
+// A design contained in an owned multiple counts as owned.
+type InstanceComponent struct {
+	StampID     string  `json:"stamp_id"`
+	ScottNumber *string `json:"scott_number,omitempty"` // Alternative to StampID when adding a component
+	Name        string  `json:"name,omitempty"`         // For joined queries
+	Quantity    int     `json:"quantity"`               // Number of stamps of this design in the multiple
+}
+
+// Certificate is an expert's certificate of opinion on a group of copies, e.g. from the PF or PSE.
+type Certificate struct {
+	ID                string    `json:"id"`
+	InstanceID        string    `json:"instance_id"`
+	StampID           string    `json:"stamp_id,omitempty"` // For joined queries
+	Issuer            string    `json:"issuer"`             // e.g. "Philatelic Foundation"
+	CertificateNumber *string   `json:"certificate_number,omitempty"`
+	IssuedDate        *string   `json:"issued_date,omitempty"` // "YYYY-MM-DD" where known
+	Opinion           *string   `json:"opinion,omitempty"`     // e.g. "Genuine, small thin"
+	FileURL           *string   `json:"file_url,omitempty"`    // Scan or PDF of the certificate
+	Notes             *string   `json:"notes,omitempty"`
+	DateAdded         time.Time `json:"date_added"`
+	DateModified      time.Time `json:"date_modified"`
+}
+
+// ProvenanceEntry is a link in the ownership chain of a group of copies: a previous collection, an
+// auction lot or both.
+type ProvenanceEntry struct {
+	ID           string    `json:"id"`
+	InstanceID   string    `json:"instance_id"`
+	StampID      string    `json:"stamp_id,omitempty"` // For joined queries
+	Owner        *string   `json:"owner,omitempty"`    // Previous owner or named collection
+	AuctionHouse *string   `json:"auction_house,omitempty"`
+	SaleName     *string   `json:"sale_name,omitempty"`
+	LotNumber    *string   `json:"lot_number,omitempty"`
+	AcquiredDate *string   `json:"acquired_date,omitempty"` // "YYYY-MM-DD" or just a year where known
+	Notes        *string   `json:"notes,omitempty"`
+	DateAdded    time.Time `json:"date_added"`
+	DateModified time.Time `json:"date_modified"`
+}
+
+// InstanceMeasurement is what was measured of a group of copies from a scan. Centering is given by the
+// margins between the design and the perforations, as fractions of the stamp's width or height, and the grade
+// they suggest; it is missing when the design couldn't be found. Size and perforation gauge are measured when
+// the scan's resolution is known.
+type InstanceMeasurement struct {
+	InstanceID         string    `json:"instance_id"`
+	ImageID            *string   `json:"image_id,omitempty"` // The scan measured; nil once it is deleted
+	MarginLeft         *float64  `json:"margin_left,omitempty"`
+	MarginRight        *float64  `json:"margin_right,omitempty"`
+	MarginTop          *float64  `json:"margin_top,omitempty"`
+	MarginBottom       *float64  `json:"margin_bottom,omitempty"`
+	HorizontalBalance  *float64  `json:"horizontal_balance,omitempty"` // Narrower margin over the wider, 1 when centred
+	VerticalBalance    *float64  `json:"vertical_balance,omitempty"`
+	SuggestedCentering *string   `json:"suggested_centering,omitempty"` // e.g. "VF"
+	DPI                *float64  `json:"dpi,omitempty"`                 // Resolution of the scan, from its metadata or as given
+	WidthMM            *float64  `json:"width_mm,omitempty"`
+	HeightMM           *float64  `json:"height_mm,omitempty"`
+	GaugeTop           *float64  `json:"gauge_top,omitempty"` // Teeth per 2 cm; 0 for a straight edge, nil if unreadable
+	GaugeBottom        *float64  `json:"gauge_bottom,omitempty"`
+	GaugeLeft          *float64  `json:"gauge_left,omitempty"`
+	GaugeRight         *float64  `json:"gauge_right,omitempty"`
+	Perforation        *string   `json:"perforation,omitempty"` // e.g. "11 x 10½"
+	DateMeasured       time.Time `json:"date_measured"`
+}
+
+// Attachment is a file kept with a stamp design or a group of copies, such as a receipt or an extra scan.
+// Exactly one of StampID and InstanceID is set.
+type Attachment struct {
+	ID           string    `json:"id"`
+	StampID      *string   `json:"stamp_id,omitempty"`
+	InstanceID   *string   `json:"instance_id,omitempty"`
+	Type         string    `json:"type"` // e.g. "receipt"
+	Caption      *string   `json:"caption,omitempty"`
+	Position     int       `json:"position"`
+	Filename     string    `json:"filename"` // Name of the file as uploaded
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	FileURL      string    `json:"file_url"`
+	ThumbnailURL *string   `json:"thumbnail_url,omitempty"` // Nil if no thumbnail could be made
+	DateAdded    time.Time `json:"date_added"`
+	DateModified time.Time `json:"date_modified"`
+}
+
+// StampImage is one picture in the gallery of a stamp design or of a group of copies, such as a scan of
+// the back or a photo under UV light. Exactly one of StampID and InstanceID is set.
+type StampImage struct {
+	ID           string            `json:"id"`
+	StampID      *string           `json:"stamp_id,omitempty"`
+	InstanceID   *string           `json:"instance_id,omitempty"`
+	Type         string            `json:"type"` // e.g. "back"
+	Caption      *string           `json:"caption,omitempty"`
+	Position     int               `json:"position"`
+	IsPrimary    bool              `json:"is_primary"` // The one image shown for the stamp or copies, e.g. on gallery cards
+	FileURL      string            `json:"file_url"`
+	Versions     []ImageVersion    `json:"versions,omitempty"`    // Newest first
+	Annotations  []ImageAnnotation `json:"annotations,omitempty"` // Marked on the version shown
+	Palette      []ImageColour     `json:"palette,omitempty"`     // Main colours, most of the image first
+	DateAdded    time.Time         `json:"date_added"`
+	DateModified time.Time         `json:"date_modified"`
+}
+
+// ImageColour is one of the main colours of an image
+type ImageColour struct {
+	Colour string  `json:"colour"` // As in HTML, e.g. "#b22234"
+	Share  float64 `json:"share"`  // Fraction of the image it covers
+}
+
+// StampMatch is a stamp with an image that looks like one being searched for
+type StampMatch struct {
+	StampID     string  `json:"stamp_id"`
+	Name        string  `json:"name"`
+	ScottNumber *string `json:"scott_number,omitempty"`
+	ImageID     string  `json:"image_id"` // The image that matched
+	ImageURL    string  `json:"image_url"`
+	Distance    int     `json:"distance"`   // Bits in which the images' perceptual hashes differ, out of 64
+	Similarity  float64 `json:"similarity"` // From 0 to 1
+}
+
+// DuplicateStamps is a pair of stamp records with matching images, likely the same design entered twice.
+// Both carry the distance between their images.
+type DuplicateStamps struct {
+	First  StampMatch `json:"first"`
+	Second StampMatch `json:"second"`
+}
+
+// ImageVersion is one upload of an image. Replacing an image keeps its earlier uploads as versions that
+// can be restored.
+type ImageVersion struct {
+	ID           string    `json:"id"`
+	ImageID      string    `json:"image_id"`
+	FileURL      string    `json:"file_url"`
+	UploadedBy   *string   `json:"uploaded_by,omitempty"`
+	DateUploaded time.Time `json:"date_uploaded"`
+	IsCurrent    bool      `json:"is_current"` // Whether the image shows this version
+}
+
+// ImageAnnotation marks a region of one version of an image, such as a plate crack, a thin spot or a re-entry.
+// The rectangle is given in fractions of the image's width and height, from its top left corner, so it fits
+// the image at any size.
+type ImageAnnotation struct {
+	ID           string    `json:"id"`
+	ImageID      string    `json:"image_id"`
+	VersionID    string    `json:"version_id"`
+	X            float64   `json:"x"`
+	Y            float64   `json:"y"`
+	Width        float64   `json:"width"`
+	Height       float64   `json:"height"`
+	Label        string    `json:"label"`
+	Note         *string   `json:"note,omitempty"`
+	DateAdded    time.Time `json:"date_added"`
+	DateModified time.Time `json:"date_modified"`
+}
+
+// ImageType is a kind of image, e.g. the back of a stamp, with its display label.
+type ImageType struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// AttachmentType is a kind of attachment, e.g. a receipt, with its display label.
+type AttachmentType struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// InstanceFormat is a way physical copies can be held, e.g. a single or a plate block, with its display label.
+type InstanceFormat struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
 }
 
 // Stamp represents the abstract design of a stamp.
 // It holds information common to all instances of that stamp design.
 type Stamp struct {
-	ID           string          `json:"id"`
-	Name         string          `json:"name"`
-	ScottNumber  *string         `json:"scott_number,omitempty"`
-	IssueDate    *string         `json:"issue_date,omitempty"`
-	Series       *string         `json:"series,omitempty"`
-	Notes        *string         `json:"notes,omitempty"` // Notes about the stamp design itself
-	ImageURL     *string         `json:"image_url,omitempty"`
-	IsOwned      bool            `json:"is_owned"` // Calculated: true if any instances exist
-	DateAdded    time.Time       `json:"date_added"`
-	DateModified time.Time       `json:"date_modified"`
-	DateDeleted  *time.Time      `json:"date_deleted,omitempty"` // For soft deletes
-	Tags         []string        `json:"tags,omitempty"`
-	Instances    []StampInstance `json:"instances,omitempty"` // Groups of physical copies
-	BoxNames     []string        `json:"box_names,omitempty"` // Comma-separated list of box names for display
+	ID                string          `json:"id"`
+	Name              string          `json:"name"`
+	ScottNumber       *string         `json:"scott_number,omitempty"`
+	IssueDate         *string         `json:"issue_date,omitempty"`
+	Series            *string         `json:"series,omitempty"`
+	SeriesID          *string         `json:"series_id,omitempty"`           // Set from Series on save
+	ParentID          *string         `json:"parent_id,omitempty"`           // Major design this stamp is a variety of
+	ParentName        *string         `json:"parent_name,omitempty"`         // For joined queries
+	ParentScottNumber *string         `json:"parent_scott_number,omitempty"` // For joined queries
+	VarietyType       *string         `json:"variety_type,omitempty"`        // e.g. "shade" or "error", set for varieties only
+	Notes             *string         `json:"notes,omitempty"`               // Notes about the stamp design itself
+	ImageURL          *string         `json:"image_url,omitempty"`
+	IsOwned           bool            `json:"is_owned"` // Calculated: true if any instances exist
+	DateAdded         time.Time       `json:"date_added"`
+	DateModified      time.Time       `json:"date_modified"`
+	DateDeleted       *time.Time      `json:"date_deleted,omitempty"` // For soft deletes
+	Tags              []string        `json:"tags,omitempty"`
+	Instances         []StampInstance `json:"instances,omitempty"`       // Groups of physical copies
+	EstimatedValue    *float64        `json:"estimated_value,omitempty"` // Sum over copies with a catalogue value, on the detail page
+	BoxNames          []string        `json:"box_names,omitempty"`       // Comma-separated list of box names for display
+	Varieties         []Stamp         `json:"varieties,omitempty"`       // Minor varieties of this design, on the detail page
+	VarietyCount      int             `json:"variety_count,omitempty"`   // Number of varieties, for collapsed views
+	ContainedIn       []StampInstance `json:"contained_in,omitempty"`    // Multiples of other designs that include this one
+	OnCover           bool            `json:"on_cover"`                  // Calculated: true if the stamp is affixed to any cover
+	Covers            []Cover         `json:"covers,omitempty"`          // Covers the stamp is affixed to, on the detail page
+	FirstDayCovers    []FirstDayCover `json:"first_day_covers,omitempty"`
+	Attachments       []Attachment    `json:"attachments,omitempty"` // Files kept with the design itself
+	Images            []StampImage    `json:"images,omitempty"`      // Gallery of the design; the primary one is ImageURL
+}
+
+// Cover is a piece of postal history: an envelope or card that went through the post with stamps affixed.
+// Stamps on a cover count as owned, in a distinct "on cover" state.
+type Cover struct {
+	ID            string       `json:"id"`
+	FrontImageURL *string      `json:"front_image_url,omitempty"`
+	BackImageURL  *string      `json:"back_image_url,omitempty"`
+	Sender        *string      `json:"sender,omitempty"`
+	Recipient     *string      `json:"recipient,omitempty"`
+	Origin        *string      `json:"origin,omitempty"`
+	Destination   *string      `json:"destination,omitempty"`
+	PostmarkDate  *string      `json:"postmark_date,omitempty"` // Free text, "YYYY-MM-DD" where known
+	PostmarkTown  *string      `json:"postmark_town,omitempty"`
+	Route         *string      `json:"route,omitempty"`     // e.g. "via Panama, by air"
+	RatePaid      *string      `json:"rate_paid,omitempty"` // e.g. "5c UPU letter rate"
+	Notes         *string      `json:"notes,omitempty"`
+	DateAdded     time.Time    `json:"date_added"`
+	DateModified  time.Time    `json:"date_modified"`
+	DateDeleted   *time.Time   `json:"date_deleted,omitempty"` // For soft deletes
+	StampCount    int          `json:"stamp_count"`            // Total number of stamps affixed
+	Stamps        []CoverStamp `json:"stamps,omitempty"`
+}
+
+// CoverStamp is a stamp design affixed to a cover, with how many of it are on the cover.
+type CoverStamp struct {
+	StampID     string  `json:"stamp_id"`
+	ScottNumber *string `json:"scott_number,omitempty"` // Alternative to StampID when adding a stamp
+	Name        string  `json:"name,omitempty"`         // For joined queries
+	ImageURL    *string `json:"image_url,omitempty"`    // For joined queries
+	Quantity    int     `json:"quantity"`
+}
+
+// FirstDayCover is a cover postmarked on the first day of issue of a stamp design, usually with a printed cachet.
+// FDCs are tracked apart from copies of the stamp, so a design isn't owned just because we have its FDC.
+type FirstDayCover struct {
+	ID           string    `json:"id"`
+	StampID      string    `json:"stamp_id"`
+	StampName    *string   `json:"stamp_name,omitempty"`   // For joined queries
+	ScottNumber  *string   `json:"scott_number,omitempty"` // For joined queries
+	City         *string   `json:"city,omitempty"`         // First-day city, e.g. "Washington, DC"
+	Date         *string   `json:"date,omitempty"`         // First-day date, "YYYY-MM-DD" where known
+	CachetMaker  *string   `json:"cachet_maker,omitempty"` // e.g. "Artcraft"
+	CachetType   *string   `json:"cachet_type,omitempty"`  // e.g. "engraved"
+	ImageURL     *string   `json:"image_url,omitempty"`
+	Notes        *string   `json:"notes,omitempty"`
+	DateAdded    time.Time `json:"date_added"`
+	DateModified time.Time `json:"date_modified"`
+}
+
+// Condition is an entry of the managed condition list that copies are recorded against.
+// Aliases are alternative spellings, e.g. "vf" for "Very Fine", that resolve to it.
+type Condition struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Position      int       `json:"position"`
+	IsDefault     bool      `json:"is_default"`
+	Aliases       []string  `json:"aliases"`
+	InstanceCount int       `json:"instance_count"` // Number of copy groups recorded in this condition
+	DateCreated   time.Time `json:"date_created"`
+	DateModified  time.Time `json:"date_modified"`
+}
+
+// ConditionValue is a condition value stored on copies that isn't exactly a name on the condition list,
+// along with the listed condition it normalizes to, if any.
+type ConditionValue struct {
+	Value     string  `json:"value"`
+	Count     int     `json:"count"`               // Number of copy groups with this value
+	Condition *string `json:"condition,omitempty"` // Nil if no condition name or alias matches
+}
+
+// ConditionNormalization reports the outcome, or with Updated zero the preview, of normalizing stored conditions.
+type ConditionNormalization struct {
+	Updated int              `json:"updated"` // Number of copy groups changed
+	Values  []ConditionValue `json:"values"`  // Values still needing attention
+}
+
+// GradeOption is one choice of a grading vocabulary, e.g. a gum state or centering grade, with its display label.
+type GradeOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// GradingOptions holds the vocabularies offered when grading a copy.
+type GradingOptions struct {
+	Gums       []GradeOption `json:"gums"`
+	Centerings []GradeOption `json:"centerings"`
+	Faults     []GradeOption `json:"faults"`
+	Cancels    []GradeOption `json:"cancels"`
+}
+
+// CachetType is a way a first-day cover's cachet was produced, with its display label.
+type CachetType struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// FDCReportRow is one stamp design in the first-day cover report: whether we own the stamp and how many FDCs we have.
+type FDCReportRow struct {
+	StampID     string  `json:"stamp_id"`
+	ScottNumber *string `json:"scott_number,omitempty"`
+	Name        string  `json:"name"`
+	IssueDate   *string `json:"issue_date,omitempty"`
+	IsOwned     bool    `json:"is_owned"`
+	FDCCount    int     `json:"fdc_count"`
+	Status      string  `json:"status"` // "both", "fdc_only" or "stamp_only"
+}
+
+// VarietyType is a kind of minor variety, e.g. a shade or an error, with its display label.
+type VarietyType struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
 }
 
 type StorageBox struct {
@@ -48,23 +364,98 @@ type Tag struct {
 	StampCount int    `json:"stamp_count,omitempty"` // Number of different stamp designs with this tag
 }
 
+// Series groups related stamp designs, e.g. "1922 Definitives".
+// Completion is measured against its expected members, plus any linked stamps not on that list.
+type Series struct {
+	ID           string         `json:"id"`
+	Name         string         `json:"name"`
+	Country      *string        `json:"country,omitempty"`
+	StartYear    *int           `json:"start_year,omitempty"`
+	EndYear      *int           `json:"end_year,omitempty"`
+	Description  *string        `json:"description,omitempty"`
+	DateCreated  time.Time      `json:"date_created"`
+	DateModified time.Time      `json:"date_modified"`
+	MemberCount  int            `json:"member_count"`
+	OwnedCount   int            `json:"owned_count"`
+	Completion   float64        `json:"completion"` // Percentage of members owned
+	Members      []SeriesMember `json:"members,omitempty"`
+}
+
+// SeriesMember is one stamp expected in (or linked to) a series.
+// Expected members are matched to stamp designs by Scott number.
+type SeriesMember struct {
+	ID          string  `json:"id,omitempty"` // Empty for linked stamps that aren't on the expected list
+	ScottNumber *string `json:"scott_number,omitempty"`
+	Name        string  `json:"name"`
+	Position    int     `json:"position"`
+	StampID     *string `json:"stamp_id,omitempty"` // Matching stamp design, if catalogued
+	IsOwned     bool    `json:"is_owned"`
+}
+
+// SmartCollection is a named, saved combination of filters, sort and view.
+// Its query is used as the scope for the gallery, list and JSON API when selected.
+type SmartCollection struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Query        string    `json:"query"` // URL-encoded filter parameters, e.g. "tag=USA&owned_status=false"
+	View         string    `json:"view"`  // "gallery" or "list"
+	DateCreated  time.Time `json:"date_created"`
+	DateModified time.Time `json:"date_modified"`
+	StampCount   int       `json:"stamp_count"` // Live count of stamps matching the collection
+}
+
+// Suggestion is a ranked autocomplete match for series, tags, stamp names or boxes.
+type Suggestion struct {
+	ID        string `json:"id,omitempty"` // Set for series, tags and boxes
+	Value     string `json:"value"`
+	Frequency int    `json:"frequency"` // How often the value is used across the collection
+}
+
 // Stats calculated from instances and stamps
 type Stats struct {
 	TotalOwned   int `json:"total_owned"`   // Sum of all instance quantities
 	UniqueStamps int `json:"unique_stamps"` // Count of distinct stamp designs
 	StampsNeeded int `json:"stamps_needed"` // Stamp designs with no instances
 	StorageBoxes int `json:"storage_boxes"` // Count of storage boxes
+
+	SeriesCount      int     `json:"series_count"`      // Count of series
+	SeriesComplete   int     `json:"series_complete"`   // Series with every member owned
+	SeriesCompletion float64 `json:"series_completion"` // Percentage of all series members owned
+
+	EstimatedValue float64 `json:"estimated_value"` // Sum of the estimated values of copies with a catalogue value
+}
+
+// Facet is a group of values the current result set can be narrowed by, e.g. tags or boxes.
+type Facet struct {
+	Name   string       `json:"name"`
+	Label  string       `json:"label"`
+	Param  string       `json:"param"` // Query parameter used to select values
+	Values []FacetValue `json:"values"`
+}
+
+// FacetValue is one selectable value within a facet along with the number of matching stamps.
+type FacetValue struct {
+	Value    string `json:"value"`
+	Label    string `json:"label"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
 }
 
 // --- View-specific Models ---
 
 // PaginatedStampsView holds data for the gallery/list view.
 type PaginatedStampsView struct {
-	Stamps      []Stamp
-	Pagination  Pagination
-	BaseURL     string
-	CurrentView string
-	FilteredBox *StorageBox // Box being filtered on, if any
+	Stamps            []Stamp
+	Pagination        Pagination
+	BaseURL           string
+	CurrentView       string
+	FilteredBox       *StorageBox      // Box being filtered on, if any
+	SearchError       string           // Explanation of an invalid search query, if any
+	Facets            []Facet          // Facet counts for the current result set, rendered out-of-band in the sidebar
+	Query             string           // Filter parameters of the current result set, for saving as a smart collection
+	CollapseVarieties bool             // Varieties are hidden under their parent designs
+	SortByColour      bool             // Stamps are sorted by the colour of their ink
+	Collection        *SmartCollection // Smart collection the results are scoped to, if any
 }
 
 // Pagination holds calculated pagination data.
@@ -80,14 +471,99 @@ type Pagination struct {
 
 // StampDetailView holds all data needed for the stamp detail page.
 type StampDetailView struct {
-	Stamp    Stamp
-	AllBoxes []StorageBox // For dropdowns when editing instances
+	Stamp           Stamp
+	AllBoxes        []StorageBox     // For dropdowns when editing instances
+	VarietyTypes    []VarietyType    // For the variety type dropdown
+	InstanceFormats []InstanceFormat // For the instance format dropdown
+	CachetTypes     []CachetType     // For the first-day cover cachet type dropdown
+	Grading         GradingOptions   // For the copy grading dropdowns
+	Conditions      []Condition      // For the condition dropdown
+	// Why a group of copies couldn't be measured, by instance ID
+	MeasurementErrors map[string]string
+}
+
+// CoverGalleryView holds the covers matching the current search filters, and the filters themselves.
+type CoverGalleryView struct {
+	Covers      []Cover
+	Search      string
+	Origin      string
+	Destination string
+	YearFrom    string
+	YearTo      string
+	ScottNumber string
+}
+
+// CoverDetailView holds a cover and any problem with the last edit to it.
+type CoverDetailView struct {
+	Cover Cover
+	Error string // e.g. an unknown Scott number in the stamps list
+}
+
+// AlbumPage is an uploaded scan of an album or stock page, split into one crop per stamp found on it. It
+// waits in storage until its crops have been assigned to stamps or it is discarded.
+type AlbumPage struct {
+	ID           string
+	ScanURL      string // The page as uploaded
+	PreviewURL   string // A smaller copy to show while assigning the crops
+	DateUploaded time.Time
+	Crops        []AlbumPageCrop
+}
+
+// AlbumPageCrop is one stamp cropped from an album page, with what to do with it
+type AlbumPageCrop struct {
+	Number      int
+	URL         string
+	Action      string // "skip", "existing" to add it to the stamp with ScottNumber, or "new" to create a stamp
+	ScottNumber string
+	Name        string // Of the stamp to create
+	ImageType   string
+}
+
+// AlbumPagesView holds the pages waiting for their crops to be assigned.
+type AlbumPagesView struct {
+	Pages  []AlbumPage
+	Error  string // Problem with the last upload
+	Notice string // Outcome of the last assignment
+}
+
+// AlbumPageView holds a split album page and any problem with the last attempt to assign its crops.
+type AlbumPageView struct {
+	Page  AlbumPage
+	Error string
+}
+
+// ImageSearchView holds the stamps that look like an uploaded photo, and the possible duplicate stamps.
+type ImageSearchView struct {
+	Matches    []StampMatch
+	Searched   bool // A photo was uploaded, so no matches means nothing looked like it
+	Duplicates []DuplicateStamps
+	Error      string
+}
+
+// FDCReportView holds the first-day cover report, narrowed to one status if Status is set.
+type FDCReportView struct {
+	Rows       []FDCReportRow `json:"rows"`
+	Status     string         `json:"status,omitempty"`
+	BothCount  int            `json:"both_count"`       // Designs with both the stamp and an FDC
+	FDCOnly    int            `json:"fdc_only_count"`   // Designs with an FDC but no copy of the stamp
+	StampOnly  int            `json:"stamp_only_count"` // Designs with the stamp but no FDC
+	TotalCount int            `json:"total_count"`
+}
+
+// CollectionListView holds data for the smart collections list in the sidebar.
+type CollectionListView struct {
+	Collections        []SmartCollection
+	ActiveCollectionID string
 }
 
 // SettingsView holds all data needed for the settings page.
 type SettingsView struct {
-	AllBoxes    []StorageBox
-	Preferences UserPreferences
+	AllBoxes        []StorageBox
+	Preferences     UserPreferences
+	Conditions      []Condition
+	ConditionValues []ConditionValue // Stored values that aren't on the condition list
+	ConditionError  string           // Problem with the last change to the condition list
+	ConditionNotice string           // Outcome of the last normalization
 }
 
 // UserPreferences represents user-specific application preferences.
@@ -5,10 +5,16 @@ import (
 	"html/template"
 	"net/http"
 	"encoding/json"
+	"fmt"
+	"math"
 	
 	"github.com/gorilla/mux"
 	"github.com/jeepinbird/stampkeeper/internal/handlers"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
 	"github.com/jeepinbird/stampkeeper/internal/middleware"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/jeepinbird/stampkeeper/internal/services"
+	"github.com/jeepinbird/stampkeeper/internal/storage"
 )
 
 func substr(s string, start, length int) string {
@@ -49,6 +55,57 @@ func Setup(db *sql.DB) *mux.Router {
 		"add": func(a, b int) int {
 			return a + b
 		},
+		"varietyLabel": func(s *string) string {
+			if s == nil {
+				return ""
+			}
+			return services.VarietyTypeLabel(*s)
+		},
+		"formatLabel":         services.InstanceFormatLabel,
+		"gradeLabel":          services.GradeLabel,
+		"formatValue":         services.FormatValue,
+		"hasCondition":        services.HasCondition,
+		"attachmentTypes":     func() []models.AttachmentType { return services.AttachmentTypes },
+		"attachmentTypeLabel": services.AttachmentTypeLabel,
+		"imageTypes":          func() []models.ImageType { return services.ImageTypes },
+		"imageTypeLabel":      services.ImageTypeLabel,
+		"imageVersionsKeep":   func() int { return services.ImageVersionsKeep },
+		"percent": func(fraction float64) string {
+			return fmt.Sprintf("%.0f%%", fraction*100)
+		},
+		"round": func(value float64) int {
+			return int(math.Round(value))
+		},
+		"millimetres": func(length float64) string {
+			return fmt.Sprintf("%.1f mm", length)
+		},
+		// The gauge of one edge, e.g. "10½"; nil is an edge that couldn't be read
+		"gauge": func(gauge *float64) string {
+			switch {
+			case gauge == nil:
+				return "?"
+			case *gauge == imaging.StraightEdge:
+				return "imperf"
+			}
+			return imaging.FormatGauge(*gauge)
+		},
+		// Smaller copies of an uploaded image, falling back to the image itself until they are made
+		"imageVariant": func(imageURL, name string) string {
+			if variant := imaging.VariantURL(storage.Files, imageURL, name, ".jpg"); variant != "" {
+				return variant
+			}
+			return imageURL
+		},
+		"imageVariantWebP": func(imageURL, name string) string {
+			return imaging.VariantURL(storage.Files, imageURL, name, ".webp")
+		},
+		// The deep-zoom tiles of a large image, or "" if it has none yet
+		"imageTiles": func(imageID, imageURL string) string {
+			if version := imaging.TilesVersion(storage.Files, imageURL); version != "" {
+				return "/tiles/" + imageID + ".dzi?v=" + version
+			}
+			return ""
+		},
 	}
 	
 	templates = template.New("").Funcs(funcMap)
@@ -66,6 +123,19 @@ func Setup(db *sql.DB) *mux.Router {
 	viewHandler := handlers.NewViewHandler(db, templates, sessionMiddleware)
 	preferencesHandler := handlers.NewPreferencesHandler(db, templates, sessionMiddleware)
 	htmxHandler := handlers.NewHTMXHandler(db, templates)
+	collectionHandler := handlers.NewCollectionHandler(db, templates)
+	autocompleteHandler := handlers.NewAutocompleteHandler(db, templates)
+	seriesHandler := handlers.NewSeriesHandler(db, templates)
+	coverHandler := handlers.NewCoverHandler(db, templates)
+	fdcHandler := handlers.NewFirstDayCoverHandler(db, templates)
+	conditionHandler := handlers.NewConditionHandler(db, templates)
+	provenanceHandler := handlers.NewProvenanceHandler(db, templates)
+	annotationHandler := handlers.NewAnnotationHandler(db, templates)
+	attachmentHandler := handlers.NewAttachmentHandler(db, templates)
+	imageHandler := handlers.NewImageHandler(db, templates)
+	albumPageHandler := handlers.NewAlbumPageHandler(db, templates)
+	imageSearchHandler := handlers.NewImageSearchHandler(db, templates)
+	measurementHandler := handlers.NewMeasurementHandler(db, templates)
 	
 	// Create main router
 	r := mux.NewRouter()
@@ -76,6 +146,9 @@ func Setup(db *sql.DB) *mux.Router {
 	// Stamp design endpoints
 	api.HandleFunc("/stamps", stampHandler.GetStamps).Methods("GET")
 	api.HandleFunc("/stamps", stampHandler.CreateStamp).Methods("POST")
+	api.HandleFunc("/stamps/facets", stampHandler.GetFacets).Methods("GET")
+	api.HandleFunc("/stamps/find-by-image", imageSearchHandler.FindStampsByImage).Methods("POST")
+	api.HandleFunc("/stamps/duplicates", imageSearchHandler.GetDuplicateStamps).Methods("GET")
 	api.HandleFunc("/stamps/{id}", stampHandler.GetStamp).Methods("GET")
 	api.HandleFunc("/stamps/{id}", stampHandler.UpdateStamp).Methods("PUT")
 	api.HandleFunc("/stamps/{id}", stampHandler.DeleteStamp).Methods("DELETE")
@@ -87,6 +160,44 @@ func Setup(db *sql.DB) *mux.Router {
 	api.HandleFunc("/instances/{instance_id}", instanceHandler.UpdateStampInstance).Methods("PUT")
 	api.HandleFunc("/instances/{instance_id}", instanceHandler.DeleteStampInstance).Methods("DELETE")
 
+	// Certificate and provenance endpoints
+	api.HandleFunc("/instances/{instance_id}/certificates", provenanceHandler.CreateCertificate).Methods("POST")
+	api.HandleFunc("/certificates/{id}", provenanceHandler.UpdateCertificate).Methods("PUT")
+	api.HandleFunc("/certificates/{id}", provenanceHandler.DeleteCertificate).Methods("DELETE")
+	api.HandleFunc("/certificates/{id}/upload-file", provenanceHandler.UploadCertificateFile).Methods("POST")
+	api.HandleFunc("/instances/{instance_id}/provenance", provenanceHandler.CreateProvenanceEntry).Methods("POST")
+	api.HandleFunc("/provenance/{id}", provenanceHandler.UpdateProvenanceEntry).Methods("PUT")
+	api.HandleFunc("/provenance/{id}", provenanceHandler.DeleteProvenanceEntry).Methods("DELETE")
+
+	// Centering measurement endpoints
+	api.HandleFunc("/instances/{instance_id}/measure", measurementHandler.MeasureInstance).Methods("POST")
+
+	// Image gallery endpoints
+	api.HandleFunc("/stamps/{id}/images", imageHandler.GetStampImages).Methods("GET")
+	api.HandleFunc("/stamps/{id}/images", imageHandler.AddStampImage).Methods("POST")
+	api.HandleFunc("/instances/{instance_id}/images", imageHandler.GetInstanceImages).Methods("GET")
+	api.HandleFunc("/instances/{instance_id}/images", imageHandler.AddInstanceImage).Methods("POST")
+	api.HandleFunc("/images/order", imageHandler.ReorderImages).Methods("PUT")
+	api.HandleFunc("/images/{id}", imageHandler.UpdateImage).Methods("PUT")
+	api.HandleFunc("/images/{id}", imageHandler.DeleteImage).Methods("DELETE")
+	api.HandleFunc("/images/{id}/edit", imageHandler.EditImage).Methods("POST")
+	api.HandleFunc("/images/{id}/versions", imageHandler.GetImageVersions).Methods("GET")
+	api.HandleFunc("/images/{id}/versions", imageHandler.UploadImageVersion).Methods("POST")
+	api.HandleFunc("/images/{id}/versions/{version_id}/restore", imageHandler.RestoreImageVersion).Methods("POST")
+	api.HandleFunc("/images/{id}/annotations", annotationHandler.GetImageAnnotations).Methods("GET")
+	api.HandleFunc("/images/{id}/annotations", annotationHandler.CreateAnnotation).Methods("POST")
+	api.HandleFunc("/annotations/{id}", annotationHandler.UpdateAnnotation).Methods("PUT")
+	api.HandleFunc("/annotations/{id}", annotationHandler.DeleteAnnotation).Methods("DELETE")
+
+	// Attachment endpoints
+	api.HandleFunc("/stamps/{id}/attachments", attachmentHandler.GetStampAttachments).Methods("GET")
+	api.HandleFunc("/stamps/{id}/attachments", attachmentHandler.UploadStampAttachment).Methods("POST")
+	api.HandleFunc("/instances/{instance_id}/attachments", attachmentHandler.GetInstanceAttachments).Methods("GET")
+	api.HandleFunc("/instances/{instance_id}/attachments", attachmentHandler.UploadInstanceAttachment).Methods("POST")
+	api.HandleFunc("/attachments/order", attachmentHandler.ReorderAttachments).Methods("PUT")
+	api.HandleFunc("/attachments/{id}", attachmentHandler.UpdateAttachment).Methods("PUT")
+	api.HandleFunc("/attachments/{id}", attachmentHandler.DeleteAttachment).Methods("DELETE")
+
 	// Storage boxes endpoints
 	api.HandleFunc("/boxes", boxHandler.GetBoxes).Methods("GET")
 	api.HandleFunc("/boxes", boxHandler.CreateBox).Methods("POST")
@@ -100,6 +211,52 @@ func Setup(db *sql.DB) *mux.Router {
 	api.HandleFunc("/tags/{id}", tagHandler.UpdateTag).Methods("PUT")
 	api.HandleFunc("/tags/{id}", tagHandler.DeleteTag).Methods("DELETE")
 
+	// Smart collection endpoints
+	api.HandleFunc("/collections", collectionHandler.GetCollections).Methods("GET")
+	api.HandleFunc("/collections", collectionHandler.CreateCollection).Methods("POST")
+	api.HandleFunc("/collections/{id}", collectionHandler.GetCollection).Methods("GET")
+	api.HandleFunc("/collections/{id}", collectionHandler.UpdateCollection).Methods("PUT")
+	api.HandleFunc("/collections/{id}", collectionHandler.DeleteCollection).Methods("DELETE")
+
+	// Series endpoints
+	api.HandleFunc("/series", seriesHandler.GetSeries).Methods("GET")
+	api.HandleFunc("/series", seriesHandler.CreateSeries).Methods("POST")
+	api.HandleFunc("/series/{id}", seriesHandler.GetSeriesByID).Methods("GET")
+	api.HandleFunc("/series/{id}", seriesHandler.UpdateSeries).Methods("PUT")
+	api.HandleFunc("/series/{id}", seriesHandler.DeleteSeries).Methods("DELETE")
+	api.HandleFunc("/series/{id}/members", seriesHandler.SetSeriesMembers).Methods("PUT")
+
+	// Cover (postal history) endpoints
+	api.HandleFunc("/covers", coverHandler.GetCovers).Methods("GET")
+	api.HandleFunc("/covers", coverHandler.CreateCover).Methods("POST")
+	api.HandleFunc("/covers/{id}", coverHandler.GetCover).Methods("GET")
+	api.HandleFunc("/covers/{id}", coverHandler.UpdateCover).Methods("PUT")
+	api.HandleFunc("/covers/{id}", coverHandler.DeleteCover).Methods("DELETE")
+	api.HandleFunc("/covers/{id}/stamps", coverHandler.SetCoverStamps).Methods("PUT")
+	api.HandleFunc("/covers/{id}/upload-image/{side:front|back}", coverHandler.UploadCoverImage).Methods("POST")
+
+	// First day cover endpoints
+	api.HandleFunc("/fdcs", fdcHandler.GetFirstDayCovers).Methods("GET")
+	api.HandleFunc("/fdcs", fdcHandler.CreateFirstDayCover).Methods("POST")
+	api.HandleFunc("/fdcs/{id}", fdcHandler.GetFirstDayCover).Methods("GET")
+	api.HandleFunc("/fdcs/{id}", fdcHandler.UpdateFirstDayCover).Methods("PUT")
+	api.HandleFunc("/fdcs/{id}", fdcHandler.DeleteFirstDayCover).Methods("DELETE")
+	api.HandleFunc("/fdcs/{id}/upload-image", fdcHandler.UploadFirstDayCoverImage).Methods("POST")
+	api.HandleFunc("/reports/fdc", fdcHandler.GetReport).Methods("GET")
+
+	// Condition list endpoints
+	api.HandleFunc("/conditions", conditionHandler.GetConditions).Methods("GET")
+	api.HandleFunc("/conditions", conditionHandler.CreateCondition).Methods("POST")
+	api.HandleFunc("/conditions/order", conditionHandler.ReorderConditions).Methods("PUT")
+	api.HandleFunc("/conditions/normalize", conditionHandler.GetNormalization).Methods("GET")
+	api.HandleFunc("/conditions/normalize", conditionHandler.NormalizeConditions).Methods("POST")
+	api.HandleFunc("/conditions/{id}", conditionHandler.UpdateCondition).Methods("PUT")
+	api.HandleFunc("/conditions/{id}", conditionHandler.DeleteCondition).Methods("DELETE")
+	api.HandleFunc("/conditions/{id}/merge", conditionHandler.MergeCondition).Methods("POST")
+
+	// Autocomplete endpoint
+	api.HandleFunc("/autocomplete/{kind:series|tags|names|boxes}", autocompleteHandler.GetSuggestions).Methods("GET")
+
 	// Stats endpoint
 	api.HandleFunc("/stats", statsHandler.GetStats).Methods("GET")
 
@@ -112,6 +269,17 @@ func Setup(db *sql.DB) *mux.Router {
 	r.HandleFunc("/views/stamps/{view:gallery|list}/scroll", viewHandler.GetStampsScroll).Methods("GET")
 	r.HandleFunc("/views/stamps/detail/{id}", viewHandler.GetStampDetail).Methods("GET")
 	r.HandleFunc("/views/boxes-list", viewHandler.GetBoxesView).Methods("GET")
+	r.HandleFunc("/views/collections-list", viewHandler.GetCollectionsView).Methods("GET")
+	r.HandleFunc("/views/series-list", viewHandler.GetSeriesListView).Methods("GET")
+	r.HandleFunc("/views/series/{id}", viewHandler.GetSeriesDetail).Methods("GET")
+	r.HandleFunc("/views/covers", viewHandler.GetCoversView).Methods("GET")
+	r.HandleFunc("/views/covers/results", viewHandler.GetCoverResults).Methods("GET")
+	r.HandleFunc("/views/covers/{id}", viewHandler.GetCoverDetail).Methods("GET")
+	r.HandleFunc("/views/reports/fdc", viewHandler.GetFDCReport).Methods("GET")
+	r.HandleFunc("/views/album-pages", albumPageHandler.GetAlbumPagesView).Methods("GET")
+	r.HandleFunc("/views/album-pages/{id}", albumPageHandler.GetAlbumPage).Methods("GET")
+	r.HandleFunc("/views/find-by-image", imageSearchHandler.GetImageSearchView).Methods("GET")
+	r.HandleFunc("/views/find-by-image/duplicates", imageSearchHandler.GetDuplicatesView).Methods("GET")
 	r.HandleFunc("/views/stamps/{id}/new-instance-row", viewHandler.GetNewInstanceRow).Methods("GET")
 	r.HandleFunc("/views/stamps/new", viewHandler.GetNewStampForm).Methods("GET")
 	r.HandleFunc("/views/settings", viewHandler.GetSettingsView).Methods("GET")
@@ -124,11 +292,60 @@ func Setup(db *sql.DB) *mux.Router {
 	r.HandleFunc("/htmx/boxes", htmxHandler.CreateBox).Methods("POST")
 	r.HandleFunc("/htmx/boxes/{id}", htmxHandler.UpdateBoxName).Methods("PUT")
 	r.HandleFunc("/htmx/boxes/{id}", htmxHandler.DeleteBox).Methods("DELETE")
+	r.HandleFunc("/htmx/autocomplete/{kind:series|tags|names|boxes}", autocompleteHandler.GetSuggestionOptions).Methods("GET")
+	r.HandleFunc("/htmx/collections", htmxHandler.CreateCollection).Methods("POST")
+	r.HandleFunc("/htmx/collections/{id}", htmxHandler.DeleteCollection).Methods("DELETE")
+	r.HandleFunc("/htmx/series", htmxHandler.CreateSeries).Methods("POST")
+	r.HandleFunc("/htmx/series/{id}", htmxHandler.UpdateSeries).Methods("POST")
+	r.HandleFunc("/htmx/covers", htmxHandler.CreateCover).Methods("POST")
+	r.HandleFunc("/htmx/covers/{id}", htmxHandler.UpdateCover).Methods("POST")
+	r.HandleFunc("/htmx/covers/{id}", htmxHandler.DeleteCover).Methods("DELETE")
+	r.HandleFunc("/htmx/covers/{id}/image/{side:front|back}", htmxHandler.UploadCoverImage).Methods("POST")
+	r.HandleFunc("/htmx/stamps/{id}/fdcs", htmxHandler.CreateFirstDayCover).Methods("POST")
+	r.HandleFunc("/htmx/fdcs/{id}", htmxHandler.UpdateFirstDayCover).Methods("POST")
+	r.HandleFunc("/htmx/fdcs/{id}", htmxHandler.DeleteFirstDayCover).Methods("DELETE")
+	r.HandleFunc("/htmx/fdcs/{id}/image", htmxHandler.UploadFirstDayCoverImage).Methods("POST")
+	r.HandleFunc("/htmx/album-pages", albumPageHandler.SplitAlbumPage).Methods("POST")
+	r.HandleFunc("/htmx/album-pages/{id}", albumPageHandler.AssignAlbumPage).Methods("POST")
+	r.HandleFunc("/htmx/album-pages/{id}", albumPageHandler.DeleteAlbumPage).Methods("DELETE")
+	r.HandleFunc("/htmx/find-by-image", imageSearchHandler.SearchByImage).Methods("POST")
+	r.HandleFunc("/htmx/instances/{id}/certificates", htmxHandler.CreateCertificate).Methods("POST")
+	r.HandleFunc("/htmx/certificates/{id}", htmxHandler.UpdateCertificate).Methods("POST")
+	r.HandleFunc("/htmx/certificates/{id}", htmxHandler.DeleteCertificate).Methods("DELETE")
+	r.HandleFunc("/htmx/certificates/{id}/file", htmxHandler.UploadCertificateFile).Methods("POST")
+	r.HandleFunc("/htmx/instances/{id}/provenance", htmxHandler.CreateProvenanceEntry).Methods("POST")
+	r.HandleFunc("/htmx/provenance/{id}", htmxHandler.UpdateProvenanceEntry).Methods("POST")
+	r.HandleFunc("/htmx/provenance/{id}", htmxHandler.DeleteProvenanceEntry).Methods("DELETE")
+	r.HandleFunc("/htmx/instances/{instance_id}/measure", measurementHandler.MeasureInstanceFromPage).Methods("POST")
+	r.HandleFunc("/htmx/stamps/{id}/images", htmxHandler.GetImageSection).Methods("GET")
+	r.HandleFunc("/htmx/stamps/{id}/images", htmxHandler.UploadImage).Methods("POST")
+	r.HandleFunc("/htmx/images/{id}", htmxHandler.UpdateImage).Methods("POST")
+	r.HandleFunc("/htmx/images/{id}", htmxHandler.DeleteImage).Methods("DELETE")
+	r.HandleFunc("/htmx/images/{id}/primary", htmxHandler.SetPrimaryImage).Methods("POST")
+	r.HandleFunc("/htmx/images/{id}/move/{direction:up|down}", htmxHandler.MoveImage).Methods("POST")
+	r.HandleFunc("/htmx/images/{id}/versions", htmxHandler.UploadImageVersion).Methods("POST")
+	r.HandleFunc("/htmx/images/{id}/versions/{version_id}/restore", htmxHandler.RestoreImageVersion).Methods("POST")
+	r.HandleFunc("/htmx/stamps/{id}/attachments", htmxHandler.UploadAttachment).Methods("POST")
+	r.HandleFunc("/htmx/attachments/{id}", htmxHandler.UpdateAttachment).Methods("POST")
+	r.HandleFunc("/htmx/attachments/{id}", htmxHandler.DeleteAttachment).Methods("DELETE")
+	r.HandleFunc("/htmx/attachments/{id}/move/{direction:up|down}", htmxHandler.MoveAttachment).Methods("POST")
+	r.HandleFunc("/htmx/conditions", htmxHandler.CreateCondition).Methods("POST")
+	r.HandleFunc("/htmx/conditions/normalize", htmxHandler.NormalizeConditions).Methods("POST")
+	r.HandleFunc("/htmx/conditions/{id}", htmxHandler.UpdateCondition).Methods("POST")
+	r.HandleFunc("/htmx/conditions/{id}", htmxHandler.DeleteCondition).Methods("DELETE")
+	r.HandleFunc("/htmx/conditions/{id}/move/{direction:up|down}", htmxHandler.MoveCondition).Methods("POST")
+	r.HandleFunc("/htmx/conditions/{id}/merge", htmxHandler.MergeCondition).Methods("POST")
 
 	// --- Static File Server ---
-	// Serves CSS, JS, images, etc. from the 'static' directory
+	// Uploaded images are served from wherever they are stored; CSS, JS, etc. from the 'static' directory
+	// Deep-zoom tiles of large images are found by the image they belong to
+	r.Handle("/tiles/{id:[0-9a-f-]+}.dzi", middleware.CacheVersioned(http.HandlerFunc(imageHandler.GetImageTiles))).Methods("GET")
+	r.Handle("/tiles/{id:[0-9a-f-]+}_files/{level:[0-9]+}/{column:[0-9]+}_{row:[0-9]+}.jpg", middleware.CacheVersioned(http.HandlerFunc(imageHandler.GetImageTile))).Methods("GET")
+	files := http.StripPrefix(storage.URLPrefix, storage.Handler(storage.Files))
+	r.PathPrefix(storage.URLPrefix + "attachments/").Handler(middleware.Download(middleware.CacheVersioned(files)))
+	r.PathPrefix(storage.URLPrefix).Handler(middleware.CacheVersioned(files))
 	fs := http.FileServer(http.Dir("./static/"))
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
+	r.PathPrefix("/static/").Handler(middleware.CacheVersioned(http.StripPrefix("/static/", fs)))
 
 	// --- Main Application Route ---
 	// Serves the main index.html template with user preferences
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidAnnotation is wrapped by the errors ValidateAnnotation returns for an annotation without a label
+// or whose rectangle isn't on the image
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+// maxAnnotationLabel is the longest label an annotation can have, as stored
+const maxAnnotationLabel = 100
+
+// annotationColumns are the columns selected for an annotation a of version v, in the order scanAnnotation
+// reads them
+const annotationColumns = `a.id, v.image_id, a.version_id, a.x, a.y, a.width, a.height, a.label, a.note,
+	a.date_added, a.date_modified`
+
+// ValidateAnnotation requires an annotation to have a label and a rectangle that lies on the image
+func ValidateAnnotation(annotation *models.ImageAnnotation) error {
+	annotation.Label = strings.TrimSpace(annotation.Label)
+	switch {
+	case annotation.Label == "":
+		return fmt.Errorf("%w: an annotation needs a label", ErrInvalidAnnotation)
+	case len([]rune(annotation.Label)) > maxAnnotationLabel:
+		return fmt.Errorf("%w: labels can be at most %d characters", ErrInvalidAnnotation, maxAnnotationLabel)
+	case annotation.Width <= 0 || annotation.Height <= 0:
+		return fmt.Errorf("%w: the rectangle needs a width and a height", ErrInvalidAnnotation)
+	case annotation.X < 0 || annotation.Y < 0 || annotation.X+annotation.Width > 1.0001 ||
+		annotation.Y+annotation.Height > 1.0001:
+		return fmt.Errorf("%w: the rectangle must lie on the image, in fractions of its size", ErrInvalidAnnotation)
+	}
+	return nil
+}
+
+// AnnotationService keeps the regions marked on images
+type AnnotationService struct {
+	db *sql.DB
+}
+
+func NewAnnotationService(db *sql.DB) *AnnotationService {
+	return &AnnotationService{db: db}
+}
+
+// GetImageAnnotations returns the annotations on the version of an image it shows
+func (s *AnnotationService) GetImageAnnotations(imageID string) ([]models.ImageAnnotation, error) {
+	return getImageAnnotations(s.db, imageID)
+}
+
+func (s *AnnotationService) GetAnnotation(id string) (*models.ImageAnnotation, error) {
+	row := s.db.QueryRow(`SELECT `+annotationColumns+`
+		  FROM image_annotations a JOIN image_versions v ON v.id = a.version_id
+		 WHERE a.id = $1`, id)
+	return scanAnnotation(row)
+}
+
+// CreateAnnotation marks a region of the version of an image it shows. Annotations stay with that version,
+// as a rescan or an edit moves what they point at; they show again if it is restored.
+func (s *AnnotationService) CreateAnnotation(annotation *models.ImageAnnotation) (*models.ImageAnnotation, error) {
+	log.Printf("services.annotations.CreateAnnotation: Inserting Annotation: %+v", annotation)
+
+	err := s.db.QueryRow(`SELECT v.id
+		  FROM image_versions v JOIN stamp_images i ON i.id = v.image_id
+		 WHERE v.image_id = $1 AND v.file_url = i.file_url
+		ORDER BY v.date_uploaded DESC
+		LIMIT 1`, annotation.ImageID).Scan(&annotation.VersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO image_annotations
+		(id, version_id, x, y, width, height, label, note, date_added, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		annotation.ID, annotation.VersionID, annotation.X, annotation.Y, annotation.Width, annotation.Height,
+		annotation.Label, annotation.Note, annotation.DateAdded, annotation.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// UpdateAnnotation saves the rectangle, label and note of an annotation
+func (s *AnnotationService) UpdateAnnotation(annotation *models.ImageAnnotation) (*models.ImageAnnotation, error) {
+	annotation.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE image_annotations SET
+		x = $1, y = $2, width = $3, height = $4, label = $5, note = $6, date_modified = $7
+		WHERE id = $8`,
+		annotation.X, annotation.Y, annotation.Width, annotation.Height, annotation.Label, annotation.Note,
+		annotation.DateModified, annotation.ID)
+	if err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+func (s *AnnotationService) DeleteAnnotation(id string) error {
+	result, err := s.db.Exec("DELETE FROM image_annotations WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func getImageAnnotations(db *sql.DB, imageID string) ([]models.ImageAnnotation, error) {
+	rows, err := db.Query(`SELECT `+annotationColumns+`
+		  FROM image_annotations a
+		    JOIN image_versions v ON v.id = a.version_id
+		    JOIN stamp_images i ON i.id = v.image_id AND i.file_url = v.file_url
+		 WHERE v.image_id = $1
+		ORDER BY a.y, a.x`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []models.ImageAnnotation
+	for rows.Next() {
+		annotation, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, *annotation)
+	}
+	return annotations, rows.Err()
+}
+
+// scanAnnotation reads a row selected with annotationColumns
+func scanAnnotation(row interface{ Scan(...interface{}) error }) (*models.ImageAnnotation, error) {
+	var annotation models.ImageAnnotation
+	err := row.Scan(&annotation.ID, &annotation.ImageID, &annotation.VersionID, &annotation.X, &annotation.Y,
+		&annotation.Width, &annotation.Height, &annotation.Label, &annotation.Note, &annotation.DateAdded,
+		&annotation.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidAttachment is wrapped by the errors ValidateAttachment returns for an unknown attachment type
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// AttachmentTypes are the kinds of files that can be attached, in display order
+var AttachmentTypes = []models.AttachmentType{
+	{Value: "scan", Label: "Scan"},
+	{Value: "receipt", Label: "Receipt"},
+	{Value: "invoice", Label: "Invoice"},
+	{Value: "certificate", Label: "Certificate"},
+	{Value: "correspondence", Label: "Correspondence"},
+	{Value: "listing", Label: "Auction Listing"},
+	{Value: "other", Label: "Other"},
+}
+
+// AttachmentTypeLabel returns the display label of an attachment type, or the value itself if it is unknown
+func AttachmentTypeLabel(value string) string {
+	for _, t := range AttachmentTypes {
+		if t.Value == value {
+			return t.Label
+		}
+	}
+	return value
+}
+
+// ValidateAttachment defaults a blank attachment type to "other" and rejects unknown types
+func ValidateAttachment(attachment *models.Attachment) error {
+	if attachment.Type == "" {
+		attachment.Type = "other"
+	}
+	for _, t := range AttachmentTypes {
+		if t.Value == attachment.Type {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown attachment type %q", ErrInvalidAttachment, attachment.Type)
+}
+
+// attachmentColumns are the columns selected for an attachment, in the order scanAttachment reads them
+const attachmentColumns = `id, stamp_id, instance_id, attachment_type, caption, position, filename, content_type,
+	       size_bytes, file_url, thumbnail_url, date_added, date_modified`
+
+type AttachmentService struct {
+	db *sql.DB
+}
+
+func NewAttachmentService(db *sql.DB) *AttachmentService {
+	return &AttachmentService{db: db}
+}
+
+// GetStampAttachments returns the files attached to a stamp design, in display order
+func (s *AttachmentService) GetStampAttachments(stampID string) ([]models.Attachment, error) {
+	return getAttachments(s.db, "stamp_id", stampID)
+}
+
+// GetInstanceAttachments returns the files attached to a group of copies, in display order
+func (s *AttachmentService) GetInstanceAttachments(instanceID string) ([]models.Attachment, error) {
+	return getAttachments(s.db, "instance_id", instanceID)
+}
+
+func (s *AttachmentService) GetAttachment(id string) (*models.Attachment, error) {
+	row := s.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = $1`, id)
+	return scanAttachment(row)
+}
+
+// CreateAttachment adds an attachment after the others of its stamp or group of copies
+func (s *AttachmentService) CreateAttachment(attachment *models.Attachment) (*models.Attachment, error) {
+	log.Printf("services.attachments.CreateAttachment: Inserting Attachment: %+v", attachment)
+
+	err := s.db.QueryRow(`INSERT INTO attachments
+		(id, stamp_id, instance_id, attachment_type, caption, position, filename, content_type, size_bytes,
+		 file_url, thumbnail_url, date_added, date_modified)
+		VALUES ($1, $2, $3, $4, $5,
+		        (SELECT COALESCE(MAX(position), 0) + 1 FROM attachments
+		          WHERE stamp_id IS NOT DISTINCT FROM $2 AND instance_id IS NOT DISTINCT FROM $3),
+		        $6, $7, $8, $9, $10, $11, $12)
+		RETURNING position`,
+		attachment.ID, attachment.StampID, attachment.InstanceID, attachment.Type, attachment.Caption,
+		attachment.Filename, attachment.ContentType, attachment.Size, attachment.FileURL, attachment.ThumbnailURL,
+		attachment.DateAdded, attachment.DateModified).Scan(&attachment.Position)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentService) UpdateAttachment(attachment *models.Attachment) (*models.Attachment, error) {
+	attachment.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE attachments SET
+		attachment_type = $1, caption = $2, thumbnail_url = $3, date_modified = $4
+		WHERE id = $5`,
+		attachment.Type, attachment.Caption, attachment.ThumbnailURL, attachment.DateModified, attachment.ID)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentService) DeleteAttachment(id string) error {
+	result, err := s.db.Exec("DELETE FROM attachments WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReorderAttachments sets the display order of attachments to the order of the given IDs
+func (s *AttachmentService) ReorderAttachments(ids []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		_, err = tx.Exec(`UPDATE attachments SET position = $1 WHERE id = $2`, i+1, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MoveAttachment moves an attachment one place up (delta -1) or down (delta 1) among those of its
+// stamp or group of copies
+func (s *AttachmentService) MoveAttachment(id string, delta int) error {
+	attachment, err := s.GetAttachment(id)
+	if err != nil {
+		return err
+	}
+
+	var siblings []models.Attachment
+	if attachment.StampID != nil {
+		siblings, err = s.GetStampAttachments(*attachment.StampID)
+	} else {
+		siblings, err = s.GetInstanceAttachments(*attachment.InstanceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(siblings))
+	index := 0
+	for i, sibling := range siblings {
+		ids[i] = sibling.ID
+		if sibling.ID == id {
+			index = i
+		}
+	}
+
+	other := index + delta
+	if other < 0 || other >= len(ids) {
+		return nil
+	}
+	ids[index], ids[other] = ids[other], ids[index]
+	return s.ReorderAttachments(ids)
+}
+
+// GetAttachmentStampID returns the stamp an attachment belongs to, directly or through a group of copies
+func (s *AttachmentService) GetAttachmentStampID(attachment *models.Attachment) (string, error) {
+	if attachment.StampID != nil {
+		return *attachment.StampID, nil
+	}
+
+	var stampID string
+	err := s.db.QueryRow(`SELECT stamp_id FROM stamp_instances WHERE id = $1`, *attachment.InstanceID).Scan(&stampID)
+	return stampID, err
+}
+
+func getAttachments(db *sql.DB, ownerColumn, ownerID string) ([]models.Attachment, error) {
+	rows, err := db.Query(`SELECT `+attachmentColumns+`
+		  FROM attachments
+		 WHERE `+ownerColumn+` = $1
+		ORDER BY position, date_added`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// scanAttachment reads a row selected with attachmentColumns
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := row.Scan(&attachment.ID, &attachment.StampID, &attachment.InstanceID, &attachment.Type, &attachment.Caption,
+		&attachment.Position, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.FileURL,
+		&attachment.ThumbnailURL, &attachment.DateAdded, &attachment.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
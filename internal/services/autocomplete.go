@@ -0,0 +1,86 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// suggestionQueries select (id, value, frequency) for each kind of autocomplete.
+// Each query filters on $1 (a %term% pattern) and ranks by $2 (a term% pattern) so that
+// prefix matches come first, then the most frequently used values.
+var suggestionQueries = map[string]string{
+	"series": `
+		SELECT se.id, se.name AS value, COUNT(s.id) AS frequency
+		  FROM series se
+		    LEFT JOIN stamps s
+		       ON s.series_id = se.id
+		      AND s.date_deleted IS NULL
+		 WHERE LOWER(se.name) LIKE LOWER($1)
+		GROUP BY se.id, se.name`,
+	"tags": `
+		SELECT t.id, t.name AS value, COUNT(st.stamp_id) AS frequency
+		  FROM tags t
+		    LEFT JOIN stamp_tags st ON st.tag_id = t.id
+		 WHERE LOWER(t.name) LIKE LOWER($1)
+		GROUP BY t.id, t.name`,
+	"names": `
+		SELECT '' AS id, s.name AS value, COUNT(*) AS frequency
+		  FROM stamps s
+		 WHERE s.date_deleted IS NULL AND LOWER(s.name) LIKE LOWER($1)
+		GROUP BY s.name`,
+	"boxes": `
+		SELECT sb.id, sb.name AS value, COALESCE(SUM(si.quantity), 0) AS frequency
+		  FROM storage_boxes sb
+		    LEFT JOIN stamp_instances si
+		       ON si.box_id = sb.id
+		      AND si.date_deleted IS NULL
+		 WHERE LOWER(sb.name) LIKE LOWER($1)
+		GROUP BY sb.id, sb.name`,
+}
+
+type AutocompleteService struct {
+	db *sql.DB
+}
+
+func NewAutocompleteService(db *sql.DB) *AutocompleteService {
+	return &AutocompleteService{db: db}
+}
+
+// GetSuggestions returns up to limit values of the given kind matching term, ranked with prefix
+// matches first and then by how often each value is used across the collection
+func (s *AutocompleteService) GetSuggestions(kind, term string, limit int) ([]models.Suggestion, error) {
+	baseQuery, ok := suggestionQueries[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown autocomplete kind: %s", kind)
+	}
+
+	escaped := database.EscapeLike(strings.TrimSpace(term))
+
+	query := fmt.Sprintf(`
+		SELECT id, value, frequency
+		  FROM (%s) matches
+		ORDER BY CASE WHEN LOWER(value) LIKE LOWER($2) THEN 0 ELSE 1 END,
+		         frequency DESC,
+		         value
+		LIMIT $3`, baseQuery)
+
+	rows, err := s.db.Query(query, "%"+escaped+"%", escaped+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []models.Suggestion{}
+	for rows.Next() {
+		var suggestion models.Suggestion
+		if err := rows.Scan(&suggestion.ID, &suggestion.Value, &suggestion.Frequency); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// maxCollectionDepth limits how many smart collections can be scoped inside one another
+const maxCollectionDepth = 5
+
+// ErrInvalidCollection is wrapped by the errors ValidateCollectionQuery returns for a query that can't be saved
+var ErrInvalidCollection = errors.New("invalid smart collection")
+
+// ErrCollectionInUse is wrapped by the error DeleteCollection returns for a collection other collections are scoped to
+var ErrCollectionInUse = errors.New("smart collection in use")
+
+// collectionParams are the query parameters a smart collection remembers
+var collectionParams = []string{
+	"search", "owned", "owned_filter", "box_id", "jump_to", "collapse_varieties",
+	"tag", "box", "series", "condition", "decade", "owned_status", "colour",
+	"collection", "sort", "order",
+}
+
+type CollectionService struct {
+	db     *sql.DB
+	stamps *StampService
+}
+
+func NewCollectionService(db *sql.DB) *CollectionService {
+	return &CollectionService{db: db, stamps: NewStampService(db)}
+}
+
+// CollectionQueryFromValues keeps only the filter, sort and scope parameters worth saving in a smart collection
+func CollectionQueryFromValues(values url.Values) string {
+	saved := url.Values{}
+	for _, param := range collectionParams {
+		for _, v := range values[param] {
+			if v == "" || (param == "owned_filter" && v == "all") {
+				continue
+			}
+			saved.Add(param, v)
+		}
+	}
+	return saved.Encode()
+}
+
+// ValidateCollectionQuery checks that the query to save for the collection id, or "" for a new one, parses into
+// valid filters, and that the collections it is scoped to exist, aren't nested too deep and don't lead back to it
+func (s *CollectionService) ValidateCollectionQuery(id, query string) error {
+	seen := map[string]bool{id: true}
+	for depth := 1; ; depth++ {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCollection, err)
+		}
+		filters, err := NewStampFiltersFromValues(values, 1, 1)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCollection, err)
+		}
+
+		scope := filters.CollectionID
+		switch {
+		case scope == "":
+			return nil
+		case seen[scope]:
+			return fmt.Errorf("%w: a smart collection can't be scoped to itself, directly or through others", ErrInvalidCollection)
+		case depth >= maxCollectionDepth:
+			return fmt.Errorf("%w: smart collections can be nested at most %d levels deep", ErrInvalidCollection, maxCollectionDepth)
+		}
+		seen[scope] = true
+
+		err = s.db.QueryRow(`SELECT query FROM smart_collections WHERE id = $1`, scope).Scan(&query)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: unknown smart collection %q", ErrInvalidCollection, scope)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// GetCollections returns all smart collections with a live count of the stamps each one matches
+func (s *CollectionService) GetCollections() ([]models.SmartCollection, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, query, view, date_created, date_modified
+		  FROM smart_collections
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []models.SmartCollection
+	for rows.Next() {
+		var collection models.SmartCollection
+		err := rows.Scan(&collection.ID, &collection.Name, &collection.Query, &collection.View,
+			&collection.DateCreated, &collection.DateModified)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range collections {
+		count, err := s.countStamps(collections[i].ID)
+		if err != nil {
+			// A collection whose query no longer resolves still shows up, just without a count
+			log.Printf("services.collections.GetCollections: could not count %s: %v", collections[i].ID, err)
+			continue
+		}
+		collections[i].StampCount = count
+	}
+	return collections, nil
+}
+
+func (s *CollectionService) GetCollectionByID(id string) (*models.SmartCollection, error) {
+	var collection models.SmartCollection
+	err := s.db.QueryRow(`
+		SELECT id, name, query, view, date_created, date_modified
+		  FROM smart_collections
+		 WHERE id = $1`, id).
+		Scan(&collection.ID, &collection.Name, &collection.Query, &collection.View,
+			&collection.DateCreated, &collection.DateModified)
+	if err != nil {
+		return nil, err
+	}
+
+	collection.StampCount, err = s.countStamps(collection.ID)
+	if err != nil {
+		log.Printf("services.collections.GetCollectionByID: could not count %s: %v", id, err)
+	}
+	return &collection, nil
+}
+
+func (s *CollectionService) CreateCollection(collection *models.SmartCollection) (*models.SmartCollection, error) {
+	log.Printf("services.collections.CreateCollection: Inserting Collection: %+v", collection)
+
+	_, err := s.db.Exec(`INSERT INTO smart_collections (id, name, query, view, date_created, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		collection.ID, collection.Name, collection.Query, collection.View,
+		collection.DateCreated, collection.DateModified)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func (s *CollectionService) UpdateCollection(collection *models.SmartCollection) (*models.SmartCollection, error) {
+	collection.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE smart_collections SET name = $1, query = $2, view = $3, date_modified = $4 WHERE id = $5`,
+		collection.Name, collection.Query, collection.View, collection.DateModified, collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+// DeleteCollection deletes a smart collection, refusing while other collections are scoped to it
+func (s *CollectionService) DeleteCollection(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the collections so none can be scoped to this one between the check and the delete
+	rows, err := tx.Query(`SELECT name, query FROM smart_collections WHERE id <> $1 ORDER BY name FOR UPDATE`, id)
+	if err != nil {
+		return err
+	}
+	var dependents []string
+	for rows.Next() {
+		var name, query string
+		if err := rows.Scan(&name, &query); err != nil {
+			rows.Close()
+			return err
+		}
+		if values, err := url.ParseQuery(query); err == nil && values.Get("collection") == id {
+			dependents = append(dependents, fmt.Sprintf("%q", name))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("%w: it is the scope of %s", ErrCollectionInUse, strings.Join(dependents, ", "))
+	}
+
+	if _, err := tx.Exec("DELETE FROM smart_collections WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// countStamps counts the stamps matching a collection, including any collection it is scoped to
+func (s *CollectionService) countStamps(id string) (int, error) {
+	filters := StampFilters{CollectionID: id}
+	if err := s.stamps.resolveCollectionScope(&filters, 0); err != nil {
+		return 0, err
+	}
+
+	qb := database.NewQueryBuilder(`
+		SELECT COUNT(s.id)
+		FROM stamps s
+		WHERE s.date_deleted IS NULL`)
+	s.stamps.addStampFilters(qb, filters)
+
+	query, args := qb.GetQuery()
+	var count int
+	err := s.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,497 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/lib/pq"
+)
+
+// ErrInvalidCondition is wrapped by the errors returned for a change that would make the condition list ambiguous
+var ErrInvalidCondition = errors.New("invalid condition")
+
+// sameInstanceGroup matches a copy group f with a group t that differs from it only in condition
+const sameInstanceGroup = `f.stamp_id = t.stamp_id AND f.box_id IS NOT DISTINCT FROM t.box_id AND f.format = t.format
+	AND f.plate_number IS NOT DISTINCT FROM t.plate_number AND f.gum IS NOT DISTINCT FROM t.gum
+	AND f.centering IS NOT DISTINCT FROM t.centering AND f.grade IS NOT DISTINCT FROM t.grade
+	AND f.date_deleted IS NULL AND t.date_deleted IS NULL`
+
+type ConditionService struct {
+	db *sql.DB
+}
+
+func NewConditionService(db *sql.DB) *ConditionService {
+	return &ConditionService{db: db}
+}
+
+// GetConditions returns the condition list in display order, with each condition's aliases and usage
+func (s *ConditionService) GetConditions() ([]models.Condition, error) {
+	rows, err := s.db.Query(`
+		SELECT c.id, c.name, c.position, c.is_default, c.date_created, c.date_modified,
+		       ARRAY(SELECT ca.alias FROM condition_aliases ca WHERE ca.condition_id = c.id ORDER BY ca.alias),
+		       (SELECT COUNT(*) FROM stamp_instances si WHERE si.condition = c.name AND si.date_deleted IS NULL)
+		  FROM conditions c
+		ORDER BY c.position, c.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conditions := []models.Condition{}
+	for rows.Next() {
+		var condition models.Condition
+		err := rows.Scan(&condition.ID, &condition.Name, &condition.Position, &condition.IsDefault,
+			&condition.DateCreated, &condition.DateModified, pq.Array(&condition.Aliases), &condition.InstanceCount)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, rows.Err()
+}
+
+func (s *ConditionService) GetCondition(id string) (*models.Condition, error) {
+	var condition models.Condition
+	err := s.db.QueryRow(`
+		SELECT c.id, c.name, c.position, c.is_default, c.date_created, c.date_modified,
+		       ARRAY(SELECT ca.alias FROM condition_aliases ca WHERE ca.condition_id = c.id ORDER BY ca.alias),
+		       (SELECT COUNT(*) FROM stamp_instances si WHERE si.condition = c.name AND si.date_deleted IS NULL)
+		  FROM conditions c
+		 WHERE c.id = $1`, id).
+		Scan(&condition.ID, &condition.Name, &condition.Position, &condition.IsDefault,
+			&condition.DateCreated, &condition.DateModified, pq.Array(&condition.Aliases), &condition.InstanceCount)
+	if err != nil {
+		return nil, err
+	}
+	return &condition, nil
+}
+
+// CreateCondition adds a condition to the end of the list
+func (s *ConditionService) CreateCondition(name string) (*models.Condition, error) {
+	name = strings.TrimSpace(name)
+	if err := s.checkName(name, ""); err != nil {
+		return nil, err
+	}
+
+	condition := &models.Condition{
+		ID:           uuid.New().String(),
+		Name:         name,
+		Aliases:      []string{},
+		DateCreated:  time.Now(),
+		DateModified: time.Now(),
+	}
+	log.Printf("services.conditions.CreateCondition: Inserting Condition: %+v", condition)
+
+	err := s.db.QueryRow(`INSERT INTO conditions (id, name, position, date_created, date_modified)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position), 0) + 1 FROM conditions), $3, $4)
+		RETURNING position`,
+		condition.ID, condition.Name, condition.DateCreated, condition.DateModified).Scan(&condition.Position)
+	if err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+// RenameCondition renames a condition along with every copy recorded in it
+func (s *ConditionService) RenameCondition(id, name string) error {
+	name = strings.TrimSpace(name)
+	condition, err := s.GetCondition(id)
+	if err != nil {
+		return err
+	}
+	if name == condition.Name {
+		return nil
+	}
+	if err := s.checkName(name, id); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE conditions SET name = $1, date_modified = $2 WHERE id = $3`, name, time.Now(), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := moveInstanceCondition(tx, condition.Name, name); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetDefault makes a condition the one new copies are recorded in when none is chosen.
+// An empty id clears the default.
+func (s *ConditionService) SetDefault(id string) error {
+	if id != "" {
+		if _, err := s.GetCondition(id); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(`UPDATE conditions SET is_default = (id = $1)`, id)
+	return err
+}
+
+// SetAliases replaces the alternative spellings that resolve to a condition
+func (s *ConditionService) SetAliases(id string, aliases []string) error {
+	var cleaned []string
+	seen := make(map[string]bool)
+	for _, alias := range aliases {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		if alias == "" || seen[alias] {
+			continue
+		}
+		seen[alias] = true
+
+		var owner string
+		err := s.db.QueryRow(`SELECT name FROM conditions WHERE LOWER(name) = $1
+			UNION ALL
+			SELECT c.name FROM condition_aliases ca JOIN conditions c ON c.id = ca.condition_id
+			 WHERE ca.alias = $1 AND ca.condition_id <> $2
+			LIMIT 1`, alias, id).Scan(&owner)
+		if err == nil {
+			return fmt.Errorf("%w: %q already means %q", ErrInvalidCondition, alias, owner)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+		cleaned = append(cleaned, alias)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM condition_aliases WHERE condition_id = $1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, alias := range cleaned {
+		_, err = tx.Exec(`INSERT INTO condition_aliases (alias, condition_id) VALUES ($1, $2)`, alias, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReorderConditions sets the display order of the conditions to the order of the given IDs
+func (s *ConditionService) ReorderConditions(ids []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		_, err = tx.Exec(`UPDATE conditions SET position = $1 WHERE id = $2`, i+1, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MoveCondition moves a condition one place up (delta -1) or down (delta 1) the list
+func (s *ConditionService) MoveCondition(id string, delta int) error {
+	conditions, err := s.GetConditions()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(conditions))
+	index := -1
+	for i, condition := range conditions {
+		ids[i] = condition.ID
+		if condition.ID == id {
+			index = i
+		}
+	}
+	if index < 0 {
+		return sql.ErrNoRows
+	}
+
+	other := index + delta
+	if other < 0 || other >= len(ids) {
+		return nil
+	}
+	ids[index], ids[other] = ids[other], ids[index]
+	return s.ReorderConditions(ids)
+}
+
+// MergeCondition folds a condition into another: its copies, aliases and default flag move to the
+// target, and its name becomes an alias of the target
+func (s *ConditionService) MergeCondition(id, targetID string) error {
+	if id == targetID {
+		return fmt.Errorf("%w: a condition can't be merged into itself", ErrInvalidCondition)
+	}
+	source, err := s.GetCondition(id)
+	if err != nil {
+		return err
+	}
+	target, err := s.GetCondition(targetID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: the condition to merge into was not found", ErrInvalidCondition)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("services.conditions.MergeCondition: %q into %q", source.Name, target.Name)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := moveInstanceCondition(tx, source.Name, target.Name); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE condition_aliases SET condition_id = $1 WHERE condition_id = $2`, target.ID, source.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if source.IsDefault {
+		_, err = tx.Exec(`UPDATE conditions SET is_default = true WHERE id = $1`, target.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM conditions WHERE id = $1`, source.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO condition_aliases (alias, condition_id) VALUES ($1, $2) ON CONFLICT (alias) DO NOTHING`,
+		strings.ToLower(source.Name), target.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCondition removes a condition that no copies are recorded in
+func (s *ConditionService) DeleteCondition(id string) error {
+	condition, err := s.GetCondition(id)
+	if err != nil {
+		return err
+	}
+	if condition.InstanceCount > 0 {
+		return fmt.Errorf("%w: %q is in use; merge it into another condition instead", ErrInvalidCondition, condition.Name)
+	}
+
+	_, err = s.db.Exec("DELETE FROM conditions WHERE id = $1", id)
+	return err
+}
+
+// ResolveCondition returns the listed name for a condition given by name or alias, ignoring case.
+// A blank value resolves to nil; anything else that isn't on the list is an ErrInvalidInstance.
+func (s *ConditionService) ResolveCondition(value string) (*string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var name string
+	err := s.db.QueryRow(`SELECT name FROM conditions WHERE LOWER(name) = LOWER($1)
+		UNION ALL
+		SELECT c.name FROM condition_aliases ca JOIN conditions c ON c.id = ca.condition_id WHERE ca.alias = LOWER($1)
+		LIMIT 1`, value).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: unknown condition %q", ErrInvalidInstance, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &name, nil
+}
+
+// GetDefaultCondition returns the name of the default condition, or nil if none is set
+func (s *ConditionService) GetDefaultCondition() (*string, error) {
+	var name string
+	err := s.db.QueryRow(`SELECT name FROM conditions WHERE is_default LIMIT 1`).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &name, nil
+}
+
+// GetConditionValues lists the condition values stored on copies that aren't exactly a listed name,
+// most used first, with the listed condition each one resolves to
+func (s *ConditionService) GetConditionValues() ([]models.ConditionValue, error) {
+	rows, err := s.db.Query(`
+		SELECT si.condition, COUNT(*),
+		       (SELECT c.name FROM conditions c
+		          LEFT JOIN condition_aliases ca ON ca.condition_id = c.id AND ca.alias = LOWER(TRIM(si.condition))
+		         WHERE LOWER(c.name) = LOWER(TRIM(si.condition)) OR ca.alias IS NOT NULL
+		         LIMIT 1)
+		  FROM stamp_instances si
+		 WHERE si.condition IS NOT NULL AND si.date_deleted IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM conditions c WHERE c.name = si.condition)
+		GROUP BY si.condition
+		ORDER BY COUNT(*) DESC, si.condition`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []models.ConditionValue{}
+	for rows.Next() {
+		var value models.ConditionValue
+		if err := rows.Scan(&value.Value, &value.Count, &value.Condition); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// NormalizeConditions rewrites every stored condition value that resolves to a listed condition to its
+// listed name and clears blank values. Values that resolve to nothing are left alone and reported.
+func (s *ConditionService) NormalizeConditions() (*models.ConditionNormalization, error) {
+	values, err := s.GetConditionValues()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ConditionNormalization{Values: []models.ConditionValue{}}
+	for _, value := range values {
+		switch {
+		case strings.TrimSpace(value.Value) == "":
+			n, err := moveInstanceCondition(tx, value.Value, "")
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			result.Updated += n
+		case value.Condition != nil:
+			n, err := moveInstanceCondition(tx, value.Value, *value.Condition)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			result.Updated += n
+		default:
+			result.Values = append(result.Values, value)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("services.conditions.NormalizeConditions: updated %d copy groups, %d values unmatched",
+		result.Updated, len(result.Values))
+	return result, nil
+}
+
+// HasCondition reports whether name is on the condition list
+func HasCondition(conditions []models.Condition, name string) bool {
+	for _, condition := range conditions {
+		if condition.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkName rejects a blank condition name, or one that matches another condition's name or an alias
+// (ignoring case). exceptID is the condition being renamed, if any.
+func (s *ConditionService) checkName(name, exceptID string) error {
+	if name == "" {
+		return fmt.Errorf("%w: a condition needs a name", ErrInvalidCondition)
+	}
+
+	var owner string
+	err := s.db.QueryRow(`SELECT name FROM conditions WHERE LOWER(name) = LOWER($1) AND id <> $2
+		UNION ALL
+		SELECT c.name FROM condition_aliases ca JOIN conditions c ON c.id = ca.condition_id
+		 WHERE ca.alias = LOWER($1) AND ca.condition_id <> $2
+		LIMIT 1`, name, exceptID).Scan(&owner)
+	if err == nil {
+		return fmt.Errorf("%w: %q is already used by %q", ErrInvalidCondition, name, owner)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// moveInstanceCondition changes the copies recorded in condition from to condition to, and returns the
+// number of copy groups changed. A group that would then duplicate an existing group of the same stamp,
+// box, format and grading is folded into it, along with its certificates, provenance, attachments and images.
+// A blank to clears the condition, folding into the group without one.
+func moveInstanceCondition(tx *sql.Tx, from, to string) (int, error) {
+	_, err := tx.Exec(`UPDATE stamp_instances t
+		   SET quantity = t.quantity + (SELECT SUM(f.quantity) FROM stamp_instances f WHERE f.condition = $1 AND `+sameInstanceGroup+`),
+		       date_modified = NOW()
+		 WHERE t.condition IS NOT DISTINCT FROM NULLIF($2, '')
+		   AND EXISTS (SELECT 1 FROM stamp_instances f WHERE f.condition = $1 AND `+sameInstanceGroup+`)`, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range []string{"instance_certificates", "instance_provenance", "attachments", "stamp_images"} {
+		set := `instance_id = t.id`
+		if table == "stamp_images" {
+			// The kept group's primary image stays its primary image
+			set += `, is_primary = FALSE`
+		}
+		_, err = tx.Exec(`UPDATE `+table+` r
+			   SET `+set+`
+			  FROM stamp_instances f, stamp_instances t
+			 WHERE r.instance_id = f.id AND f.condition = $1 AND t.condition IS NOT DISTINCT FROM NULLIF($2, '') AND `+sameInstanceGroup, from, to)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	folded, err := tx.Exec(`DELETE FROM stamp_instances f
+		 USING stamp_instances t
+		 WHERE f.condition = $1 AND t.condition IS NOT DISTINCT FROM NULLIF($2, '') AND `+sameInstanceGroup, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	moved, err := tx.Exec(`UPDATE stamp_instances SET condition = NULLIF($1, ''), date_modified = NOW() WHERE condition = $2`, to, from)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := folded.RowsAffected()
+	m, _ := moved.RowsAffected()
+	return int(n + m), nil
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidCover is wrapped by the errors returned for a stamp on a cover that can't be found
+var ErrInvalidCover = errors.New("invalid cover")
+
+// coverColumns are the columns selected for a cover, in the order scanCover reads them
+const coverColumns = `c.id, c.front_image_url, c.back_image_url, c.sender, c.recipient, c.origin, c.destination,
+	       c.postmark_date, c.postmark_town, c.route, c.rate_paid, c.notes, c.date_added, c.date_modified,
+	       (SELECT COALESCE(SUM(cs.quantity), 0) FROM cover_stamps cs WHERE cs.cover_id = c.id) AS stamp_count`
+
+// CoverFilters holds the search filters for the covers gallery
+type CoverFilters struct {
+	Search      string // Matches the sender, recipient, places, route, notes and the stamps' names
+	Origin      string
+	Destination string
+	YearFrom    *int // Postmark year bounds, nil if open-ended
+	YearTo      *int
+	ScottNumber string // Covers carrying this stamp
+}
+
+// NewCoverFiltersFromValues creates CoverFilters from query parameters
+func NewCoverFiltersFromValues(values url.Values) CoverFilters {
+	filters := CoverFilters{
+		Search:      strings.TrimSpace(values.Get("search")),
+		Origin:      strings.TrimSpace(values.Get("origin")),
+		Destination: strings.TrimSpace(values.Get("destination")),
+		ScottNumber: strings.TrimSpace(values.Get("scott")),
+	}
+	if year, err := strconv.Atoi(values.Get("year_from")); err == nil {
+		filters.YearFrom = &year
+	}
+	if year, err := strconv.Atoi(values.Get("year_to")); err == nil {
+		filters.YearTo = &year
+	}
+	return filters
+}
+
+type CoverService struct {
+	db *sql.DB
+}
+
+func NewCoverService(db *sql.DB) *CoverService {
+	return &CoverService{db: db}
+}
+
+// GetCovers returns the covers matching the filters, most recently postmarked first
+func (s *CoverService) GetCovers(filters CoverFilters) ([]models.Cover, error) {
+	qb := database.NewQueryBuilder(`
+		SELECT ` + coverColumns + `
+		  FROM covers c
+		 WHERE c.date_deleted IS NULL`)
+
+	if filters.Search != "" {
+		qb.AddCondition(` AND (LOWER(CONCAT_WS(' ', c.sender, c.recipient, c.origin, c.destination, c.postmark_town, c.route, c.notes)) LIKE LOWER(?)
+			OR EXISTS (SELECT 1 FROM cover_stamps cs JOIN stamps s ON s.id = cs.stamp_id
+			            WHERE cs.cover_id = c.id AND LOWER(CONCAT_WS(' ', s.name, s.scott_number)) LIKE LOWER(?)))`,
+			"%"+database.EscapeLike(filters.Search)+"%", "%"+database.EscapeLike(filters.Search)+"%")
+	}
+	if filters.Origin != "" {
+		qb.AddColumnLikeFilter("c.origin", filters.Origin, false)
+	}
+	if filters.Destination != "" {
+		qb.AddColumnLikeFilter("c.destination", filters.Destination, false)
+	}
+	qb.AddYearBetweenFilter("c.postmark_date", filters.YearFrom, filters.YearTo)
+	if filters.ScottNumber != "" {
+		qb.AddCondition(` AND EXISTS (SELECT 1 FROM cover_stamps cs JOIN stamps s ON s.id = cs.stamp_id
+			WHERE cs.cover_id = c.id AND LOWER(s.scott_number) = LOWER(?))`, filters.ScottNumber)
+	}
+	qb.AddCondition(` ORDER BY c.postmark_date DESC NULLS LAST, c.date_added DESC`)
+
+	query, args := qb.GetQuery()
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covers := []models.Cover{}
+	for rows.Next() {
+		cover, err := scanCover(rows)
+		if err != nil {
+			return nil, err
+		}
+		covers = append(covers, *cover)
+	}
+	return covers, rows.Err()
+}
+
+// GetCoverByID returns a cover along with the stamps affixed to it
+func (s *CoverService) GetCoverByID(id string) (*models.Cover, error) {
+	row := s.db.QueryRow(`SELECT `+coverColumns+`
+		  FROM covers c
+		 WHERE c.id = $1 AND c.date_deleted IS NULL`, id)
+	cover, err := scanCover(row)
+	if err != nil {
+		return nil, err
+	}
+
+	cover.Stamps, err = s.getCoverStamps(id)
+	if err != nil {
+		return nil, err
+	}
+	return cover, nil
+}
+
+func (s *CoverService) CreateCover(cover *models.Cover) (*models.Cover, error) {
+	log.Printf("services.covers.CreateCover: Inserting Cover: %+v", cover)
+
+	_, err := s.db.Exec(`INSERT INTO covers
+		(id, front_image_url, back_image_url, sender, recipient, origin, destination,
+		 postmark_date, postmark_town, route, rate_paid, notes, date_added, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		cover.ID, cover.FrontImageURL, cover.BackImageURL, cover.Sender, cover.Recipient, cover.Origin, cover.Destination,
+		cover.PostmarkDate, cover.PostmarkTown, cover.Route, cover.RatePaid, cover.Notes, cover.DateAdded, cover.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return cover, nil
+}
+
+func (s *CoverService) UpdateCover(cover *models.Cover) (*models.Cover, error) {
+	cover.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE covers SET
+		front_image_url = $1, back_image_url = $2, sender = $3, recipient = $4, origin = $5, destination = $6,
+		postmark_date = $7, postmark_town = $8, route = $9, rate_paid = $10, notes = $11, date_modified = $12
+		WHERE id = $13`,
+		cover.FrontImageURL, cover.BackImageURL, cover.Sender, cover.Recipient, cover.Origin, cover.Destination,
+		cover.PostmarkDate, cover.PostmarkTown, cover.Route, cover.RatePaid, cover.Notes, cover.DateModified, cover.ID)
+	if err != nil {
+		return nil, err
+	}
+	return cover, nil
+}
+
+// DeleteCover soft-deletes a cover; its stamps no longer count as owned on it
+func (s *CoverService) DeleteCover(id string) error {
+	result, err := s.db.Exec("UPDATE covers SET date_deleted = $1 WHERE id = $2 AND date_deleted IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ResolveStamps looks up the stamp IDs of stamps given by Scott number and merges repeats of the same design
+func (s *CoverService) ResolveStamps(stamps []models.CoverStamp) ([]models.CoverStamp, error) {
+	var resolved []models.CoverStamp
+	index := make(map[string]int)
+
+	for _, stamp := range stamps {
+		if stamp.StampID == "" && stamp.ScottNumber != nil {
+			err := s.db.QueryRow(`SELECT id FROM stamps WHERE scott_number = $1 AND date_deleted IS NULL`,
+				*stamp.ScottNumber).Scan(&stamp.StampID)
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("%w: no stamp with Scott number %q", ErrInvalidCover, *stamp.ScottNumber)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if stamp.StampID == "" {
+			return nil, fmt.Errorf("%w: stamps need a stamp_id or scott_number", ErrInvalidCover)
+		}
+		if stamp.Quantity <= 0 {
+			stamp.Quantity = 1
+		}
+
+		if i, ok := index[stamp.StampID]; ok {
+			resolved[i].Quantity += stamp.Quantity
+			continue
+		}
+		index[stamp.StampID] = len(resolved)
+		resolved = append(resolved, stamp)
+	}
+	return resolved, nil
+}
+
+// SetCoverStamps replaces the stamps affixed to a cover
+func (s *CoverService) SetCoverStamps(coverID string, stamps []models.CoverStamp) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM cover_stamps WHERE cover_id = $1", coverID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, stamp := range stamps {
+		_, err = tx.Exec(`INSERT INTO cover_stamps (cover_id, stamp_id, quantity) VALUES ($1, $2, $3)`,
+			coverID, stamp.StampID, stamp.Quantity)
+		if err != nil {
+			tx.Rollback()
+			if strings.Contains(err.Error(), "violates foreign key constraint") {
+				return fmt.Errorf("%w: stamp %s not found", ErrInvalidCover, stamp.StampID)
+			}
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE covers SET date_modified = $1 WHERE id = $2", time.Now(), coverID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ParseCoverStamps reads a comma-separated list of Scott numbers, each with an optional
+// count such as "x2", e.g. "1045, 1046 x2"
+func ParseCoverStamps(text string) []models.CoverStamp {
+	var stamps []models.CoverStamp
+	for _, part := range strings.Split(text, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		quantity := 1
+		if last := strings.ToLower(fields[len(fields)-1]); len(fields) > 1 && strings.HasPrefix(last, "x") {
+			if n, err := strconv.Atoi(last[1:]); err == nil {
+				quantity = n
+				fields = fields[:len(fields)-1]
+			}
+		}
+
+		scottNumber := strings.Join(fields, " ")
+		stamps = append(stamps, models.CoverStamp{ScottNumber: &scottNumber, Quantity: quantity})
+	}
+	return stamps
+}
+
+func (s *CoverService) getCoverStamps(coverID string) ([]models.CoverStamp, error) {
+	rows, err := s.db.Query(`
+		SELECT cs.stamp_id, s.scott_number, s.name, s.image_url, cs.quantity
+		  FROM cover_stamps cs
+		    JOIN stamps s ON s.id = cs.stamp_id
+		 WHERE cs.cover_id = $1 AND s.date_deleted IS NULL
+		ORDER BY s.scott_number, s.name`, coverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stamps []models.CoverStamp
+	for rows.Next() {
+		var stamp models.CoverStamp
+		if err := rows.Scan(&stamp.StampID, &stamp.ScottNumber, &stamp.Name, &stamp.ImageURL, &stamp.Quantity); err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, stamp)
+	}
+	return stamps, rows.Err()
+}
+
+// getStampCovers returns the covers the given stamp is affixed to
+func getStampCovers(db *sql.DB, stampID string) ([]models.Cover, error) {
+	rows, err := db.Query(`SELECT `+coverColumns+`
+		  FROM covers c
+		    JOIN cover_stamps ocs ON ocs.cover_id = c.id
+		 WHERE ocs.stamp_id = $1 AND c.date_deleted IS NULL
+		ORDER BY c.postmark_date, c.date_added`, stampID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var covers []models.Cover
+	for rows.Next() {
+		cover, err := scanCover(rows)
+		if err != nil {
+			return nil, err
+		}
+		covers = append(covers, *cover)
+	}
+	return covers, rows.Err()
+}
+
+// scanCover reads a row selected with coverColumns
+func scanCover(row interface{ Scan(...interface{}) error }) (*models.Cover, error) {
+	var cover models.Cover
+	err := row.Scan(&cover.ID, &cover.FrontImageURL, &cover.BackImageURL, &cover.Sender, &cover.Recipient,
+		&cover.Origin, &cover.Destination, &cover.PostmarkDate, &cover.PostmarkTown, &cover.Route, &cover.RatePaid,
+		&cover.Notes, &cover.DateAdded, &cover.DateModified, &cover.StampCount)
+	if err != nil {
+		return nil, err
+	}
+	return &cover, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// facetDefinition describes how to group the filtered stamps into the values of one facet
+type facetDefinition struct {
+	name      string
+	label     string
+	param     string
+	joins     string // Extra joins appended after "FROM stamps s"
+	valueExpr string // SQL expression for the value sent back in the query parameter
+	labelExpr string // SQL expression for the value shown to the user
+	where     string // Extra conditions, e.g. to skip NULL values
+	orderBy   string
+	selected  func(f StampFilters) []string
+	clear     func(f *StampFilters)
+	// Selected values no stamp has, such as a colour picked by hand, are listed anyway so they can be cleared
+	keepSelected bool
+}
+
+var facetDefinitions = []facetDefinition{
+	{
+		name:      "owned",
+		label:     "Owned Status",
+		param:     "owned_status",
+		valueExpr: `CASE WHEN ` + database.OwnedExpr("s.id") + ` THEN 'true' ELSE 'false' END`,
+		labelExpr: `CASE WHEN ` + database.OwnedExpr("s.id") + ` THEN 'Owned' ELSE 'Needed' END`,
+		orderBy:   `1 DESC`,
+		selected:  func(f StampFilters) []string { return f.OwnedStatus },
+		clear:     func(f *StampFilters) { f.OwnedStatus = nil },
+	},
+	{
+		name:      "tags",
+		label:     "Tags",
+		param:     "tag",
+		joins:     `JOIN stamp_tags fst ON fst.stamp_id = s.id JOIN tags ft ON ft.id = fst.tag_id`,
+		valueExpr: `ft.name`,
+		labelExpr: `ft.name`,
+		orderBy:   `3 DESC, 2`,
+		selected:  func(f StampFilters) []string { return f.Tags },
+		clear:     func(f *StampFilters) { f.Tags = nil },
+	},
+	{
+		name:  "boxes",
+		label: "Boxes",
+		param: "box",
+		joins: `JOIN stamp_instances fsi ON fsi.stamp_id = s.id AND fsi.date_deleted IS NULL
+		        JOIN storage_boxes fsb ON fsb.id = fsi.box_id`,
+		valueExpr: `fsb.id`,
+		labelExpr: `fsb.name`,
+		orderBy:   `2`,
+		selected:  func(f StampFilters) []string { return f.Boxes },
+		clear:     func(f *StampFilters) { f.Boxes = nil },
+	},
+	{
+		name:      "series",
+		label:     "Series",
+		param:     "series",
+		valueExpr: `s.series`,
+		labelExpr: `s.series`,
+		where:     ` AND s.series IS NOT NULL AND s.series <> ''`,
+		orderBy:   `3 DESC, 2`,
+		selected:  func(f StampFilters) []string { return f.Series },
+		clear:     func(f *StampFilters) { f.Series = nil },
+	},
+	{
+		name:      "condition",
+		label:     "Condition",
+		param:     "condition",
+		joins:     `JOIN stamp_instances fsi ON fsi.stamp_id = s.id AND fsi.date_deleted IS NULL`,
+		valueExpr: `fsi.condition`,
+		labelExpr: `fsi.condition`,
+		where:     ` AND fsi.condition IS NOT NULL AND fsi.condition <> ''`,
+		orderBy:   `3 DESC, 2`,
+		selected:  func(f StampFilters) []string { return f.Conditions },
+		clear:     func(f *StampFilters) { f.Conditions = nil },
+	},
+	{
+		name:      "decade",
+		label:     "Issue Decade",
+		param:     "decade",
+		valueExpr: `CAST((` + database.IssueYearExpr("s") + `) / 10 * 10 AS VARCHAR)`,
+		labelExpr: `CAST((` + database.IssueYearExpr("s") + `) / 10 * 10 AS VARCHAR) || 's'`,
+		where:     ` AND (` + database.IssueYearExpr("s") + `) IS NOT NULL`,
+		orderBy:   `1`,
+		selected: func(f StampFilters) []string {
+			var decades []string
+			for _, d := range f.Decades {
+				decades = append(decades, strconv.Itoa(d))
+			}
+			return decades
+		},
+		clear: func(f *StampFilters) { f.Decades = nil },
+	},
+	{
+		name:         "colour",
+		label:        "Colour",
+		param:        "colour",
+		joins:        colourFacetJoins(),
+		valueExpr:    `fc.value`,
+		labelExpr:    `fc.label`,
+		orderBy:      `MIN(fc.position)`,
+		selected:     func(f StampFilters) []string { return f.Colours },
+		clear:        func(f *StampFilters) { f.Colours = nil },
+		keepSelected: true,
+	},
+}
+
+// GetFacets returns the facet values and counts for the stamps matching the request's filters.
+// Each facet is counted with its own selection removed so that several values can be selected at once.
+func (s *StampService) GetFacets(r *http.Request) ([]models.Facet, error) {
+	filters, err := s.filtersFromRequest(r, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	facets := make([]models.Facet, 0, len(facetDefinitions))
+	for _, def := range facetDefinitions {
+		facet, err := s.getFacet(def, filters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s facet: %v", def.name, err)
+		}
+		facets = append(facets, *facet)
+	}
+	return facets, nil
+}
+
+func (s *StampService) getFacet(def facetDefinition, filters StampFilters) (*models.Facet, error) {
+	chosen := def.selected(filters)
+	selected := make(map[string]bool)
+	for _, v := range chosen {
+		selected[v] = true
+	}
+
+	// Count against every other facet's selection, but not this one's
+	def.clear(&filters)
+
+	qb := database.NewQueryBuilder(fmt.Sprintf(`
+		SELECT %s AS value, %s AS label, COUNT(DISTINCT s.id)
+		  FROM stamps s %s
+		 WHERE s.date_deleted IS NULL%s`, def.valueExpr, def.labelExpr, def.joins, def.where))
+	s.addStampFilters(qb, filters)
+	qb.AddCondition(fmt.Sprintf(` GROUP BY 1, 2 ORDER BY %s`, def.orderBy))
+
+	query, args := qb.GetQuery()
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facet := &models.Facet{
+		Name:   def.name,
+		Label:  def.label,
+		Param:  def.param,
+		Values: []models.FacetValue{},
+	}
+	for rows.Next() {
+		var value models.FacetValue
+		if err := rows.Scan(&value.Value, &value.Label, &value.Count); err != nil {
+			return nil, err
+		}
+		value.Selected = selected[value.Value]
+		facet.Values = append(facet.Values, value)
+		delete(selected, value.Value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if def.keepSelected {
+		for _, v := range chosen {
+			if selected[v] {
+				facet.Values = append(facet.Values, models.FacetValue{Value: v, Label: v, Selected: true})
+			}
+		}
+	}
+	return facet, nil
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidFDC is wrapped by the errors ValidateFirstDayCover returns for an unknown cachet type
+var ErrInvalidFDC = errors.New("invalid first day cover")
+
+// CachetTypes are the ways a first-day cover's cachet can be produced, in display order
+var CachetTypes = []models.CachetType{
+	{Value: "printed", Label: "Printed"},
+	{Value: "engraved", Label: "Engraved"},
+	{Value: "thermographed", Label: "Thermographed"},
+	{Value: "hand_painted", Label: "Hand-painted"},
+	{Value: "silk", Label: "Silk"},
+	{Value: "rubber_stamp", Label: "Rubber Stamp"},
+	{Value: "uncacheted", Label: "Uncacheted"},
+}
+
+// CachetTypeLabel returns the display label of a cachet type, or the value itself if it is unknown
+func CachetTypeLabel(value string) string {
+	for _, t := range CachetTypes {
+		if t.Value == value {
+			return t.Label
+		}
+	}
+	return value
+}
+
+// ValidateFirstDayCover rejects unknown cachet types
+func ValidateFirstDayCover(fdc *models.FirstDayCover) error {
+	if fdc.CachetType == nil {
+		return nil
+	}
+	for _, t := range CachetTypes {
+		if t.Value == *fdc.CachetType {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown cachet type %q", ErrInvalidFDC, *fdc.CachetType)
+}
+
+type FirstDayCoverService struct {
+	db *sql.DB
+}
+
+func NewFirstDayCoverService(db *sql.DB) *FirstDayCoverService {
+	return &FirstDayCoverService{db: db}
+}
+
+// GetFirstDayCovers returns the first-day covers of a stamp design, or of every design if stampID is empty
+func (s *FirstDayCoverService) GetFirstDayCovers(stampID string) ([]models.FirstDayCover, error) {
+	qb := database.NewQueryBuilder(`
+		SELECT f.id, f.stamp_id, s.name, s.scott_number, f.city, f.first_day_date, f.cachet_maker, f.cachet_type,
+		       f.image_url, f.notes, f.date_added, f.date_modified
+		  FROM first_day_covers f
+		    JOIN stamps s ON s.id = f.stamp_id AND s.date_deleted IS NULL
+		 WHERE 1 = 1`)
+	if stampID != "" {
+		qb.AddWhereCondition("f.stamp_id", "=", stampID)
+	}
+	qb.AddCondition(` ORDER BY f.first_day_date, f.date_added`)
+
+	query, args := qb.GetQuery()
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fdcs := []models.FirstDayCover{}
+	for rows.Next() {
+		var fdc models.FirstDayCover
+		err := rows.Scan(&fdc.ID, &fdc.StampID, &fdc.StampName, &fdc.ScottNumber, &fdc.City, &fdc.Date,
+			&fdc.CachetMaker, &fdc.CachetType, &fdc.ImageURL, &fdc.Notes, &fdc.DateAdded, &fdc.DateModified)
+		if err != nil {
+			return nil, err
+		}
+		fdcs = append(fdcs, fdc)
+	}
+	return fdcs, rows.Err()
+}
+
+func (s *FirstDayCoverService) GetFirstDayCover(id string) (*models.FirstDayCover, error) {
+	var fdc models.FirstDayCover
+	err := s.db.QueryRow(`
+		SELECT f.id, f.stamp_id, s.name, s.scott_number, f.city, f.first_day_date, f.cachet_maker, f.cachet_type,
+		       f.image_url, f.notes, f.date_added, f.date_modified
+		  FROM first_day_covers f
+		    JOIN stamps s ON s.id = f.stamp_id
+		 WHERE f.id = $1`, id).
+		Scan(&fdc.ID, &fdc.StampID, &fdc.StampName, &fdc.ScottNumber, &fdc.City, &fdc.Date,
+			&fdc.CachetMaker, &fdc.CachetType, &fdc.ImageURL, &fdc.Notes, &fdc.DateAdded, &fdc.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return &fdc, nil
+}
+
+func (s *FirstDayCoverService) CreateFirstDayCover(fdc *models.FirstDayCover) (*models.FirstDayCover, error) {
+	log.Printf("services.fdcs.CreateFirstDayCover: Inserting First Day Cover: %+v", fdc)
+
+	_, err := s.db.Exec(`INSERT INTO first_day_covers
+		(id, stamp_id, city, first_day_date, cachet_maker, cachet_type, image_url, notes, date_added, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		fdc.ID, fdc.StampID, fdc.City, fdc.Date, fdc.CachetMaker, fdc.CachetType, fdc.ImageURL, fdc.Notes,
+		fdc.DateAdded, fdc.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return fdc, nil
+}
+
+func (s *FirstDayCoverService) UpdateFirstDayCover(fdc *models.FirstDayCover) (*models.FirstDayCover, error) {
+	fdc.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE first_day_covers SET
+		city = $1, first_day_date = $2, cachet_maker = $3, cachet_type = $4, image_url = $5, notes = $6, date_modified = $7
+		WHERE id = $8`,
+		fdc.City, fdc.Date, fdc.CachetMaker, fdc.CachetType, fdc.ImageURL, fdc.Notes, fdc.DateModified, fdc.ID)
+	if err != nil {
+		return nil, err
+	}
+	return fdc, nil
+}
+
+func (s *FirstDayCoverService) DeleteFirstDayCover(id string) error {
+	result, err := s.db.Exec("DELETE FROM first_day_covers WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetReport lists every design we have the stamp or an FDC for, in Scott number order, along with the
+// number of designs in each status. If status is set, only the rows with that status are returned.
+func (s *FirstDayCoverService) GetReport(status string) (*models.FDCReportView, error) {
+	report := &models.FDCReportView{Rows: []models.FDCReportRow{}, Status: status}
+
+	rows, err := s.db.Query(`
+		SELECT stamp_id, scott_number, name, issue_date, is_owned, fdc_count
+		  FROM (SELECT s.id AS stamp_id, s.scott_number, s.name, s.issue_date,
+		               ` + database.OwnedExpr("s.id") + ` AS is_owned,
+		               (SELECT COUNT(*) FROM first_day_covers f WHERE f.stamp_id = s.id) AS fdc_count
+		          FROM stamps s
+		         WHERE s.date_deleted IS NULL) designs
+		 WHERE is_owned OR fdc_count > 0
+		ORDER BY CASE WHEN scott_number ~ '^\d+' THEN CAST(SUBSTRING(scott_number FROM '\d+') AS INTEGER) ELSE 999999 END,
+		         scott_number, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row models.FDCReportRow
+		if err := rows.Scan(&row.StampID, &row.ScottNumber, &row.Name, &row.IssueDate, &row.IsOwned, &row.FDCCount); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case row.IsOwned && row.FDCCount > 0:
+			row.Status = "both"
+			report.BothCount++
+		case row.FDCCount > 0:
+			row.Status = "fdc_only"
+			report.FDCOnly++
+		default:
+			row.Status = "stamp_only"
+			report.StampOnly++
+		}
+		report.TotalCount++
+
+		if status == "" || status == row.Status {
+			report.Rows = append(report.Rows, row)
+		}
+	}
+	return report, rows.Err()
+}
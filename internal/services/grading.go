@@ -0,0 +1,283 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// GumStates are the states of a mint copy's gum, best first
+var GumStates = []models.GradeOption{
+	{Value: "MNH", Label: "Mint Never Hinged"},
+	{Value: "MH", Label: "Mint Hinged"},
+	{Value: "HR", Label: "Hinge Remnant"},
+	{Value: "NG", Label: "No Gum"},
+}
+
+// CenteringGrades are the standard centering grades, best first
+var CenteringGrades = []models.GradeOption{
+	{Value: "S", Label: "Superb"},
+	{Value: "XF", Label: "Extremely Fine"},
+	{Value: "VF-XF", Label: "Very Fine-Extremely Fine"},
+	{Value: "VF", Label: "Very Fine"},
+	{Value: "F-VF", Label: "Fine-Very Fine"},
+	{Value: "F", Label: "Fine"},
+	{Value: "VG", Label: "Very Good"},
+	{Value: "G", Label: "Good"},
+}
+
+// centeringThresholds are the least balance, the narrower margin over the wider one on the worse of the two
+// axes, that earns each centering grade. They follow the usual rule of thumb rather than any one grading
+// service's standard, so a suggested grade is a starting point for the collector's own judgement.
+var centeringThresholds = []struct {
+	grade   string
+	balance float64
+}{
+	{"S", 0.9},
+	{"XF", 0.8},
+	{"VF-XF", 0.7},
+	{"VF", 0.6},
+	{"F-VF", 0.45},
+	{"F", 0.3},
+	{"VG", 0.15},
+}
+
+// SuggestCenteringGrade suggests the centering grade for a copy whose design sits with the given balance
+// across and down it, each from 1 for perfectly centred to 0 for touching the perforations
+func SuggestCenteringGrade(horizontal, vertical float64) string {
+	balance := min(horizontal, vertical)
+	for _, threshold := range centeringThresholds {
+		if balance >= threshold.balance {
+			return threshold.grade
+		}
+	}
+	return "G"
+}
+
+// FaultTypes are the faults a copy can have, in display order
+var FaultTypes = []models.GradeOption{
+	{Value: "thin", Label: "Thin"},
+	{Value: "crease", Label: "Crease"},
+	{Value: "short_perf", Label: "Short Perf"},
+	{Value: "pulled_perf", Label: "Pulled Perf"},
+	{Value: "tear", Label: "Tear"},
+	{Value: "pinhole", Label: "Pinhole"},
+	{Value: "stain", Label: "Stain"},
+	{Value: "toning", Label: "Toning"},
+	{Value: "repaired", Label: "Repaired"},
+}
+
+// CancelTypes are the kinds of cancellation on a used copy, in display order
+var CancelTypes = []models.GradeOption{
+	{Value: "cds", Label: "Circular Date Stamp"},
+	{Value: "machine", Label: "Machine"},
+	{Value: "duplex", Label: "Duplex"},
+	{Value: "fancy", Label: "Fancy"},
+	{Value: "manuscript", Label: "Manuscript"},
+	{Value: "precancel", Label: "Precancel"},
+	{Value: "cto", Label: "Cancelled to Order"},
+}
+
+// GetGradingOptions returns the vocabularies offered when grading a copy
+func GetGradingOptions() models.GradingOptions {
+	return models.GradingOptions{
+		Gums:       GumStates,
+		Centerings: CenteringGrades,
+		Faults:     FaultTypes,
+		Cancels:    CancelTypes,
+	}
+}
+
+// GradeLabel returns the display label of a value from one of the grading vocabularies,
+// or the value itself if it is unknown
+func GradeLabel(options []models.GradeOption, value string) string {
+	if option := findGradeOption(options, value); option != nil {
+		return option.Label
+	}
+	return value
+}
+
+// ValidateGrading checks a copy's grading against the vocabularies, matching values or labels
+// case-insensitively and storing the canonical value. Repeated faults are dropped.
+func ValidateGrading(instance *models.StampInstance) error {
+	vocabularies := []struct {
+		name    string
+		options []models.GradeOption
+		value   *string
+	}{
+		{"gum", GumStates, instance.Gum},
+		{"centering", CenteringGrades, instance.Centering},
+		{"cancel type", CancelTypes, instance.CancelType},
+	}
+	for _, v := range vocabularies {
+		if v.value == nil {
+			continue
+		}
+		option := findGradeOption(v.options, *v.value)
+		if option == nil {
+			return fmt.Errorf("%w: unknown %s %q", ErrInvalidInstance, v.name, *v.value)
+		}
+		*v.value = option.Value
+	}
+
+	if instance.Grade != nil && (*instance.Grade < 1 || *instance.Grade > 100) {
+		return fmt.Errorf("%w: grade must be between 1 and 100, got %d", ErrInvalidInstance, *instance.Grade)
+	}
+
+	if instance.CatalogueValue != nil && *instance.CatalogueValue < 0 {
+		return fmt.Errorf("%w: catalogue value can't be negative", ErrInvalidInstance)
+	}
+
+	var faults []string
+	seen := make(map[string]bool)
+	for _, fault := range instance.Faults {
+		option := findGradeOption(FaultTypes, fault)
+		if option == nil {
+			return fmt.Errorf("%w: unknown fault %q", ErrInvalidInstance, fault)
+		}
+		if !seen[option.Value] {
+			seen[option.Value] = true
+			faults = append(faults, option.Value)
+		}
+	}
+	instance.Faults = faults
+
+	return nil
+}
+
+// A catalogue value is taken to be that of a Very Fine copy without faults, never hinged if mint, as most
+// catalogues price stamps, and a copy's value is scaled from it by how its grading compares. The factors are
+// dealers' rules of thumb rather than market prices, so an estimate is a guide, not an appraisal.
+var (
+	centeringValueFactors = map[string]float64{
+		"S": 3, "XF": 2, "VF-XF": 1.5, "VF": 1, "F-VF": 0.75, "F": 0.5, "VG": 0.3, "G": 0.2,
+	}
+	gumValueFactors = map[string]float64{
+		"MNH": 1, "MH": 0.6, "HR": 0.45, "NG": 0.3,
+	}
+	// Only the worst of a copy's faults counts
+	faultValueFactors = map[string]float64{
+		"thin": 0.3, "crease": 0.4, "short_perf": 0.6, "pulled_perf": 0.5, "tear": 0.2,
+		"pinhole": 0.4, "stain": 0.5, "toning": 0.6, "repaired": 0.25,
+	}
+	cancelValueFactors = map[string]float64{
+		"fancy": 1.5, "manuscript": 0.5, "cto": 0.5,
+	}
+)
+
+// gradeCenterings are the least numeric grade that earns each centering grade on the 1 to 100 scale used by
+// grading services; lower grades are Good
+var gradeCenterings = []struct {
+	grade     int
+	centering string
+}{
+	{98, "S"},
+	{90, "XF"},
+	{85, "VF-XF"},
+	{80, "VF"},
+	{75, "F-VF"},
+	{70, "F"},
+	{50, "VG"},
+}
+
+// GradeCentering returns the centering grade a numeric grade corresponds to
+func GradeCentering(grade int) string {
+	for _, g := range gradeCenterings {
+		if grade >= g.grade {
+			return g.centering
+		}
+	}
+	return "G"
+}
+
+// EstimateValue estimates what a group of copies is worth from the catalogue value of one copy, its grading
+// and its quantity, or returns nil if it has no catalogue value. A numeric grade, being the finer measure,
+// is used before the centering grade.
+func EstimateValue(instance *models.StampInstance) *float64 {
+	if instance.CatalogueValue == nil {
+		return nil
+	}
+
+	centering := ""
+	if instance.Grade != nil {
+		centering = GradeCentering(*instance.Grade)
+	} else if instance.Centering != nil {
+		centering = *instance.Centering
+	}
+
+	factor := 1.0
+	if f, ok := centeringValueFactors[centering]; ok {
+		factor *= f
+	}
+	if instance.Gum != nil {
+		if f, ok := gumValueFactors[*instance.Gum]; ok {
+			factor *= f
+		}
+	}
+	worst := 1.0
+	for _, fault := range instance.Faults {
+		if f, ok := faultValueFactors[fault]; ok && f < worst {
+			worst = f
+		}
+	}
+	factor *= worst
+	if instance.CancelType != nil {
+		if f, ok := cancelValueFactors[*instance.CancelType]; ok {
+			factor *= f
+		}
+	}
+
+	value := math.Round(*instance.CatalogueValue*factor*float64(instance.Quantity)*100) / 100
+	return &value
+}
+
+// TotalEstimatedValue adds up the estimated values of the groups of copies that have one, or returns nil if
+// none do
+func TotalEstimatedValue(instances []models.StampInstance) *float64 {
+	var total *float64
+	for _, instance := range instances {
+		if instance.EstimatedValue == nil {
+			continue
+		}
+		if total == nil {
+			total = new(float64)
+		}
+		*total += *instance.EstimatedValue
+	}
+	if total != nil {
+		*total = math.Round(*total*100) / 100
+	}
+	return total
+}
+
+// FormatValue formats a catalogue or estimated value for display, or returns "" if there is none
+func FormatValue(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", *value)
+}
+
+// ParseFaults reads a comma-separated list of faults, e.g. "thin, short perf"
+func ParseFaults(text string) []string {
+	var faults []string
+	for _, part := range strings.Split(text, ",") {
+		if fault := strings.TrimSpace(part); fault != "" {
+			faults = append(faults, fault)
+		}
+	}
+	return faults
+}
+
+func findGradeOption(options []models.GradeOption, value string) *models.GradeOption {
+	value = strings.TrimSpace(value)
+	for i, option := range options {
+		if strings.EqualFold(option.Value, value) || strings.EqualFold(option.Label, value) ||
+			strings.EqualFold(strings.ReplaceAll(option.Value, "_", " "), value) {
+			return &options[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+func TestEstimateValue(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+	catalogue := 10.0
+
+	tests := []struct {
+		name     string
+		instance models.StampInstance
+		want     float64
+	}{
+		{"ungraded", models.StampInstance{Quantity: 1}, 10},
+		{"quantity", models.StampInstance{Quantity: 3}, 30},
+		{"very fine never hinged", models.StampInstance{Quantity: 1, Gum: str("MNH"), Centering: str("VF")}, 10},
+		{"hinged", models.StampInstance{Quantity: 1, Gum: str("MH"), Centering: str("VF")}, 6},
+		{"extremely fine", models.StampInstance{Quantity: 1, Centering: str("XF")}, 20},
+		{"numeric grade over centering", models.StampInstance{Quantity: 1, Centering: str("F"), Grade: num(90)}, 20},
+		{"worst fault counts", models.StampInstance{Quantity: 1, Faults: []string{"short_perf", "thin"}}, 3},
+		{"fancy cancel", models.StampInstance{Quantity: 2, CancelType: str("fancy")}, 30},
+		{"fine hinged with a crease", models.StampInstance{Quantity: 1, Gum: str("MH"), Centering: str("F"), Faults: []string{"crease"}}, 1.2},
+	}
+	for _, tt := range tests {
+		tt.instance.CatalogueValue = &catalogue
+		got := EstimateValue(&tt.instance)
+		if got == nil || math.Abs(*got-tt.want) > 0.005 {
+			t.Errorf("%s: EstimateValue = %v, want %v", tt.name, FormatValue(got), tt.want)
+		}
+	}
+
+	if got := EstimateValue(&models.StampInstance{Quantity: 1, Centering: str("XF")}); got != nil {
+		t.Errorf("EstimateValue without a catalogue value = %v, want nil", *got)
+	}
+}
+
+func TestGradeCentering(t *testing.T) {
+	tests := []struct {
+		grade int
+		want  string
+	}{
+		{100, "S"}, {98, "S"}, {95, "XF"}, {85, "VF-XF"}, {80, "VF"}, {79, "F-VF"}, {70, "F"}, {55, "VG"}, {30, "G"},
+	}
+	for _, tt := range tests {
+		if got := GradeCentering(tt.grade); got != tt.want {
+			t.Errorf("GradeCentering(%d) = %q, want %q", tt.grade, got, tt.want)
+		}
+	}
+}
+
+func TestTotalEstimatedValue(t *testing.T) {
+	a, b := 1.1, 2.2
+	if got := TotalEstimatedValue([]models.StampInstance{{EstimatedValue: &a}, {}, {EstimatedValue: &b}}); got == nil || *got != 3.3 {
+		t.Errorf("TotalEstimatedValue = %v, want 3.30", FormatValue(got))
+	}
+	if got := TotalEstimatedValue([]models.StampInstance{{}}); got != nil {
+		t.Errorf("TotalEstimatedValue of unvalued copies = %v, want nil", *got)
+	}
+}
@@ -0,0 +1,572 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ImageVersionsKeep is how many earlier versions of each image are kept besides the one it shows. Older
+// versions are deleted when a new one is uploaded. It is set from IMAGE_VERSIONS_KEEP at startup.
+var ImageVersionsKeep = 10
+
+// ErrInvalidImage is wrapped by the errors ValidateImage returns for an unknown image type
+var ErrInvalidImage = errors.New("invalid image")
+
+// ImageTypes are the kinds of images a gallery can hold, in display order
+var ImageTypes = []models.ImageType{
+	{Value: "front", Label: "Front"},
+	{Value: "back", Label: "Back"},
+	{Value: "uv", Label: "UV Light"},
+	{Value: "watermark", Label: "Watermark"},
+	{Value: "detail", Label: "Detail"},
+	{Value: "other", Label: "Other"},
+}
+
+// ImageTypeLabel returns the display label of an image type, or the value itself if it is unknown
+func ImageTypeLabel(value string) string {
+	for _, t := range ImageTypes {
+		if t.Value == value {
+			return t.Label
+		}
+	}
+	return value
+}
+
+// ValidateImage defaults a blank image type to "front" and rejects unknown types
+func ValidateImage(image *models.StampImage) error {
+	if image.Type == "" {
+		image.Type = "front"
+	}
+	for _, t := range ImageTypes {
+		if t.Value == image.Type {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown image type %q", ErrInvalidImage, image.Type)
+}
+
+// imageColumns are the columns selected for an image, in the order scanImage reads them
+const imageColumns = `id, stamp_id, instance_id, image_type, caption, position, is_primary, file_url, date_added, date_modified`
+
+// imageVersionColumns are the columns selected for a version of image i, in the order scanImageVersion
+// reads them
+const imageVersionColumns = `v.id, v.image_id, v.file_url, v.uploaded_by, v.date_uploaded, v.file_url = i.file_url`
+
+// sameImageOwner matches the images of the stamp ($1) or group of copies ($2) an image belongs to
+const sameImageOwner = `stamp_id IS NOT DISTINCT FROM $1 AND instance_id IS NOT DISTINCT FROM $2`
+
+type ImageService struct {
+	db *sql.DB
+}
+
+func NewImageService(db *sql.DB) *ImageService {
+	return &ImageService{db: db}
+}
+
+// GetStampImages returns the gallery of a stamp design, in display order
+func (s *ImageService) GetStampImages(stampID string) ([]models.StampImage, error) {
+	return getImages(s.db, "stamp_id", stampID)
+}
+
+// GetInstanceImages returns the gallery of a group of copies, in display order
+func (s *ImageService) GetInstanceImages(instanceID string) ([]models.StampImage, error) {
+	return getImages(s.db, "instance_id", instanceID)
+}
+
+func (s *ImageService) GetImage(id string) (*models.StampImage, error) {
+	row := s.db.QueryRow(`SELECT `+imageColumns+` FROM stamp_images WHERE id = $1`, id)
+	return scanImage(row)
+}
+
+// CreateImage adds an image to the end of its gallery, as its first version. The first image of a gallery
+// becomes its primary image, as does any image created with IsPrimary set.
+func (s *ImageService) CreateImage(image *models.StampImage, uploadedBy *string) (*models.StampImage, error) {
+	log.Printf("services.images.CreateImage: Inserting Image: %+v", image)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var hasPrimary bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM stamp_images WHERE `+sameImageOwner+` AND is_primary)`,
+		image.StampID, image.InstanceID).Scan(&hasPrimary)
+	if err != nil {
+		return nil, err
+	}
+
+	if image.IsPrimary && hasPrimary {
+		_, err = tx.Exec(`UPDATE stamp_images SET is_primary = FALSE WHERE `+sameImageOwner, image.StampID, image.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	image.IsPrimary = image.IsPrimary || !hasPrimary
+
+	err = tx.QueryRow(`INSERT INTO stamp_images
+		(id, stamp_id, instance_id, image_type, caption, position, is_primary, file_url, date_added, date_modified)
+		VALUES ($3, $1, $2, $4, $5,
+		        (SELECT COALESCE(MAX(position), 0) + 1 FROM stamp_images WHERE `+sameImageOwner+`),
+		        $6, $7, $8, $9)
+		RETURNING position`,
+		image.StampID, image.InstanceID, image.ID, image.Type, image.Caption, image.IsPrimary, image.FileURL,
+		image.DateAdded, image.DateModified).Scan(&image.Position)
+	if err != nil {
+		return nil, err
+	}
+	if err := addImageVersion(tx, image.ID, image.FileURL, uploadedBy); err != nil {
+		return nil, err
+	}
+
+	if err := syncStampImageURL(tx, image.StampID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// UpdateImage saves the type and caption of an image
+func (s *ImageService) UpdateImage(image *models.StampImage) (*models.StampImage, error) {
+	image.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE stamp_images SET image_type = $1, caption = $2, date_modified = $3 WHERE id = $4`,
+		image.Type, image.Caption, image.DateModified, image.ID)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// SetPrimaryImage makes an image the primary image of its gallery
+func (s *ImageService) SetPrimaryImage(id string) error {
+	image, err := s.GetImage(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE stamp_images SET is_primary = FALSE WHERE `+sameImageOwner+` AND is_primary`,
+		image.StampID, image.InstanceID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE stamp_images SET is_primary = TRUE WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	if err := syncStampImageURL(tx, image.StampID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteImage removes an image and its versions from its gallery. If it was the primary image, the next
+// image in the gallery takes its place. It returns the files no longer used by any image, for the caller to
+// delete.
+func (s *ImageService) DeleteImage(id string) ([]string, error) {
+	image, err := s.GetImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	fileURLs, err := queryStrings(tx, `SELECT file_url FROM image_versions WHERE image_id = $1
+		UNION SELECT file_url FROM stamp_images WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM stamp_images WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	if image.IsPrimary {
+		if err := promoteFirstImage(tx, image.StampID, image.InstanceID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := syncStampImageURL(tx, image.StampID); err != nil {
+		return nil, err
+	}
+	unused, err := unusedImageFiles(tx, fileURLs)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return unused, nil
+}
+
+// GetImageVersions returns every kept version of an image, newest first
+func (s *ImageService) GetImageVersions(imageID string) ([]models.ImageVersion, error) {
+	return getImageVersions(s.db, imageID)
+}
+
+func (s *ImageService) GetImageVersion(id string) (*models.ImageVersion, error) {
+	row := s.db.QueryRow(`SELECT `+imageVersionColumns+`
+		  FROM image_versions v JOIN stamp_images i ON i.id = v.image_id
+		 WHERE v.id = $1`, id)
+	return scanImageVersion(row)
+}
+
+// AddImageVersion replaces the file an image shows with a newly uploaded one, keeping the file it showed
+// as an earlier version. Versions beyond ImageVersionsKeep are deleted, and their files returned for the
+// caller to delete.
+func (s *ImageService) AddImageVersion(imageID, fileURL string, uploadedBy *string) ([]string, error) {
+	image, err := s.GetImage(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pruned, err := setImageVersion(tx, image, fileURL, uploadedBy)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
+
+// RestoreImageVersion makes an image show one of its earlier versions again. The version it showed is kept.
+func (s *ImageService) RestoreImageVersion(versionID string) error {
+	version, err := s.GetImageVersion(versionID)
+	if err != nil {
+		return err
+	}
+	image, err := s.GetImage(version.ImageID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE stamp_images SET file_url = $1, date_modified = NOW() WHERE id = $2`,
+		version.FileURL, image.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := syncStampImageURL(tx, image.StampID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetStampImageURL makes the given file a new version of the primary image of a stamp, adding it to the
+// gallery if the stamp has no primary image yet. A nil URL removes the primary image from the gallery
+// instead. This keeps the gallery in step with uploads and edits made through the stamp's own image_url.
+// It returns the files of versions deleted to stay within ImageVersionsKeep, for the caller to delete.
+func (s *ImageService) SetStampImageURL(stampID string, imageURL, uploadedBy *string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pruned []string
+	if imageURL == nil {
+		if _, err := tx.Exec(`DELETE FROM stamp_images WHERE stamp_id = $1 AND is_primary`, stampID); err != nil {
+			return nil, err
+		}
+		if err := promoteFirstImage(tx, &stampID, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		primary, err := scanImage(tx.QueryRow(`SELECT `+imageColumns+` FROM stamp_images
+			WHERE stamp_id = $1 AND is_primary`, stampID))
+		switch {
+		case err == sql.ErrNoRows:
+			var imageID string
+			err = tx.QueryRow(`INSERT INTO stamp_images
+				(id, stamp_id, image_type, position, is_primary, file_url, date_added, date_modified)
+				SELECT gen_random_uuid()::text, $1, 'front', COALESCE(MAX(position), 0) + 1, TRUE, $2, NOW(), NOW()
+				  FROM stamp_images WHERE stamp_id = $1
+				RETURNING id`, stampID, *imageURL).Scan(&imageID)
+			if err != nil {
+				return nil, err
+			}
+			if err := addImageVersion(tx, imageID, *imageURL, uploadedBy); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		case primary.FileURL != *imageURL:
+			if pruned, err = setImageVersion(tx, primary, *imageURL, uploadedBy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := syncStampImageURL(tx, &stampID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
+
+// ReorderImages sets the display order of images to the order of the given IDs
+func (s *ImageService) ReorderImages(ids []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		_, err = tx.Exec(`UPDATE stamp_images SET position = $1 WHERE id = $2`, i+1, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MoveImage moves an image one place up (delta -1) or down (delta 1) in its gallery
+func (s *ImageService) MoveImage(id string, delta int) error {
+	image, err := s.GetImage(id)
+	if err != nil {
+		return err
+	}
+
+	var gallery []models.StampImage
+	if image.StampID != nil {
+		gallery, err = s.GetStampImages(*image.StampID)
+	} else {
+		gallery, err = s.GetInstanceImages(*image.InstanceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(gallery))
+	index := 0
+	for i, other := range gallery {
+		ids[i] = other.ID
+		if other.ID == id {
+			index = i
+		}
+	}
+
+	other := index + delta
+	if other < 0 || other >= len(ids) {
+		return nil
+	}
+	ids[index], ids[other] = ids[other], ids[index]
+	return s.ReorderImages(ids)
+}
+
+// GetImageStampID returns the stamp an image belongs to, directly or through a group of copies
+func (s *ImageService) GetImageStampID(image *models.StampImage) (string, error) {
+	if image.StampID != nil {
+		return *image.StampID, nil
+	}
+
+	var stampID string
+	err := s.db.QueryRow(`SELECT stamp_id FROM stamp_instances WHERE id = $1`, *image.InstanceID).Scan(&stampID)
+	return stampID, err
+}
+
+// setImageVersion adds a file as the newest version of an image and makes the image show it, then deletes
+// the versions beyond ImageVersionsKeep. It returns the files no longer used by any image.
+func setImageVersion(tx *sql.Tx, image *models.StampImage, fileURL string, uploadedBy *string) ([]string, error) {
+	if err := addImageVersion(tx, image.ID, fileURL, uploadedBy); err != nil {
+		return nil, err
+	}
+
+	_, err := tx.Exec(`UPDATE stamp_images SET file_url = $1, date_modified = NOW() WHERE id = $2`, fileURL, image.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version shown doesn't count towards the limit, however old it is
+	pruned, err := queryStrings(tx, `DELETE FROM image_versions
+		WHERE id IN (SELECT v.id
+		               FROM image_versions v JOIN stamp_images i ON i.id = v.image_id
+		              WHERE v.image_id = $1 AND v.file_url <> i.file_url
+		             ORDER BY v.date_uploaded DESC
+		             OFFSET $2)
+		RETURNING file_url`, image.ID, ImageVersionsKeep)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncStampImageURL(tx, image.StampID); err != nil {
+		return nil, err
+	}
+	return unusedImageFiles(tx, pruned)
+}
+
+// addImageVersion records an upload of an image
+func addImageVersion(tx *sql.Tx, imageID, fileURL string, uploadedBy *string) error {
+	_, err := tx.Exec(`INSERT INTO image_versions (id, image_id, file_url, uploaded_by, date_uploaded)
+		VALUES (gen_random_uuid()::text, $1, $2, $3, NOW())`, imageID, fileURL, uploadedBy)
+	return err
+}
+
+// unusedImageFiles returns the files that no image or version refers to any more
+func unusedImageFiles(tx *sql.Tx, fileURLs []string) ([]string, error) {
+	var unused []string
+	for _, fileURL := range fileURLs {
+		var used bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM image_versions WHERE file_url = $1)
+			OR EXISTS (SELECT 1 FROM stamp_images WHERE file_url = $1)`, fileURL).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			unused = append(unused, fileURL)
+		}
+	}
+	return unused, nil
+}
+
+// queryStrings runs a query that returns a single text column
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// promoteFirstImage makes the first image of a gallery without a primary image its primary image
+func promoteFirstImage(tx *sql.Tx, stampID, instanceID *string) error {
+	_, err := tx.Exec(`UPDATE stamp_images SET is_primary = TRUE
+		WHERE id = (SELECT id FROM stamp_images WHERE `+sameImageOwner+` ORDER BY position, date_added LIMIT 1)
+		  AND NOT EXISTS (SELECT 1 FROM stamp_images WHERE `+sameImageOwner+` AND is_primary)`,
+		stampID, instanceID)
+	return err
+}
+
+// syncStampImageURL copies the primary image of a stamp's gallery to the stamp's image_url, which is what
+// gallery cards and the list view show. It does nothing for the galleries of copies.
+func syncStampImageURL(tx *sql.Tx, stampID *string) error {
+	if stampID == nil {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE stamps
+		SET image_url = (SELECT file_url FROM stamp_images WHERE stamp_id = $1 AND is_primary)
+		WHERE id = $1`, *stampID)
+	return err
+}
+
+func getImages(db *sql.DB, ownerColumn, ownerID string) ([]models.StampImage, error) {
+	rows, err := db.Query(`SELECT `+imageColumns+`
+		  FROM stamp_images
+		 WHERE `+ownerColumn+` = $1
+		ORDER BY position, date_added`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.StampImage
+	for rows.Next() {
+		image, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range images {
+		if images[i].Versions, err = getImageVersions(db, images[i].ID); err != nil {
+			return nil, err
+		}
+		if images[i].Annotations, err = getImageAnnotations(db, images[i].ID); err != nil {
+			return nil, err
+		}
+		if images[i].Palette, err = getImagePalette(db, images[i].FileURL); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+func getImageVersions(db *sql.DB, imageID string) ([]models.ImageVersion, error) {
+	rows, err := db.Query(`SELECT `+imageVersionColumns+`
+		  FROM image_versions v JOIN stamp_images i ON i.id = v.image_id
+		 WHERE v.image_id = $1
+		ORDER BY v.date_uploaded DESC`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.ImageVersion
+	for rows.Next() {
+		version, err := scanImageVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	return versions, rows.Err()
+}
+
+// scanImage reads a row selected with imageColumns
+func scanImage(row interface{ Scan(...interface{}) error }) (*models.StampImage, error) {
+	var image models.StampImage
+	err := row.Scan(&image.ID, &image.StampID, &image.InstanceID, &image.Type, &image.Caption, &image.Position,
+		&image.IsPrimary, &image.FileURL, &image.DateAdded, &image.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// scanImageVersion reads a row selected with imageVersionColumns
+func scanImageVersion(row interface{ Scan(...interface{}) error }) (*models.ImageVersion, error) {
+	var version models.ImageVersion
+	err := row.Scan(&version.ID, &version.ImageID, &version.FileURL, &version.UploadedBy, &version.DateUploaded,
+		&version.IsCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
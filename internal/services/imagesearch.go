@@ -0,0 +1,191 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// MatchDistance is the largest perceptual hash distance at which a stamp is offered as a match for a photo.
+// Images of unrelated designs are rarely closer than 24.
+const MatchDistance = 18
+
+// DuplicateDistance is the largest distance at which the images of two stamps mark them as likely duplicates
+const DuplicateDistance = 6
+
+// hashedImage is a front image of a stamp, or of a group of its copies, with its perceptual hash
+type hashedImage struct {
+	match models.StampMatch
+	hash  uint64
+}
+
+// ImageSearchService finds stamps by what their images look like. Only front images are compared; backs
+// look much alike from one stamp to the next.
+type ImageSearchService struct {
+	db *sql.DB
+}
+
+func NewImageSearchService(db *sql.DB) *ImageSearchService {
+	return &ImageSearchService{db: db}
+}
+
+// GetUnhashedImageURLs returns the files of gallery images that have no perceptual hash yet. Every image is
+// hashed, not just fronts, so an image whose type is changed to front later is found too.
+func (s *ImageSearchService) GetUnhashedImageURLs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT i.file_url
+		  FROM stamp_images i
+		 WHERE NOT EXISTS (SELECT 1 FROM image_hashes h WHERE h.file_url = i.file_url)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fileURLs []string
+	for rows.Next() {
+		var fileURL string
+		if err := rows.Scan(&fileURL); err != nil {
+			return nil, err
+		}
+		fileURLs = append(fileURLs, fileURL)
+	}
+	return fileURLs, rows.Err()
+}
+
+// SaveImageHash records the perceptual hash of an image file, or nil for a file that couldn't be read
+func (s *ImageSearchService) SaveImageHash(fileURL string, hash *uint64) error {
+	var value *int64
+	if hash != nil {
+		v := int64(*hash) // Postgres has no unsigned integers; the bits are kept as they are
+		value = &v
+	}
+
+	_, err := s.db.Exec(`INSERT INTO image_hashes (file_url, phash, date_hashed) VALUES ($1, $2, $3)
+		ON CONFLICT (file_url) DO UPDATE SET phash = EXCLUDED.phash, date_hashed = EXCLUDED.date_hashed`,
+		fileURL, value, time.Now())
+	return err
+}
+
+// FindStamps returns the stamps with an image within MatchDistance of any of the hashes, closest first and
+// at most limit of them. Each stamp is listed once, with its closest image.
+func (s *ImageSearchService) FindStamps(hashes []uint64, limit int) ([]models.StampMatch, error) {
+	images, err := s.getHashedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	best := map[string]models.StampMatch{}
+	for _, image := range images {
+		distance := 65
+		for _, hash := range hashes {
+			distance = min(distance, imaging.HashDistance(hash, image.hash))
+		}
+		if distance > MatchDistance {
+			continue
+		}
+		if current, ok := best[image.match.StampID]; ok && current.Distance <= distance {
+			continue
+		}
+		match := image.match
+		match.Distance = distance
+		match.Similarity = imaging.HashSimilarity(distance)
+		best[match.StampID] = match
+	}
+
+	matches := make([]models.StampMatch, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// GetDuplicateStamps returns the pairs of stamp records with images within DuplicateDistance of each other,
+// closest first. Each pair is listed once, with its closest images.
+func (s *ImageSearchService) GetDuplicateStamps() ([]models.DuplicateStamps, error) {
+	images, err := s.getHashedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	best := map[[2]string]models.DuplicateStamps{}
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			first, second := images[i].match, images[j].match
+			if first.StampID == second.StampID {
+				continue
+			}
+			distance := imaging.HashDistance(images[i].hash, images[j].hash)
+			if distance > DuplicateDistance {
+				continue
+			}
+
+			if first.StampID > second.StampID {
+				first, second = second, first
+			}
+			key := [2]string{first.StampID, second.StampID}
+			if current, ok := best[key]; ok && current.First.Distance <= distance {
+				continue
+			}
+			first.Distance, second.Distance = distance, distance
+			first.Similarity = imaging.HashSimilarity(distance)
+			second.Similarity = first.Similarity
+			best[key] = models.DuplicateStamps{First: first, Second: second}
+		}
+	}
+
+	pairs := make([]models.DuplicateStamps, 0, len(best))
+	for _, pair := range best {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].First.Distance != pairs[j].First.Distance {
+			return pairs[i].First.Distance < pairs[j].First.Distance
+		}
+		return pairs[i].First.Name < pairs[j].First.Name
+	})
+
+	log.Printf("services.imagesearch.GetDuplicateStamps: %d pairs among %d images", len(pairs), len(images))
+	return pairs, nil
+}
+
+// getHashedImages returns the hashed front images of stamps that haven't been deleted, including those of
+// their copies
+func (s *ImageSearchService) getHashedImages() ([]hashedImage, error) {
+	rows, err := s.db.Query(`
+		SELECT i.id, i.file_url, s.id, s.name, s.scott_number, h.phash
+		  FROM stamp_images i
+		  LEFT JOIN stamp_instances si ON si.id = i.instance_id
+		  JOIN stamps s ON s.id = COALESCE(i.stamp_id, si.stamp_id)
+		  JOIN image_hashes h ON h.file_url = i.file_url
+		 WHERE i.image_type = 'front' AND h.phash IS NOT NULL AND s.date_deleted IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []hashedImage
+	for rows.Next() {
+		var image hashedImage
+		var hash int64
+		err := rows.Scan(&image.match.ImageID, &image.match.ImageURL, &image.match.StampID, &image.match.Name,
+			&image.match.ScottNumber, &hash)
+		if err != nil {
+			return nil, err
+		}
+		image.hash = uint64(hash)
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
@@ -2,13 +2,53 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/lib/pq"
 )
 
+// ErrInvalidInstance is wrapped by the errors returned for an unknown format or component
+var ErrInvalidInstance = errors.New("invalid instance")
+
+// InstanceFormats are the ways physical copies can be held, in display order
+var InstanceFormats = []models.InstanceFormat{
+	{Value: "single", Label: "Single"},
+	{Value: "pair", Label: "Pair"},
+	{Value: "block", Label: "Block"},
+	{Value: "plate_block", Label: "Plate Block"},
+	{Value: "sheet", Label: "Souvenir Sheet"},
+	{Value: "booklet_pane", Label: "Booklet Pane"},
+	{Value: "coil_strip", Label: "Coil Strip"},
+}
+
+// InstanceFormatLabel returns the display label of an instance format, or the value itself if it is unknown
+func InstanceFormatLabel(value string) string {
+	for _, f := range InstanceFormats {
+		if f.Value == value {
+			return f.Label
+		}
+	}
+	return value
+}
+
+// ValidateFormat defaults an empty format to "single" and rejects unknown formats
+func ValidateFormat(instance *models.StampInstance) error {
+	if instance.Format == "" {
+		instance.Format = "single"
+	}
+	for _, f := range InstanceFormats {
+		if f.Value == instance.Format {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown format %q", ErrInvalidInstance, instance.Format)
+}
+
 type InstanceService struct {
 	db *sql.DB
 }
@@ -19,11 +59,14 @@ func NewInstanceService(db *sql.DB) *InstanceService {
 
 func (s *InstanceService) CreateStampInstance(instance *models.StampInstance) (*models.StampInstance, error) {
 	sql := `INSERT INTO stamp_instances 
-		(id, stamp_id, condition, box_id, quantity, date_added, date_modified) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		(id, stamp_id, condition, box_id, quantity, format, plate_number, plate_position,
+		 gum, centering, grade, faults, cancel_type, grade_notes, catalogue_value, date_added, date_modified) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`
 	_, err := s.db.Exec(sql,
 		instance.ID, instance.StampID, instance.Condition, instance.BoxID, 
-		instance.Quantity, instance.DateAdded, instance.DateModified)
+		instance.Quantity, instance.Format, instance.PlateNumber, instance.PlatePosition,
+		instance.Gum, instance.Centering, instance.Grade, pq.Array(instance.Faults), instance.CancelType, instance.GradeNotes,
+		instance.CatalogueValue, instance.DateAdded, instance.DateModified)
 
 	if err != nil {
 		return nil, err
@@ -34,12 +77,15 @@ func (s *InstanceService) CreateStampInstance(instance *models.StampInstance) (*
 
 func (s *InstanceService) UpdateStampInstance(instance *models.StampInstance) (*models.StampInstance, error) {
 	query := `UPDATE stamp_instances SET 
-		condition=$1, box_id=$2, quantity=$3, date_modified=$4
-		WHERE id=$5 AND date_deleted IS NULL`
+		condition=$1, box_id=$2, quantity=$3, format=$4, plate_number=$5, plate_position=$6,
+		gum=$7, centering=$8, grade=$9, faults=$10, cancel_type=$11, grade_notes=$12, catalogue_value=$13, date_modified=$14
+		WHERE id=$15 AND date_deleted IS NULL`
 	
 	result, err := s.db.Exec(query,
 		instance.Condition, instance.BoxID, instance.Quantity, 
-		instance.DateModified, instance.ID)
+		instance.Format, instance.PlateNumber, instance.PlatePosition,
+		instance.Gum, instance.Centering, instance.Grade, pq.Array(instance.Faults), instance.CancelType, instance.GradeNotes,
+		instance.CatalogueValue, instance.DateModified, instance.ID)
 
 	if err != nil {
 		return nil, err
@@ -84,20 +130,50 @@ func (s *InstanceService) GetStampInstance(id string) (*models.StampInstance, er
 	
 	query := `
 		SELECT si.id, si.stamp_id, si.condition, si.box_id, sb.name as box_name, 
-		       si.quantity, si.date_added, si.date_modified
+		       si.quantity, si.format, si.plate_number, si.plate_position,
+		       si.gum, si.centering, si.grade, si.faults, si.cancel_type, si.grade_notes, si.catalogue_value, si.date_added, si.date_modified
 		FROM stamp_instances si
 		LEFT JOIN storage_boxes sb ON si.box_id = sb.id
 		WHERE si.id = $1 AND si.date_deleted IS NULL`
 
 	err := s.db.QueryRow(query, id).Scan(&instance.ID, &instance.StampID, &instance.Condition, 
-		&instance.BoxID, &instance.BoxName, &instance.Quantity, &dateAdded, &dateModified)
+		&instance.BoxID, &instance.BoxName, &instance.Quantity,
+		&instance.Format, &instance.PlateNumber, &instance.PlatePosition,
+		&instance.Gum, &instance.Centering, &instance.Grade, pq.Array(&instance.Faults), &instance.CancelType, &instance.GradeNotes, &instance.CatalogueValue,
+		&dateAdded, &dateModified)
+
+	if err != nil {
+		return nil, err
+	}
 
+	instance.Components, err = getInstanceComponents(s.db, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	instance.Certificates, err = getInstanceCertificates(s.db, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	instance.Provenance, err = getInstanceProvenance(s.db, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	instance.Attachments, err = getAttachments(s.db, "instance_id", instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	instance.Images, err = getImages(s.db, "instance_id", instance.ID)
+	if err != nil {
+		return nil, err
+	}
+	instance.Measurement, err = getInstanceMeasurement(s.db, instance.ID)
 	if err != nil {
 		return nil, err
 	}
 
 	instance.DateAdded, _ = time.Parse(time.RFC3339, dateAdded)
 	instance.DateModified, _ = time.Parse(time.RFC3339, dateModified)
+	instance.EstimatedValue = EstimateValue(&instance)
 
 	return &instance, nil
 }
@@ -106,7 +182,8 @@ func (s *InstanceService) GetStampInstance(id string) (*models.StampInstance, er
 func (s *InstanceService) GetStampInstances(stampID string) ([]models.StampInstance, error) {
 	rows, err := s.db.Query(`
 		SELECT si.id, si.stamp_id, si.condition, si.box_id, sb.name as box_name,
-		       si.quantity, si.date_added, si.date_modified
+		       si.quantity, si.format, si.plate_number, si.plate_position,
+		       si.gum, si.centering, si.grade, si.faults, si.cancel_type, si.grade_notes, si.catalogue_value, si.date_added, si.date_modified
 		FROM stamp_instances si
 		LEFT JOIN storage_boxes sb ON si.box_id = sb.id
 		WHERE si.stamp_id = $1 AND si.date_deleted IS NULL
@@ -122,15 +199,143 @@ func (s *InstanceService) GetStampInstances(stampID string) ([]models.StampInsta
 		var dateAdded, dateModified string
 		
 		err := rows.Scan(&instance.ID, &instance.StampID, &instance.Condition, 
-			&instance.BoxID, &instance.BoxName, &instance.Quantity, &dateAdded, &dateModified)
+			&instance.BoxID, &instance.BoxName, &instance.Quantity,
+			&instance.Format, &instance.PlateNumber, &instance.PlatePosition,
+			&instance.Gum, &instance.Centering, &instance.Grade, pq.Array(&instance.Faults), &instance.CancelType, &instance.GradeNotes, &instance.CatalogueValue,
+			&dateAdded, &dateModified)
 		if err != nil {
 			return nil, err
 		}
 
 		instance.DateAdded, _ = time.Parse(time.RFC3339, dateAdded)
 		instance.DateModified, _ = time.Parse(time.RFC3339, dateModified)
+		instance.EstimatedValue = EstimateValue(&instance)
+		instance.Components, _ = getInstanceComponents(s.db, instance.ID)
+		instance.Certificates, _ = getInstanceCertificates(s.db, instance.ID)
+		instance.Provenance, _ = getInstanceProvenance(s.db, instance.ID)
+		instance.Attachments, _ = getAttachments(s.db, "instance_id", instance.ID)
+		instance.Images, _ = getImages(s.db, "instance_id", instance.ID)
+		instance.Measurement, _ = getInstanceMeasurement(s.db, instance.ID)
 		
 		instances = append(instances, instance)
 	}
 	return instances, nil
+}
+
+// ResolveComponents looks up components given by Scott number and merges repeated designs.
+// The instance's own design is left out, since its copies already count toward it.
+func (s *InstanceService) ResolveComponents(stampID string, components []models.InstanceComponent) ([]models.InstanceComponent, error) {
+	var resolved []models.InstanceComponent
+	index := make(map[string]int)
+
+	for _, component := range components {
+		if component.StampID == "" && component.ScottNumber != nil {
+			err := s.db.QueryRow(`SELECT id FROM stamps WHERE scott_number = $1 AND date_deleted IS NULL`,
+				*component.ScottNumber).Scan(&component.StampID)
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("%w: no stamp with Scott number %q", ErrInvalidInstance, *component.ScottNumber)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if component.StampID == "" {
+			return nil, fmt.Errorf("%w: components need a stamp_id or scott_number", ErrInvalidInstance)
+		}
+		if component.StampID == stampID {
+			continue
+		}
+		if component.Quantity <= 0 {
+			component.Quantity = 1
+		}
+
+		if i, ok := index[component.StampID]; ok {
+			resolved[i].Quantity += component.Quantity
+			continue
+		}
+		index[component.StampID] = len(resolved)
+		resolved = append(resolved, component)
+	}
+	return resolved, nil
+}
+
+// SetInstanceComponents replaces the other designs contained in a multiple
+func (s *InstanceService) SetInstanceComponents(instanceID string, components []models.InstanceComponent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM instance_components WHERE instance_id = $1", instanceID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, component := range components {
+		_, err = tx.Exec(`INSERT INTO instance_components (instance_id, stamp_id, quantity) VALUES ($1, $2, $3)`,
+			instanceID, component.StampID, component.Quantity)
+		if err != nil {
+			tx.Rollback()
+			if strings.Contains(err.Error(), "violates foreign key constraint") {
+				return fmt.Errorf("%w: stamp %s not found", ErrInvalidInstance, component.StampID)
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func getInstanceComponents(db *sql.DB, instanceID string) ([]models.InstanceComponent, error) {
+	rows, err := db.Query(`
+		SELECT ic.stamp_id, s.scott_number, s.name, ic.quantity
+		  FROM instance_components ic
+		    JOIN stamps s ON s.id = ic.stamp_id
+		 WHERE ic.instance_id = $1 AND s.date_deleted IS NULL
+		ORDER BY s.scott_number, s.name`, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var components []models.InstanceComponent
+	for rows.Next() {
+		var component models.InstanceComponent
+		if err := rows.Scan(&component.StampID, &component.ScottNumber, &component.Name, &component.Quantity); err != nil {
+			return nil, err
+		}
+		components = append(components, component)
+	}
+	return components, rows.Err()
+}
+
+// getContainingInstances returns the multiples of other designs that include the given stamp
+func getContainingInstances(db *sql.DB, stampID string) ([]models.StampInstance, error) {
+	rows, err := db.Query(`
+		SELECT si.id, si.stamp_id, s.name, si.condition, si.box_id, sb.name,
+		       si.quantity, si.format, si.plate_number, si.plate_position
+		  FROM instance_components ic
+		    JOIN stamp_instances si ON si.id = ic.instance_id AND si.date_deleted IS NULL
+		    JOIN stamps s ON s.id = si.stamp_id AND s.date_deleted IS NULL
+		    LEFT JOIN storage_boxes sb ON sb.id = si.box_id
+		 WHERE ic.stamp_id = $1
+		ORDER BY s.name, si.format`, stampID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []models.StampInstance
+	for rows.Next() {
+		var instance models.StampInstance
+		err := rows.Scan(&instance.ID, &instance.StampID, &instance.StampName, &instance.Condition,
+			&instance.BoxID, &instance.BoxName, &instance.Quantity,
+			&instance.Format, &instance.PlateNumber, &instance.PlatePosition)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, rows.Err()
 }
\ No newline at end of file
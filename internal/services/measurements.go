@@ -0,0 +1,76 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// MeasurementService keeps the centering, size and perforations measured from the scans of groups of copies
+type MeasurementService struct {
+	db *sql.DB
+}
+
+func NewMeasurementService(db *sql.DB) *MeasurementService {
+	return &MeasurementService{db: db}
+}
+
+// SaveMeasurement records what was measured of a group of copies, replacing any earlier measurement
+func (s *MeasurementService) SaveMeasurement(measurement *models.InstanceMeasurement) (*models.InstanceMeasurement, error) {
+	_, err := s.db.Exec(`
+		INSERT INTO instance_measurements (instance_id, image_id, margin_left, margin_right, margin_top, margin_bottom,
+		                                   suggested_centering, dpi, width_mm, height_mm, gauge_top, gauge_bottom,
+		                                   gauge_left, gauge_right, perforation, date_measured)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (instance_id) DO UPDATE SET
+			image_id = EXCLUDED.image_id, margin_left = EXCLUDED.margin_left, margin_right = EXCLUDED.margin_right,
+			margin_top = EXCLUDED.margin_top, margin_bottom = EXCLUDED.margin_bottom,
+			suggested_centering = EXCLUDED.suggested_centering, dpi = EXCLUDED.dpi, width_mm = EXCLUDED.width_mm,
+			height_mm = EXCLUDED.height_mm, gauge_top = EXCLUDED.gauge_top, gauge_bottom = EXCLUDED.gauge_bottom,
+			gauge_left = EXCLUDED.gauge_left, gauge_right = EXCLUDED.gauge_right, perforation = EXCLUDED.perforation,
+			date_measured = EXCLUDED.date_measured`,
+		measurement.InstanceID, measurement.ImageID, measurement.MarginLeft, measurement.MarginRight,
+		measurement.MarginTop, measurement.MarginBottom, measurement.SuggestedCentering, measurement.DPI,
+		measurement.WidthMM, measurement.HeightMM, measurement.GaugeTop, measurement.GaugeBottom,
+		measurement.GaugeLeft, measurement.GaugeRight, measurement.Perforation, measurement.DateMeasured)
+	if err != nil {
+		return nil, err
+	}
+	return getInstanceMeasurement(s.db, measurement.InstanceID)
+}
+
+// getInstanceMeasurement returns what was measured of a group of copies, or nil if it hasn't been
+func getInstanceMeasurement(db *sql.DB, instanceID string) (*models.InstanceMeasurement, error) {
+	var m models.InstanceMeasurement
+	err := db.QueryRow(`
+		SELECT instance_id, image_id, margin_left, margin_right, margin_top, margin_bottom, suggested_centering,
+		       dpi, width_mm, height_mm, gauge_top, gauge_bottom, gauge_left, gauge_right, perforation, date_measured
+		  FROM instance_measurements
+		 WHERE instance_id = $1`, instanceID).
+		Scan(&m.InstanceID, &m.ImageID, &m.MarginLeft, &m.MarginRight, &m.MarginTop, &m.MarginBottom,
+			&m.SuggestedCentering, &m.DPI, &m.WidthMM, &m.HeightMM, &m.GaugeTop, &m.GaugeBottom, &m.GaugeLeft,
+			&m.GaugeRight, &m.Perforation, &m.DateMeasured)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m.MarginLeft != nil && m.MarginRight != nil && m.MarginTop != nil && m.MarginBottom != nil {
+		horizontal := marginBalance(*m.MarginLeft, *m.MarginRight)
+		vertical := marginBalance(*m.MarginTop, *m.MarginBottom)
+		m.HorizontalBalance, m.VerticalBalance = &horizontal, &vertical
+	}
+	return &m, nil
+}
+
+// marginBalance is the narrower of two opposite margins over the wider
+func marginBalance(a, b float64) float64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	return math.Min(a, b) / math.Max(a, b)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ColourMatchDistance is the largest L*a*b* distance at which a stamp's colour counts as close to a swatch. It
+// takes in the shades a catalogue would give one name, such as carmine and rose carmine, but not red and orange.
+const ColourMatchDistance = 20
+
+// minColourShare is the least of an image a colour must cover to be matched, so a stamp isn't found by its
+// cancellation or a speck of another colour
+const minColourShare = 0.1
+
+// namedColours are the swatches offered for finding stamps by colour, named as catalogues name inks
+var namedColours = []struct{ hex, name string }{
+	{"#c41e3a", "Carmine"},
+	{"#d8506e", "Rose"},
+	{"#d42a2a", "Red"},
+	{"#e34a27", "Vermilion"},
+	{"#e8842a", "Orange"},
+	{"#e8c53a", "Yellow"},
+	{"#7a7a34", "Olive"},
+	{"#2e8540", "Green"},
+	{"#2a9a9a", "Turquoise"},
+	{"#2a5aa8", "Blue"},
+	{"#3a2fa0", "Ultramarine"},
+	{"#6e3a9e", "Violet"},
+	{"#7a2a68", "Purple"},
+	{"#8b5a2b", "Brown"},
+	{"#737373", "Grey"},
+	{"#1e1e1e", "Black"},
+}
+
+// colourFacetJoins pairs each stamp with the named colours it has, for counting them as a facet
+func colourFacetJoins() string {
+	values := make([]string, len(namedColours))
+	for i, named := range namedColours {
+		colour, _ := imaging.ParseHex(named.hex)
+		lab := imaging.ToLab(colour)
+		values[i] = fmt.Sprintf("('%s', '%s', %d, %.2f, %.2f, %.2f)", named.hex, named.name, i, lab.L, lab.A, lab.B)
+	}
+	return `JOIN (VALUES ` + strings.Join(values, ", ") + `) fc (value, label, position, l, a, b)
+		ON ` + database.NearColourExpr("s.id", "fc.l", "fc.a", "fc.b", ColourMatchDistance, minColourShare)
+}
+
+// PaletteService keeps the main colours of images, for finding and sorting stamps by colour. Only the colours
+// of front and primary images are searched; backs are mostly the colour of the paper.
+type PaletteService struct {
+	db *sql.DB
+}
+
+func NewPaletteService(db *sql.DB) *PaletteService {
+	return &PaletteService{db: db}
+}
+
+// GetUnextractedImageURLs returns the files of gallery images that have no palette yet. Every image is read,
+// not just fronts, so an image whose type is changed to front later is found too.
+func (s *PaletteService) GetUnextractedImageURLs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT i.file_url
+		  FROM stamp_images i
+		 WHERE NOT EXISTS (SELECT 1 FROM image_palettes p WHERE p.file_url = i.file_url)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fileURLs []string
+	for rows.Next() {
+		var fileURL string
+		if err := rows.Scan(&fileURL); err != nil {
+			return nil, err
+		}
+		fileURLs = append(fileURLs, fileURL)
+	}
+	return fileURLs, rows.Err()
+}
+
+// SavePalette records the main colours of an image file, or nil for a file that couldn't be read, along with
+// the hue and lightness of its ink for sorting. Black and grey inks have no hue, so they sort after the others.
+func (s *PaletteService) SavePalette(fileURL string, palette []imaging.Swatch) error {
+	var hue, lightness *float64
+	if ink, ok := imaging.InkColour(palette); ok {
+		lab := imaging.ToLab(ink.Colour)
+		lightness = &lab.L
+		if !lab.IsAchromatic() {
+			value := lab.Hue()
+			hue = &value
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO image_palettes (file_url, hue, lightness, date_extracted) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (file_url) DO UPDATE SET hue = EXCLUDED.hue, lightness = EXCLUDED.lightness,
+			date_extracted = EXCLUDED.date_extracted`,
+		fileURL, hue, lightness, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM image_colours WHERE file_url = $1", fileURL); err != nil {
+		return err
+	}
+	for i, swatch := range palette {
+		lab := imaging.ToLab(swatch.Colour)
+		_, err := tx.Exec(`INSERT INTO image_colours (file_url, position, colour, l, a, b, share)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			fileURL, i+1, swatch.Hex(), lab.L, lab.A, lab.B, swatch.Share)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func getImagePalette(db *sql.DB, fileURL string) ([]models.ImageColour, error) {
+	rows, err := db.Query(`SELECT colour, share FROM image_colours WHERE file_url = $1 ORDER BY position`, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var palette []models.ImageColour
+	for rows.Next() {
+		var colour models.ImageColour
+		if err := rows.Scan(&colour.Colour, &colour.Share); err != nil {
+			return nil, err
+		}
+		palette = append(palette, colour)
+	}
+	return palette, rows.Err()
+}
+
+// coloursLab converts swatches, e.g. "#b22234", to L*a*b* for comparing with the colours of images
+func coloursLab(colours []string) [][3]float64 {
+	var labs [][3]float64
+	for _, hex := range colours {
+		if colour, err := imaging.ParseHex(hex); err == nil {
+			lab := imaging.ToLab(colour)
+			labs = append(labs, [3]float64{lab.L, lab.A, lab.B})
+		}
+	}
+	return labs
+}
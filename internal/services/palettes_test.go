@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
+)
+
+func TestColourMatchDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		match bool
+	}{
+		{"#c41e3a", "#b7303f", true},  // Carmine and rose carmine
+		{"#2a5aa8", "#3366b0", true},  // Two shades of blue
+		{"#d42a2a", "#e8842a", false}, // Red and orange
+		{"#2a5aa8", "#3a2fa0", false}, // Blue and ultramarine
+		{"#1e1e1e", "#737373", false}, // Black and grey
+	}
+	for _, tt := range tests {
+		labs := coloursLab([]string{tt.a, tt.b})
+		a, b := imaging.Lab{L: labs[0][0], A: labs[0][1], B: labs[0][2]}, imaging.Lab{L: labs[1][0], A: labs[1][1], B: labs[1][2]}
+		if d := a.Distance(b); (d <= ColourMatchDistance) != tt.match {
+			t.Errorf("%s and %s are %.1f apart, match = %v, want %v", tt.a, tt.b, d, d <= ColourMatchDistance, tt.match)
+		}
+	}
+}
+
+func TestColoursLabSkipsInvalid(t *testing.T) {
+	labs := coloursLab([]string{"#c41e3a", "red", "", "#2a5aa8"})
+	if len(labs) != 2 {
+		t.Errorf("coloursLab = %v, want the two valid colours", labs)
+	}
+}
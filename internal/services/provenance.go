@@ -0,0 +1,201 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidProvenance is wrapped by the errors returned for a certificate without an issuer or a
+// provenance entry that names neither an owner nor a sale
+var ErrInvalidProvenance = errors.New("invalid provenance record")
+
+// ValidateCertificate requires a certificate to name its issuer
+func ValidateCertificate(certificate *models.Certificate) error {
+	certificate.Issuer = strings.TrimSpace(certificate.Issuer)
+	if certificate.Issuer == "" {
+		return fmt.Errorf("%w: a certificate needs an issuer", ErrInvalidProvenance)
+	}
+	return nil
+}
+
+// ValidateProvenanceEntry requires a provenance entry to name an owner, an auction house or a sale
+func ValidateProvenanceEntry(entry *models.ProvenanceEntry) error {
+	if entry.Owner == nil && entry.AuctionHouse == nil && entry.SaleName == nil {
+		return fmt.Errorf("%w: a provenance entry needs an owner, auction house or sale", ErrInvalidProvenance)
+	}
+	return nil
+}
+
+type ProvenanceService struct {
+	db *sql.DB
+}
+
+func NewProvenanceService(db *sql.DB) *ProvenanceService {
+	return &ProvenanceService{db: db}
+}
+
+func (s *ProvenanceService) GetCertificate(id string) (*models.Certificate, error) {
+	var certificate models.Certificate
+	err := s.db.QueryRow(`
+		SELECT c.id, c.instance_id, si.stamp_id, c.issuer, c.certificate_number, c.issued_date, c.opinion,
+		       c.file_url, c.notes, c.date_added, c.date_modified
+		  FROM instance_certificates c
+		    JOIN stamp_instances si ON si.id = c.instance_id
+		 WHERE c.id = $1`, id).
+		Scan(&certificate.ID, &certificate.InstanceID, &certificate.StampID, &certificate.Issuer,
+			&certificate.CertificateNumber, &certificate.IssuedDate, &certificate.Opinion, &certificate.FileURL,
+			&certificate.Notes, &certificate.DateAdded, &certificate.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+func (s *ProvenanceService) CreateCertificate(certificate *models.Certificate) (*models.Certificate, error) {
+	log.Printf("services.provenance.CreateCertificate: Inserting Certificate: %+v", certificate)
+
+	_, err := s.db.Exec(`INSERT INTO instance_certificates
+		(id, instance_id, issuer, certificate_number, issued_date, opinion, file_url, notes, date_added, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		certificate.ID, certificate.InstanceID, certificate.Issuer, certificate.CertificateNumber, certificate.IssuedDate,
+		certificate.Opinion, certificate.FileURL, certificate.Notes, certificate.DateAdded, certificate.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+func (s *ProvenanceService) UpdateCertificate(certificate *models.Certificate) (*models.Certificate, error) {
+	certificate.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE instance_certificates SET
+		issuer = $1, certificate_number = $2, issued_date = $3, opinion = $4, file_url = $5, notes = $6, date_modified = $7
+		WHERE id = $8`,
+		certificate.Issuer, certificate.CertificateNumber, certificate.IssuedDate, certificate.Opinion,
+		certificate.FileURL, certificate.Notes, certificate.DateModified, certificate.ID)
+	if err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+func (s *ProvenanceService) DeleteCertificate(id string) error {
+	result, err := s.db.Exec("DELETE FROM instance_certificates WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *ProvenanceService) GetProvenanceEntry(id string) (*models.ProvenanceEntry, error) {
+	var entry models.ProvenanceEntry
+	err := s.db.QueryRow(`
+		SELECT p.id, p.instance_id, si.stamp_id, p.owner, p.auction_house, p.sale_name, p.lot_number,
+		       p.acquired_date, p.notes, p.date_added, p.date_modified
+		  FROM instance_provenance p
+		    JOIN stamp_instances si ON si.id = p.instance_id
+		 WHERE p.id = $1`, id).
+		Scan(&entry.ID, &entry.InstanceID, &entry.StampID, &entry.Owner, &entry.AuctionHouse, &entry.SaleName,
+			&entry.LotNumber, &entry.AcquiredDate, &entry.Notes, &entry.DateAdded, &entry.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *ProvenanceService) CreateProvenanceEntry(entry *models.ProvenanceEntry) (*models.ProvenanceEntry, error) {
+	log.Printf("services.provenance.CreateProvenanceEntry: Inserting Provenance Entry: %+v", entry)
+
+	_, err := s.db.Exec(`INSERT INTO instance_provenance
+		(id, instance_id, owner, auction_house, sale_name, lot_number, acquired_date, notes, date_added, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		entry.ID, entry.InstanceID, entry.Owner, entry.AuctionHouse, entry.SaleName, entry.LotNumber,
+		entry.AcquiredDate, entry.Notes, entry.DateAdded, entry.DateModified)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *ProvenanceService) UpdateProvenanceEntry(entry *models.ProvenanceEntry) (*models.ProvenanceEntry, error) {
+	entry.DateModified = time.Now()
+	_, err := s.db.Exec(`UPDATE instance_provenance SET
+		owner = $1, auction_house = $2, sale_name = $3, lot_number = $4, acquired_date = $5, notes = $6, date_modified = $7
+		WHERE id = $8`,
+		entry.Owner, entry.AuctionHouse, entry.SaleName, entry.LotNumber, entry.AcquiredDate, entry.Notes,
+		entry.DateModified, entry.ID)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *ProvenanceService) DeleteProvenanceEntry(id string) error {
+	result, err := s.db.Exec("DELETE FROM instance_provenance WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// getInstanceCertificates returns the certificates of a group of copies, oldest first
+func getInstanceCertificates(db *sql.DB, instanceID string) ([]models.Certificate, error) {
+	rows, err := db.Query(`
+		SELECT id, instance_id, issuer, certificate_number, issued_date, opinion, file_url, notes, date_added, date_modified
+		  FROM instance_certificates
+		 WHERE instance_id = $1
+		ORDER BY issued_date NULLS LAST, date_added`, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certificates []models.Certificate
+	for rows.Next() {
+		var certificate models.Certificate
+		err := rows.Scan(&certificate.ID, &certificate.InstanceID, &certificate.Issuer, &certificate.CertificateNumber,
+			&certificate.IssuedDate, &certificate.Opinion, &certificate.FileURL, &certificate.Notes,
+			&certificate.DateAdded, &certificate.DateModified)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, rows.Err()
+}
+
+// getInstanceProvenance returns the ownership chain of a group of copies, oldest first
+func getInstanceProvenance(db *sql.DB, instanceID string) ([]models.ProvenanceEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, instance_id, owner, auction_house, sale_name, lot_number, acquired_date, notes, date_added, date_modified
+		  FROM instance_provenance
+		 WHERE instance_id = $1
+		ORDER BY acquired_date NULLS LAST, date_added`, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.ProvenanceEntry
+	for rows.Next() {
+		var entry models.ProvenanceEntry
+		err := rows.Scan(&entry.ID, &entry.InstanceID, &entry.Owner, &entry.AuctionHouse, &entry.SaleName,
+			&entry.LotNumber, &entry.AcquiredDate, &entry.Notes, &entry.DateAdded, &entry.DateModified)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
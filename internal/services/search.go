@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+)
+
+// searchFields lists the field prefixes understood by the search box. Any other "word:" is free text,
+// so that e.g. "http://" or "Re:" can be searched for.
+var searchFields = []string{"tag", "box", "owned", "cover", "scott", "year", "condition", "gum", "centering", "grade",
+	"fault", "cancel", "cert", "annotation", "series", "name"}
+
+// rangeFields are the fields that accept a "min..max" value
+var rangeFields = map[string]bool{"scott": true, "year": true, "grade": true}
+
+// gradingColumns maps the search fields on a copy's grading to their stamp_instances column
+var gradingColumns = map[string]string{"gum": "gum", "centering": "centering", "cancel": "cancel_type"}
+
+// SearchTerm is a single clause of a parsed search query, e.g. `-tag:damaged` or `year:1890..1899`
+type SearchTerm struct {
+	Field   string // Empty for free-text terms
+	Value   string
+	IsRange bool
+	Min     *int // Lower bound for range terms, nil if open-ended
+	Max     *int // Upper bound for range terms, nil if open-ended
+	Negate  bool
+}
+
+// SearchQuery is the parsed form of the text typed into the search box
+type SearchQuery struct {
+	Terms []SearchTerm
+}
+
+// SearchParseError describes why a search query could not be parsed
+type SearchParseError struct {
+	Pos int // Zero-based byte offset of the offending token
+	Msg string
+}
+
+func (e *SearchParseError) Error() string {
+	return fmt.Sprintf("invalid search at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// ParseSearchQuery parses power-user search syntax such as
+// `tag:USA box:"Box 1" owned:false cover:true scott:219..229 year:1890..1899 -tag:damaged`.
+// Bare words and quoted phrases match the stamp name, Scott number or series.
+func ParseSearchQuery(input string) (*SearchQuery, error) {
+	query := &SearchQuery{}
+
+	i := 0
+	for i < len(input) {
+		if isSearchSpace(input[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		term := SearchTerm{}
+		if input[i] == '-' && i+1 < len(input) && !isSearchSpace(input[i+1]) {
+			term.Negate = true
+			i++
+		}
+
+		var value strings.Builder
+		quoted := false
+		hasField := false
+		for i < len(input) && !isSearchSpace(input[i]) {
+			c := input[i]
+			switch {
+			case c == '"':
+				end := strings.IndexByte(input[i+1:], '"')
+				if end < 0 {
+					return nil, &SearchParseError{Pos: i, Msg: "unterminated quote"}
+				}
+				value.WriteString(input[i+1 : i+1+end])
+				quoted = true
+				i += end + 2
+			case c == ':' && !hasField && !quoted && isSearchField(value.String()):
+				term.Field = strings.ToLower(value.String())
+				hasField = true
+				value.Reset()
+				i++
+			default:
+				value.WriteByte(c)
+				i++
+			}
+		}
+		term.Value = value.String()
+
+		if term.Field == "" {
+			if strings.TrimSpace(term.Value) == "" {
+				continue
+			}
+			query.Terms = append(query.Terms, term)
+			continue
+		}
+
+		if err := validateSearchTerm(&term, quoted, start); err != nil {
+			return nil, err
+		}
+		query.Terms = append(query.Terms, term)
+	}
+
+	return query, nil
+}
+
+// validateSearchTerm checks a field:value term and fills in its range bounds
+func validateSearchTerm(term *SearchTerm, quoted bool, pos int) error {
+	if term.Value == "" {
+		return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("missing value after %s:", term.Field)}
+	}
+
+	switch term.Field {
+	case "owned", "cover":
+		switch strings.ToLower(term.Value) {
+		case "true", "yes":
+			term.Value = "true"
+		case "false", "no":
+			term.Value = "false"
+		default:
+			return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("%s must be true or false, got %q", term.Field, term.Value)}
+		}
+		return nil
+	}
+
+	if quoted || !strings.Contains(term.Value, "..") {
+		if term.Field == "year" || term.Field == "grade" {
+			n, err := strconv.Atoi(term.Value)
+			if err != nil {
+				return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("%s must be a number, got %q", term.Field, term.Value)}
+			}
+			term.IsRange = true
+			term.Min, term.Max = &n, &n
+		}
+		return nil
+	}
+
+	if !rangeFields[term.Field] {
+		return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("%s does not support ranges", term.Field)}
+	}
+
+	bounds := strings.SplitN(term.Value, "..", 2)
+	if bounds[0] == "" && bounds[1] == "" {
+		return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("range for %s needs at least one bound", term.Field)}
+	}
+	term.IsRange = true
+	for idx, bound := range bounds {
+		if bound == "" {
+			continue
+		}
+		n, err := strconv.Atoi(bound)
+		if err != nil {
+			return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("range bound %q for %s must be a whole number", bound, term.Field)}
+		}
+		if idx == 0 {
+			term.Min = &n
+		} else {
+			term.Max = &n
+		}
+	}
+	if term.Min != nil && term.Max != nil && *term.Min > *term.Max {
+		return &SearchParseError{Pos: pos, Msg: fmt.Sprintf("range %s for %s is backwards", term.Value, term.Field)}
+	}
+	return nil
+}
+
+// Apply adds a condition for every term in the query to the builder
+func (q *SearchQuery) Apply(qb *database.QueryBuilder, tableAlias string) {
+	if q == nil {
+		return
+	}
+
+	for _, term := range q.Terms {
+		switch term.Field {
+		case "":
+			qb.AddTextFilter(term.Value, tableAlias, term.Negate)
+		case "tag":
+			qb.AddTagNameFilter(term.Value, tableAlias, term.Negate)
+		case "box":
+			qb.AddBoxNameFilter(term.Value, tableAlias, term.Negate)
+		case "owned":
+			qb.AddInstanceExistsFilter((term.Value == "true") != term.Negate, tableAlias)
+		case "cover":
+			qb.AddOnCoverFilter((term.Value == "true") != term.Negate, tableAlias)
+		case "condition":
+			qb.AddConditionFilter(term.Value, tableAlias, term.Negate)
+		case "gum", "centering", "cancel":
+			qb.AddInstanceColumnFilter(gradingColumns[term.Field], term.Value, tableAlias, term.Negate)
+		case "grade":
+			qb.AddGradeRangeFilter(term.Min, term.Max, tableAlias, term.Negate)
+		case "fault":
+			qb.AddFaultFilter(strings.ReplaceAll(strings.ToLower(term.Value), " ", "_"), tableAlias, term.Negate)
+		case "cert":
+			qb.AddCertificateFilter(term.Value, tableAlias, term.Negate)
+		case "annotation":
+			qb.AddAnnotationFilter(term.Value, tableAlias, term.Negate)
+		case "series", "name":
+			qb.AddColumnLikeFilter(tableAlias+"."+term.Field, term.Value, term.Negate)
+		case "scott":
+			if term.IsRange {
+				qb.AddScottRangeFilter(term.Min, term.Max, tableAlias, term.Negate)
+			} else {
+				qb.AddScottNumberFilter(term.Value, tableAlias, term.Negate)
+			}
+		case "year":
+			qb.AddYearRangeFilter(term.Min, term.Max, tableAlias, term.Negate)
+		}
+	}
+}
+
+func isSearchSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isSearchField reports whether s, in any case, is one of the searchFields
+func isSearchField(s string) bool {
+	for _, f := range searchFields {
+		if strings.EqualFold(f, s) {
+			return true
+		}
+	}
+	return false
+}
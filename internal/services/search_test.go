@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []SearchTerm
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"penny", []SearchTerm{{Value: "penny"}}},
+		{`penny "black bird"`, []SearchTerm{{Value: "penny"}, {Value: "black bird"}}},
+		{`tag:USA box:"Box 1" -tag:damaged`, []SearchTerm{
+			{Field: "tag", Value: "USA"},
+			{Field: "box", Value: "Box 1"},
+			{Field: "tag", Value: "damaged", Negate: true},
+		}},
+		{"Tag:USA", []SearchTerm{{Field: "tag", Value: "USA"}}},
+		{"-penny", []SearchTerm{{Value: "penny", Negate: true}}},
+		{"owned:yes cover:FALSE", []SearchTerm{{Field: "owned", Value: "true"}, {Field: "cover", Value: "false"}}},
+		{"scott:219", []SearchTerm{{Field: "scott", Value: "219"}}},
+		{`scott:"219..229"`, []SearchTerm{{Field: "scott", Value: "219..229"}}},
+		{"scott:219..229", []SearchTerm{{Field: "scott", Value: "219..229", IsRange: true, Min: intPtr(219), Max: intPtr(229)}}},
+		{"year:1890..", []SearchTerm{{Field: "year", Value: "1890..", IsRange: true, Min: intPtr(1890)}}},
+		{"-year:..1899", []SearchTerm{{Field: "year", Value: "..1899", IsRange: true, Max: intPtr(1899), Negate: true}}},
+		{"year:1890", []SearchTerm{{Field: "year", Value: "1890", IsRange: true, Min: intPtr(1890), Max: intPtr(1890)}}},
+		{"grade:90..100", []SearchTerm{{Field: "grade", Value: "90..100", IsRange: true, Min: intPtr(90), Max: intPtr(100)}}},
+		{`cert:"PF 123" fault:thin`, []SearchTerm{{Field: "cert", Value: "PF 123"}, {Field: "fault", Value: "thin"}}},
+		// A colon that doesn't follow a field name is part of the word
+		{"12:30", []SearchTerm{{Value: "12:30"}}},
+		{"name:a:b", []SearchTerm{{Field: "name", Value: "a:b"}}},
+		// Unknown field prefixes are free text
+		{"colour:red", []SearchTerm{{Value: "colour:red"}}},
+		{"http://example.com Re:", []SearchTerm{{Value: "http://example.com"}, {Value: "Re:"}}},
+		{`-note:"torn corner"`, []SearchTerm{{Value: "note:torn corner", Negate: true}}},
+	}
+	for _, tt := range tests {
+		query, err := ParseSearchQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(query.Terms, tt.want) {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want %+v", tt.input, query.Terms, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{`tag:"USA`, 4, "unterminated quote"},
+		{"penny tag:", 6, "missing value after tag:"},
+		{"owned:maybe", 0, "owned must be true or false"},
+		{"year:abc", 0, "year must be a number"},
+		{"tag:a..b", 0, "tag does not support ranges"},
+		{"scott:..", 0, "needs at least one bound"},
+		{"scott:1..x", 0, `range bound "x" for scott must be a whole number`},
+		{"year:1899..1890", 0, "backwards"},
+		{"penny -year:1899..1890", 6, "backwards"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSearchQuery(tt.input)
+		var parseErr *SearchParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParseSearchQuery(%q) = %v, want a SearchParseError", tt.input, err)
+			continue
+		}
+		if parseErr.Pos != tt.pos || !strings.Contains(parseErr.Msg, tt.msg) {
+			t.Errorf("ParseSearchQuery(%q) = %v at %d, want %q at %d", tt.input, parseErr.Msg, parseErr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestSearchQueryApplyEscapesWildcards(t *testing.T) {
+	query, err := ParseSearchQuery(`100% name:C_1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qb := database.NewQueryBuilder("SELECT s.id FROM stamps s WHERE 1=1")
+	query.Apply(qb, "s")
+	_, args := qb.GetQuery()
+
+	want := []interface{}{`%100\%%`, `%100\%%`, `%100\%%`, `%C\_1%`}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
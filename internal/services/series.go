@@ -0,0 +1,271 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidSeries is wrapped by the error returned for a stamp whose series doesn't exist
+var ErrInvalidSeries = errors.New("invalid series")
+
+// seriesMembersCTE defines member_status: one row per series member with its matching stamp
+// and whether that stamp is owned. Members are the series' expected list (matched to stamps by
+// Scott number) plus any stamps linked to the series that aren't on that list.
+var seriesMembersCTE = `
+	WITH members AS (
+		SELECT sm.series_id, sm.id AS member_id, sm.scott_number,
+		       COALESCE(NULLIF(sm.name, ''), st.name, sm.scott_number, '') AS name,
+		       sm.position, st.id AS stamp_id
+		  FROM series_members sm
+		    LEFT JOIN stamps st ON st.scott_number = sm.scott_number AND st.date_deleted IS NULL
+		UNION ALL
+		SELECT st.series_id, NULL, st.scott_number, st.name, 0, st.id
+		  FROM stamps st
+		 WHERE st.series_id IS NOT NULL AND st.date_deleted IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM series_members sm WHERE sm.series_id = st.series_id AND sm.scott_number = st.scott_number)
+	), member_status AS (
+		SELECT members.*,
+		       ` + database.OwnedExpr("members.stamp_id") + ` AS is_owned
+		  FROM members
+	)`
+
+type SeriesService struct {
+	db *sql.DB
+}
+
+func NewSeriesService(db *sql.DB) *SeriesService {
+	return &SeriesService{db: db}
+}
+
+// GetSeries returns all series with their member and owned counts
+func (s *SeriesService) GetSeries() ([]models.Series, error) {
+	rows, err := s.db.Query(seriesMembersCTE + `
+		SELECT se.id, se.name, se.country, se.start_year, se.end_year, se.description,
+		       se.date_created, se.date_modified,
+		       COUNT(ms.series_id), COUNT(ms.series_id) FILTER (WHERE ms.is_owned)
+		  FROM series se
+		    LEFT JOIN member_status ms ON ms.series_id = se.id
+		GROUP BY se.id
+		ORDER BY se.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []models.Series
+	for rows.Next() {
+		var se models.Series
+		err := rows.Scan(&se.ID, &se.Name, &se.Country, &se.StartYear, &se.EndYear, &se.Description,
+			&se.DateCreated, &se.DateModified, &se.MemberCount, &se.OwnedCount)
+		if err != nil {
+			return nil, err
+		}
+		setCompletion(&se)
+		series = append(series, se)
+	}
+	return series, rows.Err()
+}
+
+// GetSeriesByID returns a series along with each of its members and whether it is owned
+func (s *SeriesService) GetSeriesByID(id string) (*models.Series, error) {
+	var se models.Series
+	err := s.db.QueryRow(`
+		SELECT id, name, country, start_year, end_year, description, date_created, date_modified
+		  FROM series
+		 WHERE id = $1`, id).
+		Scan(&se.ID, &se.Name, &se.Country, &se.StartYear, &se.EndYear, &se.Description,
+			&se.DateCreated, &se.DateModified)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(seriesMembersCTE+`
+		SELECT COALESCE(member_id, ''), scott_number, name, position, stamp_id, is_owned
+		  FROM member_status
+		 WHERE series_id = $1
+		ORDER BY member_id IS NULL, position, name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	se.Members = []models.SeriesMember{}
+	for rows.Next() {
+		var member models.SeriesMember
+		err := rows.Scan(&member.ID, &member.ScottNumber, &member.Name, &member.Position,
+			&member.StampID, &member.IsOwned)
+		if err != nil {
+			return nil, err
+		}
+		se.MemberCount++
+		if member.IsOwned {
+			se.OwnedCount++
+		}
+		se.Members = append(se.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	setCompletion(&se)
+	return &se, nil
+}
+
+func (s *SeriesService) CreateSeries(series *models.Series) (*models.Series, error) {
+	log.Printf("services.series.CreateSeries: Inserting Series: %+v", series)
+
+	_, err := s.db.Exec(`INSERT INTO series (id, name, country, start_year, end_year, description, date_created, date_modified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		series.ID, series.Name, series.Country, series.StartYear, series.EndYear, series.Description,
+		series.DateCreated, series.DateModified)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick up stamps that already use this series name
+	_, err = s.db.Exec(`UPDATE stamps SET series_id = $1 WHERE series_id IS NULL AND series = $2`, series.ID, series.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// UpdateSeries saves a series' details. Renaming a series renames it on all of its stamps.
+func (s *SeriesService) UpdateSeries(series *models.Series) (*models.Series, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	series.DateModified = time.Now()
+	_, err = tx.Exec(`UPDATE series SET name = $1, country = $2, start_year = $3, end_year = $4, description = $5, date_modified = $6
+		WHERE id = $7`,
+		series.Name, series.Country, series.StartYear, series.EndYear, series.Description, series.DateModified, series.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE stamps SET series = $1 WHERE series_id = $2`, series.Name, series.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// SetMembers replaces the expected member list of a series
+func (s *SeriesService) SetMembers(seriesID string, members []models.SeriesMember) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM series_members WHERE series_id = $1", seriesID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i, member := range members {
+		_, err = tx.Exec(`INSERT INTO series_members (id, series_id, scott_number, name, position)
+			VALUES ($1, $2, $3, $4, $5)`,
+			uuid.New().String(), seriesID, member.ScottNumber, member.Name, i+1)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE series SET date_modified = $1 WHERE id = $2", time.Now(), seriesID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSeries removes a series and clears it from its stamps
+func (s *SeriesService) DeleteSeries(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE stamps SET series = NULL, series_id = NULL WHERE series_id = $1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM series WHERE id = $1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ParseSeriesMembers reads an expected member list with one member per line:
+// the Scott number followed by an optional name, e.g. "551 Nathan Hale"
+func ParseSeriesMembers(text string) []models.SeriesMember {
+	var members []models.SeriesMember
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		scottNumber := fields[0]
+		members = append(members, models.SeriesMember{
+			ScottNumber: &scottNumber,
+			Name:        strings.Join(fields[1:], " "),
+		})
+	}
+	return members
+}
+
+// resolveSeries links stamp to the series its Series names, matching the name trimmed and ignoring case, and
+// stores the series' own spelling of it. Series are created on their own rather than by naming them on a
+// stamp, so a mistyped name isn't taken for a new series.
+func resolveSeries(db *sql.DB, stamp *models.Stamp) error {
+	if stamp.Series == nil || strings.TrimSpace(*stamp.Series) == "" {
+		stamp.Series, stamp.SeriesID = nil, nil
+		return nil
+	}
+	name := strings.TrimSpace(*stamp.Series)
+
+	// An exact match wins over series whose names differ only in case
+	var id, seriesName string
+	err := db.QueryRow(`SELECT id, name FROM series WHERE LOWER(name) = LOWER($1) ORDER BY name = $1 DESC LIMIT 1`,
+		name).Scan(&id, &seriesName)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: there is no series named %q; add it from the Series list first", ErrInvalidSeries, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve series: %v", err)
+	}
+	stamp.Series, stamp.SeriesID = &seriesName, &id
+	return nil
+}
+
+func setCompletion(series *models.Series) {
+	if series.MemberCount > 0 {
+		series.Completion = float64(series.OwnedCount) * 100 / float64(series.MemberCount)
+	}
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"database/sql"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 	"log"
@@ -10,7 +12,9 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/imaging"
 	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/lib/pq"
 )
 
 type StampService struct {
@@ -19,19 +23,45 @@ type StampService struct {
 
 // StampFilters holds all filter parameters for stamp queries
 type StampFilters struct {
-	Search     string
-	Owned      string
-	BoxID      string
-	JumpTo     string
-	Sort       string
-	Order      string
-	Limit      int
-	Offset     int
+	Search       string
+	Query        *SearchQuery // Parsed form of Search
+	Owned        string
+	BoxID        string
+	JumpTo       string
+	Collapse     bool // Hide varieties, showing only their parent designs
+
+	// Facet selections: values within a facet are OR'd, facets are AND'd together
+	Tags         []string
+	Boxes        []string
+	Series       []string
+	Conditions   []string
+	Decades      []int
+	OwnedStatus  []string
+	Colours      []string // Swatches, e.g. "#b22234", that a stamp's main colours must come close to
+
+	// Smart collection the results are scoped to; Scope holds its resolved filters
+	CollectionID string
+	Scope        *StampFilters
+
+	Sort         string
+	Order        string
+	Limit        int
+	Offset       int
 }
 
-// NewStampFiltersFromRequest creates StampFilters from HTTP request parameters
-func NewStampFiltersFromRequest(r *http.Request, page, limit int) StampFilters {
-	order := r.URL.Query().Get("order")
+// NewStampFiltersFromRequest creates StampFilters from HTTP request parameters.
+// It returns a *SearchParseError if the search box contains invalid query syntax.
+func NewStampFiltersFromRequest(r *http.Request, page, limit int) (StampFilters, error) {
+	// Debug logging to see what sort parameters are received
+	log.Printf("services.stamps.NewStampFiltersFromRequest: sort=%s, order=%s, URL=%s",
+		r.URL.Query().Get("sort"), r.URL.Query().Get("order"), r.URL.String())
+
+	return NewStampFiltersFromValues(r.URL.Query(), page, limit)
+}
+
+// NewStampFiltersFromValues creates StampFilters from query parameters, e.g. those stored in a smart collection
+func NewStampFiltersFromValues(values url.Values, page, limit int) (StampFilters, error) {
+	order := values.Get("order")
 	if order == "" {
 		order = "ASC"
 	}
@@ -40,15 +70,10 @@ func NewStampFiltersFromRequest(r *http.Request, page, limit int) StampFilters {
 		order = "ASC"
 	}
 
-	sort := r.URL.Query().Get("sort")
-
-	// Debug logging to see what sort parameters are received
-	log.Printf("services.stamps.NewStampFiltersFromRequest: sort=%s, order=%s, URL=%s", sort, order, r.URL.String())
-
 	// Handle both old 'owned' parameter and new 'owned_filter' parameter
-	owned := r.URL.Query().Get("owned")
+	owned := values.Get("owned")
 	if owned == "" {
-		ownedFilter := r.URL.Query().Get("owned_filter")
+		ownedFilter := values.Get("owned_filter")
 		if ownedFilter == "all" {
 			owned = ""
 		} else {
@@ -56,16 +81,46 @@ func NewStampFiltersFromRequest(r *http.Request, page, limit int) StampFilters {
 		}
 	}
 
-	return StampFilters{
-		Search: r.URL.Query().Get("search"),
-		Owned:  owned,
-		BoxID:  r.URL.Query().Get("box_id"),
-		JumpTo: r.URL.Query().Get("jump_to"),
-		Sort:   r.URL.Query().Get("sort"),
-		Order:  order,
-		Limit:  limit,
-		Offset: (page - 1) * limit,
+	var decades []int
+	for _, d := range values["decade"] {
+		if decade, err := strconv.Atoi(d); err == nil {
+			decades = append(decades, decade)
+		}
+	}
+
+	var colours []string
+	for _, c := range values["colour"] {
+		if colour, err := imaging.ParseHex(c); err == nil {
+			colours = append(colours, imaging.Swatch{Colour: colour}.Hex())
+		}
+	}
+
+	search := values.Get("search")
+	query, err := ParseSearchQuery(search)
+	if err != nil {
+		return StampFilters{}, err
 	}
+
+	return StampFilters{
+		Search:       search,
+		Query:        query,
+		Owned:        owned,
+		BoxID:        values.Get("box_id"),
+		JumpTo:       values.Get("jump_to"),
+		Collapse:     values.Get("collapse_varieties") == "true",
+		Tags:         nonEmpty(values["tag"]),
+		Boxes:        nonEmpty(values["box"]),
+		Series:       nonEmpty(values["series"]),
+		Conditions:   nonEmpty(values["condition"]),
+		Decades:      decades,
+		OwnedStatus:  nonEmpty(values["owned_status"]),
+		Colours:      colours,
+		CollectionID: values.Get("collection"),
+		Sort:         values.Get("sort"),
+		Order:        order,
+		Limit:        limit,
+		Offset:       (page - 1) * limit,
+	}, nil
 }
 
 func NewStampService(db *sql.DB) *StampService {
@@ -74,7 +129,10 @@ func NewStampService(db *sql.DB) *StampService {
 
 // GetStampsWithCount gets both the total count and the stamps for the current page using shared filters
 func (s *StampService) GetStampsWithCount(r *http.Request, page, limit int) (int64, []models.Stamp, error) {
-	filters := NewStampFiltersFromRequest(r, page, limit)
+	filters, err := s.filtersFromRequest(r, page, limit)
+	if err != nil {
+		return 0, nil, err
+	}
 	
 	// Get count using shared filter logic
 	count, err := s.getStampCountWithFilters(filters)
@@ -93,29 +151,119 @@ func (s *StampService) GetStampsWithCount(r *http.Request, page, limit int) (int
 
 // Gets the total count of unique stamps (not instances) matching filters
 func (s *StampService) GetStampCount(r *http.Request) (int64, error) {
-	filters := NewStampFiltersFromRequest(r, 1, 1) // Page/limit not needed for count
+	filters, err := s.filtersFromRequest(r, 1, 1) // Page/limit not needed for count
+	if err != nil {
+		return 0, err
+	}
 	return s.getStampCountWithFilters(filters)
 }
 
 func (s *StampService) GetStamps(r *http.Request, page, limit int) ([]models.Stamp, error) {
-	filters := NewStampFiltersFromRequest(r, page, limit)
+	filters, err := s.filtersFromRequest(r, page, limit)
+	if err != nil {
+		return nil, err
+	}
 	return s.getStampsWithFilters(filters)
 }
 
 // Helper method to build query with filters
 func (s *StampService) addStampFilters(qb *database.QueryBuilder, filters StampFilters) {
-	qb.AddSearchFilter(filters.Search, "s")
+	filters.Query.Apply(qb, "s")
 	qb.AddJumpToFilter(filters.JumpTo, "s")
 	
 	if filters.Owned == "true" {
-		qb.AddCondition(` AND EXISTS (SELECT 1 FROM stamp_instances si WHERE si.stamp_id = s.id AND si.date_deleted IS NULL)`)
+		qb.AddInstanceExistsFilter(true, "s")
 	} else if filters.Owned == "false" {
-		qb.AddCondition(` AND NOT EXISTS (SELECT 1 FROM stamp_instances si WHERE si.stamp_id = s.id AND si.date_deleted IS NULL)`)
+		qb.AddInstanceExistsFilter(false, "s")
+	} else if filters.Owned == "on_cover" {
+		qb.AddOnCoverFilter(true, "s")
 	}
 
 	if filters.BoxID != "" {
 		qb.AddCondition(` AND EXISTS (SELECT 1 FROM stamp_instances si WHERE si.stamp_id = s.id AND si.box_id = ? AND si.date_deleted IS NULL)`, filters.BoxID)
 	}
+
+	// Varieties whose parent has been deleted are shown on their own
+	if filters.Collapse {
+		qb.AddCondition(` AND NOT EXISTS (SELECT 1 FROM stamps sp WHERE sp.id = s.parent_id AND sp.date_deleted IS NULL)`)
+	}
+
+	qb.AddTagsAnyFilter(filters.Tags, "s")
+	qb.AddBoxesAnyFilter(filters.Boxes, "s")
+	qb.AddColumnInFilter("s.series", filters.Series)
+	qb.AddConditionsAnyFilter(filters.Conditions, "s")
+	qb.AddDecadesFilter(filters.Decades, "s")
+	qb.AddColoursAnyFilter(coloursLab(filters.Colours), ColourMatchDistance, minColourShare, "s")
+
+	// Selecting both owned and needed is the same as selecting neither
+	if len(filters.OwnedStatus) == 1 {
+		qb.AddInstanceExistsFilter(filters.OwnedStatus[0] == "true", "s")
+	}
+
+	// A smart collection narrows the results further by its own saved filters
+	if filters.Scope != nil {
+		s.addStampFilters(qb, *filters.Scope)
+	}
+}
+
+// filtersFromRequest creates StampFilters from the request and resolves any smart collection it is scoped to
+func (s *StampService) filtersFromRequest(r *http.Request, page, limit int) (StampFilters, error) {
+	filters, err := NewStampFiltersFromRequest(r, page, limit)
+	if err != nil {
+		return StampFilters{}, err
+	}
+	if err := s.resolveCollectionScope(&filters, 0); err != nil {
+		return StampFilters{}, err
+	}
+	return filters, nil
+}
+
+// resolveCollectionScope loads the saved filters of the smart collection referenced by filters, if any.
+// Collections may themselves be scoped to another collection, up to maxCollectionDepth levels.
+func (s *StampService) resolveCollectionScope(filters *StampFilters, depth int) error {
+	if filters.CollectionID == "" {
+		return nil
+	}
+	if depth >= maxCollectionDepth {
+		return fmt.Errorf("smart collections are nested more than %d levels deep", maxCollectionDepth)
+	}
+
+	var query string
+	err := s.db.QueryRow(`SELECT query FROM smart_collections WHERE id = $1`, filters.CollectionID).Scan(&query)
+	if err != nil {
+		return fmt.Errorf("failed to load smart collection %s: %w", filters.CollectionID, err)
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("smart collection %s has an invalid query: %v", filters.CollectionID, err)
+	}
+	scope, err := NewStampFiltersFromValues(values, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := s.resolveCollectionScope(&scope, depth+1); err != nil {
+		return err
+	}
+	filters.Scope = &scope
+
+	// Use the collection's sort unless the request asked for its own
+	if filters.Sort == "" {
+		filters.Sort = scope.Sort
+		filters.Order = scope.Order
+	}
+	return nil
+}
+
+// nonEmpty drops blank values from a repeated query parameter
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			result = append(result, v)
+		}
+	}
+	return result
 }
 
 func (s *StampService) getStampCountWithFilters(filters StampFilters) (int64, error) {
@@ -134,9 +282,12 @@ func (s *StampService) getStampCountWithFilters(filters StampFilters) (int64, er
 
 func (s *StampService) getStampsWithFilters(filters StampFilters) ([]models.Stamp, error) {
 	qb := database.NewQueryBuilder(`
-		SELECT s.id, s.name, s.scott_number, s.issue_date, s.series,
+		SELECT s.id, s.name, s.scott_number, s.issue_date, s.series, s.series_id,
+			   s.parent_id, s.variety_type,
 			   s.notes, s.image_url, s.date_added, s.date_modified,
-			   EXISTS (SELECT 1 FROM stamp_instances si WHERE si.stamp_id = s.id AND si.date_deleted IS NULL) as is_owned
+			   `+database.OwnedExpr("s.id")+` as is_owned,
+			   `+database.OnCoverExpr("s.id")+` as on_cover,
+			   (SELECT COUNT(*) FROM stamps sv WHERE sv.parent_id = s.id AND sv.date_deleted IS NULL) as variety_count
 		  FROM stamps s
 		 WHERE s.date_deleted IS NULL`)
 
@@ -158,8 +309,9 @@ func (s *StampService) executeStampQuery(query string, args []interface{}) ([]mo
 	for rows.Next() {
 		var stamp models.Stamp
 		var dateAdded, dateModified time.Time
-		err := rows.Scan(&stamp.ID, &stamp.Name, &stamp.ScottNumber, &stamp.IssueDate, &stamp.Series,
-			&stamp.Notes, &stamp.ImageURL, &dateAdded, &dateModified, &stamp.IsOwned)
+		err := rows.Scan(&stamp.ID, &stamp.Name, &stamp.ScottNumber, &stamp.IssueDate, &stamp.Series, &stamp.SeriesID,
+			&stamp.ParentID, &stamp.VarietyType,
+			&stamp.Notes, &stamp.ImageURL, &dateAdded, &dateModified, &stamp.IsOwned, &stamp.OnCover, &stamp.VarietyCount)
 		if err != nil {
 			return nil, err
 		}
@@ -181,15 +333,18 @@ func (s *StampService) executeStampQuery(query string, args []interface{}) ([]mo
 
 
 func (s *StampService) GetStampByID(id string) (*models.Stamp, error) {
-	sql := `SELECT s.id, s.name, s.scott_number, s.issue_date, s.series, 
+	sql := `SELECT s.id, s.name, s.scott_number, s.issue_date, s.series, s.series_id,
+		           s.parent_id, sp.name, sp.scott_number, s.variety_type,
 		           s.notes, s.image_url, s.date_added, s.date_modified
 			  FROM stamps s
+			    LEFT JOIN stamps sp ON sp.id = s.parent_id AND sp.date_deleted IS NULL
 			 WHERE s.id = $1 AND s.date_deleted IS NULL`
 
 	var stamp models.Stamp
 	var dateAdded, dateModified time.Time
 	err := s.db.QueryRow(sql, id).Scan(&stamp.ID, &stamp.Name, &stamp.ScottNumber, &stamp.IssueDate,
-		&stamp.Series, &stamp.Notes, &stamp.ImageURL, &dateAdded, &dateModified)
+		&stamp.Series, &stamp.SeriesID, &stamp.ParentID, &stamp.ParentName, &stamp.ParentScottNumber, &stamp.VarietyType,
+		&stamp.Notes, &stamp.ImageURL, &dateAdded, &dateModified)
 
 	if err != nil {
 		return nil, err
@@ -201,22 +356,49 @@ func (s *StampService) GetStampByID(id string) (*models.Stamp, error) {
 	// Get tags
 	stamp.Tags, _ = s.getStampTags(stamp.ID)
 	
-	// Get all instances
+	// Get all instances, with the details only the stamp page shows
 	stamp.Instances, _ = s.getStampInstances(stamp.ID)
+	s.loadInstanceDetails(stamp.Instances)
+	stamp.EstimatedValue = TotalEstimatedValue(stamp.Instances)
 	
-	// Set IsOwned based on whether we have any instances
-	stamp.IsOwned = len(stamp.Instances) > 0
+	// Get multiples of other designs that include this one
+	stamp.ContainedIn, _ = getContainingInstances(s.db, stamp.ID)
+
+	// Get covers this stamp is affixed to
+	stamp.Covers, _ = getStampCovers(s.db, stamp.ID)
+	stamp.OnCover = len(stamp.Covers) > 0
+
+	// Get first-day covers of this design
+	stamp.FirstDayCovers, _ = NewFirstDayCoverService(s.db).GetFirstDayCovers(stamp.ID)
+
+	// Get files attached to the design itself; those of its copies are on each instance
+	stamp.Attachments, _ = getAttachments(s.db, "stamp_id", stamp.ID)
+
+	// Get the gallery of the design; the primary image is also in image_url
+	stamp.Images, _ = getImages(s.db, "stamp_id", stamp.ID)
+
+	// Set IsOwned based on whether we have any instances, on their own, as part of a multiple or on a cover
+	stamp.IsOwned = len(stamp.Instances) > 0 || len(stamp.ContainedIn) > 0 || stamp.OnCover
+
+	// Get varieties of this design
+	stamp.Varieties, _ = s.getStampVarieties(stamp.ID)
+	stamp.VarietyCount = len(stamp.Varieties)
 
 	return &stamp, nil
 }
 
 func (s *StampService) CreateStamp(stamp *models.Stamp) (*models.Stamp, error) {
+	if err := resolveSeries(s.db, stamp); err != nil {
+		return nil, err
+	}
+
 	sql := `INSERT INTO stamps 
-		(id, name, scott_number, issue_date, series, notes, image_url, is_owned, date_added, date_modified) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+		(id, name, scott_number, issue_date, series, series_id, parent_id, variety_type, notes, image_url, is_owned, date_added, date_modified) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
 	
 	_, err := s.db.Exec(sql,
-		stamp.ID, stamp.Name, stamp.ScottNumber, stamp.IssueDate, stamp.Series, 
+		stamp.ID, stamp.Name, stamp.ScottNumber, stamp.IssueDate, stamp.Series, stamp.SeriesID,
+		stamp.ParentID, stamp.VarietyType,
 		stamp.Notes, stamp.ImageURL, stamp.IsOwned, 
 		stamp.DateAdded, stamp.DateModified)
 
@@ -234,15 +416,28 @@ func (s *StampService) CreateStamp(stamp *models.Stamp) (*models.Stamp, error) {
 
 func (s *StampService) UpdateStamp(stamp *models.Stamp) (*models.Stamp, error) {
 	log.Printf("Updating stamp with ID: %s", stamp.ID)
+
+	// The series is only looked up when it changes, so a stamp whose series name was saved before series
+	// were checked can still be edited
+	var savedSeries, savedSeriesID *string
+	err := s.db.QueryRow(`SELECT series, series_id FROM stamps WHERE id = $1`, stamp.ID).Scan(&savedSeries, &savedSeriesID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil && sameString(stamp.Series, savedSeries) {
+		stamp.SeriesID = savedSeriesID
+	} else if err := resolveSeries(s.db, stamp); err != nil {
+		return nil, err
+	}
 	
 	query := `UPDATE stamps SET 
-		name=$1, scott_number=$2, issue_date=$3, series=$4, notes=$5, image_url=$6, 
-		is_owned=$7, date_modified=$8
-		WHERE id=$9 AND date_deleted IS NULL`
+		name=$1, scott_number=$2, issue_date=$3, series=$4, series_id=$5, parent_id=$6, variety_type=$7,
+		notes=$8, image_url=$9, is_owned=$10, date_modified=$11
+		WHERE id=$12 AND date_deleted IS NULL`
 	
 	result, err := s.db.Exec(query,
-		stamp.Name, stamp.ScottNumber, stamp.IssueDate, stamp.Series, stamp.Notes, stamp.ImageURL,
-		stamp.IsOwned, stamp.DateModified, stamp.ID)
+		stamp.Name, stamp.ScottNumber, stamp.IssueDate, stamp.Series, stamp.SeriesID, stamp.ParentID, stamp.VarietyType,
+		stamp.Notes, stamp.ImageURL, stamp.IsOwned, stamp.DateModified, stamp.ID)
 
 	if err != nil {
 		log.Printf("Error executing UPDATE query: %v", err)
@@ -330,7 +525,8 @@ func (s *StampService) getStampTags(stampID string) ([]string, error) {
 func (s *StampService) getStampInstances(stampID string) ([]models.StampInstance, error) {
 	rows, err := s.db.Query(`
 		SELECT si.id, si.stamp_id, si.condition, si.box_id, sb.name as box_name,
-		       si.quantity, si.date_added, si.date_modified
+		       si.quantity, si.format, si.plate_number, si.plate_position,
+		       si.gum, si.centering, si.grade, si.faults, si.cancel_type, si.grade_notes, si.catalogue_value, si.date_added, si.date_modified
 		FROM stamp_instances si
 		LEFT JOIN storage_boxes sb ON si.box_id = sb.id
 		WHERE si.stamp_id = $1 AND si.date_deleted IS NULL
@@ -346,19 +542,37 @@ func (s *StampService) getStampInstances(stampID string) ([]models.StampInstance
 		var dateAdded, dateModified string
 		
 		err := rows.Scan(&instance.ID, &instance.StampID, &instance.Condition, 
-			&instance.BoxID, &instance.BoxName, &instance.Quantity, &dateAdded, &dateModified)
+			&instance.BoxID, &instance.BoxName, &instance.Quantity,
+			&instance.Format, &instance.PlateNumber, &instance.PlatePosition,
+			&instance.Gum, &instance.Centering, &instance.Grade, pq.Array(&instance.Faults), &instance.CancelType, &instance.GradeNotes, &instance.CatalogueValue,
+			&dateAdded, &dateModified)
 		if err != nil {
 			return nil, err
 		}
 
 		instance.DateAdded, _ = time.Parse(time.RFC3339, dateAdded)
 		instance.DateModified, _ = time.Parse(time.RFC3339, dateModified)
+		instance.EstimatedValue = EstimateValue(&instance)
 		
 		instances = append(instances, instance)
 	}
 	return instances, nil
 }
 
+// loadInstanceDetails fills in what the stamp page shows of each group of copies beyond the listings:
+// components, certificates, provenance, attachments, images and measurements
+func (s *StampService) loadInstanceDetails(instances []models.StampInstance) {
+	for i := range instances {
+		instance := &instances[i]
+		instance.Components, _ = getInstanceComponents(s.db, instance.ID)
+		instance.Certificates, _ = getInstanceCertificates(s.db, instance.ID)
+		instance.Provenance, _ = getInstanceProvenance(s.db, instance.ID)
+		instance.Attachments, _ = getAttachments(s.db, "instance_id", instance.ID)
+		instance.Images, _ = getImages(s.db, "instance_id", instance.ID)
+		instance.Measurement, _ = getInstanceMeasurement(s.db, instance.ID)
+	}
+}
+
 func (s *StampService) updateStampTags(stampID string, tags []string) error {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -429,4 +643,12 @@ func (s *StampService) getStampBoxNames(stampID string) ([]string, error) {
 		boxNames = append(boxNames, boxName)
 	}
 	return boxNames, nil
+}
+
+// sameString reports whether two optional strings are both unset or equal
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
\ No newline at end of file
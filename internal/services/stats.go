@@ -3,7 +3,9 @@ package services
 import (
 	"database/sql"
 
+	"github.com/jeepinbird/stampkeeper/internal/database"
 	"github.com/jeepinbird/stampkeeper/internal/models"
+	"github.com/lib/pq"
 )
 
 type StatsService struct {
@@ -23,18 +25,57 @@ func (s *StatsService) GetStats() (*models.Stats, error) {
 	// Unique stamps (distinct stamp designs)
 	s.db.QueryRow("SELECT COUNT(DISTINCT scott_number) FROM stamps WHERE scott_number IS NOT NULL AND date_deleted IS NULL").Scan(&stats.UniqueStamps)
 
-	// Stamps needed (stamp designs with no instances)
+	// Stamps needed (stamp designs with no instances, on their own or as part of a multiple)
 	s.db.QueryRow(`
 		SELECT COUNT(*) 
 		FROM stamps s 
 		WHERE s.date_deleted IS NULL 
-		AND NOT EXISTS (
-			SELECT 1 FROM stamp_instances si 
-			WHERE si.stamp_id = s.id AND si.date_deleted IS NULL
-		)`).Scan(&stats.StampsNeeded)
+		AND NOT `+database.OwnedExpr("s.id")).Scan(&stats.StampsNeeded)
 
 	// Storage boxes
 	s.db.QueryRow("SELECT COUNT(*) FROM storage_boxes").Scan(&stats.StorageBoxes)
 
+	// Series completion (owned members across all series)
+	var seriesMembers, seriesOwned int
+	s.db.QueryRow(seriesMembersCTE + `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE total > 0 AND owned = total),
+		       COALESCE(SUM(total), 0),
+		       COALESCE(SUM(owned), 0)
+		  FROM (SELECT se.id, COUNT(ms.series_id) AS total, COUNT(ms.series_id) FILTER (WHERE ms.is_owned) AS owned
+		          FROM series se
+		            LEFT JOIN member_status ms ON ms.series_id = se.id
+		        GROUP BY se.id) series_totals`).Scan(&stats.SeriesCount, &stats.SeriesComplete, &seriesMembers, &seriesOwned)
+	if seriesMembers > 0 {
+		stats.SeriesCompletion = float64(seriesOwned) * 100 / float64(seriesMembers)
+	}
+
+	// Estimated value of the copies with a catalogue value, allowing for their grading
+	rows, err := s.db.Query(`
+		SELECT quantity, gum, centering, grade, faults, cancel_type, catalogue_value
+		  FROM stamp_instances
+		 WHERE catalogue_value IS NOT NULL AND date_deleted IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []models.StampInstance
+	for rows.Next() {
+		var instance models.StampInstance
+		if err := rows.Scan(&instance.Quantity, &instance.Gum, &instance.Centering, &instance.Grade,
+			pq.Array(&instance.Faults), &instance.CancelType, &instance.CatalogueValue); err != nil {
+			return nil, err
+		}
+		instance.EstimatedValue = EstimateValue(&instance)
+		instances = append(instances, instance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if total := TotalEstimatedValue(instances); total != nil {
+		stats.EstimatedValue = *total
+	}
+
 	return &stats, nil
 }
\ No newline at end of file
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jeepinbird/stampkeeper/internal/database"
+	"github.com/jeepinbird/stampkeeper/internal/models"
+)
+
+// ErrInvalidVariety is wrapped by the errors ValidateVariety returns for a bad parent or variety type
+var ErrInvalidVariety = errors.New("invalid variety")
+
+// ErrAmbiguousScottNumber is returned by GetStampIDByScottNumber when several designs share the Scott number
+var ErrAmbiguousScottNumber = errors.New("several stamps have that Scott number")
+
+// VarietyTypes are the kinds of minor variety a stamp can be of its parent design, in display order
+var VarietyTypes = []models.VarietyType{
+	{Value: "shade", Label: "Shade"},
+	{Value: "perforation", Label: "Perforation"},
+	{Value: "watermark", Label: "Watermark"},
+	{Value: "error", Label: "Error"},
+	{Value: "plate_flaw", Label: "Plate Flaw"},
+}
+
+// VarietyTypeLabel returns the display label of a variety type, or the value itself if it is unknown
+func VarietyTypeLabel(value string) string {
+	for _, t := range VarietyTypes {
+		if t.Value == value {
+			return t.Label
+		}
+	}
+	return value
+}
+
+func isVarietyType(value string) bool {
+	for _, t := range VarietyTypes {
+		if t.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateVariety checks a stamp's parent and variety type before it is saved.
+// Varieties are one level deep: a parent can't itself be a variety, and a stamp with
+// varieties of its own can't become one.
+func (s *StampService) ValidateVariety(stamp *models.Stamp) error {
+	if stamp.ParentID == nil {
+		stamp.VarietyType = nil
+		return nil
+	}
+
+	if stamp.VarietyType != nil && !isVarietyType(*stamp.VarietyType) {
+		return fmt.Errorf("%w: unknown variety type %q", ErrInvalidVariety, *stamp.VarietyType)
+	}
+	if *stamp.ParentID == stamp.ID {
+		return fmt.Errorf("%w: a stamp can't be a variety of itself", ErrInvalidVariety)
+	}
+
+	var parentOfParent *string
+	err := s.db.QueryRow(`SELECT parent_id FROM stamps WHERE id = $1 AND date_deleted IS NULL`, *stamp.ParentID).
+		Scan(&parentOfParent)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: parent stamp not found", ErrInvalidVariety)
+	}
+	if err != nil {
+		return err
+	}
+	if parentOfParent != nil {
+		return fmt.Errorf("%w: the parent stamp is itself a variety", ErrInvalidVariety)
+	}
+
+	var varietyCount int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM stamps WHERE parent_id = $1 AND date_deleted IS NULL`, stamp.ID).
+		Scan(&varietyCount)
+	if err != nil {
+		return err
+	}
+	if varietyCount > 0 {
+		return fmt.Errorf("%w: a stamp with varieties of its own can't be a variety", ErrInvalidVariety)
+	}
+
+	return nil
+}
+
+// GetStampIDByScottNumber looks up a stamp design by its Scott number. Scott numbers aren't unique, so
+// it returns ErrAmbiguousScottNumber rather than pick one when several designs share it, and
+// sql.ErrNoRows when none has it.
+func (s *StampService) GetStampIDByScottNumber(scottNumber string) (string, error) {
+	rows, err := s.db.Query(`SELECT id FROM stamps WHERE scott_number = $1 AND date_deleted IS NULL LIMIT 2`, scottNumber)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", sql.ErrNoRows
+	case 1:
+		return ids[0], nil
+	default:
+		return "", ErrAmbiguousScottNumber
+	}
+}
+
+func (s *StampService) getStampVarieties(stampID string) ([]models.Stamp, error) {
+	rows, err := s.db.Query(`
+		SELECT s.id, s.name, s.scott_number, s.variety_type,
+		       `+database.OwnedExpr("s.id")+` as is_owned
+		  FROM stamps s
+		 WHERE s.parent_id = $1 AND s.date_deleted IS NULL
+		ORDER BY s.scott_number, s.name`, stampID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var varieties []models.Stamp
+	for rows.Next() {
+		var variety models.Stamp
+		if err := rows.Scan(&variety.ID, &variety.Name, &variety.ScottNumber, &variety.VarietyType, &variety.IsOwned); err != nil {
+			return nil, err
+		}
+		variety.ParentID = &stampID
+		varieties = append(varieties, variety)
+	}
+	return varieties, rows.Err()
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	// The mime package only knows TIFF from the system's table, which a container may not have
+	mime.AddExtensionType(".tif", "image/tiff")
+}
+
+// FileStorage keeps files in a directory on the local disk
+type FileStorage struct {
+	root string
+}
+
+func NewFileStorage(root string) *FileStorage {
+	return &FileStorage{root: root}
+}
+
+func (s *FileStorage) Put(key string, body io.Reader, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed upload never leaves half a file in place
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s *FileStorage) Get(key string) (io.ReadCloser, *Info, error) {
+	if err := checkKey(key); err != nil {
+		return nil, nil, ErrNotFound
+	}
+
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, nil, fileError(err)
+	}
+
+	info, err := fileInfo(f.Stat())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	info.ContentType = mime.TypeByExtension(path.Ext(key))
+	return f, info, nil
+}
+
+func (s *FileStorage) Stat(key string) (*Info, error) {
+	if err := checkKey(key); err != nil {
+		return nil, ErrNotFound
+	}
+
+	info, err := fileInfo(os.Stat(s.path(key)))
+	if err != nil {
+		return nil, err
+	}
+	info.ContentType = mime.TypeByExtension(path.Ext(key))
+	return info, nil
+}
+
+func (s *FileStorage) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (s *FileStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// fileInfo converts the result of a stat call
+func fileInfo(stat fs.FileInfo, err error) (*Info, error) {
+	if err != nil {
+		return nil, fileError(err)
+	}
+	if stat.IsDir() {
+		return nil, ErrNotFound
+	}
+	return &Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// fileError reports a missing file as ErrNotFound
+func fileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
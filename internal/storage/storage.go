@@ -0,0 +1,171 @@
+// Package storage keeps uploaded files: stamp images, cover scans and attachments. Files are addressed by a
+// key such as "stamps/abc.jpg" and served at /static/images/<key>, whichever backend holds them, so the URLs
+// saved in the database don't change when files move between backends.
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jeepinbird/stampkeeper/internal/config"
+)
+
+// URLPrefix is where stored files are served
+const URLPrefix = "/static/images/"
+
+// ErrNotFound is returned for keys that hold no file
+var ErrNotFound = errors.New("storage: file not found")
+
+// Info describes a stored file
+type Info struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Storage is a place uploaded files are kept
+type Storage interface {
+	// Put stores a file under key, replacing any file already there
+	Put(key string, body io.Reader, contentType string) error
+	// Get opens the file under key. The caller must close it.
+	Get(key string) (io.ReadCloser, *Info, error)
+	Stat(key string) (*Info, error)
+	// Delete removes the file under key. Deleting a missing file is not an error.
+	Delete(key string) error
+	// List returns the keys of every file whose key starts with prefix
+	List(prefix string) ([]string, error)
+}
+
+// Files is where uploads are kept. It is set from IMAGE_STORAGE at startup.
+var Files Storage = NewFileStorage("./static/images")
+
+// Backends are the names IMAGE_STORAGE accepts
+var Backends = []string{"filesystem", "s3"}
+
+// FromConfig returns the named backend, set up from the environment
+func FromConfig(backend string, cfg *config.Config) (Storage, error) {
+	switch backend {
+	case "filesystem":
+		return NewFileStorage(cfg.ImageStorageRoot), nil
+	case "s3":
+		return NewS3Storage(S3Options{
+			Endpoint:        cfg.S3Endpoint,
+			Bucket:          cfg.S3Bucket,
+			Region:          cfg.S3Region,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		})
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q; use one of %s", backend, strings.Join(Backends, ", "))
+	}
+}
+
+// URL returns the URL a stored file is served at
+func URL(key string) string {
+	return URLPrefix + key
+}
+
+// Key returns the key of the stored file served at fileURL. It reports false for URLs of anything else,
+// such as images linked from other sites.
+func Key(fileURL string) (string, bool) {
+	key, ok := strings.CutPrefix(fileURL, URLPrefix)
+	if !ok || !fs.ValidPath(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// Copy copies the file under key from one backend to another
+func Copy(from, to Storage, key string) error {
+	body, info, err := from.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return to.Put(key, body, info.ContentType)
+}
+
+// Migrate copies every file from one backend to another. Files already in the destination with the same
+// size are skipped, so an interrupted migration can be run again. Nothing is deleted from the source.
+func Migrate(from, to Storage) (copied, skipped int, err error) {
+	keys, err := from.List("")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, key := range keys {
+		info, err := from.Stat(key)
+		if err != nil {
+			return copied, skipped, fmt.Errorf("%v: %w", key, err)
+		}
+		if existing, err := to.Stat(key); err == nil && existing.Size == info.Size {
+			skipped++
+			continue
+		}
+
+		if err := Copy(from, to, key); err != nil {
+			return copied, skipped, fmt.Errorf("%v: %w", key, err)
+		}
+		copied++
+	}
+	return copied, skipped, nil
+}
+
+// Handler serves stored files, with the request path as the key
+func Handler(store Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if !fs.ValidPath(key) || key == "." {
+			http.NotFound(w, r)
+			return
+		}
+		Serve(store, w, r, key)
+	})
+}
+
+// Serve responds with the stored file under key
+func Serve(store Storage, w http.ResponseWriter, r *http.Request, key string) {
+	body, info, err := store.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer body.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	// Browsers mustn't take an uploaded file for another type, such as text for HTML
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Files on disk can be served a range at a time; others are small enough to read whole
+	content, ok := body.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, path.Base(key), info.ModTime, content)
+}
+
+// checkKey rejects keys that could reach outside the storage, such as "../config"
+func checkKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
@@ -3,14 +3,34 @@ package main
 import (
     "log"
     "net/http"
+    "os"
+    "strings"
     
     "github.com/jeepinbird/stampkeeper/internal/config"
     "github.com/jeepinbird/stampkeeper/internal/database"
+    "github.com/jeepinbird/stampkeeper/internal/handlers"
+    "github.com/jeepinbird/stampkeeper/internal/imaging"
     "github.com/jeepinbird/stampkeeper/internal/router"
+    "github.com/jeepinbird/stampkeeper/internal/services"
+    "github.com/jeepinbird/stampkeeper/internal/storage"
 )
 
 func main() {
     cfg := config.Load()
+    services.ImageVersionsKeep = cfg.ImageVersionsKeep
+    handlers.TrustedProxies = cfg.TrustedProxies
+    
+    files, err := storage.FromConfig(cfg.ImageStorage, cfg)
+    if err != nil {
+        log.Fatal("Failed to set up image storage:", err)
+    }
+    storage.Files = files
+    
+    // Maintenance commands run in place of the server, e.g. "go run main.go backfill-images"
+    if len(os.Args) > 1 {
+        runCommand(cfg, os.Args[1], os.Args[2:])
+        return
+    }
     
     db, err := database.Connect(cfg.DatabaseURL)
     if err != nil {
@@ -30,4 +50,57 @@ func main() {
     
     log.Printf("StampKeeper server starting on :%s", cfg.Port)
     log.Fatal(http.ListenAndServe(":"+cfg.Port, r))
+}
+
+func runCommand(cfg *config.Config, name string, args []string) {
+    switch name {
+    case "backfill-images":
+        // Make the thumbnail and medium variants of images uploaded before they existed
+        dirs := args
+        if len(dirs) == 0 {
+            dirs = []string{"stamps"}
+        }
+        for _, dir := range dirs {
+            made, err := imaging.BackfillVariants(storage.Files, dir)
+            if err != nil {
+                log.Fatalf("Failed to backfill images in %s: %v", dir, err)
+            }
+            log.Printf("Made variants or tiles of %d images in %s", made, dir)
+        }
+        
+        // Then index the gallery images saved before they were indexed on upload
+        db, err := database.Connect(cfg.DatabaseURL)
+        if err != nil {
+            log.Fatal("Failed to connect to database:", err)
+        }
+        defer db.Close()
+        if err := database.Migrate(db); err != nil {
+            log.Fatal("Failed to run migrations:", err)
+        }
+        indexed, err := handlers.IndexImages(db)
+        if err != nil {
+            log.Fatalf("Failed to index images: %v", err)
+        }
+        log.Printf("Indexed %d gallery images for searching", indexed)
+    case "migrate-images":
+        // Copy every uploaded file to another backend, before pointing IMAGE_STORAGE at it
+        if len(args) != 2 {
+            log.Fatalf("Usage: migrate-images <from> <to>, where each is one of %s", strings.Join(storage.Backends, ", "))
+        }
+        from, err := storage.FromConfig(args[0], cfg)
+        if err != nil {
+            log.Fatal(err)
+        }
+        to, err := storage.FromConfig(args[1], cfg)
+        if err != nil {
+            log.Fatal(err)
+        }
+        copied, skipped, err := storage.Migrate(from, to)
+        if err != nil {
+            log.Fatalf("Failed to migrate images after copying %d files: %v", copied, err)
+        }
+        log.Printf("Copied %d files from %s to %s; %d were already there", copied, args[0], args[1], skipped)
+    default:
+        log.Fatalf("Unknown command %q; the commands are backfill-images and migrate-images", name)
+    }
 }
\ No newline at end of file
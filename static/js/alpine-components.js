@@ -23,8 +23,8 @@ function imageUploadComponent() {
                 return;
             }
             
-            if (file.size > 5 * 1024 * 1024) {
-                alert('File size must be less than 5MB.');
+            if (file.size > 60 * 1024 * 1024) {
+                alert('File size must be less than 60MB.');
                 return;
             }
             
@@ -48,9 +48,12 @@ function imageUploadComponent() {
                 // Handle completion
                 xhr.addEventListener('load', () => {
                     if (xhr.status === 200) {
-                        const response = JSON.parse(xhr.responseText);
-                        this.updateImageDisplay(response.image_url, stampId);
                         this.$refs.fileInput.value = '';
+                        // Reload the gallery, which now has the new primary image
+                        htmx.ajax('GET', `/htmx/stamps/${stampId}/images`, {
+                            target: '#stamp-image-section',
+                            swap: 'outerHTML'
+                        });
                     } else {
                         throw new Error('Upload failed');
                     }
@@ -72,30 +75,6 @@ function imageUploadComponent() {
             }
         },
         
-        // Update image display after successful upload
-        updateImageDisplay(imageUrl, stampId) {
-            // Update the image in the UI
-            const imageContainer = document.querySelector('.stamp-detail-image-container');
-            if (imageContainer) {
-                const newImage = document.createElement('img');
-                newImage.src = imageUrl + '?t=' + Date.now();
-                newImage.alt = 'Stamp image';
-                newImage.className = 'stamp-detail-img';
-                newImage.id = 'stamp-image';
-                
-                newImage.onerror = () => {
-                    newImage.style.display = 'none';
-                    this.showImagePlaceholder();
-                };
-                
-                imageContainer.innerHTML = '';
-                imageContainer.appendChild(newImage);
-                
-                // Update controls
-                this.updateImageControls(stampId);
-            }
-        },
-        
         // Show placeholder when image fails to load
         showImagePlaceholder() {
             const imageContainer = document.querySelector('.stamp-detail-image-container');
@@ -107,25 +86,403 @@ function imageUploadComponent() {
                     </div>
                 `;
             }
+        }
+    };
+}
+
+// Image Lightbox Component
+// Pages through the images of the gallery strip (elements with data-lightbox-src) in a full-screen overlay.
+// Large scans with deep-zoom tiles (data-lightbox-tiles) are shown in an OpenSeadragon viewer to pan and zoom.
+function imageLightbox() {
+    // Made when first needed, and kept out of Alpine's reactive data
+    let viewer = null;
+
+    return {
+        open: false,
+        index: 0,
+        images: [],
+        showMarks: true,
+
+        // Open the lightbox at the image with the given source
+        show(src) {
+            this.images = Array.from(this.$refs.strip.querySelectorAll('[data-lightbox-src]')).map(el => ({
+                src: el.dataset.lightboxSrc,
+                caption: el.dataset.lightboxCaption || '',
+                tiles: el.dataset.lightboxTiles || '',
+                annotations: JSON.parse(el.dataset.lightboxAnnotations || 'null') || []
+            }));
+            if (this.images.length === 0) {
+                this.images = [{ src: src, caption: '', tiles: '', annotations: [] }];
+            }
+            this.index = Math.max(0, this.images.findIndex(image => image.src === src));
+            this.open = true;
+            this.showTiles();
+        },
+
+        close() {
+            this.open = false;
+        },
+
+        current() {
+            return this.images[this.index] || { src: '', caption: '', tiles: '', annotations: [] };
+        },
+
+        next() {
+            this.index = (this.index + 1) % this.images.length;
+            this.showTiles();
+        },
+
+        prev() {
+            this.index = (this.index + this.images.length - 1) % this.images.length;
+            this.showTiles();
+        },
+
+        // Place an annotation over the image, its rectangle being in fractions of the image's size
+        markStyle(mark) {
+            return annotationStyle(mark);
+        },
+
+        toggleMarks() {
+            this.showMarks = !this.showMarks;
+            if (viewer) this.showViewerMarks();
         },
+
+        // Whether the current image is shown in the deep-zoom viewer rather than whole
+        zoomable() {
+            return this.current().tiles !== '' && typeof OpenSeadragon !== 'undefined';
+        },
+
+        // Load the tiles of the current image into the viewer, once it is showing
+        showTiles() {
+            if (!this.zoomable()) {
+                if (viewer) viewer.close();
+                return;
+            }
+            this.$nextTick(() => {
+                if (!viewer) {
+                    viewer = OpenSeadragon({
+                        element: this.$refs.zoom,
+                        prefixUrl: 'https://unpkg.com/openseadragon@4.1.1/build/openseadragon/images/',
+                        showNavigator: true,
+                        maxZoomPixelRatio: 4
+                    });
+                }
+                viewer.addOnceHandler('open', () => this.showViewerMarks());
+                viewer.open(this.current().tiles);
+            });
+        },
+
+        // The viewer pans and zooms the annotations with the image as overlays
+        showViewerMarks() {
+            viewer.clearOverlays();
+            const item = viewer.world.getItemAt(0);
+            if (!this.showMarks || !item) return;
+            const size = item.getContentSize();
+            this.current().annotations.forEach(mark => {
+                const element = document.createElement('div');
+                element.className = 'image-annotation';
+                element.title = mark.note || mark.label;
+                const label = document.createElement('span');
+                label.className = 'image-annotation-label';
+                label.textContent = mark.label;
+                element.appendChild(label);
+                viewer.addOverlay({
+                    element: element,
+                    location: item.imageToViewportRectangle(mark.x * size.x, mark.y * size.y,
+                        mark.width * size.x, mark.height * size.y)
+                });
+            });
+        },
+
+        // Arrow keys page through the images and escape closes the lightbox
+        handleKeydown(event) {
+            if (!this.open) return;
+            if (event.key === 'Escape') {
+                this.close();
+            } else if (event.key === 'ArrowRight') {
+                this.next();
+            } else if (event.key === 'ArrowLeft') {
+                this.prev();
+            }
+        }
+    };
+}
+
+// Image Editor Component
+// Straightens, turns, flips and crops an image. The preview is only CSS; the server makes the change and
+// saves it as a new version of the image, so the original can be restored from the image history.
+function imageEditor(stampId) {
+    return {
+        imageID: '',
+        src: '',
+        quarterTurns: 0,
+        straighten: 0,
+        flipHorizontal: false,
+        flipVertical: false,
+        crop: null,       // { x, y, width, height } in fractions of the image
+        dragStart: null,
+        saving: false,
         
-        // Update image controls after upload
-        updateImageControls(stampId) {
-            const controls = document.querySelector('.image-controls');
-            if (controls) {
-                controls.innerHTML = `
-                    <button class="btn btn-sm btn-outline-secondary me-2" onclick="triggerImageUpload()">
-                        <i class="bi bi-camera"></i> Change Image
-                    </button>
-                    <button class="btn btn-sm btn-outline-danger" 
-                            hx-put="/api/stamps/${stampId}" 
-                            hx-vals='{"image_url": ""}'
-                            hx-confirm="Remove this image?"
-                            hx-target="#stamp-view-content"
-                            hx-indicator="#loading-spinner">
-                        <i class="bi bi-trash"></i> Remove
-                    </button>
-                `;
+        // Open the editor on an image; the detail carries the imageId and imageSrc of the button clicked
+        start(detail) {
+            this.imageID = detail.imageId;
+            this.src = detail.imageSrc;
+            this.reset();
+            this.$nextTick(() => this.$el.scrollIntoView({ behavior: 'smooth', block: 'nearest' }));
+        },
+        
+        close() {
+            this.imageID = '';
+            this.src = '';
+        },
+        
+        reset() {
+            this.quarterTurns = 0;
+            this.straighten = 0;
+            this.flipHorizontal = false;
+            this.flipVertical = false;
+            this.crop = null;
+        },
+        
+        // Turn a quarter to the right (1) or left (-1)
+        turn(direction) {
+            this.quarterTurns = (this.quarterTurns + direction + 4) % 4;
+        },
+        
+        rotation() {
+            return this.quarterTurns * 90 + this.straighten;
+        },
+        
+        transformed() {
+            return this.rotation() !== 0 || this.flipHorizontal || this.flipVertical;
+        },
+        
+        changed() {
+            return this.transformed() || this.crop !== null;
+        },
+        
+        // The server rotates first and flips after, which in CSS is written the other way round
+        previewStyle() {
+            const scaleX = this.flipHorizontal ? -1 : 1;
+            const scaleY = this.flipVertical ? -1 : 1;
+            return `transform: scale(${scaleX}, ${scaleY}) rotate(${this.rotation()}deg)`;
+        },
+        
+        cropStyle() {
+            if (!this.crop) return '';
+            return `left: ${this.crop.x * 100}%; top: ${this.crop.y * 100}%; ` +
+                `width: ${this.crop.width * 100}%; height: ${this.crop.height * 100}%`;
+        },
+        
+        // Where the pointer is over the image, in fractions of its size
+        pointerPosition(event) {
+            const rect = this.$refs.image.getBoundingClientRect();
+            const clamp = value => Math.min(1, Math.max(0, value));
+            return {
+                x: clamp((event.clientX - rect.left) / rect.width),
+                y: clamp((event.clientY - rect.top) / rect.height)
+            };
+        },
+        
+        // Cropping works on the image as it is, so it waits until a rotation or flip has been applied
+        startCrop(event) {
+            if (this.transformed() || this.saving) return;
+            event.preventDefault();
+            event.currentTarget.setPointerCapture(event.pointerId);
+            this.dragStart = this.pointerPosition(event);
+            this.crop = null;
+        },
+        
+        moveCrop(event) {
+            if (!this.dragStart) return;
+            const point = this.pointerPosition(event);
+            this.crop = {
+                x: Math.min(this.dragStart.x, point.x),
+                y: Math.min(this.dragStart.y, point.y),
+                width: Math.abs(point.x - this.dragStart.x),
+                height: Math.abs(point.y - this.dragStart.y)
+            };
+        },
+        
+        // A click without a drag clears the crop
+        endCrop() {
+            this.dragStart = null;
+            if (this.crop && (this.crop.width < 0.01 || this.crop.height < 0.01)) {
+                this.crop = null;
+            }
+        },
+        
+        async apply() {
+            const edit = {
+                rotate: this.rotation(),
+                flip_horizontal: this.flipHorizontal,
+                flip_vertical: this.flipVertical
+            };
+            if (this.crop && !this.transformed()) {
+                edit.crop = this.crop;
+            }
+            
+            this.saving = true;
+            try {
+                const response = await fetch(`/api/images/${this.imageID}/edit`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(edit)
+                });
+                if (!response.ok) {
+                    alert(await response.text());
+                    return;
+                }
+                // Reload the gallery, which now shows the edited version
+                htmx.ajax('GET', `/htmx/stamps/${stampId}/images`, {
+                    target: '#stamp-image-section',
+                    swap: 'outerHTML'
+                });
+            } catch (error) {
+                console.error('Edit error:', error);
+                alert('Editing failed. Please try again.');
+            } finally {
+                this.saving = false;
+            }
+        }
+    };
+}
+
+// The CSS placing an annotation's rectangle, given in fractions of the image's size, over the image
+function annotationStyle(mark) {
+    if (!mark) return '';
+    return `left: ${mark.x * 100}%; top: ${mark.y * 100}%; ` +
+        `width: ${mark.width * 100}%; height: ${mark.height * 100}%`;
+}
+
+// Image Annotator Component
+// Marks regions of an image, such as plate cracks and thin spots, with a label and a note. Changes are saved
+// as they are made, and the gallery is reloaded on closing to show them.
+function imageAnnotator(stampId) {
+    return {
+        imageID: '',
+        src: '',
+        annotations: [],
+        draft: null,      // { x, y, width, height } of the region being marked, in fractions of the image
+        dragStart: null,
+        label: '',
+        note: '',
+        saving: false,
+        changed: false,
+        
+        // Open the annotator on an image; the detail carries the imageId, imageSrc and annotations of the button
+        start(detail) {
+            this.imageID = detail.imageId;
+            this.src = detail.imageSrc;
+            this.annotations = JSON.parse(detail.annotations || 'null') || [];
+            this.draft = null;
+            this.label = '';
+            this.note = '';
+            this.changed = false;
+            this.$nextTick(() => this.$el.scrollIntoView({ behavior: 'smooth', block: 'nearest' }));
+        },
+        
+        close() {
+            const reload = this.changed;
+            this.imageID = '';
+            this.src = '';
+            if (reload) {
+                htmx.ajax('GET', `/htmx/stamps/${stampId}/images`, {
+                    target: '#stamp-image-section',
+                    swap: 'outerHTML'
+                });
+            }
+        },
+        
+        markStyle(mark) {
+            return annotationStyle(mark);
+        },
+        
+        // Where the pointer is over the image, in fractions of its size
+        pointerPosition(event) {
+            const rect = this.$refs.image.getBoundingClientRect();
+            const clamp = value => Math.min(1, Math.max(0, value));
+            return {
+                x: clamp((event.clientX - rect.left) / rect.width),
+                y: clamp((event.clientY - rect.top) / rect.height)
+            };
+        },
+        
+        startMark(event) {
+            if (this.saving) return;
+            event.preventDefault();
+            event.currentTarget.setPointerCapture(event.pointerId);
+            this.dragStart = this.pointerPosition(event);
+            this.draft = null;
+        },
+        
+        moveMark(event) {
+            if (!this.dragStart) return;
+            const point = this.pointerPosition(event);
+            this.draft = {
+                x: Math.min(this.dragStart.x, point.x),
+                y: Math.min(this.dragStart.y, point.y),
+                width: Math.abs(point.x - this.dragStart.x),
+                height: Math.abs(point.y - this.dragStart.y)
+            };
+        },
+        
+        // A click without a drag marks nothing
+        endMark() {
+            this.dragStart = null;
+            if (this.draft && (this.draft.width < 0.005 || this.draft.height < 0.005)) {
+                this.draft = null;
+            }
+            if (this.draft) {
+                this.$nextTick(() => this.$refs.label.focus());
+            }
+        },
+        
+        // Send a change to the API, returning the saved annotation, or null after telling the user why it failed
+        async send(method, url, body) {
+            this.saving = true;
+            try {
+                const response = await fetch(url, {
+                    method: method,
+                    headers: { 'Content-Type': 'application/json' },
+                    body: body ? JSON.stringify(body) : undefined
+                });
+                if (!response.ok) {
+                    alert(await response.text());
+                    return null;
+                }
+                this.changed = true;
+                return response.status === 204 ? {} : await response.json();
+            } catch (error) {
+                console.error('Annotation error:', error);
+                alert('Saving the annotation failed. Please try again.');
+                return null;
+            } finally {
+                this.saving = false;
+            }
+        },
+        
+        async add() {
+            const annotation = { ...this.draft, label: this.label, note: this.note };
+            const saved = await this.send('POST', `/api/images/${this.imageID}/annotations`, annotation);
+            if (!saved) return;
+            this.annotations.push(saved);
+            this.draft = null;
+            this.label = '';
+            this.note = '';
+        },
+        
+        async update(mark, field, value) {
+            const saved = await this.send('PUT', `/api/annotations/${mark.id}`, { [field]: value });
+            if (saved) {
+                this.annotations = this.annotations.map(other => other.id === saved.id ? saved : other);
+            }
+        },
+        
+        async remove(mark) {
+            if (!confirm(`Delete the mark "${mark.label}"?`)) return;
+            if (await this.send('DELETE', `/api/annotations/${mark.id}`)) {
+                this.annotations = this.annotations.filter(other => other.id !== mark.id);
             }
         }
     };
@@ -193,5 +550,8 @@ function formValidationComponent() {
 
 // Make components globally available
 window.imageUploadComponent = imageUploadComponent;
+window.imageLightbox = imageLightbox;
+window.imageAnnotator = imageAnnotator;
+window.imageEditor = imageEditor;
 window.modalComponent = modalComponent;
 window.formValidationComponent = formValidationComponent;
\ No newline at end of file
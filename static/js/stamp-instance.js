@@ -48,9 +48,33 @@ async function saveNewInstance(button) {
     }
 
 
-    const condition = row.querySelector('[name="condition"]').value.trim();
+    const conditionSelect = row.querySelector('[name="condition"]');
+    const condition = conditionSelect.value.trim();
+    const conditionOptions = Array.from(conditionSelect.options).map(opt => ({ value: opt.value, label: opt.textContent.trim() }));
     const boxName = row.querySelector('[name="box_name"]').value.trim();
     const quantity = parseInt(row.querySelector('[name="quantity"]').value);
+    const formatSelect = row.querySelector('[name="format"]');
+    const format = formatSelect ? formatSelect.value : 'single';
+    const formatOptions = formatSelect ? Array.from(formatSelect.options).map(opt => ({ value: opt.value, label: opt.textContent.trim() })) : [];
+
+    // Grading fields, and the choices offered for them so the saved row can offer the same
+    const grading = {};
+    const gradingOptions = {};
+    ['gum', 'centering', 'cancel_type'].forEach(field => {
+        const select = row.querySelector(`[name="${field}"]`);
+        if (!select) return;
+        grading[field] = select.value || null;
+        gradingOptions[field] = Array.from(select.options).map(opt => ({ value: opt.value, label: opt.textContent.trim() }));
+    });
+    const gradeInput = row.querySelector('[name="grade"]');
+    grading.grade = gradeInput && gradeInput.value ? parseInt(gradeInput.value) : null;
+    const faultsInput = row.querySelector('[name="faults"]');
+    grading.faults = faultsInput ? faultsInput.value.split(',').map(f => f.trim()).filter(f => f !== '') : [];
+    gradingOptions.faults_title = faultsInput ? faultsInput.title : '';
+    const gradeNotesInput = row.querySelector('[name="grade_notes"]');
+    grading.grade_notes = gradeNotesInput && gradeNotesInput.value.trim() ? gradeNotesInput.value.trim() : null;
+    const catalogueValueInput = row.querySelector('[name="catalogue_value"]');
+    grading.catalogue_value = catalogueValueInput && catalogueValueInput.value ? parseFloat(catalogueValueInput.value) : null;
 
     if (!condition && !boxName && quantity === 0) {
         alert('Please choose a condition, box, and set the quantity.');
@@ -81,7 +105,7 @@ async function saveNewInstance(button) {
             }
         }
 
-        const newInstanceData = { condition: condition || null, box_id: boxId, quantity: quantity };
+        const newInstanceData = { condition: condition || null, box_id: boxId, quantity: quantity, format: format, ...grading };
         const response = await fetch(`/api/instances/${stampId}`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
@@ -94,8 +118,9 @@ async function saveNewInstance(button) {
         }
 
         const savedInstance = await response.json();
-        const realRowHTML = createRealRowHTML(savedInstance);
+        const realRowHTML = createRealRowHTML(savedInstance, formatOptions, gradingOptions, conditionOptions);
         row.outerHTML = realRowHTML;
+        showEstimatedValue(savedInstance);
         
         updateInstanceCount();
         updateTotalCopiesCount();
@@ -112,9 +137,12 @@ async function saveNewInstance(button) {
 /**
  * Creates the HTML for a standard, editable instance row from saved data.
  * @param {object} instance - The instance data from the API.
+ * @param {Array} formatOptions - The {value, label} instance formats to offer.
+ * @param {object} gradingOptions - The {value, label} choices to offer for each grading select, by field.
+ * @param {Array} conditionOptions - The {value, label} conditions to offer.
  * @returns {string} The HTML string for the new row.
  */
-function createRealRowHTML(instance) {
+function createRealRowHTML(instance, formatOptions = [], gradingOptions = {}, conditionOptions = []) {
     let allBoxes = [];
     const allBoxesDataEl = document.getElementById('all-boxes-data');
     
@@ -136,19 +164,37 @@ function createRealRowHTML(instance) {
     
     let boxOptionsHTML = allBoxes.map(box => `<option value="${box.name}" data-id="${box.id}"></option>`).join('');
     const boxName = instance.box_name || '';
+    const conditionOptionsHTML = conditionOptions.map(c => `<option value="${c.value}" ${(instance.condition || '') === c.value ? 'selected' : ''}>${c.label}</option>`).join('');
+    const formatOptionsHTML = formatOptions.map(f => `<option value="${f.value}" ${instance.format === f.value ? 'selected' : ''}>${f.label}</option>`).join('');
+    const gradingSelectHTML = (field, title) => `
+                    <select class="form-select instance-field" data-field="${field}" data-instance-id="${instance.id}" title="${title}" onchange="saveInstanceField(this)">
+                        ${(gradingOptions[field] || []).map(o => `<option value="${o.value}" ${(instance[field] || '') === o.value ? 'selected' : ''}>${o.label}</option>`).join('')}
+                    </select>`;
 
     return `
         <tr data-instance-id="${instance.id}">
             <td>
                 <select class="form-select instance-field" data-field="condition" data-instance-id="${instance.id}" onchange="saveInstanceField(this)">
-                    <option value="" ${!instance.condition ? 'selected' : ''}>No condition</option>
-                    <option value="Mint" ${instance.condition === 'Mint' ? 'selected' : ''}>Mint</option>
-                    <option value="Used" ${instance.condition === 'Used' ? 'selected' : ''}>Used</option>
-                    <option value="Damaged" ${instance.condition === 'Damaged' ? 'selected' : ''}>Damaged</option>
-                    <option value="Fine" ${instance.condition === 'Fine' ? 'selected' : ''}>Fine</option>
-                    <option value="Very Fine" ${instance.condition === 'Very Fine' ? 'selected' : ''}>Very Fine</option>
-                    <option value="Excellent" ${instance.condition === 'Excellent' ? 'selected' : ''}>Excellent</option>
+                    ${conditionOptionsHTML}
                 </select>
+                <div class="instance-grading">
+                    ${gradingSelectHTML('gum', 'Gum')}
+                    ${gradingSelectHTML('centering', 'Centering')}
+                    <input type="number" class="info-value-input instance-field" data-field="grade" data-instance-id="${instance.id}" value="${instance.grade || ''}" min="1" max="100" placeholder="Grade" title="Numeric grade from 1 to 100" onchange="saveInstanceField(this)">
+                    ${gradingSelectHTML('cancel_type', 'Cancel')}
+                    <input class="info-value-input instance-field" data-field="faults" data-instance-id="${instance.id}" value="${(instance.faults || []).join(', ')}" placeholder="Faults, e.g. thin, crease" title="${gradingOptions.faults_title || ''}" onchange="saveInstanceField(this)">
+                    <input class="info-value-input instance-field" data-field="grade_notes" data-instance-id="${instance.id}" value="${instance.grade_notes || ''}" placeholder="Grading notes" onchange="saveInstanceField(this)">
+                    <input type="number" class="info-value-input instance-field" data-field="catalogue_value" data-instance-id="${instance.id}" value="${instance.catalogue_value != null ? instance.catalogue_value.toFixed(2) : ''}" min="0" step="0.01" placeholder="Cat. value" title="Catalogue value of one copy" onchange="saveInstanceField(this)">
+                    <span class="instance-estimated-value" data-estimated-value="${instance.id}" data-value="" title="Estimated from the catalogue value, grading and quantity"></span>
+                </div>
+            </td>
+            <td>
+                <select class="form-select instance-field" data-field="format" data-instance-id="${instance.id}" onchange="saveInstanceField(this)">${formatOptionsHTML}</select>
+                <div class="instance-format-details">
+                    <input class="info-value-input instance-field" data-field="plate_number" data-instance-id="${instance.id}" value="${instance.plate_number || ''}" placeholder="Plate #" onchange="saveInstanceField(this)">
+                    <input class="info-value-input instance-field" data-field="plate_position" data-instance-id="${instance.id}" value="${instance.plate_position || ''}" placeholder="Position" onchange="saveInstanceField(this)">
+                    <input class="info-value-input instance-field" data-instance-id="${instance.id}" value="" placeholder="Other designs, e.g. 1045, 1046 x2" title="Scott numbers of the other designs in this multiple" onchange="saveInstanceComponents(this)">
+                </div>
             </td>
             <td>
                 <input class="info-value-input instance-field" list="box-options-${instance.id}" value="${boxName}" placeholder="Type or select a box" data-instance-id="${instance.id}" onchange="handleBoxChange(this)" onkeydown="handleBoxInput(event, this)" autocomplete="off">
@@ -170,6 +216,25 @@ function createRealRowHTML(instance) {
 
 // --- UI Update and State Management ---
 
+/**
+ * Shows a group's estimated value, as saved, in its row and updates the total for the stamp.
+ * @param {object} instance - The instance data from the API.
+ */
+function showEstimatedValue(instance) {
+    const span = document.querySelector(`[data-estimated-value="${instance.id}"]`);
+    if (!span) return;
+    const value = instance.estimated_value;
+    span.dataset.value = value != null ? value.toFixed(2) : '';
+    span.textContent = value != null ? `est. ${value.toFixed(2)}` : '';
+
+    const total = document.getElementById('instance-estimated-total');
+    if (!total) return;
+    const values = Array.from(document.querySelectorAll('[data-estimated-value]'))
+        .filter(el => el.dataset.value !== '')
+        .map(el => parseFloat(el.dataset.value));
+    total.textContent = values.length ? `est. value ${values.reduce((a, b) => a + b, 0).toFixed(2)}` : '';
+}
+
 function updateInstanceCount() {
     const countSpan = document.getElementById('instance-group-count');
     if (!countSpan) return;
@@ -207,9 +272,18 @@ function removeInstanceRow(instanceId) {
 
 // --- Core API Functions ---
 
+// Sets a copy's centering to the grade suggested by measuring its scan
+function applySuggestedCentering(button, instanceId, value) {
+    const select = document.querySelector(`select[data-field="centering"][data-instance-id="${instanceId}"]`);
+    if (!select) return;
+    select.value = value;
+    saveInstanceField(select);
+    button.remove();
+}
+
 function saveInstanceField(element) {
     const instanceId = element.dataset.instanceId;
-    let value = (element.type === 'number') ? parseInt(element.value) || 0 : element.value;
+    let value = (element.type === 'number') ? parseFloat(element.value) || 0 : element.value;
     element.classList.add('saving');
     
     fetch(`/api/instances/${instanceId}`, {
@@ -230,6 +304,7 @@ function saveInstanceField(element) {
             element.classList.remove('saving');
             element.classList.add('saved');
             setTimeout(() => element.classList.remove('saved'), 1000);
+            showEstimatedValue(data);
             updateTotalCopiesCount();
             htmx.trigger(document.body, 'newBoxAdded');
         }
@@ -242,6 +317,49 @@ function saveInstanceField(element) {
     });
 }
 
+/**
+ * Saves the other designs contained in a multiple, entered as comma-separated
+ * Scott numbers with an optional "x2" style count, e.g. "1045, 1046 x2".
+ * @param {HTMLInputElement} element The contents input.
+ */
+function saveInstanceComponents(element) {
+    const instanceId = element.dataset.instanceId;
+    const components = element.value.split(',')
+        .map(part => part.trim())
+        .filter(part => part !== '')
+        .map(part => {
+            const match = part.match(/^(.*?)\s*[x×]\s*(\d+)$/i);
+            return match
+                ? { scott_number: match[1].trim(), quantity: parseInt(match[2]) || 1 }
+                : { scott_number: part, quantity: 1 };
+        });
+    element.classList.add('saving');
+
+    fetch(`/api/instances/${instanceId}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ components: components })
+    })
+    .then(response => {
+        if (!response.ok) return response.text().then(text => { throw new Error(text); });
+        return response.json();
+    })
+    .then(data => {
+        element.classList.remove('saving');
+        element.classList.add('saved');
+        setTimeout(() => element.classList.remove('saved'), 1000);
+        element.value = (data.components || [])
+            .map(c => c.quantity > 1 ? `${c.scott_number} x${c.quantity}` : c.scott_number)
+            .join(', ');
+    })
+    .catch(error => {
+        element.classList.remove('saving');
+        element.classList.add('error');
+        setTimeout(() => element.classList.remove('error'), 2000);
+        alert(`Failed to save contents: ${error.message}`);
+    });
+}
+
 // Delete an entire instance group
 function deleteInstance(instanceId) {
     if (confirm('Are you sure you want to delete this group of copies?')) {